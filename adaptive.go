@@ -0,0 +1,101 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// xAdaptiveTTL is the header holding the learned freshness lifetime (in seconds)
+	// of a cached response when AdaptiveTTL is enabled.
+	xAdaptiveTTL = "X-Adaptive-Ttl"
+
+	// adaptiveTTLMin is the learned lifetime given to a response after its first
+	// revalidation that found it unchanged.
+	adaptiveTTLMin = time.Minute
+
+	// defaultAdaptiveTTLMax is the default value of Transport.AdaptiveTTLMax.
+	defaultAdaptiveTTLMax = 24 * time.Hour
+)
+
+// hasExplicitLifetime reports whether the response headers carry freshness
+// information from the origin, i.e. a max-age directive or an Expires header.
+func hasExplicitLifetime(respHeaders http.Header) bool {
+	if _, ok := parseCacheControl(respHeaders)["max-age"]; ok {
+		return true
+	}
+	return respHeaders.Get("Expires") != ""
+}
+
+// adaptiveTTL returns the learned freshness lifetime stored in respHeaders, 0 if none.
+func adaptiveTTL(respHeaders http.Header) time.Duration {
+	secs, err := strconv.ParseInt(respHeaders.Get(xAdaptiveTTL), 10, 64)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func setAdaptiveTTL(respHeaders http.Header, ttl time.Duration) {
+	if ttl <= 0 {
+		respHeaders.Del(xAdaptiveTTL)
+		return
+	}
+	respHeaders.Set(xAdaptiveTTL, strconv.FormatInt(int64(ttl/time.Second), 10))
+}
+
+// defaultLifetime returns the freshness lifetime to use for the cached response
+// with the given headers if it lacks explicit freshness information.
+func (t *Transport) defaultLifetime(respHeaders http.Header) time.Duration {
+	if t.AdaptiveTTL {
+		if ttl := adaptiveTTL(respHeaders); ttl > 0 {
+			return ttl
+		}
+	}
+	return t.heuristicLifetime(respHeaders)
+}
+
+// learnTTL adjusts the learned freshness lifetime after a revalidation of the
+// cached response with the headers in cachedHeaders and stores it in newHeaders.
+//
+// If the revalidation found the content unchanged, the lifetime is doubled
+// (up to AdaptiveTTLMax), else it's halved, falling back to no lifetime at all.
+func (t *Transport) learnTTL(cachedHeaders, newHeaders http.Header, unchanged bool) {
+	if hasExplicitLifetime(newHeaders) {
+		newHeaders.Del(xAdaptiveTTL)
+		return
+	}
+	ttl := adaptiveTTL(cachedHeaders)
+	if unchanged {
+		ttl *= 2
+		if ttl < adaptiveTTLMin {
+			ttl = adaptiveTTLMin
+		}
+		maxTTL := t.AdaptiveTTLMax
+		if maxTTL == 0 {
+			maxTTL = defaultAdaptiveTTLMax
+		}
+		if ttl > maxTTL {
+			ttl = maxTTL
+		}
+	} else {
+		ttl /= 2
+		if ttl < adaptiveTTLMin {
+			ttl = 0
+		}
+	}
+	setAdaptiveTTL(newHeaders, ttl)
+}
+
+// sameValidators reports whether the two sets of response headers carry the
+// same, non-empty, validator (ETag or else Last-Modified).
+func sameValidators(h1, h2 http.Header) bool {
+	if etag := h1.Get("etag"); etag != "" {
+		return etag == h2.Get("etag")
+	}
+	if lm := h1.Get("last-modified"); lm != "" {
+		return lm == h2.Get("last-modified")
+	}
+	return false
+}
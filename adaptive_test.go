@@ -0,0 +1,105 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestAdaptiveTTL(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var (
+		requests int
+		etag     = `"v1"`
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Etag", etag)
+		if r.Header.Get("if-none-match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(etag))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.AdaptiveTTL = true
+	tp.AdaptiveTTLMax = 2 * time.Minute
+	client := http.Client{Transport: tp}
+
+	get := func() *http.Response {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		return resp
+	}
+
+	get()
+	c.Assert(requests, qt.Equals, 1)
+
+	// No lifetime learned yet, revalidate.
+	resp := get()
+	c.Assert(requests, qt.Equals, 2)
+	c.Assert(resp.Header.Get(xAdaptiveTTL), qt.Equals, "60")
+
+	// Unchanged on revalidation, so considered fresh for a minute.
+	clock = &fakeClock{elapsed: 30 * time.Second}
+	get()
+	c.Assert(requests, qt.Equals, 2)
+
+	clock = &fakeClock{elapsed: 90 * time.Second}
+	resp = get()
+	c.Assert(requests, qt.Equals, 3)
+	c.Assert(resp.Header.Get(xAdaptiveTTL), qt.Equals, "120")
+
+	// Capped by AdaptiveTTLMax.
+	clock = &fakeClock{elapsed: 150 * time.Second}
+	resp = get()
+	c.Assert(requests, qt.Equals, 4)
+	c.Assert(resp.Header.Get(xAdaptiveTTL), qt.Equals, "120")
+
+	// Changed content halves the lifetime, only stored with the new response.
+	etag = `"v2"`
+	resp = get()
+	c.Assert(requests, qt.Equals, 5)
+	c.Assert(resp.Header.Get(xAdaptiveTTL), qt.Equals, "")
+	b, ok := tp.Cache.Get(ts.URL)
+	c.Assert(ok, qt.IsTrue)
+	stored, err := readStoredResponse(b, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(stored.Header.Get(xAdaptiveTTL), qt.Equals, "60")
+}
+
+func TestAdaptiveTTLFromServerIgnored(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Etag", `"v1"`)
+		w.Header().Set(xAdaptiveTTL, "86400")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.AdaptiveTTL = true
+	client := http.Client{Transport: tp}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	c.Assert(requests, qt.Equals, 2)
+}
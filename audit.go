@@ -0,0 +1,243 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// ErrCacheNotIterable is returned by operations that need to walk the cache
+// when the Cache does not implement IterableCache.
+var ErrCacheNotIterable = errors.New("cache does not implement IterableCache")
+
+// AuditOptions configures Transport.Audit.
+type AuditOptions struct {
+	// Prefix limits the audit to keys with the given prefix.
+	Prefix string
+
+	// Sample is the maximum number of entries to revalidate, picked at random.
+	// If 0, all entries are revalidated.
+	Sample int
+
+	// Concurrency is the maximum number of revalidation requests in flight.
+	// Default is 4.
+	Concurrency int
+}
+
+// AuditReport is the result of a Transport.Audit.
+type AuditReport struct {
+	// Entries is the number of entries found in the cache.
+	Entries int
+
+	// Checked is the number of entries revalidated against the origin.
+	Checked int
+
+	// NotModified is the number of checked entries the origin reported as still valid.
+	NotModified int
+
+	// Modified is the number of checked entries the origin reported as changed.
+	Modified int
+
+	// FreshModified is the number of checked entries that were fresh in the cache,
+	// but had changed on the origin, i.e. their freshness lifetime was too long.
+	FreshModified int
+
+	// StaleNotModified is the number of checked entries that were stale in the cache,
+	// but still valid on the origin, i.e. their freshness lifetime was too short.
+	StaleNotModified int
+
+	// Skipped is the number of sampled entries that could not be revalidated,
+	// e.g. because they carry no validators.
+	Skipped int
+
+	// Errors is the number of revalidation requests that failed.
+	Errors int
+}
+
+// Audit walks the cache and revalidates a sample (or all) of its entries
+// using conditional requests, reporting how many of them were still valid.
+// The cache is left untouched.
+//
+// The Cache must implement IterableCache.
+func (t *Transport) Audit(ctx context.Context, opts AuditOptions) (AuditReport, error) {
+	var report AuditReport
+
+	cache, ok := t.Cache.(IterableCache)
+	if !ok {
+		return report, ErrCacheNotIterable
+	}
+
+	var keys []string
+	cache.Keys(opts.Prefix, func(key string) bool {
+		if !t.isStoredResponse(ctx, key) {
+			// E.g. a lease or the saved Counters.
+			return ctx.Err() == nil
+		}
+		report.Entries++
+		if opts.Sample <= 0 || len(keys) < opts.Sample {
+			keys = append(keys, key)
+		} else if i := rand.Intn(report.Entries); i < opts.Sample {
+			// Reservoir sampling.
+			keys[i] = key
+		}
+		return ctx.Err() == nil
+	})
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result := t.auditEntry(ctx, key)
+			mu.Lock()
+			report.add(result)
+			mu.Unlock()
+		}(key)
+	}
+	wg.Wait()
+
+	return report, ctx.Err()
+}
+
+type auditResult int
+
+const (
+	auditSkipped auditResult = iota
+	auditError
+	auditNotModified
+	auditModified
+	auditStaleNotModified
+	auditFreshModified
+)
+
+func (r *AuditReport) add(result auditResult) {
+	switch result {
+	case auditSkipped:
+		r.Skipped++
+		return
+	case auditError:
+		r.Errors++
+		return
+	case auditNotModified, auditStaleNotModified:
+		r.NotModified++
+		if result == auditStaleNotModified {
+			r.StaleNotModified++
+		}
+	case auditModified, auditFreshModified:
+		r.Modified++
+		if result == auditFreshModified {
+			r.FreshModified++
+		}
+	}
+	r.Checked++
+}
+
+func (t *Transport) auditEntry(ctx context.Context, key string) auditResult {
+	b, _ := t.cacheGet(ctx, key)
+	if len(b) == 0 {
+		return auditSkipped
+	}
+	cachedResp, err := readStoredResponse(b, nil)
+	if err != nil {
+		return auditSkipped
+	}
+	req, err := storedRequest(ctx, cachedResp)
+	if err != nil {
+		return auditSkipped
+	}
+	req = t.validation(req).Conditional(req, cachedResp)
+	if req == nil {
+		return auditSkipped
+	}
+
+	resp, err := t.originTransport(req).RoundTrip(req)
+	if err != nil {
+		return auditError
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	freshness := t.freshness(cachedResp, req)
+
+	switch {
+	case t.validation(req).NotModified(req, resp, cachedResp):
+		if freshness == fresh {
+			return auditNotModified
+		}
+		return auditStaleNotModified
+	case resp.StatusCode >= 500:
+		return auditError
+	default:
+		if freshness == fresh {
+			return auditFreshModified
+		}
+		return auditModified
+	}
+}
+
+// isStoredResponse reports whether key holds a cached response, as opposed
+// to the other values the Transport keeps in the cache.
+func (t *Transport) isStoredResponse(ctx context.Context, key string) bool {
+	b, _ := t.cacheGet(ctx, key)
+	if len(b) == 0 {
+		return false
+	}
+	e, err := decodeEntry(b)
+	if err != nil {
+		return false
+	}
+	h, err := e.Header()
+	return err == nil && h.Get(xRequestURL) != ""
+}
+
+// storedRequest reconstructs the request cachedResp was stored for,
+// including the request headers listed in its Vary header.
+func storedRequest(ctx context.Context, cachedResp *http.Response) (*http.Request, error) {
+	u := cachedResp.Header.Get(xRequestURL)
+	if u == "" {
+		return nil, errors.New("no request URL stored with response")
+	}
+	method := cachedResp.Header.Get(xRequestMethod)
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range headerAllCommaSepValues(cachedResp.Header, "vary") {
+		header = http.CanonicalHeaderKey(header)
+		if v := cachedResp.Header.Get("X-Varied-" + header); v != "" {
+			req.Header.Set(header, v)
+		}
+	}
+	return req, nil
+}
+
+// transport returns the RoundTripper used to make requests to the origin.
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
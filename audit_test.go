@@ -0,0 +1,91 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestAudit(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	doMethod(t, "GET", "/etag", nil)
+	doMethod(t, "GET", "/lastmodified", nil)
+	doMethod(t, "GET", "/method", nil)
+	c.Assert(cacheSize(), qt.Equals, 3)
+
+	report, err := s.transport.Audit(context.Background(), AuditOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(report, qt.DeepEquals, AuditReport{
+		Entries:          3,
+		Checked:          2,
+		NotModified:      2,
+		StaleNotModified: 2,
+		Skipped:          1,
+	})
+
+	report, err = s.transport.Audit(context.Background(), AuditOptions{Sample: 1, Prefix: s.server.URL + "/etag"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(report.Entries, qt.Equals, 1)
+	c.Assert(report.NotModified, qt.Equals, 1)
+
+	_, err = (&Transport{Cache: &staleCache{}}).Audit(context.Background(), AuditOptions{})
+	c.Assert(err, qt.Equals, ErrCacheNotIterable)
+}
+
+func TestAuditSkipsInternalKeys(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	doMethod(t, "GET", "/etag", nil)
+	c.Assert((&Counters{}).Save(context.Background(), s.transport.Cache), qt.IsNil)
+	s.transport.Cache.Set(leasePrefix+"key", []byte("lease"))
+	c.Assert(cacheSize(), qt.Equals, 3)
+
+	report, err := s.transport.Audit(context.Background(), AuditOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(report, qt.DeepEquals, AuditReport{
+		Entries:          1,
+		Checked:          1,
+		NotModified:      1,
+		StaleNotModified: 1,
+	})
+}
+
+func TestAuditUpstreamRetries(t *testing.T) {
+	c := qt.New(t)
+	var failures atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Etag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	tp := &Transport{Cache: newMemoryCache(), UpstreamRetries: 1, UpstreamRetryBackoff: time.Millisecond}
+	resp, err := (&http.Client{Transport: tp}).Get(ts.URL)
+	c.Assert(err, qt.IsNil)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// The audit goes through the same retries as the requests it replays.
+	failures.Store(1)
+	report, err := tp.Audit(context.Background(), AuditOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(report.Errors, qt.Equals, 0)
+	c.Assert(report.NotModified, qt.Equals, 1)
+}
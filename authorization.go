@@ -0,0 +1,77 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// AuthorizationPolicy tells how the Transport caches the responses to requests
+// with an Authorization header.
+type AuthorizationPolicy int
+
+const (
+	// AuthorizationDefault applies AuthorizationRestrict if the Transport is Shared,
+	// and caches the responses like any other otherwise.
+	AuthorizationDefault AuthorizationPolicy = iota
+
+	// AuthorizationRestrict only stores the responses explicitly allowed to be shared
+	// with the public, must-revalidate or s-maxage directives, see RFC 9111 section 3.5.
+	AuthorizationRestrict
+
+	// AuthorizationPartition stores the responses not explicitly allowed to be shared
+	// in a partition of the cache per credentials, identified by a hash of the
+	// Authorization header, as if they were sent on behalf of a User, see Options.
+	// The User of the request Options, if set, takes precedence.
+	AuthorizationPartition
+)
+
+func (p AuthorizationPolicy) String() string {
+	switch p {
+	case AuthorizationDefault:
+		return "default"
+	case AuthorizationRestrict:
+		return "restrict"
+	case AuthorizationPartition:
+		return "partition"
+	default:
+		return "unknown"
+	}
+}
+
+// authorizationPolicy returns the policy applied to the requests with an Authorization header.
+func (t *Transport) authorizationPolicy() AuthorizationPolicy {
+	if t.AuthorizationPolicy == AuthorizationDefault && t.Shared {
+		return AuthorizationRestrict
+	}
+	return t.AuthorizationPolicy
+}
+
+// partitionUser returns the user whose partition of the cache is used for req,
+// if any, see Options.User and AuthorizationPartition.
+func (t *Transport) partitionUser(req *http.Request, opts Options) string {
+	if opts.User != "" {
+		return opts.User
+	}
+	if t.authorizationPolicy() != AuthorizationPartition {
+		return ""
+	}
+	authorization := req.Header.Get("Authorization")
+	if authorization == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(authorization))
+	return "authorization:" + hex.EncodeToString(sum[:])
+}
+
+// authorizationNotStored returns the reason resp, the response to req, must not be
+// stored given its Authorization header, or "" if it may.
+func (t *Transport) authorizationNotStored(req *http.Request, resp *http.Response) StoreSkipReason {
+	if t.authorizationPolicy() != AuthorizationRestrict || req.Header.Get("Authorization") == "" {
+		return ""
+	}
+	if allowsAuthorizedSharing(parseCacheControl(resp.Header)) {
+		return ""
+	}
+	return SkipAuthorization
+}
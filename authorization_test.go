@@ -0,0 +1,84 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestAuthorizationPolicy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+
+	newGet := func(c *qt.C, tp *Transport) func(path, authorization string) (string, CacheStatus) {
+		return func(path, authorization string) (string, CacheStatus) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+			c.Assert(err, qt.IsNil)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			resp, err := (&http.Client{Transport: tp}).Do(req)
+			c.Assert(err, qt.IsNil)
+			b, err := io.ReadAll(resp.Body)
+			c.Assert(err, qt.IsNil)
+			resp.Body.Close()
+			status, _ := ResponseCacheStatus(resp)
+			return string(b), status
+		}
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		c := qt.New(t)
+		get := newGet(c, &Transport{Cache: newMemoryCache()})
+		get("/", "Bearer a")
+		_, status := get("/", "Bearer a")
+		c.Assert(status, qt.Equals, StatusHit)
+	})
+
+	t.Run("Restrict", func(t *testing.T) {
+		c := qt.New(t)
+		get := newGet(c, &Transport{Cache: newMemoryCache(), AuthorizationPolicy: AuthorizationRestrict})
+		get("/", "Bearer a")
+		_, status := get("/", "Bearer a")
+		c.Assert(status, qt.Equals, StatusMiss)
+		get("/public", "Bearer a")
+		_, status = get("/public", "Bearer b")
+		c.Assert(status, qt.Equals, StatusHit)
+	})
+
+	t.Run("Partition", func(t *testing.T) {
+		c := qt.New(t)
+		cache := newMemoryCache()
+		get := newGet(c, &Transport{Cache: cache, AuthorizationPolicy: AuthorizationPartition})
+		get("/", "Bearer a")
+		b, status := get("/", "Bearer a")
+		c.Assert(status, qt.Equals, StatusHit)
+		c.Assert(b, qt.Equals, "Bearer a")
+		b, status = get("/", "Bearer b")
+		c.Assert(status, qt.Equals, StatusMiss)
+		c.Assert(b, qt.Equals, "Bearer b")
+		_, status = get("/", "")
+		c.Assert(status, qt.Equals, StatusMiss)
+
+		// The credentials are not stored in the keys.
+		cache.Keys("", func(key string) bool {
+			c.Assert(key, qt.Not(qt.Contains), "Bearer")
+			return true
+		})
+
+		// Responses allowed to be shared are stored once.
+		get("/public", "Bearer a")
+		_, status = get("/public", "Bearer b")
+		c.Assert(status, qt.Equals, StatusHit)
+	})
+}
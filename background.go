@@ -0,0 +1,60 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// detachedContext is a context.Context that carries the values of its parent,
+// but not its deadline or cancellation.
+//
+// Background requests outlive the request that triggered them, but values
+// such as trace spans and request IDs stored in the context should follow along.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (deadline time.Time, ok bool) { return }
+func (c detachedContext) Done() <-chan struct{}                   { return nil }
+func (c detachedContext) Err() error                              { return nil }
+func (c detachedContext) Value(key any) any                       { return c.parent.Value(key) }
+
+// backgroundRequest returns a new request for the same resource as req
+// suitable to be sent to the origin in the background, after req itself has
+// been answered.
+//
+// The new request gets a context with the values of req's context, but
+// without its cancellation, and the headers configured in PropagateHeaders.
+// Headers listed in the Vary header of cachedResp, if any, are always copied,
+// so the background request selects the same variant.
+func (t *Transport) backgroundRequest(req *http.Request, cachedResp *http.Response) *http.Request {
+	ctx := detachedContext{parent: req.Context()}
+	req2 := cloneRequest(req).WithContext(ctx)
+	if req2.GetBody != nil {
+		// The body of req has been read.
+		req2.Body, _ = req2.GetBody()
+	}
+
+	if t.PropagateHeaders == nil {
+		return req2
+	}
+
+	req2.Header = make(http.Header)
+	for _, header := range t.PropagateHeaders {
+		header = http.CanonicalHeaderKey(header)
+		if v, ok := req.Header[header]; ok {
+			req2.Header[header] = v
+		}
+	}
+	if cachedResp != nil {
+		for _, header := range headerAllCommaSepValues(cachedResp.Header, "vary") {
+			header = http.CanonicalHeaderKey(header)
+			if v, ok := req.Header[header]; ok {
+				req2.Header[header] = v
+			}
+		}
+	}
+
+	return req2
+}
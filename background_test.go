@@ -0,0 +1,41 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+type ctxKey string
+
+func TestBackgroundRequest(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey("trace"), "abc"))
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://example.com/foo", nil)
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	req.Header.Set("Accept", "text/plain")
+	req.Header.Set("Authorization", "secret")
+
+	cachedResp := &http.Response{Header: http.Header{"Vary": []string{"Accept"}}}
+
+	tp := &Transport{}
+	bg := tp.backgroundRequest(req, cachedResp)
+	c.Assert(bg.URL.String(), qt.Equals, "http://example.com/foo")
+	c.Assert(bg.Header.Get("Authorization"), qt.Equals, "secret")
+	c.Assert(bg.Context().Value(ctxKey("trace")), qt.Equals, "abc")
+
+	tp.PropagateHeaders = []string{"traceparent"}
+	bg = tp.backgroundRequest(req, cachedResp)
+	c.Assert(bg.Header.Get("Traceparent"), qt.Equals, req.Header.Get("Traceparent"))
+	c.Assert(bg.Header.Get("Accept"), qt.Equals, "text/plain")
+	c.Assert(bg.Header.Get("Authorization"), qt.Equals, "")
+
+	cancel()
+	c.Assert(req.Context().Err(), qt.Not(qt.IsNil))
+	c.Assert(bg.Context().Err(), qt.IsNil)
+	c.Assert(bg.Context().Value(ctxKey("trace")), qt.Equals, "abc")
+}
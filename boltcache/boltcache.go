@@ -0,0 +1,262 @@
+// Package boltcache provides an implementation of httpcache.Cache that stores
+// responses in a single bbolt database file, so they survive process restarts.
+//
+// Entries stored with a TTL (see httpcache.CacheWithExpiryContext) have their expiry time
+// recorded in a separate bucket, and are removed by a background goroutine once
+// expired, freeing their pages in the file for reuse.
+package boltcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	entriesBucket = []byte("entries")
+	expiryBucket  = []byte("expiry")
+)
+
+// DefaultGCInterval is the default interval between removals of expired entries.
+const DefaultGCInterval = 10 * time.Minute
+
+// Options configures a Cache.
+type Options struct {
+	// GCInterval is the interval between removals of expired entries.
+	// Default is DefaultGCInterval. If negative, expired entries are only
+	// removed when looked up.
+	GCInterval time.Duration
+
+	// Timeout is how long to wait to obtain the lock on the database file,
+	// held by other processes using it. If 0, it waits indefinitely.
+	Timeout time.Duration
+}
+
+// Cache is an implementation of httpcache.Cache, httpcache.CacheContext,
+// httpcache.CacheWithExpiryContext and httpcache.IterableCache backed by a bbolt database.
+// It is safe for concurrent use.
+type Cache struct {
+	db *bolt.DB
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New opens the bbolt database at path, creating it if needed, and returns
+// a Cache using it. Call Close when done.
+func New(path string, opts Options) (*Cache, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: opts.Timeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{entriesBucket, expiryBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	c := &Cache{db: db, stop: make(chan struct{}), done: make(chan struct{})}
+	interval := opts.GCInterval
+	if interval == 0 {
+		interval = DefaultGCInterval
+	}
+	if interval > 0 {
+		go c.gcLoop(interval)
+	} else {
+		close(c.done)
+	}
+	return c, nil
+}
+
+// Close stops the background goroutine and closes the database.
+func (c *Cache) Close() error {
+	close(c.stop)
+	<-c.done
+	return c.db.Close()
+}
+
+// Get returns the response stored for key, if any.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	b, ok, err := c.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return b, ok
+}
+
+// Set stores the response b for key.
+func (c *Cache) Set(key string, b []byte) {
+	c.SetContext(context.Background(), key, b)
+}
+
+// SetWithTTL stores the response b for key, to be removed after ttl.
+func (c *Cache) SetWithTTL(key string, b []byte, ttl time.Duration) {
+	c.SetWithTTLContext(context.Background(), key, b, ttl)
+}
+
+// Delete removes the response stored for key.
+func (c *Cache) Delete(key string) {
+	c.DeleteContext(context.Background(), key)
+}
+
+// GetContext returns the response stored for key, if any.
+func (c *Cache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	var (
+		b       []byte
+		expired bool
+	)
+	err := c.db.View(func(tx *bolt.Tx) error {
+		if exp, ok := expiry(tx, []byte(key)); ok && !time.Now().Before(exp) {
+			expired = true
+			return nil
+		}
+		if v := tx.Bucket(entriesBucket).Get([]byte(key)); v != nil {
+			// v is only valid during the transaction.
+			b = bytes.Clone(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if expired {
+		return nil, false, c.DeleteContext(ctx, key)
+	}
+	return b, b != nil, nil
+}
+
+// SetContext stores the response b for key.
+func (c *Cache) SetContext(ctx context.Context, key string, b []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.set(key, b, time.Time{})
+}
+
+// SetWithTTLContext stores the response b for key, to be removed after ttl.
+func (c *Cache) SetWithTTLContext(ctx context.Context, key string, b []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.set(key, b, time.Now().Add(ttl))
+}
+
+// DeleteContext removes the response stored for key.
+func (c *Cache) DeleteContext(ctx context.Context, key string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return deleteEntry(tx, []byte(key))
+	})
+}
+
+// Keys calls fn for every key starting with prefix, in sorted order,
+// until fn returns false.
+func (c *Cache) Keys(prefix string, fn func(key string) bool) {
+	var keys []string
+	c.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(entriesBucket).Cursor()
+		p := []byte(prefix)
+		for k, _ := cur.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = cur.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	// fn may use the Cache, so call it outside of the transaction.
+	for _, key := range keys {
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+// RemoveExpired removes the expired entries, returning how many were removed.
+// It is called periodically by a background goroutine, see Options.GCInterval.
+func (c *Cache) RemoveExpired() (int, error) {
+	var n int
+	now := time.Now()
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		var expired [][]byte
+		err := tx.Bucket(expiryBucket).ForEach(func(k, v []byte) error {
+			if exp, ok := decodeTime(v); ok && !now.Before(exp) {
+				expired = append(expired, bytes.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := deleteEntry(tx, k); err != nil {
+				return err
+			}
+		}
+		n = len(expired)
+		return nil
+	})
+	return n, err
+}
+
+func (c *Cache) gcLoop(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.RemoveExpired()
+		}
+	}
+}
+
+// set stores b for key, expiring at exp, unless exp is zero.
+func (c *Cache) set(key string, b []byte, exp time.Time) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		if err := tx.Bucket(entriesBucket).Put(k, b); err != nil {
+			return err
+		}
+		if exp.IsZero() {
+			return tx.Bucket(expiryBucket).Delete(k)
+		}
+		return tx.Bucket(expiryBucket).Put(k, encodeTime(exp))
+	})
+}
+
+func deleteEntry(tx *bolt.Tx, k []byte) error {
+	if err := tx.Bucket(entriesBucket).Delete(k); err != nil {
+		return err
+	}
+	return tx.Bucket(expiryBucket).Delete(k)
+}
+
+// expiry returns the expiry time of the entry for k, if it has one.
+func expiry(tx *bolt.Tx, k []byte) (time.Time, bool) {
+	return decodeTime(tx.Bucket(expiryBucket).Get(k))
+}
+
+func encodeTime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+func decodeTime(b []byte) (time.Time, bool) {
+	if len(b) != 8 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), true
+}
@@ -0,0 +1,158 @@
+package boltcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/gohugoio/httpcache"
+)
+
+var (
+	_ httpcache.Cache                  = (*Cache)(nil)
+	_ httpcache.CacheContext           = (*Cache)(nil)
+	_ httpcache.CacheWithExpiryContext = (*Cache)(nil)
+	_ httpcache.IterableCache          = (*Cache)(nil)
+)
+
+func newTestCache(c *qt.C, path string, opts Options) *Cache {
+	cache, err := New(path, opts)
+	c.Assert(err, qt.IsNil)
+	return cache
+}
+
+func TestBoltCache(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	cache := newTestCache(c, path, Options{})
+	key := "http://example.com/foo"
+	_, ok := cache.Get(key)
+	c.Assert(ok, qt.IsFalse)
+
+	cache.Set(key, []byte("some value"))
+	cache.Set("http://example.org/", []byte("other value"))
+	b, ok := cache.Get(key)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "some value")
+
+	// Survives restarts.
+	c.Assert(cache.Close(), qt.IsNil)
+	cache = newTestCache(c, path, Options{})
+	defer cache.Close()
+	b, ok = cache.Get(key)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "some value")
+
+	var keys []string
+	cache.Keys("http://example.com/", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, qt.DeepEquals, []string{key})
+
+	cache.Delete(key)
+	_, ok = cache.Get(key)
+	c.Assert(ok, qt.IsFalse)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := cache.GetContext(ctx, key)
+	c.Assert(err, qt.Equals, context.Canceled)
+}
+
+func TestBoltCacheExpiry(t *testing.T) {
+	c := qt.New(t)
+	cache := newTestCache(c, filepath.Join(t.TempDir(), "cache.db"), Options{GCInterval: -1})
+	defer cache.Close()
+
+	cache.SetWithTTL("a", []byte("a"), time.Millisecond)
+	cache.SetWithTTL("b", []byte("b"), time.Millisecond)
+	cache.SetWithTTL("c", []byte("c"), time.Hour)
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := cache.Get("a")
+	c.Assert(ok, qt.IsFalse)
+	_, ok = cache.Get("c")
+	c.Assert(ok, qt.IsTrue)
+
+	n, err := cache.RemoveExpired()
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 1)
+
+	// Set without TTL clears the expiry.
+	cache.SetWithTTL("d", []byte("d"), time.Millisecond)
+	cache.Set("d", []byte("d"))
+	time.Sleep(5 * time.Millisecond)
+	_, ok = cache.Get("d")
+	c.Assert(ok, qt.IsTrue)
+}
+
+func TestBoltCacheSetWithTTLContext(t *testing.T) {
+	c := qt.New(t)
+	cache := newTestCache(c, filepath.Join(t.TempDir(), "cache.db"), Options{GCInterval: -1})
+
+	c.Assert(cache.SetWithTTLContext(context.Background(), "a", []byte("a"), time.Hour), qt.IsNil)
+	b, ok := cache.Get("a")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Assert(cache.SetWithTTLContext(ctx, "b", []byte("b"), time.Hour), qt.Equals, context.Canceled)
+
+	// The write errors are reported, so the Transport can retry them.
+	c.Assert(cache.Close(), qt.IsNil)
+	c.Assert(cache.SetWithTTLContext(context.Background(), "c", []byte("c"), time.Hour), qt.IsNotNil)
+}
+
+func TestBoltCacheGC(t *testing.T) {
+	c := qt.New(t)
+	cache := newTestCache(c, filepath.Join(t.TempDir(), "cache.db"), Options{GCInterval: 10 * time.Millisecond})
+	defer cache.Close()
+
+	cache.SetWithTTL("a", []byte("a"), time.Millisecond)
+	for i := 0; i < 100; i++ {
+		var keys []string
+		cache.Keys("", func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		if len(keys) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatal("expired entry not removed")
+}
+
+func TestBoltCacheTransport(t *testing.T) {
+	c := qt.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	cache := newTestCache(c, filepath.Join(t.TempDir(), "cache.db"), Options{})
+	defer cache.Close()
+	client := http.Client{Transport: &httpcache.Transport{Cache: cache}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(string(b), qt.Equals, "hello")
+		status, _ := httpcache.ResponseCacheStatus(resp)
+		if i == 1 {
+			c.Assert(status, qt.Equals, httpcache.StatusHit)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package httpcache
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker for a Cache backend: it opens after too many
+// consecutive failed operations, rejecting the operations for a cooldown. Then a
+// single operation is let through to probe the backend, the others being rejected
+// until it succeeds, which closes the breaker.
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	open     bool
+	probing  bool
+	retryAt  time.Time
+}
+
+// allow reports whether an operation may run at now, and whether it is the probe.
+// Its outcome must be reported to done or, if it says nothing about the backend,
+// to cancel.
+func (b *breaker) allow(now time.Time) (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true, false
+	}
+	if b.probing || now.Before(b.retryAt) {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// done records the outcome err at now of an operation, the probe if probe is set.
+// The breaker opens for cooldown after maxFailures consecutive failures, or again
+// when the probe fails. It reports whether the breaker opened or closed.
+func (b *breaker) done(err error, probe bool, now time.Time, maxFailures int, cooldown time.Duration) (changed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case err == nil:
+		b.failures = 0
+		changed = b.open
+		b.open = false
+	case b.open:
+		b.retryAt = now.Add(cooldown)
+	default:
+		b.failures++
+		if b.failures >= maxFailures {
+			b.open, changed = true, true
+			b.retryAt = now.Add(cooldown)
+		}
+	}
+	return changed
+}
+
+// cancel records that an operation, the probe if probe is set, was canceled.
+func (b *breaker) cancel(probe bool) {
+	if !probe {
+		return
+	}
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// isOpen reports whether the breaker is open.
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
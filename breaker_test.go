@@ -0,0 +1,48 @@
+package httpcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestBreaker(t *testing.T) {
+	c := qt.New(t)
+	var b breaker
+	now := time.Now()
+	errFailed := errors.New("failed")
+	allow := func() (bool, bool) { return b.allow(now) }
+
+	c.Assert(b.done(errFailed, false, now, 2, time.Minute), qt.IsFalse)
+	c.Assert(b.done(errFailed, false, now, 2, time.Minute), qt.IsTrue)
+	c.Assert(b.isOpen(), qt.IsTrue)
+	ok, _ := allow()
+	c.Assert(ok, qt.IsFalse)
+
+	// A single probe after the cooldown.
+	now = now.Add(time.Minute)
+	ok, probe := allow()
+	c.Assert(ok && probe, qt.IsTrue)
+	ok, _ = allow()
+	c.Assert(ok, qt.IsFalse)
+
+	// Canceled, another one may probe.
+	b.cancel(true)
+	ok, probe = allow()
+	c.Assert(ok && probe, qt.IsTrue)
+
+	// Failed, open for another cooldown.
+	c.Assert(b.done(errFailed, true, now, 2, time.Minute), qt.IsFalse)
+	ok, _ = allow()
+	c.Assert(ok, qt.IsFalse)
+
+	now = now.Add(time.Minute)
+	_, probe = allow()
+	c.Assert(b.done(nil, probe, now, 2, time.Minute), qt.IsTrue)
+	c.Assert(b.isOpen(), qt.IsFalse)
+	ok, probe = allow()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(probe, qt.IsFalse)
+}
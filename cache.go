@@ -0,0 +1,409 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// CacheContext is a variant of the Cache interface with methods that take a
+// context.Context and return errors, for backends doing I/O (e.g. remote caches).
+//
+// If the Cache used by a Transport also implements CacheContext, the Transport
+// uses the CacheContext methods, passing on the context of the request.
+// Use FromCacheContext to create a Cache from a CacheContext.
+type CacheContext interface {
+	// GetContext returns the []byte representation of a cached response and a bool
+	// set to false if the key is not found or the value is stale.
+	GetContext(ctx context.Context, key string) (responseBytes []byte, ok bool, err error)
+	// SetContext stores the []byte representation of a response against a key.
+	SetContext(ctx context.Context, key string, responseBytes []byte) error
+	// DeleteContext removes the value associated with the key.
+	DeleteContext(ctx context.Context, key string) error
+}
+
+// CacheWithExpiry is an optional interface implemented by Cache backends
+// that can expire entries natively (e.g. Redis).
+//
+// When storing a response in a CacheWithExpiry, the Transport passes down how long
+// the response is worth keeping, computed from its freshness lifetime, any
+// stale-while-revalidate and stale-if-error windows and Transport.ExpiryGrace.
+type CacheWithExpiry interface {
+	Cache
+	// SetWithTTL stores the []byte representation of a response against a key,
+	// to be removed after ttl.
+	SetWithTTL(key string, responseBytes []byte, ttl time.Duration)
+}
+
+// CacheWithExpiryContext is an optional interface implemented by CacheWithExpiry
+// backends that can report the errors of SetWithTTL, so the failed writes are
+// retried like those of CacheContext.SetContext, see Transport.WriteRetries.
+type CacheWithExpiryContext interface {
+	CacheWithExpiry
+	// SetWithTTLContext is like SetWithTTL, returning the error storing the response.
+	SetWithTTLContext(ctx context.Context, key string, responseBytes []byte, ttl time.Duration) error
+}
+
+// MetadataCache is an optional interface implemented by Cache backends that
+// store the metadata of a cached response (status line and headers) separately
+// from its body, e.g. in a small file next to the body file.
+//
+// When the Transport's Cache implements MetadataCache, the metadata of a response is
+// stored with SetMetadata and its body with Set, so a revalidation of an unchanged
+// response only rewrites the metadata. Delete must remove both.
+// Values stored with Set only, as done by earlier versions, are still read.
+//
+// Bodies up to Transport.InlineBodyBytes are stored with the metadata,
+// so reading them back takes a single GetMetadata.
+type MetadataCache interface {
+	Cache
+	// GetMetadata returns the metadata stored for key and a bool set to false
+	// if the key is not found.
+	GetMetadata(key string) (metadata []byte, ok bool)
+	// SetMetadata stores the metadata for key, leaving the body stored for key as is.
+	SetMetadata(key string, metadata []byte)
+}
+
+// EntryUpdater is an optional interface implemented by Cache backends storing
+// each response as a single value that can update a value in place, atomically,
+// e.g. an in-memory cache or a database.
+//
+// When the Transport's Cache implements EntryUpdater, but not MetadataCache or
+// CacheWithExpiry, the metadata of a cached response is updated with UpdateEntry,
+// e.g. after a 304 Not Modified, instead of storing the response again in full
+// once its body has been read, so concurrent writers of the same key
+// can't overwrite the update with stale content.
+type EntryUpdater interface {
+	Cache
+	// UpdateEntry replaces the value stored for key with the value returned by update,
+	// called with the current value, unless it returns nil. It reports false if
+	// no value is stored for key, or update returned nil.
+	// update must not call the Cache.
+	UpdateEntry(key string, update func(value []byte) []byte) bool
+}
+
+// AdaptCache returns c as a CacheContext.
+// If c implements CacheContext, it is returned as is,
+// otherwise its methods are wrapped, ignoring the context and never failing.
+func AdaptCache(c Cache) CacheContext {
+	if cc, ok := c.(CacheContext); ok {
+		return cc
+	}
+	return cacheAdapter{c}
+}
+
+type cacheAdapter struct {
+	c Cache
+}
+
+func (a cacheAdapter) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	b, ok := a.c.Get(key)
+	return b, ok, nil
+}
+
+func (a cacheAdapter) SetContext(ctx context.Context, key string, responseBytes []byte) error {
+	a.c.Set(key, responseBytes)
+	return nil
+}
+
+func (a cacheAdapter) DeleteContext(ctx context.Context, key string) error {
+	a.c.Delete(key)
+	return nil
+}
+
+// FromCacheContext returns a Cache backed by c, which can be set as the
+// Cache of a Transport.
+// The returned Cache also implements CacheContext, so the Transport will
+// still pass on contexts and see errors.
+// The methods of Cache use context.Background and treat errors as misses.
+func FromCacheContext(c CacheContext) Cache {
+	return contextCache{c}
+}
+
+type contextCache struct {
+	CacheContext
+}
+
+func (c contextCache) Get(key string) ([]byte, bool) {
+	b, ok, err := c.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return b, ok
+}
+
+func (c contextCache) Set(key string, responseBytes []byte) {
+	c.SetContext(context.Background(), key, responseBytes)
+}
+
+func (c contextCache) Delete(key string) {
+	c.DeleteContext(context.Background(), key)
+}
+
+// cacheGet gets the value for key from the cache.
+// Backend errors are treated as misses.
+// If the cache is a MetadataCache, the metadata and the body are joined.
+func (t *Transport) cacheGet(ctx context.Context, key string) ([]byte, bool) {
+	b, ok, _ := t.cacheGetErr(ctx, key)
+	return b, ok
+}
+
+// cacheGetErr is like cacheGet, but also returns the backend error, if any.
+func (t *Transport) cacheGetErr(ctx context.Context, key string) ([]byte, bool, error) {
+	if _, ok := ctx.Value(resultKey{}).(*resultRecorder); ok {
+		start := time.Now()
+		defer func() {
+			elapsed := time.Since(start)
+			recordResult(ctx, func(r *Result) { r.CacheTime += elapsed })
+		}()
+	}
+	var (
+		b  []byte
+		ok bool
+	)
+	err := t.cacheOp(ctx, "get", key, func() error {
+		var meta []byte
+		if mc, isMetadataCache := t.Cache.(MetadataCache); isMetadataCache {
+			meta, _ = mc.GetMetadata(key)
+			if inlineBody(meta) != nil {
+				b, ok = meta, true
+				return nil
+			}
+		}
+		var err error
+		b, ok, err = AdaptCache(t.Cache).GetContext(ctx, key)
+		if err != nil || meta == nil {
+			return err
+		}
+		if !ok && len(b) == 0 {
+			return nil
+		}
+		// The body follows the metadata in an entry.
+		b = append(meta[:len(meta):len(meta)], b...)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return b, ok, nil
+}
+
+// cacheSet stores the value for key in the cache.
+// If ttl > 0 and the cache is a CacheWithExpiry, the entry is set to expire after ttl.
+func (t *Transport) cacheSet(ctx context.Context, key string, b []byte, ttl time.Duration) {
+	err := t.cacheWrite(ctx, key, b, ttl)
+	if err == ErrCacheUnavailable {
+		// Skipped, see CacheFailureThreshold.
+		t.writeDropped(key, err)
+		return
+	}
+	if err != nil {
+		t.retryWrite(key, b, ttl, err)
+		return
+	}
+	// Don't let a pending retry overwrite b.
+	t.writes.cancel(key)
+}
+
+// cacheWrite stores the value for key in the cache, to expire after ttl
+// if ttl > 0 and the cache is a CacheWithExpiry.
+func (t *Transport) cacheWrite(ctx context.Context, key string, b []byte, ttl time.Duration) error {
+	return t.cacheOp(ctx, "set", key, func() error {
+		if ttl > 0 {
+			switch c := t.Cache.(type) {
+			case CacheWithExpiryContext:
+				return c.SetWithTTLContext(ctx, key, b, ttl)
+			case CacheWithExpiry:
+				c.SetWithTTL(key, b, ttl)
+				return nil
+			}
+		}
+		return AdaptCache(t.Cache).SetContext(ctx, key, b)
+	})
+}
+
+// storeEntry stores resp with the given body for key, returning the number of bytes stored.
+// resp.Body is not used.
+func (t *Transport) storeEntry(ctx context.Context, key string, resp *http.Response, body []byte) int {
+	ttl := t.storeTTL(resp.Header)
+	if mc, ok := t.Cache.(MetadataCache); ok {
+		inline := len(body) > 0 && len(body) <= t.InlineBodyBytes
+		var meta []byte
+		if inline {
+			meta = encodeEntry(resp, body)
+		} else {
+			meta = encodeEntry(resp, nil)
+		}
+		if err := t.setMetadata(ctx, mc, key, meta); err != nil {
+			return 0
+		}
+		if inline {
+			// Drop any body stored earlier.
+			t.cacheSet(ctx, key, nil, ttl)
+			return len(meta)
+		}
+		t.cacheSet(ctx, key, body, ttl)
+		return len(meta) + len(body)
+	}
+	b := encodeEntry(resp, body)
+	t.cacheSet(ctx, key, b, ttl)
+	return len(b)
+}
+
+// storeMetadata replaces the metadata of the entry stored for key with
+// that of resp, keeping its body, returning the number of bytes stored.
+// It reports false if the cache can't store the metadata on its own,
+// and the entry needs to be stored again in full.
+func (t *Transport) storeMetadata(key string, resp *http.Response) (int, bool) {
+	if _, ok := t.Cache.(CacheWithExpiry); ok {
+		// The expiry of the body needs to be extended.
+		return 0, false
+	}
+	mc, ok := t.Cache.(MetadataCache)
+	if !ok {
+		return t.updateEntry(key, resp)
+	}
+	ctx := context.Background()
+	var old []byte
+	err := t.cacheOp(ctx, "get metadata", key, func() error {
+		old, ok = mc.GetMetadata(key)
+		return nil
+	})
+	if err != nil || !ok {
+		// Stored in full.
+		return 0, false
+	}
+	// Keep the body if inlined.
+	meta := encodeEntry(resp, inlineBody(old))
+	if err := t.setMetadata(ctx, mc, key, meta); err != nil {
+		return 0, false
+	}
+	return len(meta), true
+}
+
+// setMetadata stores the metadata meta for key in mc.
+func (t *Transport) setMetadata(ctx context.Context, mc MetadataCache, key string, meta []byte) error {
+	return t.cacheOp(ctx, "set metadata", key, func() error {
+		mc.SetMetadata(key, meta)
+		return nil
+	})
+}
+
+// updateEntry replaces the metadata of the entry stored for key with that of resp,
+// keeping its body, if the Cache is an EntryUpdater, returning the number of bytes stored.
+func (t *Transport) updateEntry(key string, resp *http.Response) (int, bool) {
+	eu, ok := t.Cache.(EntryUpdater)
+	if !ok {
+		return 0, false
+	}
+	var n int
+	err := t.cacheOp(context.Background(), "update", key, func() error {
+		ok = eu.UpdateEntry(key, func(old []byte) []byte {
+			e, err := decodeEntry(old)
+			if err != nil {
+				return nil
+			}
+			b := encodeEntry(resp, e.body)
+			n = len(b)
+			return b
+		})
+		return nil
+	})
+	return n, err == nil && ok
+}
+
+// inlinesBody reports whether the body of resp is known to be small enough
+// to be stored with its metadata.
+func (t *Transport) inlinesBody(resp *http.Response) bool {
+	return t.InlineBodyBytes > 0 && resp.ContentLength >= 0 && resp.ContentLength <= int64(t.InlineBodyBytes)
+}
+
+// inlineBody returns the body stored with the metadata meta, nil if there is none.
+func inlineBody(meta []byte) []byte {
+	if len(meta) == 0 {
+		return nil
+	}
+	e, err := decodeEntry(meta)
+	if err != nil || len(e.body) == 0 {
+		return nil
+	}
+	return e.body
+}
+
+// storeTTL returns how long a response with the headers respHeaders is worth
+// keeping in the cache, or 0 if it should be kept until replaced.
+func (t *Transport) storeTTL(respHeaders http.Header) time.Duration {
+	if isPinned(respHeaders) {
+		return 0
+	}
+	retention, ok := t.retention(respHeaders)
+	if !ok {
+		return 0
+	}
+	if hasValidators(respHeaders) && t.ExpiryGrace <= 0 {
+		// Stale responses with validators can still be revalidated.
+		return 0
+	}
+	date, _ := date(respHeaders)
+	ttl := retention - t.timer().since(date)
+	if ttl < time.Second {
+		// Already stale, keep it briefly for requests accepting stale responses.
+		ttl = time.Second
+	}
+	return ttl
+}
+
+// retention returns how long after its date a response with the headers respHeaders
+// is worth keeping in the cache: its freshness lifetime, extended by the longest of
+// its stale-while-revalidate and stale-if-error windows, StaleWhileRevalidate and
+// MaxStaleOnError, plus ExpiryGrace if it has validators, so it can still be revalidated.
+// It reports false if the response has no Date header, or is served stale on
+// errors without a MaxStaleOnError limit, i.e. is worth keeping indefinitely.
+func (t *Transport) retention(respHeaders http.Header) (time.Duration, bool) {
+	date, err := date(respHeaders)
+	if err != nil {
+		return 0, false
+	}
+	respCacheControl := parseCacheControl(respHeaders)
+	lifetime := freshnessLifetime(respHeaders, respCacheControl, date, t.defaultLifetime(respHeaders), t.Shared)
+	if extended := extendedLifetime(respHeaders); extended > lifetime {
+		lifetime = extended
+	}
+	staleWindow := t.StaleWhileRevalidate
+	if t.staleOnError(respCacheControl) {
+		if t.MaxStaleOnError <= 0 {
+			return 0, false
+		}
+		if t.MaxStaleOnError > staleWindow {
+			staleWindow = t.MaxStaleOnError
+		}
+	}
+	for _, directive := range []string{"stale-while-revalidate", "stale-if-error"} {
+		if secs, err := strconv.ParseUint(respCacheControl[directive], 10, 32); err == nil {
+			if d := time.Duration(secs) * time.Second; d > staleWindow {
+				staleWindow = d
+			}
+		}
+	}
+	retention := lifetime + staleWindow
+	if hasValidators(respHeaders) {
+		retention += t.ExpiryGrace
+	}
+	return retention, true
+}
+
+// hasValidators reports whether a response with the headers respHeaders
+// has an ETag or a Last-Modified date to revalidate it with.
+func hasValidators(respHeaders http.Header) bool {
+	return respHeaders.Get("etag") != "" || respHeaders.Get("last-modified") != ""
+}
+
+// cacheDelete removes the value for key from the cache,
+// along with any write of key waiting to be retried.
+func (t *Transport) cacheDelete(ctx context.Context, key string) error {
+	t.writes.cancel(key)
+	return t.cacheOp(ctx, "delete", key, func() error {
+		return AdaptCache(t.Cache).DeleteContext(ctx, key)
+	})
+}
@@ -0,0 +1,438 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// contextMemoryCache is a CacheContext recording the context values it sees.
+type contextMemoryCache struct {
+	mu     sync.Mutex
+	items  map[string][]byte
+	values []any
+	err    error
+}
+
+func (c *contextMemoryCache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = append(c.values, ctx.Value(ctxKey("id")))
+	if c.err != nil {
+		return nil, false, c.err
+	}
+	b, ok := c.items[key]
+	return b, ok, nil
+}
+
+func (c *contextMemoryCache) SetContext(ctx context.Context, key string, b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = append(c.values, ctx.Value(ctxKey("id")))
+	if c.err != nil {
+		return c.err
+	}
+	c.items[key] = b
+	return nil
+}
+
+func (c *contextMemoryCache) DeleteContext(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = append(c.values, ctx.Value(ctxKey("id")))
+	delete(c.items, key)
+	return c.err
+}
+
+func TestCacheContext(t *testing.T) {
+	c := qt.New(t)
+	cache := &contextMemoryCache{items: map[string][]byte{}}
+	tp := &Transport{Cache: FromCacheContext(cache)}
+	client := http.Client{Transport: tp}
+
+	get := func(id string) *http.Response {
+		ctx := context.WithValue(context.Background(), ctxKey("id"), id)
+		req, err := http.NewRequestWithContext(ctx, "GET", s.server.URL+"/method", nil)
+		c.Assert(err, qt.IsNil)
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get("a")
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusMiss)
+	resp = get("b")
+	status, _ = ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(cache.values, qt.DeepEquals, []any{"a", "a", "b"})
+
+	// Backend errors are treated as misses.
+	cache.err = errors.New("backend down")
+	resp = get("c")
+	status, _ = ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusMiss)
+
+	// The legacy methods work too.
+	cache.err = nil
+	_, ok := tp.Cache.Get(s.server.URL + "/method")
+	c.Assert(ok, qt.IsTrue)
+}
+
+func TestAdaptCache(t *testing.T) {
+	c := qt.New(t)
+	mc := newMemoryCache()
+	cc := AdaptCache(mc)
+	c.Assert(cc.SetContext(context.Background(), "foo", []byte("bar")), qt.IsNil)
+	b, ok, err := cc.GetContext(context.Background(), "foo")
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "bar")
+	c.Assert(cc.DeleteContext(context.Background(), "foo"), qt.IsNil)
+	c.Assert(mc.Size(), qt.Equals, 0)
+
+	cache := &contextMemoryCache{items: map[string][]byte{}}
+	c.Assert(AdaptCache(FromCacheContext(cache)), qt.Equals, CacheContext(contextCache{cache}))
+}
+
+// expiringCache is a CacheWithExpiry recording the TTLs it sees.
+type expiringCache struct {
+	*memoryCache
+	mu   sync.Mutex
+	ttls map[string]time.Duration
+}
+
+func (c *expiringCache) SetWithTTL(key string, b []byte, ttl time.Duration) {
+	c.mu.Lock()
+	c.ttls[key] = ttl
+	c.mu.Unlock()
+	c.Set(key, b)
+}
+
+func TestCacheWithExpiry(t *testing.T) {
+	c := qt.New(t)
+	cache := &expiringCache{memoryCache: newMemoryCache(), ttls: map[string]time.Duration{}}
+	tp := &Transport{Cache: cache}
+	client := http.Client{Transport: tp}
+
+	var cacheControl, etag string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", cacheControl)
+		if etag != "" {
+			w.Header().Set("Etag", etag)
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	get := func(path string) {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+	}
+	ttl := func(path string) time.Duration {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		// Round off the time elapsed since the Date header.
+		return cache.ttls[ts.URL+path].Round(time.Minute)
+	}
+
+	cacheControl = "max-age=3600"
+	get("/a")
+	c.Assert(ttl("/a"), qt.Equals, time.Hour)
+
+	cacheControl = "max-age=3600, stale-while-revalidate=600, stale-if-error=60"
+	get("/b")
+	c.Assert(ttl("/b"), qt.Equals, 70*time.Minute)
+
+	// With validators, kept until replaced.
+	etag = `"abc"`
+	get("/c")
+	_, found := cache.ttls[ts.URL+"/c"]
+	c.Assert(found, qt.IsFalse)
+	_, found = cache.Get(ts.URL + "/c")
+	c.Assert(found, qt.IsTrue)
+
+	tp.ExpiryGrace = 24 * time.Hour
+	get("/d")
+	c.Assert(ttl("/d"), qt.Equals, 25*time.Hour+10*time.Minute)
+
+	// Kept while it may be served stale on errors.
+	etag = ""
+	tp.ExpiryGrace = 0
+	tp.ServeStaleOnError = true
+	tp.MaxStaleOnError = 2 * time.Hour
+	get("/e")
+	c.Assert(ttl("/e"), qt.Equals, 3*time.Hour)
+
+	cacheControl = "max-age=3600, must-revalidate"
+	get("/f")
+	c.Assert(ttl("/f"), qt.Equals, time.Hour)
+
+	tp.MaxStaleOnError = 0
+	cacheControl = "max-age=3600"
+	get("/g")
+	_, found = cache.ttls[ts.URL+"/g"]
+	c.Assert(found, qt.IsFalse)
+}
+
+// metadataCache is a MetadataCache counting the writes of bodies and metadata.
+type metadataCache struct {
+	*memoryCache
+	mu         sync.Mutex
+	meta       map[string][]byte
+	bodyGets   int
+	bodySets   int
+	metaWrites int
+}
+
+func (c *metadataCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	c.bodyGets++
+	c.mu.Unlock()
+	return c.memoryCache.Get(key)
+}
+
+func (c *metadataCache) Set(key string, b []byte) {
+	c.mu.Lock()
+	c.bodySets++
+	c.mu.Unlock()
+	c.memoryCache.Set(key, b)
+}
+
+func (c *metadataCache) Delete(key string) {
+	c.mu.Lock()
+	delete(c.meta, key)
+	c.mu.Unlock()
+	c.memoryCache.Delete(key)
+}
+
+func (c *metadataCache) GetMetadata(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.meta[key]
+	return b, ok
+}
+
+func (c *metadataCache) SetMetadata(key string, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metaWrites++
+	c.meta[key] = b
+}
+
+func TestMetadataCache(t *testing.T) {
+	c := qt.New(t)
+	cache := &metadataCache{memoryCache: newMemoryCache(), meta: map[string][]byte{}}
+	tp := &Transport{Cache: cache}
+	client := http.Client{Transport: tp}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Etag", `"abc"`)
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			w.Header().Set("X-Version", "2")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("X-Version", "1")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	get := func() (string, string, CacheStatus) {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), resp.Header.Get("X-Version"), status
+	}
+
+	body, version, status := get()
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(body, qt.Equals, "content")
+	c.Assert(version, qt.Equals, "1")
+	c.Assert(cache.bodySets, qt.Equals, 1)
+	c.Assert(cache.metaWrites, qt.Equals, 1)
+	// The body is stored as is.
+	b, _ := cache.memoryCache.Get(ts.URL)
+	c.Assert(string(b), qt.Equals, "content")
+
+	for i := 0; i < 2; i++ {
+		body, version, status = get()
+		c.Assert(status, qt.Equals, StatusRevalidated)
+		c.Assert(body, qt.Equals, "content")
+		c.Assert(version, qt.Equals, "2")
+	}
+	c.Assert(cache.bodySets, qt.Equals, 1)
+	c.Assert(cache.metaWrites, qt.Equals, 3)
+
+	info, ok := tp.EntryInfo(ts.URL)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(info.LastValidation, qt.Equals, ValidationNotModified)
+
+	// Entries stored in full are still read.
+	cache.Delete(ts.URL)
+	tp.Cache = cache.memoryCache
+	get()
+	tp.Cache = cache
+	body, _, status = get()
+	c.Assert(status, qt.Equals, StatusRevalidated)
+	c.Assert(body, qt.Equals, "content")
+	c.Assert(cache.bodySets, qt.Equals, 2)
+	c.Assert(cache.metaWrites, qt.Equals, 4)
+	body, _, _ = get()
+	c.Assert(body, qt.Equals, "content")
+	c.Assert(cache.bodySets, qt.Equals, 2)
+	c.Assert(cache.metaWrites, qt.Equals, 5)
+}
+
+// updatingCache is an EntryUpdater counting the values set and updated.
+type updatingCache struct {
+	*memoryCache
+	sets    int
+	updates int
+}
+
+func (c *updatingCache) Set(key string, b []byte) {
+	c.sets++
+	c.memoryCache.Set(key, b)
+}
+
+func (c *updatingCache) UpdateEntry(key string, update func(value []byte) []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	b := update(old)
+	if b == nil {
+		return false
+	}
+	c.updates++
+	c.items[key] = b
+	return true
+}
+
+func TestEntryUpdater(t *testing.T) {
+	c := qt.New(t)
+	cache := &updatingCache{memoryCache: newMemoryCache()}
+	tp := &Transport{Cache: cache}
+
+	var version int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version++
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Etag", `"abc"`)
+		w.Header().Set("X-Version", strconv.Itoa(version))
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	roundTrip := func() *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		resp, err := tp.RoundTrip(req)
+		c.Assert(err, qt.IsNil)
+		return resp
+	}
+
+	resp := roundTrip()
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(cache.sets, qt.Equals, 1)
+
+	// Updated on the 304, without reading the body.
+	resp = roundTrip()
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusRevalidated)
+	resp.Body.Close()
+	c.Assert(cache.sets, qt.Equals, 1)
+	c.Assert(cache.updates, qt.Equals, 1)
+
+	resp = roundTrip()
+	b, err := io.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(string(b), qt.Equals, "content")
+	c.Assert(resp.Header.Get("X-Version"), qt.Equals, "3")
+	c.Assert(cache.sets, qt.Equals, 1)
+	c.Assert(cache.updates, qt.Equals, 2)
+
+	info, ok := tp.EntryInfo(ts.URL)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(info.LastValidation, qt.Equals, ValidationNotModified)
+}
+
+func TestInlineBody(t *testing.T) {
+	c := qt.New(t)
+	cache := &metadataCache{memoryCache: newMemoryCache(), meta: map[string][]byte{}}
+	tp := &Transport{Cache: cache, InlineBodyBytes: 10}
+	client := http.Client{Transport: tp}
+
+	content := "small"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Etag", `"`+content+`"`)
+		if r.Header.Get("If-None-Match") == `"`+content+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	get := func() (string, CacheStatus) {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	body, status := get()
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(body, qt.Equals, "small")
+	b, _ := cache.memoryCache.Get(ts.URL)
+	c.Assert(b, qt.HasLen, 0)
+
+	cache.bodyGets = 0
+	for i := 0; i < 2; i++ {
+		body, status = get()
+		c.Assert(status, qt.Equals, StatusRevalidated)
+		c.Assert(body, qt.Equals, "small")
+	}
+	c.Assert(cache.bodyGets, qt.Equals, 0)
+
+	// Too large to be inlined.
+	content = "larger content"
+	body, _ = get()
+	c.Assert(body, qt.Equals, "larger content")
+	b, _ = cache.memoryCache.Get(ts.URL)
+	c.Assert(string(b), qt.Equals, "larger content")
+	body, status = get()
+	c.Assert(status, qt.Equals, StatusRevalidated)
+	c.Assert(body, qt.Equals, "larger content")
+	c.Assert(cache.bodyGets > 0, qt.IsTrue)
+}
@@ -0,0 +1,148 @@
+// Package cacheconfig maps a simple configuration, in the shape Hugo exposes
+// to its users for its remote resource cache, onto an httpcache.Transport
+// backed by a disk or in-memory cache, so projects embedding the cache behave
+// consistently with little glue code.
+package cacheconfig
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gohugoio/httpcache"
+	"github.com/gohugoio/httpcache/diskcache"
+	"github.com/gohugoio/httpcache/lrucache"
+)
+
+// Config configures a Transport.
+type Config struct {
+	// Dir is the directory of the disk cache.
+	// If empty, responses are cached in memory.
+	Dir string
+
+	// MaxSize is the maximum total size in bytes of the cache.
+	// If 0, there is no limit.
+	MaxSize int64
+
+	// Offline serves responses from the cache only, without contacting the servers.
+	Offline bool
+
+	// Rules adjusts the caching of the URLs they match.
+	// The first matching rule applies.
+	Rules []Rule
+}
+
+// Rule adjusts the caching of the URLs matching a glob pattern.
+type Rule struct {
+	// URL is the glob pattern of the URLs the rule applies to, e.g. "https://example.org/**".
+	// A * matches any sequence of characters other than /, a ** any sequence
+	// of characters, and a ? any single character other than /.
+	URL string
+
+	// MaxAge is how long cached responses are considered fresh, at least,
+	// regardless of what the server says, see httpcache.Options.MinTTL.
+	// If 0, the caching headers of the server are followed.
+	// If < 0, responses are not cached.
+	MaxAge time.Duration
+
+	// Revalidate makes every use of a cached response, fresh or not, go through
+	// a conditional request to the server, e.g. for feeds and manifests that
+	// must never be outdated, while still saving the bandwidth of 304 Not Modified
+	// responses, see httpcache.Options.ForceRefresh.
+	Revalidate bool
+}
+
+// Transport is an httpcache.Transport applying the Config it was created with.
+type Transport struct {
+	*httpcache.Transport
+
+	rules []rule
+}
+
+type rule struct {
+	re         *regexp.Regexp
+	maxAge     time.Duration
+	revalidate bool
+}
+
+// New returns a new Transport configured by cfg.
+func New(cfg Config) (*Transport, error) {
+	var cache httpcache.Cache
+	if cfg.Dir != "" {
+		c, err := diskcache.New(cfg.Dir, diskcache.Options{MaxSize: cfg.MaxSize})
+		if err != nil {
+			return nil, err
+		}
+		cache = c
+	} else {
+		cache = lrucache.New(lrucache.Options{MaxSize: cfg.MaxSize})
+	}
+
+	t := &Transport{
+		Transport: &httpcache.Transport{
+			Cache:               cache,
+			MarkCachedResponses: true,
+			Offline:             cfg.Offline,
+		},
+	}
+	for _, r := range cfg.Rules {
+		re, err := compileGlob(r.URL)
+		if err != nil {
+			return nil, err
+		}
+		t.rules = append(t.rules, rule{re: re, maxAge: r.MaxAge, revalidate: r.Revalidate})
+	}
+	return t, nil
+}
+
+// RoundTrip implements http.RoundTripper, sending req through the
+// httpcache.Transport with the options of the first Rule matching its URL.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r, ok := t.rule(req.URL.String()); ok {
+		opts := httpcache.Options{MinTTL: r.maxAge, ForceRefresh: r.revalidate}
+		if r.maxAge < 0 {
+			opts = httpcache.Options{BypassCache: true}
+		}
+		req = req.WithContext(httpcache.WithOptions(req.Context(), opts))
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// Client returns an *http.Client using t.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func (t *Transport) rule(u string) (rule, bool) {
+	for _, r := range t.rules {
+		if r.re.MatchString(u) {
+			return r, true
+		}
+	}
+	return rule{}, false
+}
+
+// compileGlob compiles the glob pattern to a regular expression matching the whole string.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,133 @@
+package cacheconfig
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/gohugoio/httpcache"
+)
+
+func TestTransport(t *testing.T) {
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	dir := c.TempDir()
+	cfg := Config{
+		Dir: dir,
+		Rules: []Rule{
+			{URL: ts.URL + "/nocache/**", MaxAge: -1},
+			{URL: ts.URL + "/*.json", MaxAge: time.Hour},
+		},
+	}
+	tp, err := New(cfg)
+	c.Assert(err, qt.IsNil)
+	client := tp.Client()
+
+	get := func(path string) (string, httpcache.CacheStatus) {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := httpcache.ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	for _, path := range []string{"/data.json", "/page.html", "/nocache/a/b.json"} {
+		body, status := get(path)
+		c.Assert(body, qt.Equals, path)
+		c.Assert(status, qt.Equals, httpcache.StatusMiss)
+	}
+	c.Assert(requests, qt.Equals, 3)
+
+	_, status := get("/data.json")
+	c.Assert(status, qt.Equals, httpcache.StatusHit)
+	_, status = get("/page.html")
+	c.Assert(status, qt.Not(qt.Equals), httpcache.StatusHit)
+	_, status = get("/nocache/a/b.json")
+	c.Assert(status, qt.Equals, httpcache.StatusMiss)
+	c.Assert(requests, qt.Equals, 5)
+
+	// The cache survives in Dir.
+	cfg.Offline = true
+	tp, err = New(cfg)
+	c.Assert(err, qt.IsNil)
+	client = tp.Client()
+	body, status := get("/page.html")
+	c.Assert(body, qt.Equals, "/page.html")
+	c.Assert(status, qt.Equals, httpcache.StatusStale)
+	c.Assert(requests, qt.Equals, 5)
+}
+
+func TestRevalidate(t *testing.T) {
+	c := qt.New(t)
+
+	var requests, notModified int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Etag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	tp, err := New(Config{Rules: []Rule{{URL: ts.URL + "/feeds/**", Revalidate: true}}})
+	c.Assert(err, qt.IsNil)
+	client := tp.Client()
+
+	get := func(path string) httpcache.CacheStatus {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(string(b), qt.Equals, path)
+		status, _ := httpcache.ResponseCacheStatus(resp)
+		return status
+	}
+
+	for _, path := range []string{"/feeds/index.xml", "/page.html"} {
+		c.Assert(get(path), qt.Equals, httpcache.StatusMiss)
+	}
+	// Fresh, but revalidated.
+	c.Assert(get("/feeds/index.xml"), qt.Equals, httpcache.StatusRevalidated)
+	c.Assert(get("/page.html"), qt.Equals, httpcache.StatusHit)
+	c.Assert(requests, qt.Equals, 3)
+	c.Assert(notModified, qt.Equals, 1)
+}
+
+func TestCompileGlob(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"https://example.org/**", "https://example.org/a/b", true},
+		{"https://example.org/*", "https://example.org/a/b", false},
+		{"https://example.org/*.json", "https://example.org/a.json", true},
+		{"https://example.org/?.json", "https://example.org/ab.json", false},
+		{"https://example.org/é/*", "https://example.org/é/a", true},
+		{"https://example.org/a+b", "https://example.org/aab", false},
+	} {
+		re, err := compileGlob(test.pattern)
+		c.Assert(err, qt.IsNil)
+		c.Assert(re.MatchString(test.s), qt.Equals, test.match, qt.Commentf("%s %s", test.pattern, test.s))
+	}
+}
@@ -0,0 +1,86 @@
+package httpcache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// canonicalizeHeaders rewrites the caching headers of a response in a canonical form,
+// see Transport.CanonicalizeHeaders.
+func canonicalizeHeaders(h http.Header) {
+	for _, k := range []string{"Cache-Control", "Pragma"} {
+		if len(h[k]) > 0 {
+			h[k] = joinDirectives(h[k])
+		}
+	}
+	if len(h["Vary"]) > 0 {
+		h["Vary"] = joinVary(h["Vary"])
+	}
+}
+
+// joinDirectives returns the directives of the header values vals joined in
+// a single value, with their names lowercased, dropping repeated directives
+// but the first one.
+func joinDirectives(vals []string) []string {
+	var (
+		directives []string
+		seen       = make(map[string]bool)
+	)
+	for _, val := range vals {
+		for _, d := range strings.Split(val, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			name, value, hasValue := strings.Cut(d, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if hasValue {
+				name += "=" + strings.TrimSpace(value)
+			}
+			directives = append(directives, name)
+		}
+	}
+	return []string{strings.Join(directives, ", ")}
+}
+
+// joinVary returns the header names listed in the Vary header values vals
+// joined in a single value, canonicalized and without duplicates, or "*"
+// if listed.
+func joinVary(vals []string) []string {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+	for _, val := range vals {
+		for _, name := range strings.Split(val, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return []string{"*"}
+			}
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return []string{strings.Join(names, ", ")}
+}
+
+// canonicalTransport is a http.RoundTripper canonicalizing the headers of the
+// responses received, see Transport.CanonicalizeHeaders.
+type canonicalTransport struct {
+	http.RoundTripper
+}
+
+func (t canonicalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil && resp.Header != nil {
+		canonicalizeHeaders(resp.Header)
+	}
+	return resp, err
+}
@@ -0,0 +1,60 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCanonicalizeHeaders(t *testing.T) {
+	c := qt.New(t)
+	h := http.Header{
+		"Cache-Control": {"public", "Max-Age=60, max-age=0", " stale-if-error = 300 "},
+		"Pragma":        {"no-cache"},
+		"Vary":          {"accept-encoding", "Accept-Encoding, origin", "", "Accept"},
+		"Etag":          {`"a"`},
+	}
+	canonicalizeHeaders(h)
+	c.Assert(h, qt.DeepEquals, http.Header{
+		"Cache-Control": {"public, max-age=60, stale-if-error=300"},
+		"Pragma":        {"no-cache"},
+		"Vary":          {"Accept-Encoding, Origin, Accept"},
+		"Etag":          {`"a"`},
+	})
+
+	h = http.Header{"Vary": {"Origin", "*"}}
+	canonicalizeHeaders(h)
+	c.Assert(h["Vary"], qt.DeepEquals, []string{"*"})
+}
+
+func TestCanonicalizeHeadersTransport(t *testing.T) {
+	c := qt.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Cache-Control", "public")
+		w.Header().Add("Cache-Control", "max-age=3600")
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	for _, canonicalize := range []bool{false, true} {
+		tp := &Transport{Cache: newMemoryCache(), Clock: &offsetClock{}, CanonicalizeHeaders: canonicalize}
+		var status CacheStatus
+		for i := 0; i < 2; i++ {
+			resp, err := (&http.Client{Transport: tp}).Get(ts.URL)
+			c.Assert(err, qt.IsNil)
+			_, err = io.ReadAll(resp.Body)
+			c.Assert(err, qt.IsNil)
+			resp.Body.Close()
+			status, _ = ResponseCacheStatus(resp)
+		}
+		if canonicalize {
+			c.Assert(status, qt.Equals, StatusHit)
+		} else {
+			// Only the first Cache-Control header is used.
+			c.Assert(status, qt.Equals, StatusMiss)
+		}
+	}
+}
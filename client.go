@@ -0,0 +1,104 @@
+package httpcache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Client is a http.Client sending its requests through a Transport, with helpers
+// reading the response bodies, so the responses get cached, and reporting how
+// they were answered in a GetResult rather than in headers such as X-From-Cache.
+type Client struct {
+	// Client is the http.Client used, e.g. to set a Timeout or a CheckRedirect func.
+	// Its Transport is the one given to NewClient and should not be changed.
+	http.Client
+
+	t *Transport
+}
+
+// NewClient returns a Client sending its requests through t.
+func NewClient(t *Transport) *Client {
+	return &Client{Client: http.Client{Transport: t}, t: t}
+}
+
+// GetResult describes the response to a GET request sent with a Client.
+type GetResult struct {
+	// StatusCode and Header are those of the response, after following redirects.
+	StatusCode int
+	Header     http.Header
+
+	// Result describes how the Transport answered the last request,
+	// following the redirects, if any: its Status tells whether the response
+	// was served from the cache, and its Age how old it is.
+	Result Result
+}
+
+// GetBytes sends a GET request for url and returns the response body.
+// As with http.Get, a response with an error status is not an error.
+func (c *Client) GetBytes(ctx context.Context, url string) ([]byte, GetResult, error) {
+	return c.getBytes(ctx, url)
+}
+
+// GetWithTTL is like GetBytes, but the cached response, if any, is served if
+// it is younger than ttl, regardless of its Cache-Control and Expires headers,
+// and revalidated with the server otherwise, see Options.MinTTL and Options.MaxAge.
+func (c *Client) GetWithTTL(ctx context.Context, url string, ttl time.Duration) ([]byte, GetResult, error) {
+	opts, _ := ctx.Value(optionsKey{}).(Options)
+	opts.MinTTL = ttl
+	return c.getBytes(WithMaxAge(WithOptions(ctx, opts), ttl), url)
+}
+
+// GetJSON sends a GET request for url and decodes the JSON response body into v.
+// Unlike GetBytes, it returns an error for responses with a status other than 2xx,
+// their body is not decoded.
+func (c *Client) GetJSON(ctx context.Context, url string, v any) (GetResult, error) {
+	resp, result, err := c.get(ctx, url)
+	if err != nil {
+		return result, err
+	}
+	defer closeBody(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return result, fmt.Errorf("httpcache: GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return result, fmt.Errorf("httpcache: GET %s: %w", url, err)
+	}
+	return result, nil
+}
+
+func (c *Client) getBytes(ctx context.Context, url string) ([]byte, GetResult, error) {
+	resp, result, err := c.get(ctx, url)
+	if err != nil {
+		return nil, result, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return b, result, err
+}
+
+// get sends a GET request for url, the caller must close the response body.
+func (c *Client) get(ctx context.Context, url string) (*http.Response, GetResult, error) {
+	var result GetResult
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, result, err
+	}
+	client := c.Client
+	client.Transport = &resultTransport{t: c.t, result: &result.Result}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, result, err
+	}
+	result.StatusCode, result.Header = resp.StatusCode, resp.Header
+	return resp, result, nil
+}
+
+// closeBody reads what is left of body, so the response is cached, before closing it.
+func closeBody(body io.ReadCloser) {
+	io.Copy(io.Discard, body)
+	body.Close()
+}
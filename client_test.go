@@ -0,0 +1,69 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestClient(t *testing.T) {
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		case "/nocache":
+			w.Header().Set("Cache-Control", "no-cache")
+		default:
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		// Trailing content not read by the JSON decoder.
+		w.Write([]byte(`{"path": "` + r.URL.Path + `"}` + "\n\n"))
+	}))
+	defer ts.Close()
+
+	client := NewClient(newMemoryCacheTransport())
+	ctx := context.Background()
+
+	var v struct{ Path string }
+	result, err := client.GetJSON(ctx, ts.URL+"/a", &v)
+	c.Assert(err, qt.IsNil)
+	c.Assert(v.Path, qt.Equals, "/a")
+	c.Assert(result.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(result.Header.Get("Content-Type"), qt.Equals, "application/json")
+	c.Assert(result.Result.Status, qt.Equals, StatusMiss)
+
+	// The body was drained, so the response was cached.
+	b, result, err := client.GetBytes(ctx, ts.URL+"/a")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(b), qt.Equals, `{"path": "/a"}`+"\n\n")
+	c.Assert(result.Result.Status, qt.Equals, StatusHit)
+	c.Assert(requests, qt.Equals, 1)
+
+	_, err = client.GetJSON(ctx, ts.URL+"/missing", &v)
+	c.Assert(err, qt.ErrorMatches, `httpcache: GET .*/missing: 404 Not Found`)
+	b, result, err = client.GetBytes(ctx, ts.URL+"/missing")
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.StatusCode, qt.Equals, http.StatusNotFound)
+	c.Assert(string(b), qt.Contains, "not found")
+
+	requests = 0
+	for i := 0; i < 2; i++ {
+		_, result, err = client.GetWithTTL(ctx, ts.URL+"/nocache", time.Hour)
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(result.Result.Status, qt.Equals, StatusHit)
+	c.Assert(requests, qt.Equals, 1)
+
+	_, _, err = client.GetBytes(ctx, "://invalid")
+	c.Assert(err, qt.IsNotNil)
+}
@@ -0,0 +1,95 @@
+package httpcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of the current time of a Transport, see Transport.Clock.
+// Implementations must be safe for concurrent use.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Since returns the time elapsed since t.
+	Since(t time.Time) time.Duration
+}
+
+type timer interface {
+	now() time.Time
+	since(d time.Time) time.Duration
+}
+
+// clock is the timer of the Transports without a Clock, the system clock.
+// All the freshness computations get their timer from Transport.timer.
+var clock timer = &realClock{}
+
+// clockTimer adapts a Clock to a timer.
+type clockTimer struct {
+	c Clock
+}
+
+func (c clockTimer) now() time.Time                  { return c.c.Now() }
+func (c clockTimer) since(d time.Time) time.Duration { return c.c.Since(d) }
+
+// timer returns the timer measuring the age of responses and failures.
+func (t *Transport) timer() timer {
+	if t.Clock != nil {
+		return clockTimer{t.Clock}
+	}
+	return clock
+}
+
+// processStart is the monotonic clock reading all elapsed times are measured from.
+var processStart = time.Now()
+
+// clockJumpTolerance is how far the wall clock may fall behind the monotonic
+// clock before it's considered to have jumped backwards. Smaller differences
+// come from the wall clock being slewed by NTP.
+const clockJumpTolerance = time.Second
+
+// realClock measures the age of responses with the wall clock, the only one
+// their Date header can be compared with, but guards against it jumping
+// backwards, e.g. on an NTP correction: the current time doesn't go back, and
+// advances with the monotonic clock until the wall clock catches up.
+// Jumps forward, e.g. when a laptop resumes from sleep while the monotonic clock
+// was stopped, are followed, as the time really passed.
+type realClock struct {
+	// wall and monotonic, if set, replace the system clocks in tests.
+	wall      func() time.Time
+	monotonic func() time.Duration
+
+	// base is the latest time returned by now, in Unix nanoseconds, minus
+	// the monotonic clock reading then, 0 before the first call.
+	base atomic.Int64
+}
+
+func (c *realClock) since(d time.Time) time.Duration {
+	return c.now().Sub(d)
+}
+
+// now returns the current wall clock time, corrected for jumps backwards.
+func (c *realClock) now() time.Time {
+	wall, mono := c.read()
+	for {
+		// Retry if another call moved base in between, not to lose its correction.
+		base := c.base.Load()
+		now := wall
+		if base != 0 {
+			if floor := time.Unix(0, base+int64(mono)); floor.Sub(wall) > clockJumpTolerance {
+				// The wall clock jumped backwards.
+				now = floor
+			}
+		}
+		if c.base.CompareAndSwap(base, now.UnixNano()-int64(mono)) {
+			return now
+		}
+	}
+}
+
+func (c *realClock) read() (time.Time, time.Duration) {
+	if c.wall != nil {
+		return c.wall(), c.monotonic()
+	}
+	now := time.Now()
+	return now.Round(0), now.Sub(processStart)
+}
@@ -0,0 +1,120 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRealClock(t *testing.T) {
+	c := qt.New(t)
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var (
+		wall = start
+		mono time.Duration
+	)
+	clk := &realClock{
+		wall:      func() time.Time { return wall },
+		monotonic: func() time.Duration { return mono },
+	}
+	advance := func(wallBy, monoBy time.Duration) {
+		wall = wall.Add(wallBy)
+		mono += monoBy
+	}
+
+	c.Assert(clk.since(start), qt.Equals, time.Duration(0))
+	advance(time.Minute, time.Minute)
+	c.Assert(clk.since(start), qt.Equals, time.Minute)
+
+	// NTP steps the wall clock back an hour.
+	advance(-time.Hour+time.Minute, time.Minute)
+	c.Assert(clk.since(start), qt.Equals, 2*time.Minute)
+	advance(time.Minute, time.Minute)
+	c.Assert(clk.since(start), qt.Equals, 3*time.Minute)
+
+	// Small differences are tolerated.
+	wall = start.Add(3*time.Minute + 500*time.Millisecond)
+	advance(0, time.Second)
+	c.Assert(clk.since(start), qt.Equals, 3*time.Minute+500*time.Millisecond)
+
+	// Resuming from sleep, the monotonic clock was stopped.
+	advance(8*time.Hour, time.Second)
+	c.Assert(clk.since(start), qt.Equals, 8*time.Hour+3*time.Minute+500*time.Millisecond)
+
+	// The system clocks.
+	c.Assert((&realClock{}).since(time.Now().Add(-time.Hour)) >= time.Hour, qt.IsTrue)
+}
+
+// offsetClock is a Clock running ahead of the system clock by offset.
+type offsetClock struct {
+	offset time.Duration
+}
+
+func (c *offsetClock) Now() time.Time                  { return time.Now().Add(c.offset) }
+func (c *offsetClock) Since(t time.Time) time.Duration { return c.Now().Sub(t) }
+
+func TestTransportClock(t *testing.T) {
+	c := qt.New(t)
+	cache := newMemoryCache()
+	now := &Transport{Cache: cache, Clock: &offsetClock{}}
+	later := &Transport{Cache: cache, Clock: &offsetClock{offset: 2 * time.Hour}}
+
+	get := func(tp *Transport) CacheStatus {
+		resp, err := (&http.Client{Transport: tp}).Get(s.server.URL + "/method")
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(get(now), qt.Equals, StatusMiss)
+	c.Assert(get(now), qt.Equals, StatusHit)
+	// The same cached response is stale an hour past its max-age.
+	c.Assert(get(later), qt.Equals, StatusMiss)
+	c.Assert(get(now), qt.Equals, StatusHit)
+}
+
+func TestRealClockConcurrent(t *testing.T) {
+	c := qt.New(t)
+	clk := &realClock{}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				clk.now()
+			}
+		}()
+	}
+	wg.Wait()
+	c.Assert(clk.since(start) >= 0, qt.IsTrue)
+}
+
+func TestTransportClockValidation(t *testing.T) {
+	c := qt.New(t)
+	clk := &offsetClock{offset: 24 * time.Hour}
+	tp := &Transport{Cache: newMemoryCache(), Clock: clk}
+	client := &http.Client{Transport: tp}
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, s.server.URL+"/etag", nil)
+		c.Assert(err, qt.IsNil)
+		req.Header.Set("Cache-Control", "max-age=0")
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	info, ok := tp.EntryInfo(s.server.URL + "/etag")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(info.LastValidation, qt.Equals, ValidationNotModified)
+	c.Assert(info.LastValidated.After(time.Now().Add(time.Hour)), qt.IsTrue)
+}
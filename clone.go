@@ -0,0 +1,144 @@
+package httpcache
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Clone returns a copy of the configuration of t.
+// The internal state of t, e.g. its counters and background refreshes, is not copied.
+func (t *Transport) Clone() *Transport {
+	return &Transport{
+		Transport:                t.Transport,
+		Cache:                    t.Cache,
+		MarkCachedResponses:      t.MarkCachedResponses,
+		EnableETagPair:           t.EnableETagPair,
+		ETagPairHashLimit:        t.ETagPairHashLimit,
+		CacheKey:                 t.CacheKey,
+		HashKey:                  t.HashKey,
+		AlwaysUseCachedResponse:  t.AlwaysUseCachedResponse,
+		ShouldCache:              t.ShouldCache,
+		TransformBeforeStore:     t.TransformBeforeStore,
+		TransformAfterLoad:       t.TransformAfterLoad,
+		OnStoreSkip:              t.OnStoreSkip,
+		Around:                   t.Around,
+		TransformCachedBody:      t.TransformCachedBody,
+		PropagateHeaders:         append([]string(nil), t.PropagateHeaders...),
+		AdaptiveTTL:              t.AdaptiveTTL,
+		AdaptiveTTLMax:           t.AdaptiveTTLMax,
+		Offline:                  t.Offline,
+		OfflineError:             t.OfflineError,
+		CacheFailureThreshold:    t.CacheFailureThreshold,
+		CacheCooldown:            t.CacheCooldown,
+		StrictErrors:             t.StrictErrors,
+		HostPolicies:             cloneHostPolicies(t.HostPolicies),
+		IndexContentLocation:     t.IndexContentLocation,
+		MicroCache:               t.MicroCache,
+		Shared:                   t.Shared,
+		AuthorizationPolicy:      t.AuthorizationPolicy,
+		FollowCachedRedirects:    t.FollowCachedRedirects,
+		CacheRangeResponses:      t.CacheRangeResponses,
+		MaxVariants:              t.MaxVariants,
+		HeuristicFreshness:       t.HeuristicFreshness,
+		HeuristicFreshnessFactor: t.HeuristicFreshnessFactor,
+		HeuristicFreshnessMax:    t.HeuristicFreshnessMax,
+		InlineBodyBytes:          t.InlineBodyBytes,
+		MaxBodyBufferBytes:       t.MaxBodyBufferBytes,
+		MaxCacheableBodySize:     t.MaxCacheableBodySize,
+		MaxInFlightBufferBytes:   t.MaxInFlightBufferBytes,
+		VerifyChecksums:          t.VerifyChecksums,
+		PreserveHeaders:          t.PreserveHeaders,
+		MaxExtension:             t.MaxExtension,
+		Logger:                   t.Logger,
+		VaryOn:                   t.VaryOn,
+		CacheableMethods:         cloneStrings(t.CacheableMethods),
+		MaxRequestBodyBytes:      t.MaxRequestBodyBytes,
+		CanonicalizeHeaders:      t.CanonicalizeHeaders,
+		OmitHeaders:              append([]string(nil), t.OmitHeaders...),
+		StoreHeaders:             cloneStrings(t.StoreHeaders),
+		SWRRevalidateTimeout:     t.SWRRevalidateTimeout,
+		MaxConcurrentRefreshes:   t.MaxConcurrentRefreshes,
+		MaxQueuedRefreshes:       t.MaxQueuedRefreshes,
+		StaleWhileRevalidate:     t.StaleWhileRevalidate,
+		UpstreamRetries:          t.UpstreamRetries,
+		UpstreamRetryBackoff:     t.UpstreamRetryBackoff,
+		RetryUpstream:            t.RetryUpstream,
+		ServeStaleOnError:        t.ServeStaleOnError,
+		MaxStaleOnError:          t.MaxStaleOnError,
+		RefreshRetries:           t.RefreshRetries,
+		RefreshRetryBackoff:      t.RefreshRetryBackoff,
+		RefreshLease:             t.RefreshLease,
+		OnRefreshError:           t.OnRefreshError,
+		FailureMemo:              t.FailureMemo,
+		ExpiryGrace:              t.ExpiryGrace,
+		WriteRetries:             t.WriteRetries,
+		WriteRetryBackoff:        t.WriteRetryBackoff,
+		MaxQueuedWrites:          t.MaxQueuedWrites,
+		GuardImmutable:           t.GuardImmutable,
+		OnSuspiciousUpdate:       t.OnSuspiciousUpdate,
+		Immutable:                t.Immutable,
+		EmitWarnings:             t.EmitWarnings,
+		Clock:                    t.Clock,
+		Validation:               t.Validation,
+		Stats:                    t.Stats,
+		EventHandler:             t.EventHandler,
+	}
+}
+
+func cloneHostPolicies(m map[string]HostPolicy) map[string]HostPolicy {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]HostPolicy, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// cloneStrings returns a copy of s, nil if s is nil.
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// SwappableTransport is a http.RoundTripper delegating to a Transport
+// whose configuration can be changed at runtime without data races.
+//
+// A Transport must not be modified once it is in use; Update instead
+// replaces it with a modified clone, which is used for new requests.
+type SwappableTransport struct {
+	mu sync.Mutex // serializes updates.
+	t  atomic.Pointer[Transport]
+}
+
+// NewSwappableTransport returns a new SwappableTransport delegating to t.
+func NewSwappableTransport(t *Transport) *SwappableTransport {
+	s := &SwappableTransport{}
+	s.t.Store(t)
+	return s
+}
+
+// Load returns the current Transport, which must not be modified.
+func (s *SwappableTransport) Load() *Transport {
+	return s.t.Load()
+}
+
+// Update calls fn with a clone of the current Transport and makes it the current one.
+// Requests in flight complete using the Transport they started with.
+// Note that the new Transport starts with fresh internal state (see Clone).
+func (s *SwappableTransport) Update(fn func(t *Transport)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.t.Load().Clone()
+	fn(t)
+	s.t.Store(t)
+}
+
+// RoundTrip sends req through the current Transport.
+func (s *SwappableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return s.t.Load().RoundTrip(req)
+}
@@ -0,0 +1,136 @@
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestClone(t *testing.T) {
+	c := qt.New(t)
+
+	// Set every exported field, so new ones must be added to Clone.
+	tp := &Transport{}
+	v := reflect.ValueOf(tp).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if !v.Type().Field(i).IsExported() {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			f.SetInt(42)
+		case reflect.Float64:
+			f.SetFloat(0.5)
+		case reflect.Slice:
+			f.Set(reflect.MakeSlice(f.Type(), 1, 1))
+		case reflect.Map:
+			m := reflect.MakeMap(f.Type())
+			m.SetMapIndex(reflect.New(f.Type().Key()).Elem(), reflect.New(f.Type().Elem()).Elem())
+			f.Set(m)
+		case reflect.Func:
+			f.Set(reflect.MakeFunc(f.Type(), func(args []reflect.Value) []reflect.Value { return nil }))
+		case reflect.Interface:
+			switch f.Type() {
+			case reflect.TypeOf((*http.RoundTripper)(nil)).Elem():
+				f.Set(reflect.ValueOf(http.DefaultTransport))
+			case reflect.TypeOf((*Cache)(nil)).Elem():
+				f.Set(reflect.ValueOf(newMemoryCache()))
+			case reflect.TypeOf((*ValidationStrategy)(nil)).Elem():
+				f.Set(reflect.ValueOf(DefaultValidation))
+			case reflect.TypeOf((*StatsRecorder)(nil)).Elem():
+				f.Set(reflect.ValueOf(nopStatsRecorder{}))
+			case reflect.TypeOf((*EventHandler)(nil)).Elem():
+				f.Set(reflect.ValueOf(EventHandlerFunc(func(e Event) {})))
+			case reflect.TypeOf((*Logger)(nil)).Elem():
+				f.Set(reflect.ValueOf(LoggerFunc(func(msg string, args ...any) {})))
+			case reflect.TypeOf((*Clock)(nil)).Elem():
+				f.Set(reflect.ValueOf(&offsetClock{}))
+			case reflect.TypeOf((*error)(nil)).Elem():
+				f.Set(reflect.ValueOf(errors.New("error")))
+			default:
+				c.Fatalf("unhandled interface field %s", v.Type().Field(i).Name)
+			}
+		default:
+			c.Fatalf("unhandled field %s", v.Type().Field(i).Name)
+		}
+	}
+
+	clone := reflect.ValueOf(tp.Clone()).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).IsExported() {
+			continue
+		}
+		c.Assert(clone.Field(i).IsZero(), qt.IsFalse, qt.Commentf("field %s", v.Type().Field(i).Name))
+	}
+	tp.PropagateHeaders[0] = "changed"
+	c.Assert(clone.FieldByName("PropagateHeaders").Index(0).String(), qt.Equals, "")
+	tp.HostPolicies["changed"] = HostPolicy{}
+	c.Assert(clone.FieldByName("HostPolicies").Len(), qt.Equals, 1)
+}
+
+func TestSwappableTransportStampede(t *testing.T) {
+	c := qt.New(t)
+
+	var origin atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin.Add(1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	st := NewSwappableTransport(newMemoryCacheTransport())
+	client := http.Client{Transport: st}
+
+	const (
+		numGoroutines = 20
+		numRequests   = 50
+		numPaths      = 5
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < numRequests; j++ {
+				path := fmt.Sprintf("/%d", j%numPaths)
+				resp, err := client.Get(ts.URL + path)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				b, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil || string(b) != path {
+					t.Errorf("got %q, %v", b, err)
+					return
+				}
+				if i == 0 && j%10 == 0 {
+					st.Update(func(t *Transport) {
+						t.ShouldCache = func(req *http.Request, resp *http.Response, key string) bool {
+							return true
+						}
+						t.MarkCachedResponses = !t.MarkCachedResponses
+					})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// The cache is shared between the clones, so most requests are served from it.
+	c.Assert(int(origin.Load()) < numGoroutines*numRequests/2, qt.IsTrue)
+	c.Assert(st.Load().ShouldCache, qt.Not(qt.IsNil))
+}
@@ -0,0 +1,230 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// Codec compresses the values stored by a CompressedCache.
+// Implementations must be safe for concurrent use.
+type Codec interface {
+	// ID identifies the values compressed with the codec.
+	// It must be unique among the codecs of a CompressedCache.
+	ID() byte
+	// Encode returns b compressed.
+	Encode(b []byte) ([]byte, error)
+	// Decode returns b, as returned by Encode, decompressed.
+	Decode(b []byte) ([]byte, error)
+}
+
+// GzipCodec is a Codec compressing values with gzip at the default compression level.
+var GzipCodec Codec = gzipCodec{}
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte { return 1 }
+
+func (gzipCodec) Encode(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// compressedMagic starts every value compressed by a CompressedCache,
+// followed by the ID of its Codec.
+const compressedMagic = "\x00HCZ"
+
+// defaultCompressMinSize is the default value of CompressedCacheOptions.MinSize.
+const defaultCompressMinSize = 512
+
+// CompressedCacheOptions configures a CompressedCache.
+type CompressedCacheOptions struct {
+	// Codec compresses the stored values.
+	// Default is GzipCodec.
+	Codec Codec
+
+	// Decoders are codecs used to decompress the values stored with an earlier Codec,
+	// e.g. while switching from gzip to zstd.
+	Decoders []Codec
+
+	// MinSize is the size in bytes under which values are stored uncompressed.
+	// Default is 512.
+	MinSize int
+}
+
+// CompressedCache is a Cache compressing the responses stored in another Cache,
+// e.g. a disk or Redis cache holding HTML or JSON documents.
+//
+// Responses with a Content-Encoding or an already compressed media type
+// (images, audio, video, archives, web fonts) are stored as is,
+// as are values that don't get any smaller.
+// Uncompressed values, e.g. stored before compression was enabled, are read as is.
+//
+// The optional interfaces of the wrapped Cache, besides CacheContext and IterableCache,
+// are hidden, so the Transport buffers the bodies to compress them.
+type CompressedCache struct {
+	cache   CacheContext
+	c       Cache
+	codec   Codec
+	codecs  map[byte]Codec
+	minSize int
+}
+
+var (
+	_ CacheContext  = (*CompressedCache)(nil)
+	_ IterableCache = (*CompressedCache)(nil)
+)
+
+// NewCompressedCache returns a new CompressedCache storing the compressed responses in c.
+func NewCompressedCache(c Cache, opts CompressedCacheOptions) *CompressedCache {
+	cc := &CompressedCache{
+		cache:   AdaptCache(c),
+		c:       c,
+		codec:   opts.Codec,
+		codecs:  make(map[byte]Codec),
+		minSize: opts.MinSize,
+	}
+	if cc.codec == nil {
+		cc.codec = GzipCodec
+	}
+	if cc.minSize <= 0 {
+		cc.minSize = defaultCompressMinSize
+	}
+	for _, codec := range append(opts.Decoders, cc.codec) {
+		cc.codecs[codec.ID()] = codec
+	}
+	return cc
+}
+
+// Get returns the response stored for key, if any.
+func (c *CompressedCache) Get(key string) ([]byte, bool) {
+	b, ok, err := c.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return b, ok
+}
+
+// Set stores the response b for key.
+func (c *CompressedCache) Set(key string, b []byte) {
+	c.SetContext(context.Background(), key, b)
+}
+
+// Delete removes the response stored for key.
+func (c *CompressedCache) Delete(key string) {
+	c.DeleteContext(context.Background(), key)
+}
+
+// GetContext returns the response stored for key, if any, decompressed.
+func (c *CompressedCache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	b, ok, err := c.cache.GetContext(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if !bytes.HasPrefix(b, []byte(compressedMagic)) || len(b) == len(compressedMagic) {
+		return b, true, nil
+	}
+	id := b[len(compressedMagic)]
+	codec, found := c.codecs[id]
+	if !found {
+		return nil, false, fmt.Errorf("httpcache: no codec with ID %d to decompress %q", id, key)
+	}
+	b, err = codec.Decode(b[len(compressedMagic)+1:])
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// SetContext stores the response b for key, compressed if worth it.
+func (c *CompressedCache) SetContext(ctx context.Context, key string, b []byte) error {
+	return c.cache.SetContext(ctx, key, c.compress(b))
+}
+
+// DeleteContext removes the response stored for key.
+func (c *CompressedCache) DeleteContext(ctx context.Context, key string) error {
+	return c.cache.DeleteContext(ctx, key)
+}
+
+// Keys calls fn for every key starting with prefix, until fn returns false.
+// Nothing is listed if the wrapped Cache doesn't implement IterableCache.
+func (c *CompressedCache) Keys(prefix string, fn func(key string) bool) {
+	if ic, ok := c.c.(IterableCache); ok {
+		ic.Keys(prefix, fn)
+	}
+}
+
+// compress returns the value to store for b.
+func (c *CompressedCache) compress(b []byte) []byte {
+	if len(b) < c.minSize || !compressible(b) {
+		return b
+	}
+	z, err := c.codec.Encode(b)
+	if err != nil || len(z)+len(compressedMagic)+1 >= len(b) {
+		return b
+	}
+	v := make([]byte, 0, len(compressedMagic)+1+len(z))
+	v = append(v, compressedMagic...)
+	v = append(v, c.codec.ID())
+	return append(v, z...)
+}
+
+// compressible reports whether the value b is worth compressing:
+// it's not a cache entry with an already compressed body.
+func compressible(b []byte) bool {
+	e, err := decodeEntry(b)
+	if err != nil {
+		// Compress other values.
+		return true
+	}
+	enc, err := e.Get("Content-Encoding")
+	if err != nil {
+		return true
+	}
+	if enc != "" && !strings.EqualFold(enc, "identity") {
+		return false
+	}
+	contentType, _ := e.Get("Content-Type")
+	return !compressedMediaType(contentType)
+}
+
+// compressedMediaType reports whether contentType is a compressed format.
+func compressedMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "video/"):
+		return true
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+		"application/vnd.rar", "font/woff", "font/woff2":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCompressedCache(t *testing.T) {
+	c := qt.New(t)
+	html := strings.Repeat("<p>Hello, world!</p>\n", 1000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		switch r.URL.Path {
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		case "/png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/gzipped":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			b, _ := GzipCodec.Encode([]byte(html))
+			w.Write(b)
+			return
+		}
+		w.Write([]byte(html))
+	}))
+	defer ts.Close()
+
+	backend := newMemoryCache()
+	cache := NewCompressedCache(backend, CompressedCacheOptions{})
+	client := http.Client{Transport: &Transport{Cache: cache, Clock: &offsetClock{}}}
+	get := func(path string) (string, CacheStatus) {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		c.Assert(err, qt.IsNil)
+		// Keep the gzipped body as is.
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+	compressed := func(path string) bool {
+		b, ok := backend.Get(ts.URL + path)
+		c.Assert(ok, qt.IsTrue)
+		return bytes.HasPrefix(b, []byte(compressedMagic))
+	}
+
+	for _, path := range []string{"/html", "/png", "/gzipped"} {
+		first, _ := get(path)
+		body, status := get(path)
+		c.Assert(status, qt.Equals, StatusHit)
+		c.Assert(body, qt.Equals, first)
+		if path != "/gzipped" {
+			c.Assert(body, qt.Equals, html)
+		}
+	}
+	c.Assert(compressed("/html"), qt.IsTrue)
+	c.Assert(compressed("/png"), qt.IsFalse)
+	c.Assert(compressed("/gzipped"), qt.IsFalse)
+	b, _ := backend.Get(ts.URL + "/html")
+	c.Assert(len(b) < len(html)/10, qt.IsTrue)
+
+	// Small values and values stored before compression are returned as is.
+	cache.Set("small", []byte("small"))
+	backend.Set("legacy", []byte(html))
+	for _, key := range []string{"small", "legacy"} {
+		want, _ := backend.Get(key)
+		got, ok := cache.Get(key)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(got, qt.DeepEquals, want)
+	}
+
+	// Switching codecs.
+	cache = NewCompressedCache(backend, CompressedCacheOptions{Codec: halvingCodec{}, Decoders: []Codec{GzipCodec}})
+	b, ok := cache.Get(ts.URL + "/html")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(strings.HasSuffix(string(b), html), qt.IsTrue)
+	cache.Set("halved", []byte(html))
+	b, _ = cache.Get("halved")
+	c.Assert(string(b), qt.Equals, html)
+
+	cache = NewCompressedCache(backend, CompressedCacheOptions{})
+	_, _, err := cache.GetContext(context.Background(), "halved")
+	c.Assert(err, qt.ErrorMatches, `httpcache: no codec with ID 42 .*`)
+}
+
+// halvingCodec is a Codec "compressing" values made of two identical halves.
+type halvingCodec struct{}
+
+func (halvingCodec) ID() byte { return 42 }
+
+func (halvingCodec) Encode(b []byte) ([]byte, error) {
+	return b[:len(b)/2], nil
+}
+
+func (halvingCodec) Decode(b []byte) ([]byte, error) {
+	return append(b, b...), nil
+}
@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+)
+
+// contentLocationURL returns the URL in the Content-Location header of resp,
+// a response to req, if it has the same origin as and differs from the URL of req.
+func contentLocationURL(req *http.Request, resp *http.Response) string {
+	loc := resp.Header.Get("Content-Location")
+	if loc == "" {
+		return ""
+	}
+	u, err := req.URL.Parse(loc)
+	if err != nil || u.Scheme != req.URL.Scheme || u.Host != req.URL.Host {
+		return ""
+	}
+	u.Fragment = ""
+	if s := u.String(); s != req.URL.String() {
+		return s
+	}
+	return ""
+}
+
+// contentLocationKey returns the Content-Location of resp, a response to req,
+// and the cache key for GET requests to it, or "" if there is none or the key
+// is the same as cacheKey.
+func (t *Transport) contentLocationKey(req *http.Request, resp *http.Response, cacheKey string) (string, string) {
+	u := contentLocationURL(req, resp)
+	if u == "" {
+		return "", ""
+	}
+	key := t.urlKey(req, u)
+	if key == cacheKey {
+		return "", ""
+	}
+	return u, key
+}
+
+// urlKey returns the cache key for a GET request to u with the context and
+// the headers of req, or "" if it can't be cached.
+func (t *Transport) urlKey(req *http.Request, u string) string {
+	r, err := http.NewRequestWithContext(req.Context(), http.MethodGet, u, nil)
+	if err != nil {
+		return ""
+	}
+	r.Header = req.Header
+	return t.cacheKey(r)
+}
+
+// storeContentLocation stores a copy of resp, stored for req under cacheKey with body,
+// under the cache key of its Content-Location, if any.
+// If body is nil, it's read from the stored entry.
+func (t *Transport) storeContentLocation(req *http.Request, resp *http.Response, body []byte, cacheKey string) {
+	u, key := t.contentLocationKey(req, resp, cacheKey)
+	if key == "" {
+		return
+	}
+	if body == nil {
+		b, _ := t.cacheGet(req.Context(), cacheKey)
+		e, err := decodeEntry(b)
+		if err != nil {
+			return
+		}
+		body = e.body
+	}
+	locResp := *resp
+	locResp.Header = resp.Header.Clone()
+	locResp.Header.Set(xRequestURL, u)
+	locResp.Header.Set(xRequestMethod, http.MethodGet)
+	// Not the key of the copy.
+	locResp.Header.Del(xOriginalKey)
+	defer t.keyLocks.lock(key)()
+	t.storeEntry(req.Context(), key, &locResp, body)
+}
+
+// invalidateContentLocation removes the responses cached for the Content-Location
+// of resp, a successful response to req with an unsafe method.
+func (t *Transport) invalidateContentLocation(ctx context.Context, req *http.Request, resp *http.Response) {
+	if u := contentLocationURL(req, resp); u != "" {
+		t.InvalidateURL(ctx, u)
+	}
+}
+
+// isSafeMethod reports whether method is safe as defined in RFC 7231, section 4.2.1.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestIndexContentLocation(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/latest":
+			w.Header().Set("Content-Location", "/v1")
+		case "/other":
+			w.Header().Set("Content-Location", "http://example.com/v1")
+		case "/update":
+			w.Header().Set("Content-Location", "/v1")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("v1"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.IndexContentLocation = true
+	client := http.Client{Transport: tp}
+
+	get := func(path string) CacheStatus {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(get("/latest"), qt.Equals, StatusMiss)
+	c.Assert(get("/v1"), qt.Equals, StatusHit)
+	c.Assert(get("/latest"), qt.Equals, StatusHit)
+	c.Assert(requests, qt.Equals, 1)
+	info, ok := tp.EntryInfo(ts.URL + "/v1")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(info.URL, qt.Equals, ts.URL+"/v1")
+
+	// Other origins are ignored.
+	get("/other")
+	c.Assert(tp.Cache.(*memoryCache).Size(), qt.Equals, 3)
+
+	// Unsafe methods invalidate the Content-Location.
+	resp, err := client.Post(ts.URL+"/update", "text/plain", nil)
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(get("/v1"), qt.Equals, StatusMiss)
+	c.Assert(get("/latest"), qt.Equals, StatusHit)
+}
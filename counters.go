@@ -0,0 +1,194 @@
+package httpcache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// StatsCacheKey is the cache key under which Counters.Save stores the counters.
+const StatsCacheKey = "httpcache:stats"
+
+// AgeBuckets are the upper bounds of the buckets of CounterValues.ServedAges.
+var AgeBuckets = [...]time.Duration{
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// CounterValues holds the values of Counters.
+type CounterValues struct {
+	Hits                   uint64 `json:"hits"`
+	Misses                 uint64 `json:"misses"`
+	RevalidatedNotModified uint64 `json:"revalidated_not_modified"`
+	RevalidatedModified    uint64 `json:"revalidated_modified"`
+	StaleServed            uint64 `json:"stale_served"`
+	Stores                 uint64 `json:"stores"`
+	StoredBytes            uint64 `json:"stored_bytes"`
+	Evictions              uint64 `json:"evictions"`
+	UpstreamErrors         uint64 `json:"upstream_errors"`
+	BytesServed            uint64 `json:"bytes_served"`
+	DroppedWrites          uint64 `json:"dropped_writes"`
+	CacheErrors            uint64 `json:"cache_errors"`
+
+	// ServedAges is the histogram of the ages of the responses served from the
+	// cache without contacting the server: ServedAges[i] counts those up to
+	// AgeBuckets[i] old, and older than the previous bucket, the last one those
+	// older than all AgeBuckets.
+	ServedAges [len(AgeBuckets) + 1]uint64 `json:"served_ages"`
+}
+
+// Counters is a StatsRecorder keeping cumulative counts in memory.
+// The zero value is ready to use.
+//
+// The counts can be saved to a Cache and loaded from it, so they survive restarts.
+type Counters struct {
+	hits                   atomic.Uint64
+	misses                 atomic.Uint64
+	revalidatedNotModified atomic.Uint64
+	revalidatedModified    atomic.Uint64
+	staleServed            atomic.Uint64
+	stores                 atomic.Uint64
+	storedBytes            atomic.Uint64
+	evictions              atomic.Uint64
+	upstreamErrors         atomic.Uint64
+	bytesServed            atomic.Uint64
+	droppedWrites          atomic.Uint64
+	cacheErrors            atomic.Uint64
+	servedAges             [len(AgeBuckets) + 1]atomic.Uint64
+}
+
+var (
+	_ StatsRecorder       = (*Counters)(nil)
+	_ BytesServedRecorder = (*Counters)(nil)
+	_ WriteDropRecorder   = (*Counters)(nil)
+	_ AgeRecorder         = (*Counters)(nil)
+	_ CacheErrorRecorder  = (*Counters)(nil)
+)
+
+// Hit implements StatsRecorder.
+func (c *Counters) Hit(req *http.Request) { c.hits.Add(1) }
+
+// Miss implements StatsRecorder.
+func (c *Counters) Miss(req *http.Request) { c.misses.Add(1) }
+
+// Revalidated implements StatsRecorder.
+func (c *Counters) Revalidated(req *http.Request, modified bool) {
+	if modified {
+		c.revalidatedModified.Add(1)
+	} else {
+		c.revalidatedNotModified.Add(1)
+	}
+}
+
+// StaleServed implements StatsRecorder.
+func (c *Counters) StaleServed(req *http.Request) { c.staleServed.Add(1) }
+
+// Stored implements StatsRecorder.
+func (c *Counters) Stored(req *http.Request, size int) {
+	c.stores.Add(1)
+	c.storedBytes.Add(uint64(size))
+}
+
+// Evicted implements StatsRecorder.
+func (c *Counters) Evicted(req *http.Request) { c.evictions.Add(1) }
+
+// UpstreamError implements StatsRecorder.
+func (c *Counters) UpstreamError(req *http.Request, err error) { c.upstreamErrors.Add(1) }
+
+// ServedFromCache implements BytesServedRecorder.
+func (c *Counters) ServedFromCache(req *http.Request, size int64) { c.bytesServed.Add(uint64(size)) }
+
+// WriteDropped implements WriteDropRecorder.
+func (c *Counters) WriteDropped(key string, err error) { c.droppedWrites.Add(1) }
+
+// CacheError implements CacheErrorRecorder.
+func (c *Counters) CacheError(op, key string, err error) { c.cacheErrors.Add(1) }
+
+// ServedAge implements AgeRecorder.
+func (c *Counters) ServedAge(req *http.Request, age time.Duration) {
+	i := sort.Search(len(AgeBuckets), func(i int) bool { return age <= AgeBuckets[i] })
+	c.servedAges[i].Add(1)
+}
+
+// Values returns the current values of the counters.
+func (c *Counters) Values() CounterValues {
+	v := CounterValues{
+		Hits:                   c.hits.Load(),
+		Misses:                 c.misses.Load(),
+		RevalidatedNotModified: c.revalidatedNotModified.Load(),
+		RevalidatedModified:    c.revalidatedModified.Load(),
+		StaleServed:            c.staleServed.Load(),
+		Stores:                 c.stores.Load(),
+		StoredBytes:            c.storedBytes.Load(),
+		Evictions:              c.evictions.Load(),
+		UpstreamErrors:         c.upstreamErrors.Load(),
+		BytesServed:            c.bytesServed.Load(),
+		DroppedWrites:          c.droppedWrites.Load(),
+		CacheErrors:            c.cacheErrors.Load(),
+	}
+	for i := range c.servedAges {
+		v.ServedAges[i] = c.servedAges[i].Load()
+	}
+	return v
+}
+
+// add adds v to the counters.
+func (c *Counters) add(v CounterValues) {
+	c.hits.Add(v.Hits)
+	c.misses.Add(v.Misses)
+	c.revalidatedNotModified.Add(v.RevalidatedNotModified)
+	c.revalidatedModified.Add(v.RevalidatedModified)
+	c.staleServed.Add(v.StaleServed)
+	c.stores.Add(v.Stores)
+	c.storedBytes.Add(v.StoredBytes)
+	c.evictions.Add(v.Evictions)
+	c.upstreamErrors.Add(v.UpstreamErrors)
+	c.bytesServed.Add(v.BytesServed)
+	c.droppedWrites.Add(v.DroppedWrites)
+	c.cacheErrors.Add(v.CacheErrors)
+	for i := range c.servedAges {
+		c.servedAges[i].Add(v.ServedAges[i])
+	}
+}
+
+// Save stores the current values of the counters in cache, as JSON under StatsCacheKey.
+// Counters shared by several processes using the same cache overwrite each other.
+func (c *Counters) Save(ctx context.Context, cache Cache) error {
+	b, err := json.Marshal(c.Values())
+	if err != nil {
+		return err
+	}
+	return AdaptCache(cache).SetContext(ctx, StatsCacheKey, b)
+}
+
+// LoadCounters returns Counters starting from the values saved in cache by Save, if any.
+func LoadCounters(ctx context.Context, cache Cache) (*Counters, error) {
+	v, err := ReadCounterValues(ctx, cache)
+	if err != nil {
+		return nil, err
+	}
+	c := &Counters{}
+	c.add(v)
+	return c, nil
+}
+
+// ReadCounterValues returns the counter values saved in cache by Counters.Save,
+// the zero values if there are none.
+func ReadCounterValues(ctx context.Context, cache Cache) (CounterValues, error) {
+	var v CounterValues
+	b, ok, err := AdaptCache(cache).GetContext(ctx, StatsCacheKey)
+	if err != nil || !ok {
+		return v, err
+	}
+	err = json.Unmarshal(b, &v)
+	return v, err
+}
@@ -0,0 +1,56 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCounters(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	counters := &Counters{}
+	s.transport.Stats = counters
+	defer func() { s.transport.Stats = nil }()
+
+	doMethod(t, http.MethodGet, "/method", nil)
+	doMethod(t, http.MethodGet, "/method", nil)
+	doMethod(t, http.MethodGet, "/etag", nil)
+	doMethod(t, http.MethodGet, "/etag", nil)
+
+	v := counters.Values()
+	c.Assert(v.Hits, qt.Equals, uint64(1))
+	c.Assert(v.Misses, qt.Equals, uint64(2))
+	c.Assert(v.RevalidatedNotModified, qt.Equals, uint64(1))
+	c.Assert(v.BytesServed, qt.Equals, uint64(len("GET")))
+	c.Assert(v.StoredBytes > 0, qt.IsTrue)
+	// Only the hit, the revalidated response needed the server.
+	c.Assert(v.ServedAges[0], qt.Equals, uint64(1))
+
+	// Survive restarts.
+	cache := newMemoryCache()
+	c.Assert(counters.Save(ctx, cache), qt.IsNil)
+	loaded, err := LoadCounters(ctx, cache)
+	c.Assert(err, qt.IsNil)
+	c.Assert(loaded.Values(), qt.Equals, v)
+	loaded.Hit(nil)
+	c.Assert(loaded.Values().Hits, qt.Equals, uint64(2))
+
+	empty, err := LoadCounters(ctx, newMemoryCache())
+	c.Assert(err, qt.IsNil)
+	c.Assert(empty.Values(), qt.Equals, CounterValues{})
+}
+
+func TestCountersServedAges(t *testing.T) {
+	c := qt.New(t)
+	counters := &Counters{}
+	for _, age := range []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Hour, 30 * 24 * time.Hour} {
+		counters.ServedAge(nil, age)
+	}
+	c.Assert(counters.Values().ServedAges, qt.Equals, [len(AgeBuckets) + 1]uint64{2, 1, 0, 0, 0, 1, 0, 0, 1})
+}
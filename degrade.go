@@ -0,0 +1,64 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultCacheCooldown is the default of Transport.CacheCooldown.
+const defaultCacheCooldown = 10 * time.Second
+
+// CacheErrorRecorder is an optional interface implemented by StatsRecorders that
+// want to know about the failures of the Cache, see Transport.CacheFailureThreshold.
+type CacheErrorRecorder interface {
+	// CacheError is called when the Cache operation op (e.g. "get") on key failed,
+	// returning an error or panicking, with err, a *CacheBackendError.
+	CacheError(op, key string, err error)
+}
+
+// cacheOp runs op, the Cache operation named name on key with ctx, recovering from
+// panics, and reports its failure, unless ctx is done. While the Cache is skipped
+// after too many consecutive failures, op is not run and ErrCacheUnavailable is returned.
+func (t *Transport) cacheOp(ctx context.Context, name, key string, op func() error) (err error) {
+	var probe bool
+	if t.CacheFailureThreshold > 0 {
+		var ok bool
+		if ok, probe = t.circuit.allow(t.timer().now()); !ok {
+			return ErrCacheUnavailable
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+		if err != nil && ctx.Err() != nil {
+			t.circuit.cancel(probe)
+			return
+		}
+		if t.CacheFailureThreshold > 0 {
+			cooldown := t.CacheCooldown
+			if cooldown <= 0 {
+				cooldown = defaultCacheCooldown
+			}
+			t.circuit.done(err, probe, t.timer().now(), t.CacheFailureThreshold, cooldown)
+		}
+		if err != nil {
+			t.cacheFailed(name, key, err)
+		}
+	}()
+	return op()
+}
+
+// cacheFailed reports the failure of the Cache operation name on key with err.
+func (t *Transport) cacheFailed(name, key string, err error) {
+	err = &CacheBackendError{Op: name, Key: key, Err: err}
+	t.counters.CacheError(name, key, err)
+	if r, ok := t.Stats.(CacheErrorRecorder); ok {
+		r.CacheError(name, key, err)
+	}
+	if t.Logger != nil {
+		t.Logger.Debug("httpcache: cache error", "key", key, "error", err)
+	}
+	t.event(nil, Event{Kind: EventCacheError, Key: key, Err: err})
+}
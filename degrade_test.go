@@ -0,0 +1,174 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// panickingCache is a Cache panicking on every operation while broken.
+type panickingCache struct {
+	*memoryCache
+	mu     sync.Mutex
+	broken bool
+	calls  int
+}
+
+func (c *panickingCache) check() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.broken {
+		panic("connection lost")
+	}
+}
+
+func (c *panickingCache) Get(key string) ([]byte, bool) {
+	c.check()
+	return c.memoryCache.Get(key)
+}
+
+func (c *panickingCache) Set(key string, b []byte) {
+	c.check()
+	c.memoryCache.Set(key, b)
+}
+
+func (c *panickingCache) Delete(key string) {
+	c.check()
+	c.memoryCache.Delete(key)
+}
+
+func TestCacheFailureThreshold(t *testing.T) {
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	cache := &panickingCache{memoryCache: newMemoryCache(), broken: true}
+	clock := &offsetClock{}
+	counters := &Counters{}
+	var (
+		mu     sync.Mutex
+		events []Event
+	)
+	tp := &Transport{
+		Cache:                 cache,
+		Clock:                 clock,
+		Stats:                 counters,
+		CacheFailureThreshold: 3,
+		CacheCooldown:         time.Minute,
+		EventHandler: EventHandlerFunc(func(e Event) {
+			if e.Kind == EventCacheError {
+				mu.Lock()
+				events = append(events, e)
+				mu.Unlock()
+			}
+		}),
+	}
+	client := http.Client{Transport: tp}
+	get := func() CacheStatus {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(string(b), qt.Equals, "content")
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	// The lookup and the store fail, the response is passed through.
+	c.Assert(get(), qt.Equals, StatusMiss)
+	c.Assert(cache.calls, qt.Equals, 2)
+	c.Assert(events, qt.HasLen, 2)
+	var backendErr *CacheBackendError
+	c.Assert(errors.As(events[0].Err, &backendErr), qt.IsTrue)
+	c.Assert(backendErr.Op, qt.Equals, "get")
+	c.Assert(backendErr.Key, qt.Equals, ts.URL)
+	c.Assert(backendErr.Err, qt.ErrorMatches, "panic: connection lost")
+	c.Assert(events[0].Request, qt.IsNil)
+
+	// The third failure skips the Cache for CacheCooldown.
+	c.Assert(get(), qt.Equals, StatusMiss)
+	c.Assert(cache.calls, qt.Equals, 3)
+	c.Assert(get(), qt.Equals, StatusMiss)
+	c.Assert(cache.calls, qt.Equals, 3)
+	c.Assert(counters.Values().CacheErrors, qt.Equals, uint64(3))
+	c.Assert(tp.Snapshot().CacheErrors, qt.Equals, uint64(3))
+
+	// Back.
+	cache.mu.Lock()
+	cache.broken = false
+	cache.mu.Unlock()
+	clock.offset = 2 * time.Minute
+	c.Assert(get(), qt.Equals, StatusMiss)
+	c.Assert(get(), qt.Equals, StatusHit)
+	c.Assert(events, qt.HasLen, 3)
+}
+
+func TestCacheFailureThresholdSingleProbe(t *testing.T) {
+	c := qt.New(t)
+	clock := &offsetClock{}
+	tp := &Transport{
+		Cache:                 newMemoryCache(),
+		Clock:                 clock,
+		CacheFailureThreshold: 1,
+		CacheCooldown:         time.Minute,
+	}
+	errBroken := errors.New("broken")
+	ctx := context.Background()
+	c.Assert(tp.cacheOp(ctx, "get", "k", func() error { return errBroken }), qt.Equals, errBroken)
+	c.Assert(tp.cacheOp(ctx, "get", "k", func() error { return nil }), qt.Equals, ErrCacheUnavailable)
+
+	clock.offset = 2 * time.Minute
+	var (
+		wg      sync.WaitGroup
+		calls   atomic.Int32
+		release = make(chan struct{})
+		started = make(chan struct{})
+	)
+	probeErr := make(chan error, 1)
+	go func() {
+		probeErr <- tp.cacheOp(ctx, "get", "k", func() error {
+			calls.Add(1)
+			close(started)
+			<-release
+			return errBroken
+		})
+	}()
+	<-started
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := tp.cacheOp(ctx, "get", "k", func() error {
+				calls.Add(1)
+				return nil
+			})
+			c.Check(err, qt.Equals, ErrCacheUnavailable)
+		}()
+	}
+	wg.Wait()
+	close(release)
+	c.Assert(<-probeErr, qt.Equals, errBroken)
+	c.Assert(calls.Load(), qt.Equals, int32(1))
+
+	// The failed probe reopened the circuit.
+	c.Assert(tp.cacheOp(ctx, "get", "k", func() error { return nil }), qt.Equals, ErrCacheUnavailable)
+
+	// A successful probe closes it.
+	clock.offset = 4 * time.Minute
+	c.Assert(tp.cacheOp(ctx, "get", "k", func() error { return nil }), qt.IsNil)
+	c.Assert(tp.cacheOp(ctx, "get", "k", func() error { return nil }), qt.IsNil)
+}
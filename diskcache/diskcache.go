@@ -0,0 +1,495 @@
+package diskcache
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gohugoio/httpcache"
+)
+
+// magic is written at the start of every entry file.
+const magic = "HCD2"
+
+// magicV1 starts the entry files written by earlier versions, without checksum.
+const magicV1 = "HCD1"
+
+// checksumSize is the size of the CRC-32C trailer of entry files.
+const checksumSize = 4
+
+// crcTable is the CRC-32C (Castagnoli) table used for the checksums of entry files.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// tmpPrefix is the name prefix of temporary files.
+const tmpPrefix = ".tmp-"
+
+// metaSuffix is the name suffix of metadata files, stored next to the entry files.
+const metaSuffix = ".meta"
+
+var (
+	// errInvalidEntry signals a file that isn't a valid entry.
+	errInvalidEntry = errors.New("diskcache: invalid entry file")
+
+	// errChecksum signals an entry file whose content doesn't match its checksum.
+	errChecksum = errors.New("diskcache: entry file checksum mismatch")
+)
+
+// Options configures a Cache.
+type Options struct {
+	// MaxSize is the maximum total size in bytes of the entry and metadata files.
+	// When exceeded, the least recently used entries are removed.
+	// If 0, there is no limit.
+	MaxSize int64
+}
+
+// Cache is an implementation of httpcache.Cache, httpcache.CacheContext,
+// httpcache.MetadataCache and httpcache.StreamingCache that stores responses
+// in files below a directory.
+// It is safe for concurrent use.
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List               // of *entry, most recently used first.
+	entries map[string]*list.Element // by key.
+}
+
+type entry struct {
+	key      string
+	size     int64 // of the entry file.
+	metaSize int64 // of the metadata file.
+}
+
+// New returns a new Cache storing its entries below dir, which is created if needed.
+// Entries already present in dir are picked up, ordered by their last use.
+func New(dir string, opts Options) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:     dir,
+		maxSize: opts.MaxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load indexes the entries found on disk.
+func (c *Cache) load() error {
+	type found struct {
+		entry
+		modTime time.Time
+	}
+	var all []found
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), tmpPrefix) {
+			// Left over from an interrupted write.
+			os.Remove(path)
+			return nil
+		}
+		if strings.HasSuffix(d.Name(), metaSuffix) {
+			// Picked up with the entry file.
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		key, err := readKey(path)
+		if err != nil || c.path(key) != path {
+			// Not ours.
+			return nil
+		}
+		e := entry{key: key, size: fi.Size()}
+		if mfi, err := os.Stat(path + metaSuffix); err == nil {
+			e.metaSize = mfi.Size()
+		}
+		all = append(all, found{e, fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].modTime.After(all[j].modTime) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range all {
+		e := f.entry
+		c.entries[e.key] = c.lru.PushBack(&e)
+		c.size += e.size + e.metaSize
+	}
+	c.prune()
+	return nil
+}
+
+// Get returns the response stored for key, if any.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	b, ok, err := c.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return b, ok
+}
+
+// Set stores the response b for key.
+func (c *Cache) Set(key string, b []byte) {
+	c.SetContext(context.Background(), key, b)
+}
+
+// Delete removes the response stored for key.
+func (c *Cache) Delete(key string) {
+	c.DeleteContext(context.Background(), key)
+}
+
+// GetContext returns the response stored for key, if any.
+func (c *Cache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			c.mu.Lock()
+			c.remove(key)
+			c.mu.Unlock()
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	fileKey, b, err := decode(data)
+	if err == errChecksum {
+		// Corrupted, e.g. by a crash or a disk error.
+		c.DeleteContext(ctx, key)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if fileKey != key {
+		// Hash collision.
+		return nil, false, nil
+	}
+
+	c.mu.Lock()
+	c.touch(key, int64(len(data)), false)
+	c.prune()
+	c.mu.Unlock()
+
+	// Persist the access for the LRU order after restarts.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return b, true, nil
+}
+
+// SetContext stores the response b for key.
+func (c *Cache) SetContext(ctx context.Context, key string, b []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.write(c.path(key), key, b, false)
+}
+
+// GetMetadata returns the metadata stored for key, if any.
+func (c *Cache) GetMetadata(key string) ([]byte, bool) {
+	data, err := os.ReadFile(c.path(key) + metaSuffix)
+	if err != nil {
+		return nil, false
+	}
+	fileKey, b, err := decode(data)
+	if err != nil || fileKey != key {
+		return nil, false
+	}
+	return b, true
+}
+
+// SetMetadata stores the metadata b for key, leaving the entry file as is.
+func (c *Cache) SetMetadata(key string, b []byte) {
+	c.write(c.path(key)+metaSuffix, key, b, true)
+}
+
+// Writer returns a writer for the response body for key,
+// stored when the writer is closed.
+func (c *Cache) Writer(key string) (httpcache.BodyWriter, error) {
+	return c.newWriter(c.path(key), key, false)
+}
+
+// write atomically writes the file at path for key and b.
+func (c *Cache) write(path, key string, b []byte, meta bool) error {
+	w, err := c.newWriter(path, key, meta)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// writer writes an entry file to a temporary file, renamed into place on Close.
+type writer struct {
+	c    *Cache
+	path string
+	key  string
+	meta bool
+	f    *os.File
+	w    *bufio.Writer
+	crc  hash.Hash32 // of everything written so far.
+	n    int64
+	err  error
+}
+
+func (c *Cache) newWriter(path, key string, meta bool) (*writer, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, tmpPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	w := &writer{c: c, path: path, key: key, meta: meta, f: f, w: bufio.NewWriter(f), crc: crc32.New(crcTable)}
+	w.n, w.err = encode(io.MultiWriter(w.w, w.crc), key, nil)
+	return w, nil
+}
+
+// Write appends p to the entry.
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.crc.Write(p[:n])
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+// Close stores the entry, replacing any previous one.
+func (w *writer) Close() error {
+	err := w.err
+	if err == nil {
+		_, err = w.w.Write(w.crc.Sum(nil))
+		w.n += checksumSize
+	}
+	if err == nil {
+		err = w.w.Flush()
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(w.f.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	w.c.touch(w.key, w.n, w.meta)
+	w.c.prune()
+	return nil
+}
+
+// Abort discards the entry.
+func (w *writer) Abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// DeleteContext removes the response stored for key.
+func (c *Cache) DeleteContext(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	path := c.path(key)
+	os.Remove(path + metaSuffix)
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Keys calls fn for every key starting with prefix, in no particular order,
+// until fn returns false.
+func (c *Cache) Keys(prefix string, fn func(key string) bool) {
+	c.mu.Lock()
+	var keys []string
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+	for _, key := range keys {
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+// Size returns the total size in bytes of the entry and metadata files.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// touch marks key as most recently used, recording the given size
+// of its metadata file if meta is set, of its entry file otherwise.
+// c.mu must be held.
+func (c *Cache) touch(key string, size int64, meta bool) {
+	el, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	} else {
+		el = c.lru.PushFront(&entry{key: key})
+		c.entries[key] = el
+	}
+	e := el.Value.(*entry)
+	if meta {
+		c.size += size - e.metaSize
+		e.metaSize = size
+	} else {
+		c.size += size - e.size
+		e.size = size
+	}
+}
+
+// remove removes key from the index.
+// c.mu must be held.
+func (c *Cache) remove(key string) {
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		c.size -= e.size + e.metaSize
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// prune removes the least recently used entries until the cache fits in MaxSize.
+// The most recently used entry is always kept.
+// c.mu must be held.
+func (c *Cache) prune() {
+	if c.maxSize <= 0 {
+		return
+	}
+	for c.size > c.maxSize && c.lru.Len() > 1 {
+		e := c.lru.Back().Value.(*entry)
+		c.remove(e.key)
+		os.Remove(c.path(e.key))
+		os.Remove(c.path(e.key) + metaSuffix)
+	}
+}
+
+// path returns the path of the file for key,
+// sharded on the first two characters of the key's hash.
+func (c *Cache) path(key string) string {
+	return keyPath(c.dir, key)
+}
+
+// keyPath returns the path of the entry file for key below dir.
+func keyPath(dir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(dir, name[:2], name)
+}
+
+// encode writes the start of the entry file content for key and b to w,
+// everything but the checksum, returning the number of bytes written.
+// See the package documentation for the format.
+func encode(w io.Writer, key string, b []byte) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	var lenBuf [binary.MaxVarintLen64]byte
+	buf.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(key)))])
+	buf.WriteString(key)
+	n1, err := w.Write(buf.Bytes())
+	if err != nil {
+		return int64(n1), err
+	}
+	n2, err := w.Write(b)
+	return int64(n1 + n2), err
+}
+
+// decode returns the key and the value of the entry file content data,
+// verifying its checksum.
+func decode(data []byte) (key string, b []byte, err error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(magic)):
+		if len(data) < len(magic)+checksumSize {
+			return "", nil, errInvalidEntry
+		}
+		sum := data[len(data)-checksumSize:]
+		data = data[:len(data)-checksumSize]
+		if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(sum) {
+			return "", nil, errChecksum
+		}
+	case !bytes.HasPrefix(data, []byte(magicV1)):
+		return "", nil, errInvalidEntry
+	}
+	data = data[len(magic):]
+	n, i := binary.Uvarint(data)
+	if i <= 0 || uint64(len(data)-i) < n {
+		return "", nil, errInvalidEntry
+	}
+	return string(data[i : i+int(n)]), data[i+int(n):], nil
+}
+
+// readKey returns the key of the entry file at path.
+func readKey(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, m); err != nil || (string(m) != magic && string(m) != magicV1) {
+		return "", errInvalidEntry
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > 1<<20 {
+		return "", errInvalidEntry
+	}
+	key := make([]byte, n)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return "", errInvalidEntry
+	}
+	return string(key), nil
+}
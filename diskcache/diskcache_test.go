@@ -0,0 +1,340 @@
+package diskcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/gohugoio/httpcache"
+)
+
+var (
+	_ httpcache.Cache          = (*Cache)(nil)
+	_ httpcache.CacheContext   = (*Cache)(nil)
+	_ httpcache.IterableCache  = (*Cache)(nil)
+	_ httpcache.MetadataCache  = (*Cache)(nil)
+	_ httpcache.StreamingCache = (*Cache)(nil)
+)
+
+func TestDiskCache(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+
+	cache, err := New(dir, Options{})
+	c.Assert(err, qt.IsNil)
+
+	key := "http://example.com/foo"
+	_, ok := cache.Get(key)
+	c.Assert(ok, qt.IsFalse)
+
+	cache.Set(key, []byte("some value"))
+	b, ok := cache.Get(key)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "some value")
+
+	// Sharded.
+	matches, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(matches, qt.HasLen, 1)
+
+	// Survives restarts.
+	cache, err = New(dir, Options{})
+	c.Assert(err, qt.IsNil)
+	b, ok = cache.Get(key)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "some value")
+
+	var keys []string
+	cache.Keys("http://example.com/", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, qt.DeepEquals, []string{key})
+
+	cache.Delete(key)
+	_, ok = cache.Get(key)
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(cache.Size(), qt.Equals, int64(0))
+
+	_, _, err = cache.GetContext(canceledContext(), key)
+	c.Assert(err, qt.Equals, context.Canceled)
+}
+
+func TestDiskCacheMaxSize(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+
+	value := bytes.Repeat([]byte("a"), 100)
+	cache, err := New(dir, Options{MaxSize: 350})
+	c.Assert(err, qt.IsNil)
+
+	for i := 0; i < 3; i++ {
+		cache.Set(fmt.Sprint(i), value)
+	}
+	c.Assert(cache.Size() <= 350, qt.IsTrue)
+	// Use 0, so 1 is the least recently used.
+	_, ok := cache.Get("0")
+	c.Assert(ok, qt.IsTrue)
+	time.Sleep(10 * time.Millisecond)
+	cache.Set("3", value)
+
+	var keys []string
+	cache.Keys("", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Strings(keys)
+	c.Assert(keys, qt.DeepEquals, []string{"0", "2", "3"})
+	_, ok = cache.Get("1")
+	c.Assert(ok, qt.IsFalse)
+
+	// The LRU order survives restarts.
+	time.Sleep(10 * time.Millisecond)
+	cache.Get("2")
+	cache, err = New(dir, Options{MaxSize: 250})
+	c.Assert(err, qt.IsNil)
+	_, ok = cache.Get("2")
+	c.Assert(ok, qt.IsTrue)
+	_, ok = cache.Get("0")
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestDiskCacheMetadata(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+
+	cache, err := New(dir, Options{})
+	c.Assert(err, qt.IsNil)
+
+	key := "http://example.com/foo"
+	_, ok := cache.GetMetadata(key)
+	c.Assert(ok, qt.IsFalse)
+	cache.SetMetadata(key, []byte("meta"))
+	cache.Set(key, []byte("body"))
+	size := cache.Size()
+
+	cache.SetMetadata(key, []byte("meta2"))
+	c.Assert(cache.Size(), qt.Equals, size+1)
+	b, ok := cache.GetMetadata(key)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "meta2")
+	b, ok = cache.Get(key)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "body")
+
+	// Survives restarts.
+	cache, err = New(dir, Options{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(cache.Size(), qt.Equals, size+1)
+	b, ok = cache.GetMetadata(key)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "meta2")
+
+	cache.Delete(key)
+	_, ok = cache.GetMetadata(key)
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(cache.Size(), qt.Equals, int64(0))
+	matches, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(matches, qt.HasLen, 0)
+}
+
+func TestDiskCacheIgnoresStrayFiles(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "ab"), 0o755), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "ab", tmpPrefix+"123"), []byte("partial"), 0o644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "README"), []byte("hello"), 0o644), qt.IsNil)
+
+	cache, err := New(dir, Options{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(cache.Size(), qt.Equals, int64(0))
+	_, err = os.Stat(filepath.Join(dir, "ab", tmpPrefix+"123"))
+	c.Assert(os.IsNotExist(err), qt.IsTrue)
+}
+
+func TestDiskCacheConcurrent(t *testing.T) {
+	c := qt.New(t)
+	cache, err := New(t.TempDir(), Options{MaxSize: 1000})
+	c.Assert(err, qt.IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				key := fmt.Sprint(j % 5)
+				cache.Set(key, bytes.Repeat([]byte{byte(i)}, 50))
+				if b, ok := cache.Get(key); ok && len(b) != 50 {
+					t.Errorf("got %d bytes", len(b))
+				}
+				if j%7 == 0 {
+					cache.Delete(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestDiskCacheTransport(t *testing.T) {
+	c := qt.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	cache, err := New(t.TempDir(), Options{})
+	c.Assert(err, qt.IsNil)
+	client := http.Client{Transport: &httpcache.Transport{Cache: cache}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(string(b), qt.Equals, "hello")
+		status, _ := httpcache.ResponseCacheStatus(resp)
+		if i == 0 {
+			c.Assert(status, qt.Equals, httpcache.StatusMiss)
+		} else {
+			c.Assert(status, qt.Equals, httpcache.StatusHit)
+		}
+	}
+}
+
+func TestDiskCacheStreaming(t *testing.T) {
+	c := qt.New(t)
+	content := strings.Repeat("abc", 10000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	cache, err := New(t.TempDir(), Options{})
+	c.Assert(err, qt.IsNil)
+	client := http.Client{Transport: &httpcache.Transport{Cache: cache, MaxBodyBufferBytes: 100}}
+
+	get := func(path string, n int64) (string, httpcache.CacheStatus) {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(io.LimitReader(resp.Body, n))
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := httpcache.ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	body, status := get("/", 1<<20)
+	c.Assert(status, qt.Equals, httpcache.StatusMiss)
+	c.Assert(body, qt.Equals, content)
+	body, status = get("/", 1<<20)
+	c.Assert(status, qt.Equals, httpcache.StatusHit)
+	c.Assert(body, qt.Equals, content)
+	b, ok := cache.Get(ts.URL + "/")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, content)
+
+	// Aborted writes leave no files behind.
+	get("/partial", 10)
+	_, status = get("/partial", 1<<20)
+	c.Assert(status, qt.Equals, httpcache.StatusMiss)
+	matches, err := filepath.Glob(filepath.Join(cache.dir, "*", tmpPrefix+"*"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(matches, qt.HasLen, 0)
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestVerify(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+	cache, err := New(dir, Options{})
+	c.Assert(err, qt.IsNil)
+
+	cache.Set("a", []byte("value a"))
+	cache.SetMetadata("a", []byte("meta a"))
+	cache.Set("b", []byte("value b"))
+	rel := func(key string) string {
+		p, err := filepath.Rel(dir, cache.path(key))
+		c.Assert(err, qt.IsNil)
+		return p
+	}
+
+	report, err := Verify(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(report.OK(), qt.IsTrue)
+	c.Assert(report.Entries, qt.Equals, 2)
+	c.Assert(report.Metadata, qt.Equals, 1)
+	c.Assert(report.Size, qt.Equals, cache.Size())
+
+	// Flip a bit of the value of b.
+	data, err := os.ReadFile(cache.path("b"))
+	c.Assert(err, qt.IsNil)
+	data[len(data)-checksumSize-1] ^= 1
+	c.Assert(os.WriteFile(cache.path("b"), data, 0o644), qt.IsNil)
+	// Stored at the path of another key.
+	misplaced := cache.path("c")
+	c.Assert(os.MkdirAll(filepath.Dir(misplaced), 0o755), qt.IsNil)
+	data, err = os.ReadFile(cache.path("a"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(os.WriteFile(misplaced, data, 0o644), qt.IsNil)
+	// Metadata without entry.
+	cache.SetMetadata("d", []byte("meta d"))
+	c.Assert(os.WriteFile(filepath.Join(dir, rel("a")[:2], tmpPrefix+"1"), nil, 0o644), qt.IsNil)
+
+	report, err = Verify(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(report.OK(), qt.IsFalse)
+	c.Assert(report.Corrupt, qt.DeepEquals, []string{rel("b")})
+	c.Assert(report.Misplaced, qt.DeepEquals, []string{rel("c")})
+	c.Assert(report.OrphanedMetadata, qt.DeepEquals, []string{rel("d") + metaSuffix})
+	c.Assert(report.TempFiles, qt.DeepEquals, []string{filepath.Join(rel("a")[:2], tmpPrefix+"1")})
+
+	// Corrupted entries are misses, and removed.
+	_, ok := cache.Get("b")
+	c.Assert(ok, qt.IsFalse)
+	_, err = os.Stat(cache.path("b"))
+	c.Assert(os.IsNotExist(err), qt.IsTrue)
+}
+
+func TestReadV1(t *testing.T) {
+	c := qt.New(t)
+	dir := t.TempDir()
+	cache, err := New(dir, Options{})
+	c.Assert(err, qt.IsNil)
+
+	// Written by earlier versions, without checksum.
+	path := cache.path("a")
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0o755), qt.IsNil)
+	c.Assert(os.WriteFile(path, []byte(magicV1+"\x01avalue"), 0o644), qt.IsNil)
+
+	cache, err = New(dir, Options{})
+	c.Assert(err, qt.IsNil)
+	b, ok := cache.Get("a")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "value")
+	report, err := Verify(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(report.OK(), qt.IsTrue)
+	c.Assert(report.Entries, qt.Equals, 1)
+}
@@ -0,0 +1,36 @@
+// Package diskcache provides an implementation of httpcache.Cache that stores
+// responses in files on disk, so they survive process restarts.
+//
+// Entries are stored in sharded directories below the cache directory, written
+// atomically (via rename of a temporary file), and pruned in least recently used
+// order when the configured maximum size is exceeded.
+//
+// The Cache implements httpcache.MetadataCache, storing the metadata of an entry
+// in a separate file, so revalidations don't rewrite the body, and
+// httpcache.StreamingCache, so bodies are written to disk as they are read.
+//
+// # Layout
+//
+// The layout of a cache directory is stable, so it can be backed up, inspected
+// and pruned by other tools. Verify checks a directory for consistency.
+//
+// The value stored for a key lives in the entry file
+//
+//	<dir>/<hash[:2]>/<hash>
+//
+// where hash is the lowercase hex encoded SHA-256 of the key, and its metadata,
+// if stored separately, in the metadata file of the same name with the suffix ".meta".
+// Files named with the prefix ".tmp-" are being written, or left over from an
+// interrupted write, and are removed when a Cache is opened. Other files are ignored.
+//
+// Entry and metadata files share the same format:
+//
+//	"HCD2" | uvarint key length | key | value | CRC-32C
+//
+// where the uvarint is encoded as by encoding/binary, and the CRC-32C (Castagnoli)
+// checksum of everything before it is stored as 4 bytes, big endian.
+// Files starting with "HCD1", written by earlier versions, have no checksum.
+//
+// The modification time of an entry file is its last use,
+// used to restore the least recently used order when a Cache is opened.
+package diskcache
@@ -0,0 +1,105 @@
+package diskcache
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VerifyReport is the result of Verify.
+// The paths it lists are relative to the cache directory.
+type VerifyReport struct {
+	// Entries is the number of valid entry files.
+	Entries int
+
+	// Metadata is the number of valid metadata files.
+	Metadata int
+
+	// Size is the total size in bytes of the valid entry and metadata files.
+	Size int64
+
+	// Corrupt lists the entry and metadata files that can't be decoded,
+	// or whose checksum doesn't match.
+	Corrupt []string
+
+	// Misplaced lists the valid files not at the path of their key.
+	Misplaced []string
+
+	// OrphanedMetadata lists the metadata files without an entry file.
+	OrphanedMetadata []string
+
+	// TempFiles lists the temporary files, left over from interrupted writes
+	// unless the directory is in use.
+	TempFiles []string
+}
+
+// OK reports whether no problems were found.
+// Temporary files are not a problem.
+func (r VerifyReport) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.Misplaced) == 0 && len(r.OrphanedMetadata) == 0
+}
+
+// Verify checks the integrity of the cache directory dir, see the package
+// documentation for its layout, reading every entry and metadata file.
+// It doesn't modify dir, and can be run while a Cache uses it,
+// though files being written may then be reported.
+func Verify(dir string) (VerifyReport, error) {
+	var report VerifyReport
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, tmpPrefix) {
+			report.TempFiles = append(report.TempFiles, rel)
+			return nil
+		}
+		hash, meta := strings.CutSuffix(name, metaSuffix)
+		if !isHash(hash) || filepath.Dir(rel) != hash[:2] {
+			// Not ours.
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, _, err := decode(data)
+		switch {
+		case err != nil:
+			report.Corrupt = append(report.Corrupt, rel)
+			return nil
+		case keyPath(dir, key) != strings.TrimSuffix(path, metaSuffix):
+			report.Misplaced = append(report.Misplaced, rel)
+			return nil
+		}
+		report.Size += int64(len(data))
+		if !meta {
+			report.Entries++
+			return nil
+		}
+		report.Metadata++
+		if _, err := os.Stat(strings.TrimSuffix(path, metaSuffix)); err != nil {
+			report.OrphanedMetadata = append(report.OrphanedMetadata, rel)
+		}
+		return nil
+	})
+	return report, err
+}
+
+// isHash reports whether s is a lowercase hex encoded SHA-256 hash.
+func isHash(s string) bool {
+	if len(s) != 64 || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
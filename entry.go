@@ -0,0 +1,285 @@
+package httpcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// Cached responses are stored in a binary format:
+//
+//	magic (4 bytes) | version (1 byte) | uvarint metadata length | metadata | body
+//
+// where the metadata holds the status code, the status, the protocol version and
+// the header, sorted by key, all length prefixed. The body is stored as is, so it
+// can be served without copying. The header is decoded into an http.Header when
+// the entry is read as a response, Get looks up a single value without decoding it.
+//
+// Entries stored by earlier versions, in HTTP/1.1 wire format, are still read.
+
+// entryMagic starts every entry in the binary format.
+// An entry in wire format starts with "HTTP/".
+const entryMagic = "\x00HCE"
+
+// entryVersion is the version of the binary format.
+const entryVersion = 1
+
+var errInvalidEntry = errors.New("httpcache: invalid cache entry")
+
+// entry is a decoded cache entry.
+type entry struct {
+	statusCode int
+	status     string
+	protoMajor int
+	protoMinor int
+	body       []byte
+
+	rawHeader []byte      // the encoded header, decoded on first use.
+	header    http.Header // the decoded header.
+}
+
+// encodeEntry returns the cache entry for resp with the given body.
+// resp.Body is not used.
+func encodeEntry(resp *http.Response, body []byte) []byte {
+	var meta []byte
+	meta = binary.AppendUvarint(meta, uint64(resp.StatusCode))
+	meta = appendString(meta, resp.Status)
+	meta = binary.AppendUvarint(meta, uint64(resp.ProtoMajor))
+	meta = binary.AppendUvarint(meta, uint64(resp.ProtoMinor))
+	meta = binary.AppendUvarint(meta, uint64(len(resp.Header)))
+	keys := make([]string, 0, len(resp.Header))
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		vv := resp.Header[k]
+		meta = appendString(meta, k)
+		meta = binary.AppendUvarint(meta, uint64(len(vv)))
+		for _, v := range vv {
+			meta = appendString(meta, v)
+		}
+	}
+
+	b := make([]byte, 0, len(entryMagic)+1+binary.MaxVarintLen64+len(meta)+len(body))
+	b = append(b, entryMagic...)
+	b = append(b, entryVersion)
+	b = binary.AppendUvarint(b, uint64(len(meta)))
+	b = append(b, meta...)
+	return append(b, body...)
+}
+
+// decodeEntry decodes the cache entry b, in binary or wire format.
+// The entry references b, which must not be modified.
+func decodeEntry(b []byte) (*entry, error) {
+	if !bytes.HasPrefix(b, []byte(entryMagic)) {
+		return decodeWireEntry(b)
+	}
+	b = b[len(entryMagic):]
+	if len(b) == 0 || b[0] != entryVersion {
+		return nil, errInvalidEntry
+	}
+	b = b[1:]
+	n, i := binary.Uvarint(b)
+	if i <= 0 || uint64(len(b)-i) < n {
+		return nil, errInvalidEntry
+	}
+	meta, body := b[i:i+int(n)], b[i+int(n):]
+
+	e := &entry{body: body}
+	var (
+		v  uint64
+		ok bool
+	)
+	if v, meta, ok = readUvarint(meta); !ok {
+		return nil, errInvalidEntry
+	}
+	e.statusCode = int(v)
+	if e.status, meta, ok = readString(meta); !ok {
+		return nil, errInvalidEntry
+	}
+	if v, meta, ok = readUvarint(meta); !ok {
+		return nil, errInvalidEntry
+	}
+	e.protoMajor = int(v)
+	if v, meta, ok = readUvarint(meta); !ok {
+		return nil, errInvalidEntry
+	}
+	e.protoMinor = int(v)
+	e.rawHeader = meta
+	return e, nil
+}
+
+// decodeWireEntry decodes an entry stored in HTTP/1.1 wire format.
+func decodeWireEntry(b []byte) (*entry, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength >= 0 {
+		// ReadResponse moves it out of the header for some responses.
+		resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	return &entry{
+		statusCode: resp.StatusCode,
+		status:     resp.Status,
+		protoMajor: resp.ProtoMajor,
+		protoMinor: resp.ProtoMinor,
+		body:       body,
+		header:     resp.Header,
+	}, nil
+}
+
+// Header returns the header of the entry, decoding it if needed.
+func (e *entry) Header() (http.Header, error) {
+	if e.header != nil {
+		return e.header, nil
+	}
+	b := e.rawHeader
+	n, b, ok := readUvarint(b)
+	if !ok || n > uint64(len(b)) {
+		return nil, errInvalidEntry
+	}
+	h := make(http.Header, n)
+	for i := uint64(0); i < n; i++ {
+		var (
+			k    string
+			nv   uint64
+			vals []string
+		)
+		if k, b, ok = readString(b); !ok {
+			return nil, errInvalidEntry
+		}
+		if nv, b, ok = readUvarint(b); !ok || nv > uint64(len(b)) {
+			return nil, errInvalidEntry
+		}
+		vals = make([]string, nv)
+		for j := range vals {
+			if vals[j], b, ok = readString(b); !ok {
+				return nil, errInvalidEntry
+			}
+		}
+		h[k] = vals
+	}
+	e.header = h
+	return h, nil
+}
+
+// Get returns the first value of the header key of the entry, like http.Header.Get,
+// without decoding the whole header.
+func (e *entry) Get(key string) (string, error) {
+	if e.header != nil {
+		return e.header.Get(key), nil
+	}
+	key = http.CanonicalHeaderKey(key)
+	b := e.rawHeader
+	n, b, ok := readUvarint(b)
+	if !ok {
+		return "", errInvalidEntry
+	}
+	for i := uint64(0); i < n; i++ {
+		var (
+			k  []byte
+			nv uint64
+		)
+		if k, b, ok = readBytes(b); !ok {
+			return "", errInvalidEntry
+		}
+		if nv, b, ok = readUvarint(b); !ok {
+			return "", errInvalidEntry
+		}
+		for j := uint64(0); j < nv; j++ {
+			var v []byte
+			if v, b, ok = readBytes(b); !ok {
+				return "", errInvalidEntry
+			}
+			if j == 0 && string(k) == key {
+				return string(v), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// response returns the entry as a response to req, which may be nil.
+// The body is not read for HEAD requests.
+func (e *entry) response(req *http.Request) (*http.Response, error) {
+	h, err := e.Header()
+	if err != nil {
+		return nil, err
+	}
+	proto := "HTTP/" + strconv.Itoa(e.protoMajor) + "." + strconv.Itoa(e.protoMinor)
+	resp := &http.Response{
+		Status:        e.status,
+		StatusCode:    e.statusCode,
+		Proto:         proto,
+		ProtoMajor:    e.protoMajor,
+		ProtoMinor:    e.protoMinor,
+		Header:        h,
+		Body:          entryBody{bytes.NewReader(e.body)},
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+	if req != nil && req.Method == http.MethodHead {
+		resp.Body = http.NoBody
+		resp.ContentLength = -1
+		if cl, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil {
+			resp.ContentLength = cl
+		}
+	}
+	return resp, nil
+}
+
+// entryBody is the body of a response read from an entry.
+// It's an io.ReaderAt, so parts of it can be read without reading all of it.
+type entryBody struct {
+	*bytes.Reader
+}
+
+func (entryBody) Close() error { return nil }
+
+// readStoredResponse decodes the cache entry b as a response to req, which may be nil.
+func readStoredResponse(b []byte, req *http.Request) (*http.Response, error) {
+	e, err := decodeEntry(b)
+	if err != nil {
+		return nil, err
+	}
+	return e.response(req)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func readUvarint(b []byte) (uint64, []byte, bool) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, b, false
+	}
+	return v, b[n:], true
+}
+
+func readString(b []byte) (string, []byte, bool) {
+	s, b, ok := readBytes(b)
+	return string(s), b, ok
+}
+
+// readBytes is like readString, returning a slice of b.
+func readBytes(b []byte) ([]byte, []byte, bool) {
+	n, b, ok := readUvarint(b)
+	if !ok || n > uint64(len(b)) {
+		return nil, b, false
+	}
+	return b[:n], b[n:], true
+}
@@ -0,0 +1,169 @@
+package httpcache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestEntryRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type": {"text/plain"},
+			"Vary":         {"Accept", "Accept-Encoding"},
+			xRequestURL:    {"http://example.com/"},
+		},
+	}
+	b := encodeEntry(resp, []byte("hello"))
+
+	e, err := decodeEntry(b)
+	c.Assert(err, qt.IsNil)
+	c.Assert(e.header, qt.IsNil)
+	c.Assert(string(e.body), qt.Equals, "hello")
+
+	got, err := e.response(nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(got.Status, qt.Equals, "200 OK")
+	c.Assert(got.Proto, qt.Equals, "HTTP/1.1")
+	c.Assert(got.Header, qt.DeepEquals, resp.Header)
+	c.Assert(got.ContentLength, qt.Equals, int64(5))
+	body, err := io.ReadAll(got.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(body), qt.Equals, "hello")
+
+	head, err := http.NewRequest(http.MethodHead, "http://example.com/", nil)
+	c.Assert(err, qt.IsNil)
+	got, err = readStoredResponse(b, head)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Body, qt.Equals, http.NoBody)
+
+	// Truncated entries are rejected.
+	for i := 0; i < len(b)-5; i++ {
+		e, err := decodeEntry(b[:i])
+		if err == nil {
+			_, err = e.Header()
+		}
+		c.Assert(err, qt.Not(qt.IsNil), qt.Commentf("length %d", i))
+	}
+}
+
+func TestEntryHeaderLookup(t *testing.T) {
+	c := qt.New(t)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type":  {"text/plain"},
+			"Cache-Control": {"max-age=60"},
+			"Etag":          {`"abc"`},
+			"Vary":          {"Accept", "Accept-Encoding"},
+			xRequestURL:     {"http://example.com/"},
+		},
+	}
+	b := encodeEntry(resp, []byte("hello"))
+
+	// The encoding does not depend on the map iteration order.
+	for i := 0; i < 20; i++ {
+		c.Assert(bytes.Equal(encodeEntry(resp, []byte("hello")), b), qt.IsTrue)
+	}
+
+	e, err := decodeEntry(b)
+	c.Assert(err, qt.IsNil)
+	for _, key := range []string{"Content-Type", "Etag", "Vary", xRequestURL, "Missing"} {
+		v, err := e.Get(key)
+		c.Assert(err, qt.IsNil)
+		c.Assert(v, qt.Equals, resp.Header.Get(key), qt.Commentf("key %s", key))
+	}
+	// Get does not decode the header.
+	c.Assert(e.header, qt.IsNil)
+
+	h, err := e.Header()
+	c.Assert(err, qt.IsNil)
+	c.Assert(h, qt.DeepEquals, resp.Header)
+	v, err := e.Get("Etag")
+	c.Assert(err, qt.IsNil)
+	c.Assert(v, qt.Equals, `"abc"`)
+}
+
+func TestEntryLegacyFormat(t *testing.T) {
+	c := qt.New(t)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Etag": {`"abc"`}, xRequestURL: {"http://example.com/"}},
+		Body:       io.NopCloser(bytes.NewReader([]byte("hello"))),
+	}
+	b, err := httputil.DumpResponse(resp, true)
+	c.Assert(err, qt.IsNil)
+
+	got, err := readStoredResponse(b, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Header.Get("Etag"), qt.Equals, `"abc"`)
+	body, err := io.ReadAll(got.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(body), qt.Equals, "hello")
+
+	// Served by the Transport.
+	resetTest()
+	s.transport.Cache.Set("http://example.com/", b)
+	s.transport.Transport = transportMock{response: &http.Response{StatusCode: http.StatusNotModified, Body: http.NoBody}}
+	defer func() { s.transport.Transport = nil }()
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	c.Assert(err, qt.IsNil)
+	got, err = s.transport.RoundTrip(req)
+	c.Assert(err, qt.IsNil)
+	body, err = io.ReadAll(got.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(body), qt.Equals, "hello")
+	status, _ := ResponseCacheStatus(got)
+	c.Assert(status, qt.Equals, StatusRevalidated)
+
+	// Stored again in the binary format.
+	b, _ = s.transport.Cache.Get("http://example.com/")
+	c.Assert(bytes.HasPrefix(b, []byte(entryMagic)), qt.IsTrue)
+}
+
+func BenchmarkEntryDecode(b *testing.B) {
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type":  {"text/html"},
+			"Cache-Control": {"max-age=3600"},
+			"Etag":          {`"abc"`},
+			"Date":          {"Mon, 02 Jan 2006 15:04:05 GMT"},
+		},
+	}
+	body := bytes.Repeat([]byte("a"), 64<<10)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	wire, _ := httputil.DumpResponse(resp, true)
+	binary := encodeEntry(resp, body)
+
+	for _, bb := range []struct {
+		name string
+		b    []byte
+	}{{"wire", wire}, {"binary", binary}} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				resp, err := readStoredResponse(bb.b, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+			}
+		})
+	}
+}
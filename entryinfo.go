@@ -0,0 +1,212 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// xValidation and xValidatedAt are the headers holding the outcome and the time
+	// of the last revalidation of a cached response with the server.
+	xValidation  = "X-Validation"
+	xValidatedAt = "X-Validated-At"
+)
+
+// ValidationOutcome is the outcome of a revalidation of a cached response with the server.
+type ValidationOutcome int
+
+const (
+	// ValidationNone is the outcome of entries never revalidated.
+	ValidationNone ValidationOutcome = iota
+
+	// ValidationNotModified means the server confirmed the cached response (304 Not Modified).
+	ValidationNotModified
+
+	// ValidationModified means the server sent a new response, which replaced the cached one.
+	ValidationModified
+
+	// ValidationFailed means the request to the server failed or returned a server error,
+	// and the cached response was kept.
+	ValidationFailed
+)
+
+func (o ValidationOutcome) String() string {
+	switch o {
+	case ValidationNone:
+		return "none"
+	case ValidationNotModified:
+		return "not-modified"
+	case ValidationModified:
+		return "modified"
+	case ValidationFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+func parseValidationOutcome(s string) ValidationOutcome {
+	for o := ValidationNotModified; o <= ValidationFailed; o++ {
+		if o.String() == s {
+			return o
+		}
+	}
+	return ValidationNone
+}
+
+// ValidationObserver is an optional interface implemented by Cache backends that
+// want to know the outcome of revalidations, e.g. for their eviction policies to
+// prefer entries recently confirmed by the server.
+type ValidationObserver interface {
+	// Validated is called after the cached response for key was revalidated with the server.
+	Validated(key string, outcome ValidationOutcome)
+}
+
+// EntryInfo describes a cached response.
+type EntryInfo struct {
+	// Key is the cache key of the entry.
+	Key string
+
+	// OriginalKey is the cache key of the entry before Transport.HashKey
+	// was applied, the same as Key if it wasn't.
+	OriginalKey string
+
+	// URL and Method are those of the request the response was stored for.
+	URL    string
+	Method string
+
+	// StatusCode is the status code of the response.
+	StatusCode int
+
+	// Size is the size in bytes of the stored entry.
+	Size int
+
+	// Stored is the date of the response (its Date header),
+	// the zero time if it has none.
+	Stored time.Time
+
+	// Expires is when the response becomes stale, taking Extend into account.
+	// It is the zero time if the response has no Date header.
+	Expires time.Time
+
+	// LastValidation is the outcome of the latest revalidation with the server.
+	LastValidation ValidationOutcome
+
+	// LastValidated is when the latest revalidation happened,
+	// the zero time if it never was revalidated.
+	LastValidated time.Time
+
+	// Pinned is set if the response was pinned with Transport.Pin.
+	Pinned bool
+}
+
+// EntryInfo returns information about the cached response stored for key,
+// and false if there is none.
+func (t *Transport) EntryInfo(key string) (EntryInfo, bool) {
+	b, _ := t.cacheGet(context.Background(), key)
+	if len(b) == 0 {
+		return EntryInfo{}, false
+	}
+	e, err := decodeEntry(b)
+	if err != nil {
+		return EntryInfo{}, false
+	}
+	h, err := e.Header()
+	if err != nil {
+		return EntryInfo{}, false
+	}
+	info := EntryInfo{
+		Key:            key,
+		OriginalKey:    key,
+		URL:            h.Get(xRequestURL),
+		Method:         h.Get(xRequestMethod),
+		StatusCode:     e.statusCode,
+		Size:           len(b),
+		LastValidation: parseValidationOutcome(h.Get(xValidation)),
+		Pinned:         isPinned(h),
+	}
+	if k := h.Get(xOriginalKey); k != "" {
+		info.OriginalKey = k
+	}
+	if date, err := date(h); err == nil {
+		lifetime := freshnessLifetime(h, parseCacheControl(h), date, t.defaultLifetime(h), t.Shared)
+		if extended := extendedLifetime(h); extended > lifetime {
+			lifetime = extended
+		}
+		info.Stored = date
+		info.Expires = date.Add(lifetime)
+	}
+	if validatedAt, err := http.ParseTime(h.Get(xValidatedAt)); err == nil {
+		info.LastValidated = validatedAt
+	}
+	return info, true
+}
+
+// Entries calls fn with the EntryInfo of every cached response with a key
+// starting with prefix, until fn returns false, e.g. to audit the content of the
+// cache or to delete the expired entries.
+// Entries not holding a response, e.g. the saved Counters, are skipped.
+//
+// The Cache must implement IterableCache.
+func (t *Transport) Entries(ctx context.Context, prefix string, fn func(EntryInfo) bool) error {
+	cache, ok := t.Cache.(IterableCache)
+	if !ok {
+		return ErrCacheNotIterable
+	}
+	var keys []string
+	cache.Keys(prefix, func(key string) bool {
+		keys = append(keys, key)
+		return ctx.Err() == nil
+	})
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, ok := t.EntryInfo(key)
+		if !ok || info.URL == "" {
+			continue
+		}
+		if !fn(info) {
+			break
+		}
+	}
+	return ctx.Err()
+}
+
+// setValidation records outcome as the latest revalidation in h.
+func (t *Transport) setValidation(h http.Header, outcome ValidationOutcome) {
+	h.Set(xValidation, outcome.String())
+	h.Set(xValidatedAt, t.timer().now().UTC().Format(http.TimeFormat))
+}
+
+// validated notifies the cache of the outcome of the revalidation of key, if it cares.
+func (t *Transport) validated(key string, outcome ValidationOutcome) {
+	if o, ok := t.Cache.(ValidationObserver); ok {
+		o.Validated(key, outcome)
+	}
+}
+
+// recordFailedValidation records a failed revalidation in the entry stored for key.
+func (t *Transport) recordFailedValidation(ctx context.Context, key string) {
+	t.validated(key, ValidationFailed)
+	defer t.keyLocks.lock(key)()
+	b, _ := t.cacheGet(ctx, key)
+	if len(b) == 0 {
+		return
+	}
+	cachedResp, err := readStoredResponse(b, nil)
+	if err != nil {
+		return
+	}
+	t.setValidation(cachedResp.Header, ValidationFailed)
+	if _, ok := t.storeMetadata(key, cachedResp); ok {
+		return
+	}
+	body, err := io.ReadAll(cachedResp.Body)
+	if err != nil {
+		return
+	}
+	t.storeEntry(ctx, key, cachedResp, body)
+}
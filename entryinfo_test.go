@@ -0,0 +1,135 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+type observingCache struct {
+	*memoryCache
+	outcomes []ValidationOutcome
+}
+
+func (c *observingCache) Validated(key string, outcome ValidationOutcome) {
+	c.outcomes = append(c.outcomes, outcome)
+}
+
+func TestEntryInfo(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	etag := `"v1"`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10, stale-if-error=3600")
+		w.Header().Set("Etag", etag)
+		if r.Header.Get("if-none-match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(etag))
+	}))
+	defer ts.Close()
+
+	cache := &observingCache{memoryCache: newMemoryCache()}
+	tp := &Transport{Cache: cache}
+	client := http.Client{Transport: tp}
+	get := func() {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+	}
+	info := func() EntryInfo {
+		info, ok := tp.EntryInfo(ts.URL)
+		c.Assert(ok, qt.IsTrue)
+		return info
+	}
+
+	_, ok := tp.EntryInfo(ts.URL)
+	c.Assert(ok, qt.IsFalse)
+
+	get()
+	c.Assert(info().URL, qt.Equals, ts.URL)
+	c.Assert(info().Method, qt.Equals, http.MethodGet)
+	c.Assert(info().LastValidation, qt.Equals, ValidationNone)
+	c.Assert(info().LastValidated.IsZero(), qt.IsTrue)
+
+	clock = &fakeClock{elapsed: 30 * time.Second}
+	get()
+	c.Assert(info().LastValidation, qt.Equals, ValidationNotModified)
+	c.Assert(time.Since(info().LastValidated) < time.Minute, qt.IsTrue)
+
+	etag = `"v2"`
+	get()
+	c.Assert(info().LastValidation, qt.Equals, ValidationModified)
+
+	tp.Transport = transportMock{err: errors.New("network down")}
+	get()
+	c.Assert(info().LastValidation, qt.Equals, ValidationFailed)
+	c.Assert(info().Size > 0, qt.IsTrue)
+
+	c.Assert(cache.outcomes, qt.DeepEquals, []ValidationOutcome{ValidationNotModified, ValidationModified, ValidationFailed})
+}
+
+func TestEntries(t *testing.T) {
+	c := qt.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	cache := newMemoryCache()
+	tp := &Transport{Cache: cache, Clock: &offsetClock{}}
+	client := http.Client{Transport: tp}
+	for _, path := range []string{"/a", "/b", "/missing"} {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+	}
+	cache.Set("not-a-response", []byte("junk"))
+
+	var infos []EntryInfo
+	err := tp.Entries(context.Background(), "", func(info EntryInfo) bool {
+		infos = append(infos, info)
+		return true
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(infos, qt.HasLen, 3)
+	sort.Slice(infos, func(i, j int) bool { return infos[i].URL < infos[j].URL })
+	c.Assert(infos[0].URL, qt.Equals, ts.URL+"/a")
+	c.Assert(infos[0].StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(infos[2].StatusCode, qt.Equals, http.StatusNotFound)
+	c.Assert(time.Since(infos[0].Stored) < time.Minute, qt.IsTrue)
+	c.Assert(infos[0].Expires.Sub(infos[0].Stored), qt.Equals, time.Minute)
+
+	c.Assert(tp.Extend(ts.URL+"/a", time.Hour), qt.IsNil)
+	info, _ := tp.EntryInfo(ts.URL + "/a")
+	c.Assert(info.Expires.Sub(info.Stored) >= time.Hour, qt.IsTrue)
+
+	var n int
+	err = tp.Entries(context.Background(), ts.URL+"/b", func(info EntryInfo) bool {
+		n++
+		return false
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 1)
+
+	tp.Cache = &staleCache{}
+	err = tp.Entries(context.Background(), "", func(EntryInfo) bool { return true })
+	c.Assert(err, qt.Equals, ErrCacheNotIterable)
+}
@@ -0,0 +1,43 @@
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoCachedResponse indicates that a request can't be answered without
+	// contacting the server, which is not allowed, and no usable response is cached.
+	// It is returned in Offline mode with StrictErrors, unless OfflineError is set.
+	ErrNoCachedResponse = errors.New("httpcache: no cached response")
+
+	// ErrOnlyIfCachedMiss is returned with StrictErrors for requests with an
+	// only-if-cached directive without a usable cached response.
+	// It wraps ErrNoCachedResponse.
+	ErrOnlyIfCachedMiss = fmt.Errorf("%w for only-if-cached request", ErrNoCachedResponse)
+
+	// ErrEntryTooLarge indicates that a response was not stored because its body
+	// exceeds the size limits of the Transport, see Event.Err.
+	ErrEntryTooLarge = errors.New("httpcache: entry too large")
+)
+
+// CacheBackendError is an error returned by the Cache, e.g. a CacheContext
+// failing to reach its server, returned with StrictErrors.
+type CacheBackendError struct {
+	// Op is the failed operation, e.g. "get".
+	Op string
+
+	// Key is the key of the operation.
+	Key string
+
+	// Err is the error returned by the Cache.
+	Err error
+}
+
+func (e *CacheBackendError) Error() string {
+	return fmt.Sprintf("httpcache: cache %s %q: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *CacheBackendError) Unwrap() error {
+	return e.Err
+}
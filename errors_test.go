@@ -0,0 +1,78 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestStrictErrors(t *testing.T) {
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer ts.Close()
+
+	cache := &contextMemoryCache{items: map[string][]byte{}}
+	tp := &Transport{Cache: FromCacheContext(cache)}
+	roundTrip := func(path, cacheControl string) (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	// Without StrictErrors, a 504 is synthesized and backend failures are misses.
+	resp, err := roundTrip("/a", "only-if-cached")
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusGatewayTimeout)
+	cache.err = errors.New("down")
+	resp, err = roundTrip("/b", "")
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	cache.err = nil
+
+	tp.StrictErrors = true
+	_, err = roundTrip("/a", "only-if-cached")
+	c.Assert(err, qt.Equals, ErrOnlyIfCachedMiss)
+	c.Assert(errors.Is(err, ErrNoCachedResponse), qt.IsTrue)
+
+	tp.Offline = true
+	_, err = roundTrip("/c", "")
+	c.Assert(err, qt.Equals, ErrNoCachedResponse)
+	tp.Offline = false
+
+	cache.err = errors.New("down")
+	_, err = roundTrip("/d", "")
+	var backendErr *CacheBackendError
+	c.Assert(errors.As(err, &backendErr), qt.IsTrue)
+	c.Assert(backendErr.Op, qt.Equals, "get")
+	c.Assert(backendErr.Key, qt.Equals, ts.URL+"/d")
+	c.Assert(errors.Is(err, cache.err), qt.IsTrue)
+	cache.err = nil
+
+	// Reported with the events of responses too large to be stored.
+	var storeErr error
+	tp.MaxCacheableBodySize = 50
+	tp.EventHandler = EventHandlerFunc(func(e Event) {
+		if e.Kind == EventStoreSkipped {
+			storeErr = e.Err
+		}
+	})
+	_, err = roundTrip("/e", "")
+	c.Assert(err, qt.IsNil)
+	c.Assert(storeErr, qt.Equals, ErrEntryTooLarge)
+}
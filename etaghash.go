@@ -0,0 +1,42 @@
+package httpcache
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"hash"
+	"strconv"
+)
+
+// etagHasher computes the MD5 hash of a response body used in place of its ETag
+// in EnableETagPair mode, of the first limit bytes only if limit > 0.
+type etagHasher struct {
+	h     hash.Hash
+	limit int64
+	n     int64 // the number of bytes written.
+}
+
+func newETagHasher(limit int64) *etagHasher {
+	return &etagHasher{h: md5.New(), limit: limit}
+}
+
+func (e *etagHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	if e.limit > 0 && e.n+int64(n) > e.limit {
+		if e.n < e.limit {
+			e.h.Write(p[:e.limit-e.n])
+		}
+	} else {
+		e.h.Write(p)
+	}
+	e.n += int64(n)
+	return n, nil
+}
+
+// sum returns the hex encoded hash. When the body was truncated to the limit,
+// its length is hashed too, so bodies up to the limit hash as with no limit.
+func (e *etagHasher) sum() string {
+	if e.limit > 0 && e.n > e.limit {
+		e.h.Write([]byte(strconv.FormatInt(e.n, 10)))
+	}
+	return hex.EncodeToString(e.h.Sum(nil))
+}
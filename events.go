@@ -0,0 +1,188 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// EventLookup is sent when the cache was looked up for a request.
+	EventLookup EventKind = iota
+
+	// EventHit is sent when a fresh cached response was served.
+	EventHit
+
+	// EventMiss is sent when the response was fetched from the server.
+	EventMiss
+
+	// EventStale is sent when a stale cached response was served.
+	EventStale
+
+	// EventRevalidated is sent when a cached response was served after
+	// the server confirmed it was still valid (304 Not Modified).
+	EventRevalidated
+
+	// EventStored is sent when a response was stored in the cache.
+	EventStored
+
+	// EventStoreSkipped is sent when a response was not stored in the cache.
+	EventStoreSkipped
+
+	// EventEvicted is sent when a cached response was removed from the cache,
+	// e.g. because the server no longer returned a cacheable response.
+	EventEvicted
+
+	// EventUpstreamError is sent when the request to the server failed.
+	EventUpstreamError
+
+	// EventCacheError is sent when an operation of the Cache failed, returning
+	// an error or panicking, see Transport.CacheFailureThreshold.
+	// Its Request is nil.
+	EventCacheError
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventLookup:
+		return "lookup"
+	case EventHit:
+		return "hit"
+	case EventMiss:
+		return "miss"
+	case EventStale:
+		return "stale"
+	case EventRevalidated:
+		return "revalidated"
+	case EventStored:
+		return "stored"
+	case EventStoreSkipped:
+		return "store-skipped"
+	case EventEvicted:
+		return "evicted"
+	case EventUpstreamError:
+		return "upstream-error"
+	case EventCacheError:
+		return "cache-error"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a step in the handling of a request by a Transport, see Transport.EventHandler.
+type Event struct {
+	// Kind is the kind of the event.
+	Kind EventKind
+
+	// Request is the request handled, nil for EventCacheError.
+	Request *http.Request
+
+	// Key is the cache key of the request, empty if it is not cacheable or not known yet.
+	Key string
+
+	// URL is the URL of the request.
+	URL string
+
+	// Time is when the event happened.
+	Time time.Time
+
+	// Found is set for EventLookup if a cached response was found.
+	Found bool
+
+	// Size is the number of bytes stored for EventStored.
+	Size int
+
+	// Reason tells why the response was not stored for EventStoreSkipped.
+	Reason StoreSkipReason
+
+	// Err is the error of the request to the server for EventUpstreamError,
+	// of RoundTrip for EventMiss, of the Cache (a *CacheBackendError) for EventCacheError,
+	// and ErrEntryTooLarge for an EventStoreSkipped with SkipTooLarge.
+	Err error
+
+	// Duration, CacheTime and OriginTime are, for the events telling how the request
+	// was answered (EventHit, EventMiss, EventStale and EventRevalidated),
+	// the time spent in RoundTrip, reading from the Cache and waiting for the server.
+	Duration   time.Duration
+	CacheTime  time.Duration
+	OriginTime time.Duration
+}
+
+// EventHandler receives the Events of a Transport, see Transport.EventHandler.
+// Implementations must be safe for concurrent use.
+type EventHandler interface {
+	HandleEvent(e Event)
+}
+
+// EventHandlerFunc is a func implementing EventHandler.
+type EventHandlerFunc func(e Event)
+
+// HandleEvent calls f(e).
+func (f EventHandlerFunc) HandleEvent(e Event) {
+	f(e)
+}
+
+// withEventRecorder returns req with a resultRecorder to collect the key and the
+// timings reported with the outcome of req to the EventHandler, if there is none yet.
+func (t *Transport) withEventRecorder(req *http.Request) *http.Request {
+	if t.EventHandler == nil {
+		return req
+	}
+	if _, ok := req.Context().Value(resultKey{}).(*resultRecorder); ok {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), resultKey{}, &resultRecorder{}))
+}
+
+// event sends e, an event for req, which may be nil, to the EventHandler, if set.
+func (t *Transport) event(req *http.Request, e Event) {
+	if t.EventHandler == nil {
+		return
+	}
+	if req != nil {
+		e.Request = req
+		e.URL = req.URL.String()
+	}
+	e.Time = t.timer().now()
+	t.EventHandler.HandleEvent(e)
+}
+
+// outcomeEvent sends the event telling how req was answered with status
+// after the given time in RoundTrip, err being its error, to the EventHandler, if set.
+func (t *Transport) outcomeEvent(req *http.Request, status CacheStatus, d time.Duration, err error) {
+	if t.EventHandler == nil {
+		return
+	}
+	e := Event{Duration: d, Err: err}
+	switch status {
+	case StatusHit:
+		e.Kind = EventHit
+	case StatusStale:
+		e.Kind = EventStale
+	case StatusRevalidated:
+		e.Kind = EventRevalidated
+	default:
+		e.Kind = EventMiss
+	}
+	if rec, ok := req.Context().Value(resultKey{}).(*resultRecorder); ok {
+		rec.mu.Lock()
+		e.Key, e.CacheTime, e.OriginTime = rec.result.Key, rec.result.CacheTime, rec.result.OriginTime
+		rec.mu.Unlock()
+	}
+	t.event(req, e)
+}
+
+// upstreamError reports that the request req to the server for key failed with err.
+func (t *Transport) upstreamError(req *http.Request, key string, err error) {
+	t.stats().UpstreamError(req, err)
+	t.event(req, Event{Kind: EventUpstreamError, Key: key, Err: err})
+}
+
+// evicted reports that the cached response for req, stored for key, was removed from the cache.
+func (t *Transport) evicted(req *http.Request, key string) {
+	t.stats().Evicted(req)
+	t.event(req, Event{Kind: EventEvicted, Key: key})
+}
@@ -0,0 +1,82 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestEventHandler(t *testing.T) {
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/nostore" {
+			w.Header().Set("Cache-Control", "no-store")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	var (
+		mu       sync.Mutex
+		recorded []Event
+	)
+	tp := newMemoryCacheTransport()
+	tp.EventHandler = EventHandlerFunc(func(e Event) {
+		mu.Lock()
+		recorded = append(recorded, e)
+		mu.Unlock()
+	})
+	client := http.Client{Transport: tp}
+	get := func(u string) []Event {
+		mu.Lock()
+		recorded = nil
+		mu.Unlock()
+		resp, err := client.Get(u)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return recorded
+	}
+	kinds := func(events []Event) []string {
+		var s []string
+		for _, e := range events {
+			s = append(s, e.Kind.String())
+		}
+		return s
+	}
+
+	events := get(ts.URL)
+	c.Assert(kinds(events), qt.DeepEquals, []string{"lookup", "miss", "stored"})
+	c.Assert(events[0].Found, qt.IsFalse)
+	c.Assert(events[1].Key, qt.Equals, ts.URL)
+	c.Assert(events[1].URL, qt.Equals, ts.URL)
+	c.Assert(events[1].OriginTime > 0, qt.IsTrue)
+	c.Assert(events[1].Duration >= events[1].OriginTime, qt.IsTrue)
+	c.Assert(events[2].Size > 0, qt.IsTrue)
+
+	events = get(ts.URL)
+	c.Assert(kinds(events), qt.DeepEquals, []string{"lookup", "hit"})
+	c.Assert(events[0].Found, qt.IsTrue)
+
+	events = get(ts.URL + "/nostore")
+	c.Assert(kinds(events), qt.DeepEquals, []string{"lookup", "store-skipped", "miss"})
+	c.Assert(events[1].Reason, qt.Equals, SkipNoStore)
+
+	errFail := errors.New("fail")
+	tp.Transport = transportMock{err: errFail}
+	events = get(ts.URL + "/fail")
+	c.Assert(kinds(events), qt.DeepEquals, []string{"lookup", "upstream-error", "miss"})
+	c.Assert(events[1].Err, qt.Equals, errFail)
+	c.Assert(events[2].Err, qt.Equals, errFail)
+}
@@ -0,0 +1,91 @@
+package httpcache
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// entryMetadata is the record written by ExportMetadata for every entry.
+type entryMetadata struct {
+	Key            string     `json:"key"`
+	URL            string     `json:"url,omitempty"`
+	Method         string     `json:"method,omitempty"`
+	Status         int        `json:"status"`
+	Size           int        `json:"size"`
+	BodySize       int        `json:"body_size"`
+	Date           *time.Time `json:"date,omitempty"`
+	AgeSeconds     float64    `json:"age_seconds"`
+	ETag           string     `json:"etag,omitempty"`
+	LastModified   string     `json:"last_modified,omitempty"`
+	CacheControl   string     `json:"cache_control,omitempty"`
+	Fresh          bool       `json:"fresh"`
+	LastValidation string     `json:"last_validation"`
+}
+
+// ExportMetadata writes the metadata of the cached responses to w as
+// newline-delimited JSON, one record per entry, sorted by key if the Cache
+// lists its keys in order. Bodies are not exported.
+//
+// Each record has the fields key, url, method, status, size (of the stored entry),
+// body_size, date, age_seconds, etag, last_modified, cache_control, fresh
+// and last_validation.
+//
+// The Cache must implement IterableCache, or ErrCacheNotIterable is returned.
+func (t *Transport) ExportMetadata(w io.Writer) error {
+	ic, ok := t.Cache.(IterableCache)
+	if !ok {
+		return ErrCacheNotIterable
+	}
+	ctx := context.Background()
+	enc := json.NewEncoder(w)
+	var err error
+	ic.Keys("", func(key string) bool {
+		if key == StatsCacheKey {
+			return true
+		}
+		b, _ := t.cacheGet(ctx, key)
+		if len(b) == 0 {
+			return true
+		}
+		m, ok := t.entryMetadata(key, b)
+		if !ok {
+			return true
+		}
+		err = enc.Encode(m)
+		return err == nil
+	})
+	return err
+}
+
+func (t *Transport) entryMetadata(key string, b []byte) (entryMetadata, bool) {
+	e, err := decodeEntry(b)
+	if err != nil {
+		return entryMetadata{}, false
+	}
+	h, err := e.Header()
+	if err != nil {
+		return entryMetadata{}, false
+	}
+	m := entryMetadata{
+		Key:            key,
+		URL:            h.Get(xRequestURL),
+		Method:         h.Get(xRequestMethod),
+		Status:         e.statusCode,
+		Size:           len(b),
+		BodySize:       len(e.body),
+		ETag:           h.Get("Etag"),
+		LastModified:   h.Get("Last-Modified"),
+		CacheControl:   h.Get("Cache-Control"),
+		Fresh:          getFreshnessWithDefault(h, http.Header{}, t.defaultLifetime(h), t.Shared, t.timer()) == fresh,
+		LastValidation: parseValidationOutcome(h.Get(xValidation)).String(),
+	}
+	if d, err := date(h); err == nil {
+		d = d.UTC()
+		m.Date = &d
+		m.AgeSeconds = t.timer().since(d).Seconds()
+	}
+	return m, true
+}
@@ -0,0 +1,46 @@
+package httpcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestExportMetadata(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	doMethod(t, "GET", "/", nil)
+	doMethod(t, "GET", "/etag", nil)
+	s.transport.Cache.Set(StatsCacheKey, []byte("{}"))
+
+	var buf bytes.Buffer
+	c.Assert(s.transport.ExportMetadata(&buf), qt.IsNil)
+
+	var records []map[string]any
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var m map[string]any
+		c.Assert(json.Unmarshal(sc.Bytes(), &m), qt.IsNil)
+		records = append(records, m)
+	}
+	c.Assert(records, qt.HasLen, 2)
+
+	root, etag := records[0], records[1]
+	c.Assert(root["key"], qt.Equals, s.server.URL+"/")
+	c.Assert(root["method"], qt.Equals, "GET")
+	c.Assert(root["status"], qt.Equals, float64(200))
+	c.Assert(root["cache_control"], qt.Equals, "max-age=3600")
+	c.Assert(root["fresh"], qt.Equals, true)
+	c.Assert(root["last_validation"], qt.Equals, "none")
+	c.Assert(etag["etag"], qt.Equals, "124567")
+	c.Assert(etag["fresh"], qt.Equals, false)
+	_, found := etag["body"]
+	c.Assert(found, qt.IsFalse)
+
+	tp := &Transport{Cache: FromCacheContext(&contextMemoryCache{items: map[string][]byte{}})}
+	c.Assert(tp.ExportMetadata(&buf), qt.Equals, ErrCacheNotIterable)
+}
@@ -0,0 +1,82 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// xExtendedLifetime is the header holding the freshness lifetime (in seconds,
+// counted from the Date header) of a cached response extended with Transport.Extend.
+const xExtendedLifetime = "X-Extended-Lifetime"
+
+// ErrNotCached is returned by operations on a cache entry that does not exist.
+var ErrNotCached = errors.New("httpcache: no cached response for key")
+
+// Extend keeps the response cached for key fresh for d from now, regardless of its
+// own freshness lifetime, e.g. while its server is down for maintenance and stale
+// content must keep being served. d is capped by MaxExtension, if set.
+// Requests with a no-cache directive still revalidate the response, and the
+// extension ends when the response is replaced.
+func (t *Transport) Extend(key string, d time.Duration) error {
+	if t.MaxExtension > 0 && d > t.MaxExtension {
+		d = t.MaxExtension
+	}
+	ctx := context.Background()
+	defer t.keyLocks.lock(key)()
+	b, _ := t.cacheGet(ctx, key)
+	if len(b) == 0 {
+		return ErrNotCached
+	}
+	cachedResp, err := readStoredResponse(b, nil)
+	if err != nil {
+		return err
+	}
+	date, err := date(cachedResp.Header)
+	if err != nil {
+		return err
+	}
+	lifetime := t.timer().since(date) + d
+	cachedResp.Header.Set(xExtendedLifetime, strconv.FormatInt(int64(lifetime/time.Second), 10))
+	if _, ok := t.storeMetadata(key, cachedResp); ok {
+		return nil
+	}
+	body, err := io.ReadAll(cachedResp.Body)
+	if err != nil {
+		return err
+	}
+	t.storeEntry(ctx, key, cachedResp, body)
+	return nil
+}
+
+// extendedLifetime returns the freshness lifetime set by Extend in respHeaders, 0 if none.
+func extendedLifetime(respHeaders http.Header) time.Duration {
+	secs, err := strconv.ParseInt(respHeaders.Get(xExtendedLifetime), 10, 64)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// applyExtension returns freshness adjusted for the lifetime set by Extend, if any,
+// the age of the response measured with clk.
+func applyExtension(freshness int, respHeaders http.Header, clk timer) int {
+	if freshness != stale {
+		return freshness
+	}
+	lifetime := extendedLifetime(respHeaders)
+	if lifetime <= 0 {
+		return freshness
+	}
+	date, err := date(respHeaders)
+	if err != nil {
+		return freshness
+	}
+	if clk.since(date) < lifetime {
+		return fresh
+	}
+	return freshness
+}
@@ -0,0 +1,80 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestExtend(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	client := http.Client{Transport: tp}
+	get := func() CacheStatus {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(tp.Extend(ts.URL, time.Hour), qt.Equals, ErrNotCached)
+	c.Assert(get(), qt.Equals, StatusMiss)
+
+	clock = &fakeClock{elapsed: 2 * time.Minute}
+	defer func() { clock = &realClock{} }()
+	c.Assert(tp.Extend(ts.URL, time.Hour), qt.IsNil)
+	c.Assert(get(), qt.Equals, StatusHit)
+	c.Assert(requests, qt.Equals, 1)
+
+	clock = &fakeClock{elapsed: 2 * time.Hour}
+	c.Assert(get(), qt.Equals, StatusMiss)
+	c.Assert(requests, qt.Equals, 2)
+
+	// Capped.
+	tp.MaxExtension = time.Minute
+	clock = &fakeClock{elapsed: 2 * time.Minute}
+	c.Assert(tp.Extend(ts.URL, time.Hour), qt.IsNil)
+	clock = &fakeClock{elapsed: 150 * time.Second}
+	c.Assert(get(), qt.Equals, StatusHit)
+	clock = &fakeClock{elapsed: 4 * time.Minute}
+	c.Assert(get(), qt.Equals, StatusMiss)
+}
+
+func TestExtendFromServerIgnored(t *testing.T) {
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set(xExtendedLifetime, "3600")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	client := http.Client{Transport: newMemoryCacheTransport()}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		c.Assert(resp.Header.Get(xExtendedLifetime), qt.Equals, "")
+	}
+	c.Assert(requests, qt.Equals, 3)
+}
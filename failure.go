@@ -0,0 +1,53 @@
+package httpcache
+
+import (
+	"sync"
+	"time"
+)
+
+// failureMemo remembers recent transport-level failures by cache key.
+type failureMemo struct {
+	mu       sync.Mutex
+	failures map[string]failure
+}
+
+type failure struct {
+	err error
+	at  time.Time
+}
+
+// add records err as the failure for key, if window > 0, at the current time of clk.
+func (m *failureMemo) add(key string, err error, window time.Duration, clk timer) {
+	if window <= 0 || key == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures == nil {
+		m.failures = make(map[string]failure)
+	}
+	for k, f := range m.failures {
+		if clk.since(f.at) >= window {
+			delete(m.failures, k)
+		}
+	}
+	m.failures[key] = failure{err: err, at: clk.now()}
+}
+
+// get returns the failure recorded for key within window, if any, measured with clk.
+func (m *failureMemo) get(key string, window time.Duration, clk timer) error {
+	if window <= 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, found := m.failures[key]
+	if !found {
+		return nil
+	}
+	if clk.since(f.at) >= window {
+		delete(m.failures, key)
+		return nil
+	}
+	return f.err
+}
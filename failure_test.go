@@ -0,0 +1,75 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// countingTransport counts the requests passed on to its RoundTripper.
+type countingTransport struct {
+	http.RoundTripper
+	requests atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestFailureMemo(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	clock = &fakeClock{}
+
+	errDown := errors.New("connection refused")
+	origin := &countingTransport{RoundTripper: transportMock{err: errDown}}
+	tp := newMemoryCacheTransport()
+	tp.Transport = origin
+	tp.FailureMemo = 10 * time.Second
+	client := http.Client{Transport: tp}
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Get("http://example.com/down")
+		c.Assert(errors.Is(err, errDown), qt.IsTrue)
+	}
+	c.Assert(origin.requests.Load(), qt.Equals, int64(1))
+
+	// Other keys are not affected.
+	client.Get("http://example.com/other")
+	c.Assert(origin.requests.Load(), qt.Equals, int64(2))
+
+	clock = &fakeClock{elapsed: 20 * time.Second}
+	client.Get("http://example.com/down")
+	c.Assert(origin.requests.Load(), qt.Equals, int64(3))
+
+	// Cached responses are served stale meanwhile.
+	clock = &fakeClock{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Etag", `"abc"`)
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+	origin.RoundTripper = http.DefaultTransport
+	resp, err := client.Get(ts.URL)
+	c.Assert(err, qt.IsNil)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	tp.failures.add(ts.URL, errDown, tp.FailureMemo, clock)
+	requests := origin.requests.Load()
+	resp, err = client.Get(ts.URL)
+	c.Assert(err, qt.IsNil)
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(string(b), qt.Equals, "content")
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusStale)
+	c.Assert(origin.requests.Load(), qt.Equals, requests)
+}
@@ -0,0 +1,119 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// GetOrFetch returns the response to a GET request for url, from the cache if
+// possible, fetching it from the server otherwise, following redirects as
+// http.Client does. Use ResponseCacheStatus to tell which.
+//
+// As with http.Get, the caller must close the response body, and a response
+// with an error status is not an error.
+func (t *Transport) GetOrFetch(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{Transport: t}
+	return client.Do(req)
+}
+
+// FetchResult is the outcome of fetching one URL with Transport.FetchAll.
+type FetchResult struct {
+	// URL is the URL fetched.
+	URL string
+
+	// StatusCode and Body are those of the response,
+	// after following redirects.
+	StatusCode int
+	Body       []byte
+
+	// Result describes how the Transport answered the last request,
+	// following the redirects, if any.
+	Result Result
+
+	// Err is the error fetching the URL, if any.
+	// A response with an error status is not an error.
+	Err error
+}
+
+// FetchAll fetches urls through the cache with GET requests, as GetOrFetch does,
+// with up to concurrency requests in flight (default 4), e.g. to gather the assets
+// of a build. It returns a FetchResult for every URL, in the same order.
+// URLs listed more than once are fetched once and share their FetchResult.
+// The error returned is that of ctx, if done before all URLs were fetched.
+func (t *Transport) FetchAll(ctx context.Context, urls []string, concurrency int) ([]FetchResult, error) {
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	results := make([]FetchResult, len(urls))
+	first := make(map[string]int) // index of the first occurrence, by URL.
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+	for i, u := range urls {
+		if _, ok := first[u]; ok {
+			continue
+		}
+		first[u] = i
+		if ctx.Err() != nil {
+			results[i] = FetchResult{URL: u, Err: ctx.Err()}
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, u string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = t.fetch(ctx, u)
+		}(i, u)
+	}
+	wg.Wait()
+
+	for i, u := range urls {
+		if j := first[u]; j != i {
+			results[i] = results[j]
+		}
+	}
+	return results, ctx.Err()
+}
+
+func (t *Transport) fetch(ctx context.Context, u string) FetchResult {
+	fr := FetchResult{URL: u}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		fr.Err = err
+		return fr
+	}
+	client := http.Client{Transport: &resultTransport{t: t, result: &fr.Result}}
+	resp, err := client.Do(req)
+	if err != nil {
+		fr.Err = err
+		return fr
+	}
+	defer resp.Body.Close()
+	fr.StatusCode = resp.StatusCode
+	fr.Body, fr.Err = io.ReadAll(resp.Body)
+	return fr
+}
+
+// resultTransport is a http.RoundTripper keeping the Result of the last request
+// sent through t.
+type resultTransport struct {
+	t      *Transport
+	result *Result
+}
+
+func (rt *resultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, result, err := rt.t.RoundTripWithResult(req)
+	*rt.result = result
+	return resp, err
+}
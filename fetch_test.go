@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestGetOrFetch(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	get := func(u string) (string, CacheStatus) {
+		resp, err := s.transport.GetOrFetch(context.Background(), u)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	body, status := get(s.server.URL + "/method")
+	c.Assert(body, qt.Equals, "GET")
+	c.Assert(status, qt.Equals, StatusMiss)
+	body, status = get(s.server.URL + "/method")
+	c.Assert(body, qt.Equals, "GET")
+	c.Assert(status, qt.Equals, StatusHit)
+
+	_, err := s.transport.GetOrFetch(context.Background(), "://invalid")
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestFetchAll(t *testing.T) {
+	c := qt.New(t)
+	var (
+		mu       sync.Mutex
+		requests = make(map[string]int)
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/a", http.StatusFound)
+			return
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	tp := &Transport{Cache: newMemoryCache(), Clock: &offsetClock{}}
+	urls := []string{ts.URL + "/a", ts.URL + "/b", ts.URL + "/a", ts.URL + "/missing", ts.URL + "/redirect", "://invalid"}
+	results, err := tp.FetchAll(context.Background(), urls, 2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(results, qt.HasLen, len(urls))
+	for i, r := range results {
+		c.Assert(r.URL, qt.Equals, urls[i])
+	}
+	c.Assert(string(results[0].Body), qt.Equals, "/a")
+	c.Assert(string(results[1].Body), qt.Equals, "/b")
+	c.Assert(results[2], qt.DeepEquals, results[0])
+	c.Assert(results[3].StatusCode, qt.Equals, http.StatusNotFound)
+	c.Assert(results[3].Err, qt.IsNil)
+	c.Assert(string(results[4].Body), qt.Equals, "/a")
+	c.Assert(results[5].Err, qt.IsNotNil)
+	mu.Lock()
+	c.Assert(requests["/a"] <= 2, qt.IsTrue) // Unless fetched through the redirect first.
+	c.Assert(requests["/b"], qt.Equals, 1)
+	mu.Unlock()
+
+	results, err = tp.FetchAll(context.Background(), urls[:2], 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(results[0].Result.Status, qt.Equals, StatusHit)
+	c.Assert(results[0].Result.Key, qt.Equals, ts.URL+"/a")
+	c.Assert(results[1].Result.Status, qt.Equals, StatusHit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = tp.FetchAll(ctx, urls, 1)
+	c.Assert(err, qt.Equals, context.Canceled)
+	c.Assert(results[1].Err, qt.Equals, context.Canceled)
+}
@@ -0,0 +1,107 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// xContentSHA256 and xContentSize are the headers holding the SHA-256 hash
+	// and the size of the body of a cached response.
+	xContentSHA256 = "X-Content-Sha256"
+	xContentSize   = "X-Content-Size"
+)
+
+// ErrCorruptEntry indicates that the body of a cached response doesn't match
+// the size or SHA-256 hash recorded when it was stored, see Transport.VerifyChecksums.
+var ErrCorruptEntry = errors.New("httpcache: corrupt cache entry")
+
+// Fingerprint identifies the content of a cached response.
+type Fingerprint struct {
+	// Hash is the hex encoded SHA-256 hash of the body.
+	Hash string
+
+	// Validator is the ETag of the response, or else its Last-Modified date, if any.
+	Validator string
+
+	// Length is the size of the body in bytes.
+	Length int64
+}
+
+// Fingerprint returns the fingerprint of the cached response for a GET request to url,
+// and false if there is none.
+//
+// It can be used to cheaply tell whether a remote resource has changed since it was
+// last seen without reading its body.
+func (t *Transport) Fingerprint(url string) (Fingerprint, bool) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return Fingerprint{}, false
+	}
+	key := t.cacheKey(req)
+	if key == "" {
+		return Fingerprint{}, false
+	}
+	b, _ := t.cacheGet(req.Context(), key)
+	if len(b) == 0 {
+		return Fingerprint{}, false
+	}
+	e, err := decodeEntry(b)
+	if err != nil {
+		return Fingerprint{}, false
+	}
+	h, err := e.Header()
+	if err != nil {
+		return Fingerprint{}, false
+	}
+	return getFingerprint(h)
+}
+
+func getFingerprint(h http.Header) (Fingerprint, bool) {
+	hash := h.Get(xContentSHA256)
+	if hash == "" {
+		return Fingerprint{}, false
+	}
+	size, err := strconv.ParseInt(h.Get(xContentSize), 10, 64)
+	if err != nil {
+		return Fingerprint{}, false
+	}
+	validator := h.Get("etag")
+	if validator == "" {
+		validator = h.Get("last-modified")
+	}
+	return Fingerprint{Hash: hash, Validator: validator, Length: size}, true
+}
+
+// verifyChecksum checks the body of e against its fingerprint,
+// if it has one, returning an error wrapping ErrCorruptEntry if it doesn't match.
+// Entries of HEAD responses and entries stored before fingerprints were recorded
+// are not checked.
+func (e *entry) verifyChecksum() error {
+	h, err := e.Header()
+	if err != nil {
+		return err
+	}
+	fp, ok := getFingerprint(h)
+	if !ok {
+		return nil
+	}
+	if int64(len(e.body)) != fp.Length {
+		return fmt.Errorf("%w: body of %d bytes, expected %d", ErrCorruptEntry, len(e.body), fp.Length)
+	}
+	if sum := sha256.Sum256(e.body); hex.EncodeToString(sum[:]) != fp.Hash {
+		return fmt.Errorf("%w: SHA-256 mismatch", ErrCorruptEntry)
+	}
+	return nil
+}
+
+// setFingerprint stores the fingerprint of a body of the given size
+// and SHA-256 hash sum in h.
+func setFingerprint(h http.Header, sum []byte, size int64) {
+	h.Set(xContentSHA256, hex.EncodeToString(sum))
+	h.Set(xContentSize, strconv.FormatInt(size, 10))
+}
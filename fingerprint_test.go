@@ -0,0 +1,98 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFingerprint(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	_, ok := s.transport.Fingerprint(s.server.URL + "/method")
+	c.Assert(ok, qt.IsFalse)
+
+	doMethod(t, "GET", "/method", nil)
+	fp, ok := s.transport.Fingerprint(s.server.URL + "/method")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(fp, qt.Equals, Fingerprint{
+		Hash:   "14e30cd163c732912e048c4c837e15c4e90c062ebb795ab947d57706e2d10dd8",
+		Length: 3,
+	})
+
+	doMethod(t, "GET", "/etag", nil)
+	fp, ok = s.transport.Fingerprint(s.server.URL + "/etag")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(fp, qt.Equals, Fingerprint{
+		Hash:      "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		Validator: "124567",
+	})
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	cache := newMemoryCache()
+	tp := &Transport{Cache: cache, VerifyChecksums: true}
+	get := func() (string, CacheStatus, error) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			return "", StatusMiss, err
+		}
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status, nil
+	}
+	corrupt := func(truncate bool) {
+		b, ok := cache.Get(ts.URL)
+		c.Assert(ok, qt.IsTrue)
+		b = append([]byte(nil), b...)
+		if truncate {
+			b = b[:len(b)-1]
+		} else {
+			b[len(b)-1] = 'x'
+		}
+		cache.Set(ts.URL, b)
+	}
+
+	get()
+	body, status, err := get()
+	c.Assert(err, qt.IsNil)
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(body, qt.Equals, "content")
+
+	// Fetched again, and stored in place of the corrupt entry.
+	for _, truncate := range []bool{true, false} {
+		corrupt(truncate)
+		body, status, err = get()
+		c.Assert(err, qt.IsNil)
+		c.Assert(status, qt.Equals, StatusMiss)
+		c.Assert(body, qt.Equals, "content")
+		_, status, _ = get()
+		c.Assert(status, qt.Equals, StatusHit)
+	}
+	c.Assert(requests, qt.Equals, 3)
+
+	corrupt(false)
+	tp.Offline = true
+	_, _, err = get()
+	c.Assert(errors.Is(err, ErrCorruptEntry), qt.IsTrue)
+	_, ok := cache.Get(ts.URL)
+	c.Assert(ok, qt.IsFalse)
+}
@@ -0,0 +1,106 @@
+package httpcache
+
+import (
+	"context"
+	"time"
+)
+
+// GCReport is the result of a Transport.GC.
+type GCReport struct {
+	// Entries is the number of cached responses examined.
+	Entries int
+
+	// Deleted is the number of expired responses deleted.
+	Deleted int
+
+	// DeletedBytes is the size in bytes of the deleted entries.
+	DeletedBytes int64
+
+	// Errors is the number of expired responses that failed to be deleted.
+	Errors int
+}
+
+// GC deletes the cached responses that are no longer worth keeping: those past
+// their freshness lifetime, including any lifetime set by Extend, and their
+// stale-while-revalidate and stale-if-error windows (or StaleWhileRevalidate),
+// plus ExpiryGrace for responses with validators, which could still be revalidated.
+// Unlike CacheWithExpiry backends, GC doesn't keep responses with validators forever
+// when ExpiryGrace is 0. Responses without a Date header and those pinned with Pin are kept.
+//
+// Caches without expiry of their own, e.g. diskcache, otherwise grow forever.
+// See RunGC to run it periodically. The Cache must implement IterableCache.
+func (t *Transport) GC(ctx context.Context) (GCReport, error) {
+	var report GCReport
+
+	cache, ok := t.Cache.(IterableCache)
+	if !ok {
+		return report, ErrCacheNotIterable
+	}
+
+	var keys []string
+	cache.Keys("", func(key string) bool {
+		keys = append(keys, key)
+		return ctx.Err() == nil
+	})
+
+	clk := t.timer()
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		b, _ := t.cacheGet(ctx, key)
+		if len(b) == 0 {
+			continue
+		}
+		e, err := decodeEntry(b)
+		if err != nil {
+			// Not a response, e.g. the saved Counters.
+			continue
+		}
+		h, err := e.Header()
+		if err != nil || h.Get(xRequestURL) == "" {
+			continue
+		}
+		report.Entries++
+		if isPinned(h) {
+			continue
+		}
+		retention, ok := t.retention(h)
+		if !ok {
+			continue
+		}
+		if d, _ := date(h); clk.since(d) < retention {
+			continue
+		}
+		if err := t.cacheDelete(ctx, key); err != nil {
+			report.Errors++
+			continue
+		}
+		report.Deleted++
+		report.DeletedBytes += int64(len(b))
+	}
+
+	return report, ctx.Err()
+}
+
+// RunGC runs GC every interval until ctx is done, calling report, if not nil,
+// with the outcome of every run. It returns the error of ctx, or ErrCacheNotIterable
+// if the Cache doesn't implement IterableCache.
+func (t *Transport) RunGC(ctx context.Context, interval time.Duration, report func(GCReport, error)) error {
+	if _, ok := t.Cache.(IterableCache); !ok {
+		return ErrCacheNotIterable
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			r, err := t.GC(ctx)
+			if report != nil {
+				report(r, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestGC(t *testing.T) {
+	c := qt.New(t)
+	clk := &offsetClock{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", clk.Now().UTC().Format(http.TimeFormat))
+		switch r.URL.Path {
+		case "/short":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/stale-if-error":
+			w.Header().Set("Cache-Control", "max-age=60, stale-if-error=3600")
+		case "/etag":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Etag", `"v1"`)
+		case "/long":
+			w.Header().Set("Cache-Control", "max-age=86400")
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	cache := newMemoryCache()
+	tp := &Transport{Cache: cache, Clock: clk}
+	for _, path := range []string{"/short", "/stale-if-error", "/etag", "/long"} {
+		resp, err := (&http.Client{Transport: tp}).Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+	}
+	cache.Set("not-a-response", []byte("junk"))
+	ctx := context.Background()
+
+	report, err := tp.GC(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(report, qt.DeepEquals, GCReport{Entries: 4})
+
+	clk.offset = 2 * time.Minute
+	tp.ExpiryGrace = time.Hour
+	report, err = tp.GC(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(report.Entries, qt.Equals, 4)
+	c.Assert(report.Deleted, qt.Equals, 1)
+	c.Assert(report.DeletedBytes > 0, qt.IsTrue)
+	_, ok := cache.Get(ts.URL + "/short")
+	c.Assert(ok, qt.IsFalse)
+
+	clk.offset = 2 * time.Hour
+	report, err = tp.GC(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(report.Deleted, qt.Equals, 2)
+	_, ok = cache.Get(ts.URL + "/long")
+	c.Assert(ok, qt.IsTrue)
+	_, ok = cache.Get("not-a-response")
+	c.Assert(ok, qt.IsTrue)
+
+	tp.Cache = &staleCache{}
+	_, err = tp.GC(ctx)
+	c.Assert(err, qt.Equals, ErrCacheNotIterable)
+}
+
+func TestRunGC(t *testing.T) {
+	c := qt.New(t)
+	tp := &Transport{Cache: newMemoryCache(), Clock: &offsetClock{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := make(chan GCReport)
+	done := make(chan error)
+	go func() {
+		done <- tp.RunGC(ctx, time.Millisecond, func(r GCReport, err error) {
+			select {
+			case runs <- r:
+			default:
+			}
+		})
+	}()
+	<-runs
+	<-runs
+	cancel()
+	c.Assert(<-done, qt.Equals, context.Canceled)
+
+	tp.Cache = &staleCache{}
+	c.Assert(tp.RunGC(context.Background(), time.Millisecond, nil), qt.Equals, ErrCacheNotIterable)
+}
@@ -0,0 +1,159 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCacheUnavailable is returned by a HealthCheckedCache while its backend is down,
+// and is the error of the operations skipped by a Transport, see Transport.CacheFailureThreshold.
+var ErrCacheUnavailable = errors.New("httpcache: cache backend unavailable")
+
+// HealthCheckOptions configures a HealthCheckedCache.
+type HealthCheckOptions struct {
+	// MaxFailures is the number of consecutive failed operations after which
+	// the backend is considered down. Default is 5.
+	MaxFailures int
+
+	// ProbeInterval is the time to wait before letting an operation through
+	// to probe a backend that is down. Default is 10 seconds.
+	ProbeInterval time.Duration
+
+	// Timeout, if > 0, is the timeout of every operation,
+	// operations timing out counting as failures.
+	Timeout time.Duration
+
+	// OnStateChange is an optional func called when the backend goes down,
+	// with the error of the last failed operation, and when it's back up, with a nil error.
+	OnStateChange func(healthy bool, err error)
+}
+
+// HealthCheckedCache is a Cache detecting a persistently failing backend, e.g. a
+// Redis server during an outage, and failing fast with ErrCacheUnavailable while
+// it's down, so the Transport passes requests through to the servers without
+// waiting for the backend to time out on every request.
+// While the backend is down, an operation is let through every ProbeInterval
+// to check whether it's back up.
+//
+// The optional interfaces of the wrapped Cache, besides CacheContext and IterableCache,
+// are hidden.
+type HealthCheckedCache struct {
+	cache CacheContext
+	c     Cache
+	opts  HealthCheckOptions
+
+	breaker breaker
+
+	now func() time.Time
+}
+
+var (
+	_ CacheContext  = (*HealthCheckedCache)(nil)
+	_ IterableCache = (*HealthCheckedCache)(nil)
+)
+
+// NewHealthCheckedCache returns a new HealthCheckedCache checking the health of c.
+func NewHealthCheckedCache(c Cache, opts HealthCheckOptions) *HealthCheckedCache {
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = 5
+	}
+	if opts.ProbeInterval <= 0 {
+		opts.ProbeInterval = 10 * time.Second
+	}
+	return &HealthCheckedCache{
+		cache: AdaptCache(c),
+		c:     c,
+		opts:  opts,
+		now:   time.Now,
+	}
+}
+
+// Healthy reports whether the backend is considered up.
+func (c *HealthCheckedCache) Healthy() bool {
+	return !c.breaker.isOpen()
+}
+
+// Get returns the response stored for key, if any.
+func (c *HealthCheckedCache) Get(key string) ([]byte, bool) {
+	b, ok, err := c.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return b, ok
+}
+
+// Set stores the response b for key.
+func (c *HealthCheckedCache) Set(key string, b []byte) {
+	c.SetContext(context.Background(), key, b)
+}
+
+// Delete removes the response stored for key.
+func (c *HealthCheckedCache) Delete(key string) {
+	c.DeleteContext(context.Background(), key)
+}
+
+// GetContext returns the response stored for key, if any.
+func (c *HealthCheckedCache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	var (
+		b  []byte
+		ok bool
+	)
+	err := c.do(ctx, func(ctx context.Context) error {
+		var err error
+		b, ok, err = c.cache.GetContext(ctx, key)
+		return err
+	})
+	return b, ok, err
+}
+
+// SetContext stores the response b for key.
+func (c *HealthCheckedCache) SetContext(ctx context.Context, key string, b []byte) error {
+	return c.do(ctx, func(ctx context.Context) error {
+		return c.cache.SetContext(ctx, key, b)
+	})
+}
+
+// DeleteContext removes the response stored for key.
+func (c *HealthCheckedCache) DeleteContext(ctx context.Context, key string) error {
+	return c.do(ctx, func(ctx context.Context) error {
+		return c.cache.DeleteContext(ctx, key)
+	})
+}
+
+// Keys calls fn for every key starting with prefix, until fn returns false.
+// Nothing is listed if the wrapped Cache doesn't implement IterableCache,
+// or while the backend is down.
+func (c *HealthCheckedCache) Keys(prefix string, fn func(key string) bool) {
+	ic, ok := c.c.(IterableCache)
+	if !ok || !c.Healthy() {
+		return
+	}
+	ic.Keys(prefix, fn)
+}
+
+// do runs op against the backend, unless it's down, and records its outcome.
+func (c *HealthCheckedCache) do(ctx context.Context, op func(ctx context.Context) error) error {
+	ok, probe := c.breaker.allow(c.now())
+	if !ok {
+		return ErrCacheUnavailable
+	}
+
+	opCtx := ctx
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		opCtx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+	err := op(opCtx)
+	if err != nil && ctx.Err() != nil {
+		// Canceled by the caller, it says nothing about the backend.
+		c.breaker.cancel(probe)
+		return err
+	}
+	changed := c.breaker.done(err, probe, c.now(), c.opts.MaxFailures, c.opts.ProbeInterval)
+	if changed && c.opts.OnStateChange != nil {
+		c.opts.OnStateChange(err == nil, err)
+	}
+	return err
+}
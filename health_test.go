@@ -0,0 +1,121 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestHealthCheckedCache(t *testing.T) {
+	c := qt.New(t)
+	backend := &contextMemoryCache{items: map[string][]byte{}}
+	var transitions []string
+	cache := NewHealthCheckedCache(FromCacheContext(backend), HealthCheckOptions{
+		MaxFailures:   2,
+		ProbeInterval: time.Minute,
+		OnStateChange: func(healthy bool, err error) {
+			if healthy {
+				transitions = append(transitions, "up")
+			} else {
+				transitions = append(transitions, "down: "+err.Error())
+			}
+		},
+	})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+	calls := func() int {
+		backend.mu.Lock()
+		defer backend.mu.Unlock()
+		return len(backend.values)
+	}
+
+	c.Assert(cache.SetContext(ctx, "k", []byte("v")), qt.IsNil)
+	b, ok, err := cache.GetContext(ctx, "k")
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "v")
+
+	errDown := errors.New("connection refused")
+	backend.err = errDown
+	_, _, err = cache.GetContext(ctx, "k")
+	c.Assert(err, qt.Equals, errDown)
+	c.Assert(cache.Healthy(), qt.IsTrue)
+	_, _, err = cache.GetContext(ctx, "k")
+	c.Assert(err, qt.Equals, errDown)
+	c.Assert(cache.Healthy(), qt.IsFalse)
+	c.Assert(transitions, qt.DeepEquals, []string{"down: connection refused"})
+
+	// Fail fast while down.
+	n := calls()
+	_, _, err = cache.GetContext(ctx, "k")
+	c.Assert(err, qt.Equals, ErrCacheUnavailable)
+	c.Assert(cache.SetContext(ctx, "k", nil), qt.Equals, ErrCacheUnavailable)
+	c.Assert(calls(), qt.Equals, n)
+
+	// A failed probe.
+	now = now.Add(time.Minute)
+	_, _, err = cache.GetContext(ctx, "k")
+	c.Assert(err, qt.Equals, errDown)
+	c.Assert(calls(), qt.Equals, n+1)
+	_, _, err = cache.GetContext(ctx, "k")
+	c.Assert(err, qt.Equals, ErrCacheUnavailable)
+
+	// A successful probe.
+	backend.err = nil
+	now = now.Add(time.Minute)
+	_, ok, err = cache.GetContext(ctx, "k")
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(cache.Healthy(), qt.IsTrue)
+	c.Assert(transitions, qt.DeepEquals, []string{"down: connection refused", "up"})
+}
+
+func TestHealthCheckedCacheTimeout(t *testing.T) {
+	c := qt.New(t)
+	cache := NewHealthCheckedCache(FromCacheContext(slowCache{}), HealthCheckOptions{MaxFailures: 1, Timeout: time.Millisecond})
+
+	_, _, err := cache.GetContext(context.Background(), "k")
+	c.Assert(err, qt.Equals, context.DeadlineExceeded)
+	c.Assert(cache.Healthy(), qt.IsFalse)
+
+	// With the Transport, requests are passed through.
+	client := http.Client{Transport: &Transport{Cache: cache, MarkCachedResponses: true}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(s.server.URL + "/method")
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+		c.Assert(resp.Header.Get(XFromCache), qt.Equals, "")
+	}
+
+	// Canceled operations don't count.
+	cache = NewHealthCheckedCache(FromCacheContext(slowCache{}), HealthCheckOptions{MaxFailures: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = cache.GetContext(ctx, "k")
+	c.Assert(err, qt.Equals, context.Canceled)
+	c.Assert(cache.Healthy(), qt.IsTrue)
+}
+
+// slowCache is a CacheContext blocking until its context is done.
+type slowCache struct{}
+
+func (slowCache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	<-ctx.Done()
+	return nil, false, ctx.Err()
+}
+
+func (slowCache) SetContext(ctx context.Context, key string, b []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (slowCache) DeleteContext(ctx context.Context, key string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
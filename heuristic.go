@@ -0,0 +1,45 @@
+package httpcache
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultHeuristicFreshnessFactor is the default value of Transport.HeuristicFreshnessFactor,
+	// as suggested by RFC 7234, section 4.2.2.
+	defaultHeuristicFreshnessFactor = 0.1
+
+	// defaultHeuristicFreshnessMax is the default value of Transport.HeuristicFreshnessMax.
+	defaultHeuristicFreshnessMax = 24 * time.Hour
+)
+
+// heuristicLifetime returns the heuristic freshness lifetime of a response with the
+// given headers: a fraction of the time elapsed between its Last-Modified and Date headers,
+// or 0 if it has none.
+func (t *Transport) heuristicLifetime(respHeaders http.Header) time.Duration {
+	if !t.HeuristicFreshness {
+		return 0
+	}
+	lastModified, err := http.ParseTime(respHeaders.Get("Last-Modified"))
+	if err != nil {
+		return 0
+	}
+	date, err := date(respHeaders)
+	if err != nil || !lastModified.Before(date) {
+		return 0
+	}
+	factor := t.HeuristicFreshnessFactor
+	if factor <= 0 {
+		factor = defaultHeuristicFreshnessFactor
+	}
+	maxLifetime := t.HeuristicFreshnessMax
+	if maxLifetime <= 0 {
+		maxLifetime = defaultHeuristicFreshnessMax
+	}
+	lifetime := time.Duration(float64(date.Sub(lastModified)) * factor)
+	if lifetime > maxLifetime {
+		lifetime = maxLifetime
+	}
+	return lifetime
+}
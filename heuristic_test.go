@@ -0,0 +1,65 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestHeuristicFreshness(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var (
+		requests     int
+		lastModified = time.Now().Add(-10 * time.Hour)
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.HeuristicFreshness = true
+	client := http.Client{Transport: tp}
+
+	get := func() {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+	}
+
+	get()
+	c.Assert(requests, qt.Equals, 1)
+
+	// Fresh for 10% of 10 hours.
+	clock = &fakeClock{elapsed: 50 * time.Minute}
+	get()
+	c.Assert(requests, qt.Equals, 1)
+	clock = &fakeClock{elapsed: 70 * time.Minute}
+	get()
+	c.Assert(requests, qt.Equals, 2)
+
+	h := http.Header{}
+	h.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	h.Set("Last-Modified", time.Now().Add(-1000*time.Hour).UTC().Format(http.TimeFormat))
+	c.Assert(tp.heuristicLifetime(h), qt.Equals, 24*time.Hour)
+	tp.HeuristicFreshnessMax = time.Hour
+	c.Assert(tp.heuristicLifetime(h), qt.Equals, time.Hour)
+	tp.HeuristicFreshnessMax = 0
+	tp.HeuristicFreshnessFactor = 0.01
+	c.Assert(tp.heuristicLifetime(h), qt.Equals, 10*time.Hour)
+	h.Del("Last-Modified")
+	c.Assert(tp.heuristicLifetime(h), qt.Equals, time.Duration(0))
+	tp.HeuristicFreshness = false
+	h.Set("Last-Modified", time.Now().Add(-1000*time.Hour).UTC().Format(http.TimeFormat))
+	c.Assert(tp.heuristicLifetime(h), qt.Equals, time.Duration(0))
+}
@@ -0,0 +1,81 @@
+package httpcache
+
+import (
+	"net/http"
+	"time"
+)
+
+// HostPolicy configures how the Transport caches the responses of a host,
+// see Transport.HostPolicies.
+// The Options of a request take precedence.
+type HostPolicy struct {
+	// Disable sends the requests to the host without looking up
+	// or storing the responses in the cache.
+	Disable bool
+
+	// MinTTL is the minimum time, counted from their Date header, the responses
+	// of the host are considered fresh, see Options.MinTTL.
+	MinTTL time.Duration
+
+	// CacheKey is an optional func that returns the key to use to store the
+	// responses of the host, overriding Transport.CacheKey.
+	CacheKey func(req *http.Request) string
+
+	// MaxEntrySize is the maximum size of the body of a response of the host
+	// to store in the cache. Larger responses are passed through.
+	// If 0, Transport.MaxCacheableBodySize applies.
+	MaxEntrySize int64
+
+	// Offline serves cached responses of the host, fresh or stale, without ever
+	// contacting it, see Transport.Offline.
+	Offline bool
+
+	// Validation is the ValidationStrategy used to revalidate the stale cached
+	// responses of the host, e.g. ETagValidation for a host mishandling
+	// If-Modified-Since, overriding Transport.Validation.
+	Validation ValidationStrategy
+}
+
+// hostPolicy returns the HostPolicy for the host of req, and false if there is none.
+func (t *Transport) hostPolicy(req *http.Request) (HostPolicy, bool) {
+	if len(t.HostPolicies) == 0 || req.URL == nil {
+		return HostPolicy{}, false
+	}
+	if p, ok := t.HostPolicies[req.URL.Host]; ok {
+		return p, true
+	}
+	p, ok := t.HostPolicies[req.URL.Hostname()]
+	return p, ok
+}
+
+// maxEntrySize returns the maximum size of the bodies of the responses
+// to store under policy, 0 if there is no limit.
+func (t *Transport) maxEntrySize(policy HostPolicy) int64 {
+	if policy.MaxEntrySize > 0 {
+		return policy.MaxEntrySize
+	}
+	return t.MaxCacheableBodySize
+}
+
+// options returns the Options for req, with the defaults of
+// the HostPolicy for its host, if any, applied.
+func (t *Transport) options(req *http.Request) Options {
+	opts := requestOptions(req)
+	p, ok := t.hostPolicy(req)
+	if !ok {
+		return opts
+	}
+	if p.Disable {
+		opts.BypassCache = true
+	}
+	if p.Offline {
+		opts.Offline = true
+	}
+	if opts.MinTTL == 0 {
+		opts.MinTTL = p.MinTTL
+	}
+	if opts.CacheKey == "" && p.CacheKey != nil {
+		opts.CacheKey = p.CacheKey(req)
+	}
+	return opts
+}
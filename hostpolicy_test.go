@@ -0,0 +1,86 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestHostPolicies(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/large" {
+			w.Write([]byte(strings.Repeat("a", 100)))
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+	host := func() string {
+		u, _ := url.Parse(ts.URL)
+		return u.Host
+	}()
+
+	tp := newMemoryCacheTransport()
+	client := http.Client{Transport: tp}
+	get := func(path string) (*http.Response, CacheStatus) {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return resp, status
+	}
+
+	// Host names match all ports, host and port take precedence.
+	tp.HostPolicies = map[string]HostPolicy{
+		"127.0.0.1": {Disable: true},
+		host:        {MinTTL: time.Hour},
+	}
+	get("/a")
+	clock = &fakeClock{elapsed: 30 * time.Minute}
+	_, status := get("/a")
+	c.Assert(status, qt.Equals, StatusHit)
+	clock = &realClock{}
+
+	tp.HostPolicies = map[string]HostPolicy{"127.0.0.1": {Disable: true}}
+	requests = 0
+	_, status = get("/b")
+	c.Assert(status, qt.Equals, StatusMiss)
+	_, status = get("/b")
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(requests, qt.Equals, 2)
+
+	tp.HostPolicies = map[string]HostPolicy{host: {
+		CacheKey:     func(req *http.Request) string { return "key" + req.URL.Path },
+		MaxEntrySize: 10,
+	}}
+	get("/c")
+	_, found := tp.Cache.Get("key/c")
+	c.Assert(found, qt.IsTrue)
+	get("/large")
+	_, found = tp.Cache.Get("key/large")
+	c.Assert(found, qt.IsFalse)
+
+	tp.HostPolicies = map[string]HostPolicy{host: {Offline: true, CacheKey: func(req *http.Request) string { return "key" + req.URL.Path }}}
+	requests = 0
+	clock = &fakeClock{elapsed: 2 * time.Hour}
+	_, status = get("/c")
+	c.Assert(status, qt.Equals, StatusStale)
+	resp, status := get("/d")
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusGatewayTimeout)
+	c.Assert(requests, qt.Equals, 0)
+}
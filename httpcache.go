@@ -1,21 +1,24 @@
 // Package httpcache provides a http.RoundTripper implementation that works as a
 // mostly RFC-compliant cache for http responses.
 //
-// It is only suitable for use as a 'private' cache (i.e. for a web-browser or an API-client
-// and not for a shared proxy).
+// By default it acts as a 'private' cache (i.e. for a web-browser or an API-client).
+// Set Transport.Shared to use it as a shared cache, e.g. in a proxy serving many users.
+//
+// For an in-memory cache with a bounded size, use the lrucache package,
+// e.g. lrucache.NewTransport; for a persistent cache, see the diskcache package.
 package httpcache
 
 import (
 	"bufio"
 	"bytes"
-	"crypto/md5"
-	"encoding/hex"
+	"crypto/sha256"
 	"errors"
-	"hash"
+	"fmt"
 	"io"
 	"net/http"
-	"net/http/httputil"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -37,9 +40,19 @@ const (
 	// In the Response returned from Response, XETag1 will be the cached value (old) and
 	// XETag2 will be the eTag value from the server (new).
 	XETag2 = xEtags + "2"
+
+	// xRequestURL and xRequestMethod are the headers holding the URL and the method
+	// of the request a cached response was stored for.
+	xRequestURL    = "X-Request-Url"
+	xRequestMethod = "X-Request-Method"
+
+	// xOriginalKey is the header holding the cache key of a cached response
+	// before Transport.HashKey was applied.
+	xOriginalKey = "X-Original-Key"
 )
 
 // A Cache interface is used by the Transport to store and retrieve responses.
+// See the lrucache and diskcache packages for implementations.
 type Cache interface {
 	// Get returns the []byte representation of a cached response and a bool
 	// set to set to false if the key is not found or the value is stale.
@@ -50,34 +63,83 @@ type Cache interface {
 	Delete(key string)
 }
 
+// IterableCache is an optional interface implemented by Cache backends
+// that can enumerate their keys.
+type IterableCache interface {
+	Cache
+	// Keys calls fn for every key starting with prefix, until fn returns false.
+	Keys(prefix string, fn func(key string) bool)
+}
+
 // cacheKey returns the cache key for req.
 func (t *Transport) cacheKey(req *http.Request) string {
+	key := t.unhashedCacheKey(req)
+	if key == "" || t.HashKey == nil {
+		return key
+	}
+	return t.HashKey(key)
+}
+
+// unhashedCacheKey returns the cache key for req before HashKey is applied.
+func (t *Transport) unhashedCacheKey(req *http.Request) string {
+	if key := t.options(req).CacheKey; key != "" {
+		return key
+	}
 	if t.CacheKey != nil {
 		return t.CacheKey(req)
 	}
+	if t.CacheRangeResponses {
+		if key := rangeCacheKey(req); key != "" {
+			return key
+		}
+	}
+	return defaultCacheKey(req, req.URL.String())
+}
 
-	cacheable := (req.Method != http.MethodHead || req.Method == "HEAD") && req.Header.Get("range") == ""
-	if !cacheable {
+// defaultCacheKey returns the default cache key for req, identified by the URL u.
+func defaultCacheKey(req *http.Request, u string) string {
+	if req.Header.Get("range") != "" {
 		return ""
 	}
 
 	if req.Method == http.MethodGet {
-		return req.URL.String()
+		return u
+	} else if h, ok := RequestBodyHash(req); ok {
+		return req.Method + " " + u + " " + h
 	} else {
-		return req.Method + " " + req.URL.String()
+		return req.Method + " " + u
 	}
 }
 
 // cachedResponse returns the cached http.Response for req if present and
 // a bool set to false if the value is stale.
 func (t *Transport) cachedResponse(req *http.Request) (*http.Response, bool, error) {
-	cachedVal, ok := t.Cache.Get(t.cacheKey(req))
+	return t.cachedResponseForKey(req, t.cacheKey(req))
+}
+
+// cachedResponseForKey is like cachedResponse, but looks up the response stored for key.
+func (t *Transport) cachedResponseForKey(req *http.Request, key string) (*http.Response, bool, error) {
+	cachedVal, ok, err := t.cacheGetErr(req.Context(), key)
+	if err != nil && t.StrictErrors {
+		return nil, false, &CacheBackendError{Op: "get", Key: key, Err: err}
+	}
 	if !ok && len(cachedVal) == 0 {
 		return nil, false, nil
 	}
-	b := bytes.NewBuffer(cachedVal)
-	resp, err := http.ReadResponse(bufio.NewReader(b), req)
+	e, err := decodeEntry(cachedVal)
+	if err == nil && t.VerifyChecksums {
+		err = e.verifyChecksum()
+	}
+	var resp *http.Response
+	if err == nil {
+		resp, err = e.response(req)
+	}
 	if err != nil {
+		if errors.Is(err, ErrCorruptEntry) {
+			t.debug(req, "httpcache: corrupt entry", "key", key, "error", err)
+			t.deleteEntry(req.Context(), key)
+			t.evicted(req, key)
+		}
 		return nil, false, err
 	}
 	return resp, ok, nil
@@ -86,6 +148,9 @@ func (t *Transport) cachedResponse(req *http.Request) (*http.Response, bool, err
 // Transport is an implementation of http.RoundTripper that will return values from a cache
 // where possible (avoiding a network request) and will additionally add validators (etag/if-modified-since)
 // to repeated requests allowing servers to return 304 / Not Modified
+//
+// A Transport is safe for concurrent use, but its fields must not be modified once it is in use.
+// Use Clone to derive a new configuration, or a SwappableTransport to change it at runtime.
 type Transport struct {
 	// The RoundTripper interface actually used to make requests
 	// If nil, http.DefaultTransport is used
@@ -103,10 +168,22 @@ type Transport struct {
 	// If the server does not return an eTag, the MD5 hash of the response body is used.
 	EnableETagPair bool
 
+	// ETagPairHashLimit, if > 0, limits the hash computed in EnableETagPair mode for
+	// responses without an ETag to the first ETagPairHashLimit bytes of the body and
+	// its total length, so change detection stays cheap for huge bodies, at the cost
+	// of missing changes past the limit that keep the length.
+	ETagPairHashLimit int64
+
 	// CacheKey is an optional func that returns the key to use to store the response.
 	// An empty string signals that this request should not be cached.
 	CacheKey func(req *http.Request) string
 
+	// HashKey is an optional func applied to the cache keys, e.g. SHA256Key, so they
+	// fit the key length limits of some backends (e.g. 250 bytes for memcached)
+	// whatever the length of the URLs. The key before hashing is kept in the entry,
+	// see EntryInfo.OriginalKey. PurgePrefix matches the hashed keys.
+	HashKey func(key string) string
+
 	// AlwaysUseCachedResponse is an optional func that when it returns true
 	// a successful response from the cache will be returned without connecting to the server.
 	AlwaysUseCachedResponse func(req *http.Request, key string) bool
@@ -114,22 +191,404 @@ type Transport struct {
 	// ShouldCache is an optional func that when it returns false, the response will not be cached.
 	ShouldCache func(req *http.Request, resp *http.Response, key string) bool
 
+	// TransformBeforeStore is an optional func that rewrites the responses before they
+	// are stored, e.g. to strip Set-Cookie headers or truncate huge bodies.
+	// The Header and Body of the response it returns are stored, the response returned
+	// to the caller is left as is. If it returns nil, the response is not stored.
+	// The headers the Transport uses for its own book-keeping are hidden from it.
+	// Responses are buffered, not streamed to a StreamingCache, when it is set.
+	TransformBeforeStore func(req *http.Request, resp *http.Response) *http.Response
+
+	// TransformAfterLoad is an optional func that rewrites the responses served from
+	// the cache, e.g. to add headers or check the integrity of the bodies, before
+	// they are returned, the cache entry being left as is. If it returns an error,
+	// RoundTrip fails with it. Use ResponseCacheStatus to tell how the response was served.
+	TransformAfterLoad func(req *http.Request, resp *http.Response) (*http.Response, error)
+
+	// OnStoreSkip is an optional func called when resp, the response to req,
+	// is not stored in the cache, with the reason why, e.g. to debug caching.
+	// It may be called once the body of resp has been read, e.g. with SkipTooLarge.
+	OnStoreSkip func(req *http.Request, resp *http.Response, reason StoreSkipReason)
+
 	// Around is an optional func.
 	// If set, the Transport will call Around at the start of RoundTrip
 	// and defer the returned func until the end of RoundTrip.
 	// Typically used to implement a lock that is held for the duration of the RoundTrip.
 	Around func(req *http.Request, key string) func()
+
+	// TransformCachedBody is an optional func, meant as a development aid, that
+	// rewrites the bodies of responses served from the cache, e.g. to inject a banner
+	// telling when the response was cached, to see whether an application gets cached
+	// or live content. The cache entry is left as is.
+	// Use ResponseCacheStatus to tell how the response was served.
+	TransformCachedBody func(req *http.Request, resp *http.Response, body []byte) []byte
+
+	// PropagateHeaders is an optional list of request headers that will be copied
+	// from the triggering request onto requests the Transport sends in the background
+	// (e.g. revalidations of stale entries), so origin-side logs can correlate them.
+	// If nil, all headers are copied.
+	// The context values (e.g. trace spans) of the triggering request are always propagated.
+	PropagateHeaders []string
+
+	// If AdaptiveTTL is true, the Transport learns a freshness lifetime for responses
+	// that come without one (no max-age directive or Expires header) by observing
+	// how often their validators change across revalidations.
+	// Responses found unchanged get their lifetime doubled, changed ones halved.
+	AdaptiveTTL bool
+
+	// AdaptiveTTLMax caps the freshness lifetime learned in AdaptiveTTL mode.
+	// Default is 24 hours.
+	AdaptiveTTLMax time.Duration
+
+	// If Offline is true, the Transport never contacts the servers: cached responses
+	// are served regardless of their freshness, as with the only-if-cached request
+	// directive, and requests for uncached responses get a 504 Gateway Timeout,
+	// or fail with OfflineError if set.
+	// See Options.Offline to enable it for a single request.
+	Offline bool
+
+	// OfflineError is the error returned for requests for uncached responses
+	// in Offline mode. If nil, a 504 Gateway Timeout response is returned,
+	// or ErrNoCachedResponse with StrictErrors.
+	OfflineError error
+
+	// CacheFailureThreshold, if > 0, is the number of consecutive failed operations of
+	// the Cache, returning an error or panicking, after which the Cache is skipped for
+	// CacheCooldown: requests are passed through to the servers without looking up or
+	// storing their responses. Failures are always reported to the EventHandler and to
+	// Stats if it implements CacheErrorRecorder, and panics of the Cache recovered,
+	// a failed lookup being a miss, see also HealthCheckedCache.
+	CacheFailureThreshold int
+
+	// CacheCooldown is the time the Cache is skipped after CacheFailureThreshold
+	// consecutive failures. The next operation then probes whether it's back, the
+	// others still skipping it until the probe succeeds.
+	// Default is 10 seconds.
+	CacheCooldown time.Duration
+
+	// StrictErrors, if set, makes RoundTrip fail with typed errors where it would
+	// otherwise answer with a synthesized response or carry on silently:
+	// ErrNoCachedResponse in Offline mode and ErrOnlyIfCachedMiss for only-if-cached
+	// requests instead of a 504 Gateway Timeout response, and a *CacheBackendError
+	// when reading from the Cache fails instead of fetching the response from the server.
+	StrictErrors bool
+
+	// HostPolicies optionally configures caching per host, keyed by host name
+	// (e.g. "example.com") or host and port (e.g. "example.com:8080"),
+	// the latter taking precedence.
+	HostPolicies map[string]HostPolicy
+
+	// If IndexContentLocation is true, responses to GET requests are also stored
+	// under the URL in their Content-Location header, if it has the same origin,
+	// and the responses stored for the Content-Location of a successful response
+	// to an unsafe method (e.g. POST or PUT) are invalidated.
+	IndexContentLocation bool
+
+	// MicroCache, if > 0, is the time during which any cached response to a GET request
+	// is served to identical requests without revalidation, whatever its Cache-Control
+	// and Expires headers, e.g. for crawlers requesting the same URLs hundreds of times
+	// in a few seconds. Responses that may not be stored (no-store) are still fetched
+	// every time, and requests with an Authorization header or a no-cache directive
+	// are left out.
+	MicroCache time.Duration
+
+	// If Shared is true, the Transport acts as a shared cache, e.g. in a proxy
+	// serving many users, instead of a private one: the s-maxage directive overrides
+	// max-age, responses with the private directive are not stored, and neither are
+	// responses to requests with an Authorization header, unless explicitly allowed
+	// with public, must-revalidate or s-maxage, see RFC 9111 section 3.5
+	// and AuthorizationPolicy.
+	Shared bool
+
+	// AuthorizationPolicy tells how the responses to requests with an Authorization
+	// header are cached. The default restricts them if Shared is set and caches them
+	// like any other otherwise.
+	AuthorizationPolicy AuthorizationPolicy
+
+	// If FollowCachedRedirects is true, a fresh cached redirect (301, 302, 307 or 308)
+	// in response to a GET request is followed from the cache when all the hops of
+	// the chain it starts are cached and fresh, and the response at its end is returned,
+	// its Request being that of the last hop. Otherwise, e.g. on loops,
+	// the redirect is returned for the client to follow.
+	FollowCachedRedirects bool
+
+	// If CacheRangeResponses is true, the 206 Partial Content responses to GET requests
+	// with a Range header are stored under a key made of the URL and the range requested,
+	// and served to requests for the same range.
+	// Range requests are always served from a fresh cached full 200 response, if any.
+	CacheRangeResponses bool
+
+	// MaxVariants, if > 1, is the number of variants of a response to a GET request
+	// with a Vary header kept in the cache, e.g. for each Accept-Language requested,
+	// instead of only the last one stored. The least recently stored variants
+	// are evicted first, and all of them when the Vary header of the response changes.
+	// Variants are not kept for responses streamed to a StreamingCache.
+	MaxVariants int
+
+	// If HeuristicFreshness is true, responses with a Last-Modified header but
+	// no explicit freshness lifetime (no max-age directive or Expires header) are
+	// considered fresh for a fraction of the time elapsed since they were last modified,
+	// as described in RFC 7234, section 4.2.2.
+	// A lifetime learned in AdaptiveTTL mode takes precedence.
+	HeuristicFreshness bool
+
+	// HeuristicFreshnessFactor is the fraction used in HeuristicFreshness mode.
+	// Default is 0.1.
+	HeuristicFreshnessFactor float64
+
+	// HeuristicFreshnessMax caps the freshness lifetime in HeuristicFreshness mode.
+	// Default is 24 hours.
+	HeuristicFreshnessMax time.Duration
+
+	// InlineBodyBytes, if > 0, is the size up to which the bodies of responses are stored
+	// with their metadata when the Cache implements MetadataCache, so most small responses
+	// are read back in a single round trip to the backend.
+	InlineBodyBytes int
+
+	// MaxBodyBufferBytes is an optional limit on the size of a response body buffered
+	// in memory to store it in the cache. Larger responses are passed through without
+	// being cached, unless the Cache implements StreamingCache.
+	// If 0, there is no limit.
+	MaxBodyBufferBytes int64
+
+	// MaxCacheableBodySize is an optional limit on the size of the response bodies
+	// stored in the cache, buffered or streamed to a StreamingCache.
+	// Responses with a larger Content-Length are passed through without being cached,
+	// others are dropped from the cache as soon as their body exceeds the limit,
+	// discarding what was buffered so far.
+	// HostPolicy.MaxEntrySize overrides it for its host.
+	// If 0, there is no limit.
+	MaxCacheableBodySize int64
+
+	// MaxInFlightBufferBytes is an optional ceiling on the total number of bytes
+	// buffered for cache fills across all in-flight responses.
+	// When the ceiling is reached, new responses are passed through without being cached
+	// until enough of the in-flight fills have completed.
+	// If 0, there is no limit.
+	MaxInFlightBufferBytes int64
+
+	// VerifyChecksums, if set, verifies the bodies of the cached responses read from
+	// the Cache against the size and SHA-256 hash recorded when they were stored,
+	// so a backend returning truncated or altered data can't go unnoticed.
+	// Corrupt entries are deleted and the request is sent to the server,
+	// or fails with ErrCorruptEntry in Offline mode.
+	VerifyChecksums bool
+
+	// MaxExtension, if > 0, caps the time a cached response can be kept fresh by Extend.
+	MaxExtension time.Duration
+
+	// Logger, if set, is sent the caching decisions taken for each request at debug level,
+	// such as the freshness of the cached response and whether the response
+	// was stored. See WithTrace to trace a single request.
+	Logger Logger
+
+	// VaryOn, if set, returns a value identifying the variant of a response selected by
+	// attributes of the request other than its headers, e.g. RequestBodyHash.
+	// A cached response is only served to requests with the same value, as if its
+	// Vary header listed these attributes.
+	VaryOn func(req *http.Request) string
+
+	// CacheableMethods, if non-nil, lists the methods other than GET and HEAD of the requests
+	// whose responses may be cached, e.g. POST for read-only GraphQL or search APIs.
+	// The cache key of these requests includes a hash of their body, see RequestBodyHash.
+	// Requests with other methods are passed through.
+	// If nil, responses to requests of any method are cached, under a key made
+	// of the method and the URL only.
+	CacheableMethods []string
+
+	// MaxRequestBodyBytes is the maximum size of the body of a request with one of
+	// the CacheableMethods to be hashed in its cache key. Requests with larger bodies
+	// are passed through. Default is 64 KiB.
+	MaxRequestBodyBytes int64
+
+	// If CanonicalizeHeaders is true, the caching headers of the responses received from
+	// the servers are rewritten in a canonical form before they are used or stored:
+	// repeated Cache-Control and Pragma headers are joined in one, with the directive
+	// names lowercased and only the first of repeated directives kept, and the Vary
+	// headers are joined in one, listing every header name once, canonicalized.
+	// Freshness and Vary then behave the same however the servers format these headers.
+	CanonicalizeHeaders bool
+
+	// OmitHeaders is an optional list of response headers never stored in the cache,
+	// e.g. Set-Cookie. They are still returned with the response from the server.
+	OmitHeaders []string
+
+	// StoreHeaders, if non-nil, switches the Transport to an allowlist mode, where only
+	// the listed response headers are stored in the cache, along with a minimal set:
+	// the validators (ETag, Last-Modified), the content metadata (Content-Type,
+	// Content-Length, Content-Encoding, Content-Language, Content-Location, Location)
+	// and the headers driving caching (Cache-Control, Expires, Pragma, Date, Age, Vary).
+	// Use an empty, non-nil slice to store the minimal set only, shrinking the entries.
+	// OmitHeaders applies on top of it.
+	StoreHeaders []string
+
+	// If PreserveHeaders is true, the headers of responses returned by the Transport
+	// are left as sent by the server: neither X-From-Cache nor the headers the Transport
+	// uses for its own book-keeping (e.g. X-Varied-*) are added.
+	// Use ResponseCacheStatus to tell how a response was produced.
+	// The X-Etags-* headers are still set if EnableETagPair is true.
+	PreserveHeaders bool
+
+	// SWRRevalidateTimeout is the timeout for the background revalidation of stale
+	// responses served because of the stale-while-revalidate Cache-Control directive.
+	// If 0, there is no timeout.
+	SWRRevalidateTimeout time.Duration
+
+	// MaxConcurrentRefreshes limits the number of background revalidations running
+	// at the same time. Waiting revalidations are started in order of popularity,
+	// i.e. the number of requests served the stale response meanwhile.
+	// If 0, there is no limit.
+	MaxConcurrentRefreshes int
+
+	// MaxQueuedRefreshes limits the number of background revalidations waiting to start.
+	// When reached, stale responses are revalidated on demand instead.
+	// If 0, there is no limit.
+	MaxQueuedRefreshes int
+
+	// StaleWhileRevalidate, if > 0, is the time after they have become stale during which
+	// responses without a stale-while-revalidate directive of their own are served stale while
+	// they are revalidated in the background, as if they had one.
+	// Responses with a must-revalidate, proxy-revalidate or no-cache directive are always
+	// revalidated on demand.
+	StaleWhileRevalidate time.Duration
+
+	// UpstreamRetries is the number of times a request to a server failing with
+	// a transport error or a response deemed retryable by RetryUpstream is retried,
+	// waiting UpstreamRetryBackoff, doubled on every attempt, in between,
+	// before a stale cached response is served in its place or the failure returned.
+	// Requests with a body are only retried if it can be read again (see http.Request.GetBody).
+	UpstreamRetries int
+
+	// UpstreamRetryBackoff is the time to wait before retrying a failed request to
+	// a server, doubled on every attempt. Default is 100ms.
+	UpstreamRetryBackoff time.Duration
+
+	// RetryUpstream is an optional func reporting whether a request to a server
+	// with the response resp, or the error err, is retried in UpstreamRetries mode.
+	// If nil, DefaultRetryUpstream is used, also retrying the requests with one of
+	// the CacheableMethods. Set it to retry requests with other methods, e.g. POST.
+	RetryUpstream func(req *http.Request, resp *http.Response, err error) bool
+
+	// If ServeStaleOnError is true, stale cached responses are served when revalidating
+	// them fails with a transport error or a 5xx response, as if they had a stale-if-error
+	// directive, unless they have a must-revalidate, proxy-revalidate or no-cache directive.
+	ServeStaleOnError bool
+
+	// MaxStaleOnError, if > 0, is the maximum time past their freshness lifetime
+	// during which responses are served in ServeStaleOnError mode.
+	MaxStaleOnError time.Duration
+
+	// RefreshRetries is the number of times a failed background revalidation is retried.
+	RefreshRetries int
+
+	// RefreshRetryBackoff is the time to wait before retrying a failed background revalidation,
+	// doubled after each retry. Default is 1 second.
+	RefreshRetryBackoff time.Duration
+
+	// RefreshLease is how long the lease acquired for the background revalidation
+	// of a stale response is held, when the Cache is a LeaseCache shared by several nodes.
+	// Nodes failing to acquire the lease serve the stale response without revalidating it.
+	// If 0, no leases are used.
+	RefreshLease time.Duration
+
+	// OnRefreshError is an optional func called when the background revalidation
+	// of a cached response fails.
+	OnRefreshError func(req *http.Request, err error)
+
+	// FailureMemo is how long a transport-level failure (e.g. a refused connection)
+	// to fetch a response is remembered. Meanwhile, requests for the same cache key
+	// are served the cached response, if any, even if stale, or else fail with the
+	// same error, without contacting the server.
+	// If 0, failures are not remembered.
+	FailureMemo time.Duration
+
+	// ExpiryGrace is how long responses with validators are kept in a CacheWithExpiry
+	// after they have become stale, so they can still be revalidated.
+	// If 0, such responses are stored without expiry.
+	ExpiryGrace time.Duration
+
+	// WriteRetries is the number of times storing an entry in a CacheContext backend
+	// is retried in the background after failing, e.g. because of a network blip
+	// to a remote cache, waiting WriteRetryBackoff, doubled on every attempt, in between.
+	// Writes still failing, or failing when MaxQueuedWrites retries are already pending,
+	// are dropped and reported to Stats if it implements WriteDropRecorder.
+	// If 0, failed writes are dropped right away.
+	WriteRetries int
+
+	// WriteRetryBackoff is the time to wait before retrying a failed cache write,
+	// doubled on every attempt. If 0, it defaults to 1s.
+	WriteRetryBackoff time.Duration
+
+	// MaxQueuedWrites limits the number of failed cache writes waiting to be retried.
+	// If 0, it defaults to 100.
+	MaxQueuedWrites int
+
+	// Validation is the ValidationStrategy used to revalidate stale cached responses.
+	// If nil, DefaultValidation is used. See also ETagValidation, LastModifiedValidation
+	// and ValidatorFunc to choose the validators sent, and HostPolicy.Validation.
+	Validation ValidationStrategy
+
+	// If GuardImmutable is true, a 200 response fetched to replace a cached response
+	// marked immutable (Cache-Control: immutable) is rejected when its media type
+	// differs from the cached one, or its Content-Length is more than ten times
+	// smaller or larger than the cached body, defending against misbehaving
+	// intermediaries, e.g. serving error pages with a 200 status.
+	// The cached response is served stale instead, and left in the cache.
+	GuardImmutable bool
+
+	// OnSuspiciousUpdate is an optional func called when a response is rejected
+	// in GuardImmutable mode, with the reason why.
+	OnSuspiciousUpdate func(req *http.Request, key, reason string)
+
+	// If EmitWarnings is true, the stale cached responses served, e.g. with max-stale,
+	// stale-while-revalidate or stale-if-error, get a Warning header telling so
+	// (RFC 7234 section 5.5): 110 (Response is Stale), along with 111 (Revalidation
+	// Failed) if the server could not be reached or failed, and 112 (Disconnected
+	// Operation) in Offline mode. The 1xx warnings of the cached responses are
+	// removed when they are revalidated.
+	EmitWarnings bool
+
+	// Immutable tells whether the fresh cached responses marked immutable
+	// (Cache-Control: immutable) are served without revalidation to requests
+	// asking for it, e.g. when the user reloads a page, see ImmutablePolicy.
+	// The default, ImmutableIgnore, handles them like any other.
+	Immutable ImmutablePolicy
+
+	// Clock is the source of the current time used to compute the age of cached
+	// responses, e.g. to simulate the passing of time in tests.
+	// If nil, the system clock is used, guarded against jumping backwards.
+	Clock Clock
+
+	// Stats is an optional StatsRecorder notified of the decisions made by the Transport,
+	// e.g. to export them as Prometheus or expvar metrics.
+	Stats StatsRecorder
+
+	// EventHandler is an optional EventHandler sent the Events of the lifecycle
+	// of the requests and their cache entries, e.g. lookups, hits and stores.
+	EventHandler EventHandler
+
+	inFlightBufferBytes atomic.Int64
+	counters            Counters
+	keyLocks            keyLocks
+	circuit             breaker
+	refreshes           refreshTracker
+	writes              writeRetrier
+	failures            failureMemo
 }
 
 // varyMatches will return false unless all of the cached values for the headers listed in Vary
-// match the new request
-func varyMatches(cachedResp *http.Response, req *http.Request) bool {
+// match the new request, and so does the value returned by VaryOn, if set.
+// A Vary: * is left to freshness, which requires such responses to be revalidated.
+func (t *Transport) varyMatches(cachedResp *http.Response, req *http.Request) bool {
 	for _, header := range headerAllCommaSepValues(cachedResp.Header, "vary") {
 		header = http.CanonicalHeaderKey(header)
-		if header != "" && req.Header.Get(header) != cachedResp.Header.Get("X-Varied-"+header) {
+		if header != "" && header != "*" && req.Header.Get(header) != cachedResp.Header.Get("X-Varied-"+header) {
 			return false
 		}
 	}
+	if t.VaryOn != nil && t.VaryOn(req) != cachedResp.Header.Get(xVariant) {
+		return false
+	}
 	return true
 }
 
@@ -141,34 +600,137 @@ func varyMatches(cachedResp *http.Response, req *http.Request) bool {
 // If there is a stale Response, then any validators it contains will be set on the new request
 // to give the server a chance to respond with NotModified. If this happens, then the cached Response
 // will be returned.
-func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+//
+// The behavior can be adjusted per request with WithOptions.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	req = t.withEventRecorder(req)
+	resp, status, err := t.roundTrip(req)
+	t.debug(req, "httpcache: done", "status", status.String(), "error", err)
+	t.recordStatus(req, status)
+	t.outcomeEvent(req, status, time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	t.recordServed(req, resp, status)
+	resp = t.withStatus(resp, status)
+	if t.TransformAfterLoad != nil && status != StatusMiss {
+		if resp, err = t.TransformAfterLoad(req, resp); err != nil {
+			return nil, err
+		}
+	}
+	if t.TransformCachedBody != nil && status != StatusMiss && req.Method != http.MethodHead {
+		if err := t.transformCachedBody(req, resp); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (t *Transport) roundTrip(req *http.Request) (resp *http.Response, status CacheStatus, err error) {
+	opts := t.options(req)
+	policy, _ := t.hostPolicy(req)
+	if opts.MaxAge > 0 {
+		req = withMaxAge(req, opts.MaxAge)
+	}
+	offline := t.Offline || opts.Offline
+	var bypass StoreSkipReason
+	if opts.BypassCache {
+		bypass = SkipBypass
+	} else if !t.methodCacheable(req.Method) {
+		bypass = SkipMethod
+	} else if t.needsBodyHash(req) {
+		var ok bool
+		req, ok, err = t.withBodyHash(req)
+		if err != nil {
+			return nil, StatusMiss, err
+		}
+		if !ok {
+			bypass = SkipRequestBody
+		}
+	}
+	if bypass != "" {
+		if offline {
+			return t.offlineMiss(req)
+		}
+		resp, err = t.originTransport(req).RoundTrip(req)
+		if err != nil {
+			t.upstreamError(req, "", err)
+			return nil, StatusMiss, err
+		}
+		t.storeSkipped(req, resp, "", bypass)
+		return resp, StatusMiss, nil
+	}
+
 	cacheKey := t.cacheKey(req)
 	if f := t.Around; f != nil {
 		defer f(req, cacheKey)()
 	}
 
+	user := t.partitionUser(req, opts)
+
+	if req.Method == http.MethodGet && req.Header.Get("range") != "" {
+		if _, ok := parseCacheControl(req.Header)["only-if-cached"]; ok {
+			return t.cachedRangeResponse(req, user)
+		}
+		if resp, ok, err := t.rangeFromFullResponse(req, user); ok {
+			t.debug(req, "httpcache: range served from cached response", "range", req.Header.Get("range"))
+			return resp, StatusHit, err
+		}
+	}
+
 	var cachedXEtag string
 
+	refresh := isRefresh(req.Context())
+	forceRevalidate := refresh || opts.ForceRefresh
+
 	cacheable := cacheKey != ""
+	sharedKey := cacheKey
 
 	var (
 		cachedResp    *http.Response
 		hasCachedResp bool
+		// primaryHeaders are the headers of the entry found for cacheKey in MaxVariants mode.
+		primaryHeaders http.Header
+		// pinned is set if the entry found for cacheKey was pinned with Pin.
+		pinned bool
 	)
 	if cacheable {
-		cachedResp, hasCachedResp, err = t.cachedResponse(req)
+		if req.Method == http.MethodHead {
+			if getResp, ok := t.cachedGetResponseForHead(req); ok {
+				if t.MarkCachedResponses {
+					getResp.Header.Set(XFromCache, "1")
+				}
+				return getResp, StatusHit, nil
+			}
+		}
+		cacheKey, cachedResp, hasCachedResp, err = t.lookupPartitioned(req, sharedKey, user)
+		t.debug(req, "httpcache: lookup", "key", cacheKey, "found", hasCachedResp)
+		t.event(req, Event{Kind: EventLookup, Key: cacheKey, Found: cachedResp != nil})
+		pinned = err == nil && cachedResp != nil && isPinned(cachedResp.Header)
+		if t.MaxVariants > 1 && err == nil && cachedResp != nil {
+			primaryHeaders = cachedResp.Header.Clone()
+			if !t.varyMatches(cachedResp, req) {
+				if v, ok, verr := t.lookupVariant(req, cacheKey, primaryHeaders); verr == nil && v != nil {
+					t.debug(req, "httpcache: variant found", "key", cacheKey, "fresh", ok)
+					cachedResp, hasCachedResp = v, ok
+				}
+			}
+		}
+		recordResult(req.Context(), func(r *Result) { r.Key = cacheKey })
+		var backendErr *CacheBackendError
+		if errors.As(err, &backendErr) {
+			return nil, StatusMiss, err
+		}
 		if err == nil && hasCachedResp && t.AlwaysUseCachedResponse != nil && t.AlwaysUseCachedResponse(req, cacheKey) {
-			return cachedResp, nil
+			return cachedResp, StatusHit, nil
 		}
 	} else {
 		// Need to invalidate an existing value
-		t.Cache.Delete(cacheKey)
+		t.cacheDelete(req.Context(), cacheKey)
 	}
 
-	transport := t.Transport
-	if transport == nil {
-		transport = http.DefaultTransport
-	}
+	transport := t.originTransport(req)
 
 	if cachedResp != nil {
 		if t.EnableETagPair {
@@ -176,91 +738,358 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 		}
 	}
 
+	// replacedHeader is the header of the cached response replaced by resp,
+	// the 200 response to its revalidation, if any.
+	var replacedHeader http.Header
+
 	if cacheable && hasCachedResp && err == nil {
 		if t.MarkCachedResponses {
 			cachedResp.Header.Set(XFromCache, "1")
 		}
 
-		if varyMatches(cachedResp, req) {
+		if offline {
+			if !t.varyMatches(cachedResp, req) {
+				return t.offlineMiss(req)
+			}
+			if t.freshness(cachedResp, req) == fresh {
+				return t.servedFresh(cachedResp, req), StatusHit, nil
+			}
+			return t.servedStale(cachedResp, warnDisconnected), StatusStale, nil
+		}
+
+		if _, ok := parseCacheControl(req.Header)["only-if-cached"]; ok {
+			// Never contact the server, serve the cached response if it satisfies
+			// the other request directives (max-age, min-fresh, max-stale).
+			if t.varyMatches(cachedResp, req) && t.freshness(cachedResp, req) == fresh {
+				return t.servedFresh(cachedResp, req), StatusHit, nil
+			}
+			return t.onlyIfCachedMiss(req)
+		}
+
+		var (
+			revalidating bool
+			variants     []*http.Response
+			origReq      = req
+		)
+		if t.varyMatches(cachedResp, req) {
 			// Can only use cached value if the new request doesn't Vary significantly
-			freshness := getFreshness(cachedResp.Header, req.Header)
+			freshness := t.freshness(cachedResp, req)
+			if forceRevalidate && freshness == fresh && !isPinned(cachedResp.Header) {
+				freshness = stale
+			}
+			t.debug(req, "httpcache: freshness", "key", cacheKey, "freshness", freshnessString(freshness))
 			if freshness == fresh {
-				return cachedResp, nil
+				if t.FollowCachedRedirects && req.Method == http.MethodGet && isRedirect(cachedResp.StatusCode) {
+					if resp, ok := t.followCachedRedirects(req, cachedResp, user); ok {
+						if t.MarkCachedResponses {
+							resp.Header.Set(XFromCache, "1")
+						}
+						t.debug(req, "httpcache: followed cached redirects", "key", cacheKey, "url", resp.Request.URL.String())
+						return resp, StatusHit, nil
+					}
+				}
+				return t.servedFresh(cachedResp, req), StatusHit, nil
+			}
+
+			if freshness == stale && !forceRevalidate && req.Method == http.MethodGet &&
+				t.canRefreshInBackground(cachedResp.Header) && t.refreshInBackground(cacheKey, req, cachedResp) {
+				return t.servedStale(cachedResp), StatusStale, nil
 			}
 
 			if freshness == stale {
-				var req2 *http.Request
-				// Add validators if caller hasn't already done so
-				etag := cachedResp.Header.Get("etag")
-				if etag != "" && req.Header.Get("etag") == "" {
-					req2 = cloneRequest(req)
-					req2.Header.Set("if-none-match", etag)
-				}
-				lastModified := cachedResp.Header.Get("last-modified")
-				if lastModified != "" && req.Header.Get("last-modified") == "" {
-					if req2 == nil {
-						req2 = cloneRequest(req)
-					}
-					req2.Header.Set("if-modified-since", lastModified)
-				}
-				if req2 != nil {
+				if req2 := t.validation(req).Conditional(req, cachedResp); req2 != nil {
 					req = req2
+					revalidating = true
+					t.debug(req, "httpcache: revalidate", "key", cacheKey,
+						"if-none-match", req.Header.Get("If-None-Match"), "if-modified-since", req.Header.Get("If-Modified-Since"))
 				}
 			}
+		} else if _, ok := t.validation(req).(defaultValidation); ok && req.Method == http.MethodGet {
+			// Ask the server whether another variant is the one it would select.
+			variants = []*http.Response{cachedResp}
+			if primaryHeaders != nil {
+				variants = append(variants, t.storedVariants(req, cacheKey, primaryHeaders)...)
+			}
+			if req2 := variantConditional(req, variants); req2 != nil {
+				req = req2
+				revalidating = true
+				t.debug(req, "httpcache: revalidate variants", "key", cacheKey, "if-none-match", req.Header.Get("If-None-Match"))
+			} else {
+				variants = nil
+			}
 		}
 
+		if ferr := t.failures.get(cacheKey, t.FailureMemo, t.timer()); ferr != nil {
+			// The server failed recently, don't contact it again.
+			if !refresh && t.varyMatches(cachedResp, req) && !varyAll(cachedResp.Header) {
+				return t.servedStale(cachedResp, warnRevalidationFailed), StatusStale, nil
+			}
+			return nil, StatusMiss, ferr
+		}
 		resp, err = transport.RoundTrip(req)
+		var notModified bool
+		if err != nil {
+			t.upstreamError(req, cacheKey, err)
+			t.failures.add(cacheKey, err, t.FailureMemo, t.timer())
+		} else {
+			notModified = t.validation(req).NotModified(req, resp, cachedResp)
+			if revalidating {
+				t.stats().Revalidated(req, !notModified)
+			}
+			if notModified && variants != nil {
+				if selected := selectVariant(resp, variants); selected != nil {
+					cachedResp = selected
+				} else {
+					// The server selected a variant that isn't stored.
+					resp.Body.Close()
+					req = origReq
+					notModified = false
+					resp, err = transport.RoundTrip(req)
+					if err != nil {
+						t.upstreamError(req, cacheKey, err)
+						t.failures.add(cacheKey, err, t.FailureMemo, t.timer())
+					}
+				}
+			}
+		}
 
-		if err == nil && req.Method != http.MethodHead && resp.StatusCode == http.StatusNotModified {
+		if err == nil && req.Method != http.MethodHead && notModified {
 			// Replace the 304 response with the one from cache, but update with some new headers
+			resp.Body.Close()
 			endToEndHeaders := getEndToEndHeaders(resp.Header)
 			for _, header := range endToEndHeaders {
 				cachedResp.Header[header] = resp.Header[header]
 			}
+			if t.EmitWarnings {
+				stripStaleWarnings(cachedResp.Header)
+			}
+			if t.AdaptiveTTL {
+				t.learnTTL(cachedResp.Header, cachedResp.Header, true)
+			}
+			t.setValidation(cachedResp.Header, ValidationNotModified)
+			t.validated(cacheKey, ValidationNotModified)
 			resp = cachedResp
+			status = StatusRevalidated
+		} else if reason := t.suspiciousUpdate(req, cachedResp, resp, err); reason != "" {
+			resp.Body.Close()
+			t.debug(req, "httpcache: update rejected", "key", cacheKey, "reason", reason)
+			if t.OnSuspiciousUpdate != nil {
+				t.OnSuspiciousUpdate(req, cacheKey, reason)
+			}
+			return t.servedStale(cachedResp, warnRevalidationFailed), StatusStale, nil
 		} else if (err != nil || resp.StatusCode >= 500) &&
-			req.Method != http.MethodHead && canStaleOnError(cachedResp.Header, req.Header) {
+			req.Method != http.MethodHead && !varyAll(cachedResp.Header) &&
+			(canStaleOnError(cachedResp.Header, req.Header, t.timer()) || t.serveStaleOnError(cachedResp.Header)) {
 			// In case of transport failure and stale-if-error activated, returns cached content
 			// when available
-			return cachedResp, nil
+			if err == nil && resp.Body != nil {
+				resp.Body.Close()
+			}
+			t.recordFailedValidation(req.Context(), cacheKey)
+			return t.servedStale(cachedResp, warnRevalidationFailed), StatusStale, nil
+		} else if refresh && (err != nil || resp.StatusCode >= 500) {
+			// Keep the cached response, it was served stale.
+			if err == nil {
+				resp.Body.Close()
+				err = fmt.Errorf("httpcache: refresh of %s failed: %s", req.URL, resp.Status)
+			}
+			t.recordFailedValidation(req.Context(), cacheKey)
+			return nil, StatusMiss, err
 		} else {
-			if err != nil || resp.StatusCode != http.StatusOK {
-				t.Cache.Delete(cacheKey)
+			if (err != nil || resp.StatusCode != http.StatusOK) && !pinned {
+				t.deleteEntry(req.Context(), cacheKey)
+				t.evicted(req, cacheKey)
+				hasCachedResp = false
 			}
 			if err != nil {
-				return nil, err
+				return nil, StatusMiss, err
+			}
+			if resp.StatusCode == http.StatusOK {
+				replacedHeader = cachedResp.Header
+				t.validated(cacheKey, ValidationModified)
 			}
 		}
 	} else {
+		if offline {
+			if errors.Is(err, ErrCorruptEntry) {
+				return nil, StatusMiss, err
+			}
+			return t.offlineMiss(req)
+		}
 		reqCacheControl := parseCacheControl(req.Header)
 		if _, ok := reqCacheControl["only-if-cached"]; ok {
-			resp = newGatewayTimeoutResponse(req)
+			if resp, status, err = t.onlyIfCachedMiss(req); err != nil {
+				return nil, status, err
+			}
+		} else if ferr := t.failures.get(cacheKey, t.FailureMemo, t.timer()); ferr != nil {
+			return nil, StatusMiss, ferr
 		} else {
 			resp, err = transport.RoundTrip(req)
 			if err != nil {
-				return nil, err
+				t.upstreamError(req, cacheKey, err)
+				t.failures.add(cacheKey, err, t.FailureMemo, t.timer())
+				return nil, StatusMiss, err
 			}
 		}
 	}
 
-	if cacheable && (t.ShouldCache == nil || t.ShouldCache(req, resp, cacheKey)) && canStore(parseCacheControl(req.Header), parseCacheControl(resp.Header)) {
+	if cacheable && req.Method == http.MethodHead && status == StatusMiss {
+		t.updateGetFromHead(req, resp)
+	}
+
+	var notStored StoreSkipReason
+	switch {
+	case !cacheable && req.Header.Get("range") != "":
+		notStored = SkipPartialContent
+	case !cacheable:
+		notStored = SkipNoCacheKey
+	case pinned:
+		// Another variant, the pinned entry is kept.
+		notStored = SkipPinned
+	case t.ShouldCache != nil && !t.ShouldCache(req, resp, cacheKey):
+		notStored = SkipShouldCache
+	case !canStore(parseCacheControl(req.Header), parseCacheControl(resp.Header)):
+		notStored = SkipNoStore
+	case isRedirect(resp.StatusCode) && !t.canStoreRedirect(resp):
+		notStored = SkipTemporaryRedirect
+	case t.Shared && parseCacheControl(resp.Header).unqualified("private"):
+		// With header names, only these headers are not stored, see strippedHeaders.
+		notStored = SkipPrivate
+	default:
+		notStored = t.authorizationNotStored(req, resp)
+	}
+	if notStored == "" {
+		var obsoleteKey string
+		lookupKey := cacheKey
+		cacheKey, obsoleteKey = storeKey(req, resp, cacheKey, sharedKey, user)
+		if obsoleteKey != "" {
+			t.deleteEntry(req.Context(), obsoleteKey)
+		}
+		if resp.Header == nil {
+			resp.Header = make(http.Header)
+		}
+		// The book-keeping headers are only added to the headers stored,
+		// keeping them out of the response returned.
+		var storeHeader http.Header
+		if t.filterHeaders() {
+			storeHeader = t.storedHeaders(resp.Header)
+		} else {
+			storeHeader = resp.Header.Clone()
+		}
+		if stripped := strippedHeaders(parseCacheControl(resp.Header)); len(stripped) > 0 {
+			for _, k := range stripped {
+				storeHeader.Del(k)
+			}
+		}
 		for _, varyKey := range headerAllCommaSepValues(resp.Header, "vary") {
 			varyKey = http.CanonicalHeaderKey(varyKey)
+			if varyKey == "*" {
+				continue
+			}
 			fakeHeader := "X-Varied-" + varyKey
 			reqValue := req.Header.Get(varyKey)
 			if reqValue != "" {
-				resp.Header.Set(fakeHeader, reqValue)
+				storeHeader.Set(fakeHeader, reqValue)
+			} else {
+				// Left over from another variant revalidated for req.
+				storeHeader.Del(fakeHeader)
 			}
 		}
+		if t.VaryOn != nil {
+			if v := t.VaryOn(req); v != "" {
+				storeHeader.Set(xVariant, v)
+			} else {
+				storeHeader.Del(xVariant)
+			}
+		}
+		if replacedHeader != nil {
+			if t.AdaptiveTTL {
+				t.learnTTL(replacedHeader, storeHeader, sameValidators(replacedHeader, resp.Header))
+			}
+			t.setValidation(storeHeader, ValidationModified)
+		}
+		storeHeader.Set(xRequestURL, req.URL.String())
+		storeHeader.Set(xRequestMethod, req.Method)
+		if t.HashKey != nil {
+			storeHeader.Set(xOriginalKey, t.unhashedCacheKey(req))
+		}
+		if isRedirect(resp.StatusCode) {
+			t.setRedirectKey(storeHeader, req, resp)
+		}
 		switch req.Method {
 		case http.MethodHead:
-			respBytes, err := httputil.DumpResponse(resp, true)
-			if err == nil {
-				t.Cache.Set(cacheKey, respBytes)
+			resp := *resp
+			resp.Header = storeHeader
+			if t.TransformBeforeStore != nil {
+				r, _, ok := t.transformBeforeStore(req, &resp, nil)
+				if !ok {
+					t.storeSkipped(req, &resp, cacheKey, SkipTransformBeforeStore)
+					t.deleteEntry(req.Context(), cacheKey)
+					break
+				}
+				resp = *r
 			}
+			unlock := t.keyLocks.lock(cacheKey)
+			n := t.storeEntry(req.Context(), cacheKey, &resp, nil)
+			unlock()
+			t.stored(req, cacheKey, n)
 		default:
+			fp := t.variantFingerprint(resp.Header, req)
+			if status == StatusRevalidated && cacheKey == lookupKey && (t.MaxVariants <= 1 || fp == "") && t.TransformBeforeStore == nil {
+				resp := *resp
+				resp.Header = storeHeader
+				unlock := t.keyLocks.lock(cacheKey)
+				n, ok := t.storeMetadata(cacheKey, &resp)
+				unlock()
+				if ok {
+					// The body is unchanged.
+					t.stored(req, cacheKey, n)
+					break
+				}
+			}
+			maxEntrySize := t.maxEntrySize(policy)
+			if maxEntrySize > 0 && resp.ContentLength > maxEntrySize {
+				// Too large, pass through.
+				t.storeSkipped(req, resp, cacheKey, SkipTooLarge)
+				break
+			}
+			var bw BodyWriter
+			sc := t.streamingCache()
+			if sc != nil && !t.inlinesBody(resp) && t.TransformBeforeStore == nil {
+				// Fall back to buffering on errors.
+				t.cacheOp(req.Context(), "write", cacheKey, func() (err error) {
+					bw, err = sc.Writer(cacheKey)
+					return err
+				})
+			}
+			if bw == nil && t.MaxInFlightBufferBytes > 0 && t.inFlightBufferBytes.Load() >= t.MaxInFlightBufferBytes {
+				// Too much buffered already, pass through.
+				t.storeSkipped(req, resp, cacheKey, SkipBufferLimit)
+				break
+			}
+			if t.MaxVariants > 1 {
+				if bw != nil {
+					// Streamed bodies are only stored once.
+					fp = ""
+				}
+				prevHeaders := primaryHeaders
+				if cacheKey != lookupKey {
+					prevHeaders = nil
+				}
+				index, evicted := t.updateVariantIndex(prevHeaders, resp.Header, fp)
+				for _, v := range evicted {
+					t.cacheDelete(req.Context(), variantKey(cacheKey, v))
+				}
+				if len(index) > 0 {
+					storeHeader.Set(xVariants, strings.Join(index, ", "))
+				} else {
+					storeHeader.Del(xVariants)
+				}
+			} else {
+				fp = ""
+			}
 			var (
-				etagHash hash.Hash
+				etagHash *etagHasher
 				etag1    = cachedXEtag
 				etag2    string
 			)
@@ -273,7 +1102,7 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 						etag2 = etag
 					}
 				} else {
-					etagHash = md5.New()
+					etagHash = newETagHasher(t.ETagPairHashLimit)
 					r = struct {
 						io.Reader
 						io.Closer
@@ -284,41 +1113,120 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 				}
 			}
 
-			r = &cachingReadCloser{
-				R: r,
-				OnEOF: func(r io.Reader) {
-					if etagHash != nil {
-						md5Str := hex.EncodeToString(etagHash.Sum(nil))
-						etag2 = md5Str
-						resp.Header.Set(XETag1, md5Str)
-						resp.Header.Set(XETag2, md5Str)
-						if etag1 == "" {
-							etag1 = md5Str
-						}
-					} else {
-						resp.Header.Set(XETag1, etag1)
-						resp.Header.Set(XETag2, etag1)
-					}
+			var sr *streamingReadCloser
 
+			// store stores the response with body, or only its metadata
+			// if the body was streamed to bw.
+			store := func(body, sum []byte, size int64) {
+				if t.TransformBeforeStore != nil {
 					resp := *resp
-					resp.Body = io.NopCloser(r)
-					respBytes, err := httputil.DumpResponse(&resp, true)
-					if err == nil {
-						// Signal any change back to the caller.
-						resp.Header.Set(XETag1, etag1)
-						t.Cache.Set(cacheKey, respBytes)
+					resp.Header = storeHeader
+					r, b, ok := t.transformBeforeStore(req, &resp, body)
+					if !ok {
+						t.storeSkipped(req, &resp, cacheKey, SkipTransformBeforeStore)
+						t.deleteEntry(req.Context(), cacheKey)
+						return
+					}
+					storeHeader, body = r.Header, b
+					s := sha256.Sum256(body)
+					sum, size = s[:], int64(len(body))
+				}
+				setFingerprint(storeHeader, sum, size)
+				if etagHash != nil {
+					md5Str := etagHash.sum()
+					etag2 = md5Str
+					storeHeader.Set(XETag1, md5Str)
+					storeHeader.Set(XETag2, md5Str)
+					if etag1 == "" {
+						etag1 = md5Str
+					}
+				} else {
+					storeHeader.Set(XETag1, etag1)
+					storeHeader.Set(XETag2, etag1)
+				}
+
+				header := resp.Header
+				resp := *resp
+				resp.Header = storeHeader
+				var n int
+				if bw != nil {
+					// The key is locked by the streamingReadCloser since the body
+					// was committed, until the metadata matching it is stored.
+					meta := encodeEntry(&resp, nil)
+					t.setMetadata(req.Context(), sc, cacheKey, meta)
+					sr.Unlock()
+					n = len(meta) + int(size)
+				} else {
+					unlock := t.keyLocks.lock(cacheKey)
+					n = t.storeEntry(req.Context(), cacheKey, &resp, body)
+					unlock()
+					if fp != "" {
+						variant := resp
+						variant.Header = storeHeader.Clone()
+						variant.Header.Del(xVariants)
+						vkey := variantKey(cacheKey, fp)
+						unlock := t.keyLocks.lock(vkey)
+						n += t.storeEntry(req.Context(), vkey, &variant, body)
+						unlock()
 					}
-				},
-				buf: &bytes.Buffer{},
+				}
+				if t.EnableETagPair {
+					// Signal any change back to the caller.
+					header.Set(XETag2, storeHeader.Get(XETag2))
+					header.Set(XETag1, etag1)
+				}
+				t.stored(req, cacheKey, n)
+				if t.IndexContentLocation && req.Method == http.MethodGet && cacheKey == sharedKey {
+					t.storeContentLocation(req, &resp, body, cacheKey)
+				}
+			}
+
+			tooLarge := func() { t.storeSkipped(req, resp, cacheKey, SkipTooLarge) }
+			if bw != nil {
+				sr = &streamingReadCloser{
+					R:          r,
+					W:          bw,
+					OnEOF:      func(sum []byte, size int64) { store(nil, sum, size) },
+					OnTooLarge: tooLarge,
+					Lock:       func() func() { return t.keyLocks.lock(cacheKey) },
+					max:        maxEntrySize,
+					hash:       sha256.New(),
+				}
+				r = sr
+			} else {
+				maxBuffer := t.MaxBodyBufferBytes
+				if maxEntrySize > 0 && (maxBuffer <= 0 || maxEntrySize < maxBuffer) {
+					maxBuffer = maxEntrySize
+				}
+				r = &cachingReadCloser{
+					R: r,
+					OnEOF: func(body []byte) {
+						sum := sha256.Sum256(body)
+						store(body, sum[:], int64(len(body)))
+					},
+					OnTooLarge: tooLarge,
+					buf:        &bytes.Buffer{},
+					max:        maxBuffer,
+					counter:    &t.inFlightBufferBytes,
+				}
 			}
 			// Delay caching until EOF is reached.
 			resp.Body = r
 
 		}
 	} else {
-		t.Cache.Delete(cacheKey)
+		t.storeSkipped(req, resp, cacheKey, notStored)
+		if !pinned {
+			t.deleteEntry(req.Context(), cacheKey)
+		}
+		if hasCachedResp && !pinned {
+			t.evicted(req, cacheKey)
+		}
 	}
-	return resp, nil
+	if t.IndexContentLocation && !isSafeMethod(req.Method) && resp.StatusCode < 400 {
+		t.invalidateContentLocation(req.Context(), req, resp)
+	}
+	return resp, status, nil
 }
 
 // ErrNoDateHeader indicates that the HTTP headers contained no Date header.
@@ -335,22 +1243,38 @@ func date(respHeaders http.Header) (date time.Time, err error) {
 	return time.Parse(time.RFC1123, dateHeader)
 }
 
-type realClock struct{}
-
-func (c *realClock) since(d time.Time) time.Duration {
-	return time.Since(d)
-}
-
-type timer interface {
-	since(d time.Time) time.Duration
-}
-
-var clock timer = &realClock{}
-
 func getXETags(h http.Header) (string, string) {
 	return h.Get(XETag1), h.Get(XETag2)
 }
 
+// freshness returns the freshness of cachedResp for req, see getFreshness.
+// Responses pinned with Pin are always fresh.
+// The lifetime set by Extend, the MinTTL of the request Options or HostPolicy
+// and MicroCache, if any, are applied.
+// The request directives ignored by the Immutable policy are left out for responses
+// marked immutable.
+func (t *Transport) freshness(cachedResp *http.Response, req *http.Request) int {
+	if isPinned(cachedResp.Header) {
+		return fresh
+	}
+	if varyAll(cachedResp.Header) {
+		// The response may vary on anything, never reuse it without revalidation.
+		if _, ok := parseCacheControl(req.Header)["no-cache"]; ok {
+			return transparent
+		}
+		return stale
+	}
+	clk := t.timer()
+	reqHeader := t.immutableRequestHeader(cachedResp.Header, req.Header)
+	freshness := getFreshnessWithDefault(cachedResp.Header, reqHeader, t.defaultLifetime(cachedResp.Header), t.Shared, clk)
+	freshness = applyExtension(freshness, cachedResp.Header, clk)
+	minTTL := t.options(req).MinTTL
+	if w := t.microCacheWindow(req); w > minTTL {
+		minTTL = w
+	}
+	return applyMinTTL(freshness, cachedResp.Header, minTTL, clk)
+}
+
 // getFreshness will return one of fresh/stale/transparent based on the cache-control
 // values of the request and the response
 //
@@ -358,49 +1282,37 @@ func getXETags(h http.Header) (string, string) {
 // stale indicates that the response needs validating before it is returned
 // transparent indicates the response should not be used to fulfil the request
 //
-// Because this is only a private cache, 'public' and 'private' in cache-control aren't
-// significant. Similarly, smax-age isn't used.
-func getFreshness(respHeaders, reqHeaders http.Header) (freshness int) {
+// The response is looked at as stored by a private cache, so 's-maxage' isn't used,
+// see getFreshnessWithDefault. Its age is measured with clk.
+func getFreshness(respHeaders, reqHeaders http.Header, clk timer) (freshness int) {
+	return getFreshnessWithDefault(respHeaders, reqHeaders, 0, false, clk)
+}
+
+// getFreshnessWithDefault is like getFreshness, but uses defaultLifetime as the
+// freshness lifetime of responses without a max-age directive or an Expires header,
+// and measures their age with clk.
+// If shared is set, the response is looked at as stored by a shared cache:
+// s-maxage overrides max-age, and proxy-revalidate works like must-revalidate.
+func getFreshnessWithDefault(respHeaders, reqHeaders http.Header, defaultLifetime time.Duration, shared bool, clk timer) (freshness int) {
 	respCacheControl := parseCacheControl(respHeaders)
 	reqCacheControl := parseCacheControl(reqHeaders)
 	if _, ok := reqCacheControl["no-cache"]; ok {
 		return transparent
 	}
-	if _, ok := respCacheControl["no-cache"]; ok {
+	if respCacheControl.unqualified("no-cache") {
+		// With header names, only these headers are not to be reused, see strippedHeaders.
 		return stale
 	}
-	if _, ok := reqCacheControl["only-if-cached"]; ok {
-		return fresh
-	}
 
 	date, err := date(respHeaders)
 	if err != nil {
 		return stale
 	}
-	currentAge := clock.since(date)
+	currentAge := clk.since(date)
 
-	var lifetime time.Duration
+	lifetime := freshnessLifetime(respHeaders, respCacheControl, date, defaultLifetime, shared)
 	var zeroDuration time.Duration
 
-	// If a response includes both an Expires header and a max-age directive,
-	// the max-age directive overrides the Expires header, even if the Expires header is more restrictive.
-	if maxAge, ok := respCacheControl["max-age"]; ok {
-		lifetime, err = time.ParseDuration(maxAge + "s")
-		if err != nil {
-			lifetime = zeroDuration
-		}
-	} else {
-		expiresHeader := respHeaders.Get("Expires")
-		if expiresHeader != "" {
-			expires, err := time.Parse(time.RFC1123, expiresHeader)
-			if err != nil {
-				lifetime = zeroDuration
-			} else {
-				lifetime = expires.Sub(date)
-			}
-		}
-	}
-
 	if maxAge, ok := reqCacheControl["max-age"]; ok {
 		// the client is willing to accept a response whose age is no greater than the specified time in seconds
 		lifetime, err = time.ParseDuration(maxAge + "s")
@@ -422,9 +1334,20 @@ func getFreshness(respHeaders, reqHeaders http.Header) (freshness int) {
 		// its expiration time by no more than the specified number of seconds.
 		// If no value is assigned to max-stale, then the client is willing to accept a stale response of any age.
 		//
-		// Responses served only because of a max-stale value are supposed to have a Warning header added to them,
-		// but that seems like a  hassle, and is it actually useful? If so, then there needs to be a different
-		// return-value available here.
+		// Responses served only because of a max-stale value get a Warning header in EmitWarnings mode,
+		// see Transport.servedFresh.
+		if _, ok := respCacheControl["must-revalidate"]; ok {
+			// Stale responses must not be served without validation.
+			maxstale = "0"
+		}
+		if shared {
+			// So do proxy-revalidate and s-maxage for shared caches, see RFC 9111 section 5.2.2.
+			for _, directive := range []string{"proxy-revalidate", "s-maxage"} {
+				if _, ok := respCacheControl[directive]; ok {
+					maxstale = "0"
+				}
+			}
+		}
 		if maxstale == "" {
 			return fresh
 		}
@@ -441,9 +1364,40 @@ func getFreshness(respHeaders, reqHeaders http.Header) (freshness int) {
 	return stale
 }
 
+// freshnessLifetime returns the freshness lifetime of a response with the given
+// headers, parsed Cache-Control and Date, or defaultLifetime if it has none.
+// If shared is set, the s-maxage directive takes precedence.
+func freshnessLifetime(respHeaders http.Header, respCacheControl cacheControl, date time.Time, defaultLifetime time.Duration, shared bool) time.Duration {
+	if sMaxAge, ok := respCacheControl["s-maxage"]; ok && shared {
+		lifetime, err := time.ParseDuration(sMaxAge + "s")
+		if err != nil {
+			return 0
+		}
+		return lifetime
+	}
+	// If a response includes both an Expires header and a max-age directive,
+	// the max-age directive overrides the Expires header, even if the Expires header is more restrictive.
+	if maxAge, ok := respCacheControl["max-age"]; ok {
+		lifetime, err := time.ParseDuration(maxAge + "s")
+		if err != nil {
+			return 0
+		}
+		return lifetime
+	}
+	if expiresHeader := respHeaders.Get("Expires"); expiresHeader != "" {
+		expires, err := time.Parse(time.RFC1123, expiresHeader)
+		if err != nil {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	return defaultLifetime
+}
+
 // Returns true if either the request or the response includes the stale-if-error
 // cache control extension: https://tools.ietf.org/html/rfc5861
-func canStaleOnError(respHeaders, reqHeaders http.Header) bool {
+// and the response is within its window, its age measured with clk.
+func canStaleOnError(respHeaders, reqHeaders http.Header, clk timer) bool {
 	respCacheControl := parseCacheControl(respHeaders)
 	reqCacheControl := parseCacheControl(reqHeaders)
 
@@ -476,7 +1430,7 @@ func canStaleOnError(respHeaders, reqHeaders http.Header) bool {
 		if err != nil {
 			return false
 		}
-		currentAge := clock.since(date)
+		currentAge := clk.since(date)
 		if lifetime > currentAge {
 			return true
 		}
@@ -485,6 +1439,23 @@ func canStaleOnError(respHeaders, reqHeaders http.Header) bool {
 	return false
 }
 
+// canStaleWhileRevalidate reports whether the stale cached response with respHeaders
+// may be served while it's revalidated in the background, that is, whether it
+// is within the window given by its stale-while-revalidate directive: https://tools.ietf.org/html/rfc5861
+func canStaleWhileRevalidate(respHeaders http.Header, defaultLifetime time.Duration, shared bool, clk timer) bool {
+	swr, ok := parseCacheControl(respHeaders)["stale-while-revalidate"]
+	if !ok {
+		return false
+	}
+	if _, err := strconv.ParseUint(swr, 10, 64); err != nil {
+		return false
+	}
+	// The window works like a max-stale request directive.
+	reqHeaders := http.Header{}
+	reqHeaders.Set("Cache-Control", "max-stale="+swr)
+	return getFreshnessWithDefault(respHeaders, reqHeaders, defaultLifetime, shared, clk) == fresh
+}
+
 func getEndToEndHeaders(respHeaders http.Header) []string {
 	// These headers are always hop-by-hop
 	hopByHopHeaders := map[string]struct{}{
@@ -548,26 +1519,123 @@ func cloneRequest(r *http.Request) *http.Request {
 	return r2
 }
 
+// cacheControl maps the directives of a Cache-Control header, lowercased,
+// to their unquoted values, empty if they have none.
 type cacheControl map[string]string
 
 func parseCacheControl(headers http.Header) cacheControl {
 	cc := cacheControl{}
-	ccHeader := headers.Get("Cache-Control")
-	for _, part := range strings.Split(ccHeader, ",") {
-		part = strings.Trim(part, " ")
-		if part == "" {
+	for _, part := range splitDirectives(headers.Get("Cache-Control")) {
+		name, value, _ := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
 			continue
 		}
-		if strings.ContainsRune(part, '=') {
-			keyval := strings.Split(part, "=")
-			cc[strings.Trim(keyval[0], " ")] = strings.Trim(keyval[1], ",")
-		} else {
-			cc[part] = ""
-		}
+		cc[name] = unquote(strings.TrimSpace(value))
 	}
 	return cc
 }
 
+// fieldNames returns the header names listed in the value of directive,
+// e.g. no-cache="Set-Cookie", canonicalized, or nil if it has none.
+func (cc cacheControl) fieldNames(directive string) []string {
+	var names []string
+	for _, name := range strings.Split(cc[directive], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	return names
+}
+
+// unqualified reports whether cc has directive without a list of header names,
+// e.g. no-cache as opposed to no-cache="Set-Cookie".
+func (cc cacheControl) unqualified(directive string) bool {
+	_, ok := cc[directive]
+	return ok && len(cc.fieldNames(directive)) == 0
+}
+
+// splitDirectives splits the Cache-Control header value v on the commas
+// outside of quoted strings.
+func splitDirectives(v string) []string {
+	var (
+		parts   []string
+		start   int
+		quoted  bool
+		escaped bool
+	)
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, v[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, v[start:])
+}
+
+// withoutDirectives returns a copy of h without the given Cache-Control directives,
+// or h if it has none of them.
+func withoutDirectives(h http.Header, names ...string) http.Header {
+	var (
+		kept    []string
+		removed bool
+	)
+	for _, part := range splitDirectives(h.Get("Cache-Control")) {
+		name, _, _ := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		keep := true
+		for _, n := range names {
+			if n == name {
+				keep = false
+			}
+		}
+		if !keep {
+			removed = true
+			continue
+		}
+		kept = append(kept, strings.TrimSpace(part))
+	}
+	if !removed {
+		return h
+	}
+	h = h.Clone()
+	h.Set("Cache-Control", strings.Join(kept, ", "))
+	return h
+}
+
+// unquote returns the quoted string s unquoted, or s if it isn't quoted.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// strippedHeaders returns the names of the headers of a response with the
+// Cache-Control directives respCacheControl that may not be stored:
+// those listed in its no-cache and private directives.
+func strippedHeaders(respCacheControl cacheControl) []string {
+	return append(respCacheControl.fieldNames("no-cache"), respCacheControl.fieldNames("private")...)
+}
+
 // headerAllCommaSepValues returns all comma-separated values (each
 // with whitespace trimmed) for header name in headers. According to
 // Section 4.2 of the HTTP/1.1 spec
@@ -593,9 +1661,21 @@ type cachingReadCloser struct {
 	// Underlying ReadCloser.
 	R io.ReadCloser
 	// OnEOF is called with a copy of the content of R when EOF is reached.
-	OnEOF func(io.Reader)
+	OnEOF func([]byte)
+	// OnTooLarge, if set, is called when the content of R exceeds max.
+	OnTooLarge func()
 
 	buf *bytes.Buffer // buf stores a copy of the content of R.
+
+	// max, if > 0, is the maximum number of bytes buffered.
+	// Larger contents are not cached.
+	max int64
+
+	// counter, if set, is incremented with the number of bytes buffered
+	// and decremented when the buffer is released.
+	counter  *atomic.Int64
+	buffered int64
+	done     bool
 }
 
 // Read reads the next len(p) bytes from R or until R is drained. The
@@ -604,13 +1684,42 @@ type cachingReadCloser struct {
 // has been read so far.
 func (r *cachingReadCloser) Read(p []byte) (n int, err error) {
 	n, err = r.R.Read(p)
+	if r.done {
+		return n, err
+	}
 	r.buf.Write(p[:n])
+	if r.counter != nil {
+		r.counter.Add(int64(n))
+		r.buffered += int64(n)
+	}
+	if r.max > 0 && int64(r.buf.Len()) > r.max {
+		r.release()
+		if r.OnTooLarge != nil {
+			r.OnTooLarge()
+		}
+		return n, err
+	}
 	if err == io.EOF {
-		r.OnEOF(r.buf)
+		r.OnEOF(r.buf.Bytes())
+		r.release()
 	}
 	return n, err
 }
 
 func (r *cachingReadCloser) Close() error {
+	r.release()
 	return r.R.Close()
 }
+
+// release releases the buffer, no more content will be cached.
+func (r *cachingReadCloser) release() {
+	if r.done {
+		return
+	}
+	r.done = true
+	r.buf = nil
+	if r.counter != nil {
+		r.counter.Add(-r.buffered)
+		r.buffered = 0
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -27,6 +29,10 @@ type fakeClock struct {
 	elapsed time.Duration
 }
 
+func (c *fakeClock) now() time.Time {
+	return time.Now()
+}
+
 func (c *fakeClock) since(t time.Time) time.Duration {
 	return c.elapsed
 }
@@ -181,7 +187,10 @@ func resetTest() {
 	s.transport.AlwaysUseCachedResponse = nil
 	s.transport.ShouldCache = nil
 	s.transport.EnableETagPair = false
+	s.transport.ETagPairHashLimit = 0
 	s.transport.MarkCachedResponses = false
+	s.transport.MaxInFlightBufferBytes = 0
+	s.transport.PreserveHeaders = false
 	clock = &realClock{}
 }
 
@@ -251,6 +260,34 @@ func TestEnableETagPair(t *testing.T) {
 	}
 }
 
+func TestETagPairHashLimit(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	s.transport.EnableETagPair = true
+	s.transport.ETagPairHashLimit = 5
+
+	etags := func(hello string) (string, string) {
+		_, resp := doMethod(t, "GET", "/helloheaderasbody", map[string]string{"Hello": hello})
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+		return getXETags(resp.Header)
+	}
+
+	// A change past the limit goes unnoticed, unless the length changes.
+	etag1, etag2 := etags("world1")
+	c.Assert(etag1, qt.Equals, etag2)
+	_, etag2 = etags("world2")
+	c.Assert(etag2, qt.Equals, etag1)
+	_, etag2 = etags("world12")
+	c.Assert(etag2, qt.Not(qt.Equals), etag1)
+	_, etag2 = etags("World1")
+	c.Assert(etag2, qt.Not(qt.Equals), etag1)
+
+	// Bodies up to the limit hash as without limit.
+	s.transport.ETagPairHashLimit = 6
+	_, etag2 = etags("world1")
+	c.Assert(etag2, qt.Equals, "48b21a691481958c34cc165011bdb9bc")
+}
+
 func TestAlwaysUseCachedResponse(t *testing.T) {
 	resetTest()
 	c := qt.New(t)
@@ -425,6 +462,31 @@ func TestOnlyReadBodyOnDemand(t *testing.T) {
 	resp.Body.Close()
 }
 
+func TestMaxInFlightBufferBytes(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	s.transport.MaxInFlightBufferBytes = 1
+
+	req, err := http.NewRequest("GET", s.server.URL+"/method", nil)
+	c.Assert(err, qt.IsNil)
+	resp, err := s.client.Do(req)
+	c.Assert(err, qt.IsNil)
+	_, err = resp.Body.Read(make([]byte, 1))
+	c.Assert(err, qt.IsNil)
+	c.Assert(s.transport.inFlightBufferBytes.Load(), qt.Equals, int64(1))
+
+	// Ceiling reached, pass through.
+	doMethod(t, "GET", "/etag", nil)
+	c.Assert(cacheSize(), qt.Equals, 0)
+
+	resp.Body.Close()
+	c.Assert(s.transport.inFlightBufferBytes.Load(), qt.Equals, int64(0))
+
+	doMethod(t, "GET", "/etag", nil)
+	c.Assert(cacheSize(), qt.Equals, 1)
+	c.Assert(s.transport.inFlightBufferBytes.Load(), qt.Equals, int64(0))
+}
+
 func TestGetOnlyIfCachedHit(t *testing.T) {
 	resetTest()
 	c := qt.New(t)
@@ -453,6 +515,50 @@ func TestGetOnlyIfCachedMiss(t *testing.T) {
 	c.Assert(cacheSize(), qt.Equals, 1)
 }
 
+func TestGetOnlyIfCachedMaxStale(t *testing.T) {
+	resetTest()
+	s.transport.MarkCachedResponses = true
+	c := qt.New(t)
+	doMethod(t, "GET", "/", nil)
+	c.Assert(cacheSize(), qt.Equals, 1)
+
+	clock = &fakeClock{elapsed: 2 * time.Hour}
+	{
+		_, resp := doMethod(t, "GET", "/", map[string]string{"cache-control": "only-if-cached"})
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusGatewayTimeout)
+	}
+	{
+		_, resp := doMethod(t, "GET", "/", map[string]string{"cache-control": "only-if-cached, max-stale=3600"})
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusGatewayTimeout)
+	}
+	{
+		_, resp := doMethod(t, "GET", "/", map[string]string{"cache-control": "only-if-cached, max-stale=7200"})
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+		c.Assert(resp.Header.Get(XFromCache), qt.Equals, "1")
+	}
+	{
+		_, resp := doMethod(t, "GET", "/", map[string]string{"cache-control": "only-if-cached, max-stale"})
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+		c.Assert(resp.Header.Get(XFromCache), qt.Equals, "1")
+	}
+}
+
+func TestGetOnlyIfCachedMustRevalidate(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	doMethod(t, "GET", "/etag", nil)
+	c.Assert(cacheSize(), qt.Equals, 1)
+	_, resp := doMethod(t, "GET", "/etag", map[string]string{"cache-control": "only-if-cached, max-stale"})
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+
+	respHeaders := http.Header{}
+	respHeaders.Set("Cache-Control", "max-age=0, must-revalidate")
+	respHeaders.Set("Date", time.Now().Format(time.RFC1123))
+	reqHeaders := http.Header{}
+	reqHeaders.Set("Cache-Control", "only-if-cached, max-stale")
+	c.Assert(getFreshness(respHeaders, reqHeaders, clock), qt.Equals, stale)
+}
+
 func TestGetNoStoreRequest(t *testing.T) {
 	resetTest()
 	s.transport.MarkCachedResponses = true
@@ -843,6 +949,61 @@ func TestParseCacheControl(t *testing.T) {
 	}
 }
 
+func TestParseCacheControlFieldNames(t *testing.T) {
+	c := qt.New(t)
+	h := http.Header{}
+	h.Set("Cache-Control", `No-Cache="Set-Cookie, x-trace", max-age=60, private="X-Internal", ext="a\"b,c"`)
+	cc := parseCacheControl(h)
+	c.Assert(cc, qt.DeepEquals, cacheControl{
+		"no-cache": "Set-Cookie, x-trace",
+		"max-age":  "60",
+		"private":  "X-Internal",
+		"ext":      `a"b,c`,
+	})
+	c.Assert(cc.fieldNames("no-cache"), qt.DeepEquals, []string{"Set-Cookie", "X-Trace"})
+	c.Assert(cc.unqualified("no-cache"), qt.IsFalse)
+	c.Assert(strippedHeaders(cc), qt.DeepEquals, []string{"Set-Cookie", "X-Trace", "X-Internal"})
+
+	h.Set("Cache-Control", "no-cache, private")
+	cc = parseCacheControl(h)
+	c.Assert(cc.unqualified("no-cache"), qt.IsTrue)
+	c.Assert(strippedHeaders(cc), qt.HasLen, 0)
+}
+
+func TestNoCacheFieldNames(t *testing.T) {
+	c := qt.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", `max-age=3600, no-cache="Set-Cookie", private="X-Internal"`)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Internal", "node-1")
+		w.Header().Set("X-Public", "yes")
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	tp := &Transport{Cache: newMemoryCache(), Clock: &offsetClock{}}
+	get := func() *http.Response {
+		resp, err := (&http.Client{Transport: tp}).Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get()
+	c.Assert(resp.Header.Get("Set-Cookie"), qt.Equals, "session=secret")
+	c.Assert(resp.Header.Get("X-Internal"), qt.Equals, "node-1")
+
+	// Still cached, without the listed headers.
+	resp = get()
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(resp.Header.Get("Set-Cookie"), qt.Equals, "")
+	c.Assert(resp.Header.Get("X-Internal"), qt.Equals, "")
+	c.Assert(resp.Header.Get("X-Public"), qt.Equals, "yes")
+}
+
 func TestNoCacheRequestExpiration(t *testing.T) {
 	resetTest()
 	respHeaders := http.Header{}
@@ -850,7 +1011,7 @@ func TestNoCacheRequestExpiration(t *testing.T) {
 
 	reqHeaders := http.Header{}
 	reqHeaders.Set("Cache-Control", "no-cache")
-	if getFreshness(respHeaders, reqHeaders) != transparent {
+	if getFreshness(respHeaders, reqHeaders, clock) != transparent {
 		t.Fatal("freshness isn't transparent")
 	}
 }
@@ -862,7 +1023,7 @@ func TestNoCacheResponseExpiration(t *testing.T) {
 	respHeaders.Set("Expires", "Wed, 19 Apr 3000 11:43:00 GMT")
 
 	reqHeaders := http.Header{}
-	if getFreshness(respHeaders, reqHeaders) != stale {
+	if getFreshness(respHeaders, reqHeaders, clock) != stale {
 		t.Fatal("freshness isn't stale")
 	}
 }
@@ -875,7 +1036,7 @@ func TestReqMustRevalidate(t *testing.T) {
 
 	reqHeaders := http.Header{}
 	reqHeaders.Set("Cache-Control", "must-revalidate")
-	if getFreshness(respHeaders, reqHeaders) != stale {
+	if getFreshness(respHeaders, reqHeaders, clock) != stale {
 		t.Fatal("freshness isn't stale")
 	}
 }
@@ -886,7 +1047,7 @@ func TestRespMustRevalidate(t *testing.T) {
 	respHeaders.Set("Cache-Control", "must-revalidate")
 
 	reqHeaders := http.Header{}
-	if getFreshness(respHeaders, reqHeaders) != stale {
+	if getFreshness(respHeaders, reqHeaders, clock) != stale {
 		t.Fatal("freshness isn't stale")
 	}
 }
@@ -899,12 +1060,12 @@ func TestFreshExpiration(t *testing.T) {
 	respHeaders.Set("expires", now.Add(time.Duration(2)*time.Second).Format(time.RFC1123))
 
 	reqHeaders := http.Header{}
-	if getFreshness(respHeaders, reqHeaders) != fresh {
+	if getFreshness(respHeaders, reqHeaders, clock) != fresh {
 		t.Fatal("freshness isn't fresh")
 	}
 
 	clock = &fakeClock{elapsed: 3 * time.Second}
-	if getFreshness(respHeaders, reqHeaders) != stale {
+	if getFreshness(respHeaders, reqHeaders, clock) != stale {
 		t.Fatal("freshness isn't stale")
 	}
 }
@@ -917,12 +1078,12 @@ func TestMaxAge(t *testing.T) {
 	respHeaders.Set("cache-control", "max-age=2")
 
 	reqHeaders := http.Header{}
-	if getFreshness(respHeaders, reqHeaders) != fresh {
+	if getFreshness(respHeaders, reqHeaders, clock) != fresh {
 		t.Fatal("freshness isn't fresh")
 	}
 
 	clock = &fakeClock{elapsed: 3 * time.Second}
-	if getFreshness(respHeaders, reqHeaders) != stale {
+	if getFreshness(respHeaders, reqHeaders, clock) != stale {
 		t.Fatal("freshness isn't stale")
 	}
 }
@@ -935,7 +1096,7 @@ func TestMaxAgeZero(t *testing.T) {
 	respHeaders.Set("cache-control", "max-age=0")
 
 	reqHeaders := http.Header{}
-	if getFreshness(respHeaders, reqHeaders) != stale {
+	if getFreshness(respHeaders, reqHeaders, clock) != stale {
 		t.Fatal("freshness isn't stale")
 	}
 }
@@ -949,7 +1110,7 @@ func TestBothMaxAge(t *testing.T) {
 
 	reqHeaders := http.Header{}
 	reqHeaders.Set("cache-control", "max-age=0")
-	if getFreshness(respHeaders, reqHeaders) != stale {
+	if getFreshness(respHeaders, reqHeaders, clock) != stale {
 		t.Fatal("freshness isn't stale")
 	}
 }
@@ -963,13 +1124,13 @@ func TestMinFreshWithExpires(t *testing.T) {
 
 	reqHeaders := http.Header{}
 	reqHeaders.Set("cache-control", "min-fresh=1")
-	if getFreshness(respHeaders, reqHeaders) != fresh {
+	if getFreshness(respHeaders, reqHeaders, clock) != fresh {
 		t.Fatal("freshness isn't fresh")
 	}
 
 	reqHeaders = http.Header{}
 	reqHeaders.Set("cache-control", "min-fresh=2")
-	if getFreshness(respHeaders, reqHeaders) != stale {
+	if getFreshness(respHeaders, reqHeaders, clock) != stale {
 		t.Fatal("freshness isn't stale")
 	}
 }
@@ -984,12 +1145,12 @@ func TestEmptyMaxStale(t *testing.T) {
 	reqHeaders := http.Header{}
 	reqHeaders.Set("cache-control", "max-stale")
 	clock = &fakeClock{elapsed: 10 * time.Second}
-	if getFreshness(respHeaders, reqHeaders) != fresh {
+	if getFreshness(respHeaders, reqHeaders, clock) != fresh {
 		t.Fatal("freshness isn't fresh")
 	}
 
 	clock = &fakeClock{elapsed: 60 * time.Second}
-	if getFreshness(respHeaders, reqHeaders) != fresh {
+	if getFreshness(respHeaders, reqHeaders, clock) != fresh {
 		t.Fatal("freshness isn't fresh")
 	}
 }
@@ -1004,17 +1165,17 @@ func TestMaxStaleValue(t *testing.T) {
 	reqHeaders := http.Header{}
 	reqHeaders.Set("cache-control", "max-stale=20")
 	clock = &fakeClock{elapsed: 5 * time.Second}
-	if getFreshness(respHeaders, reqHeaders) != fresh {
+	if getFreshness(respHeaders, reqHeaders, clock) != fresh {
 		t.Fatal("freshness isn't fresh")
 	}
 
 	clock = &fakeClock{elapsed: 15 * time.Second}
-	if getFreshness(respHeaders, reqHeaders) != fresh {
+	if getFreshness(respHeaders, reqHeaders, clock) != fresh {
 		t.Fatal("freshness isn't fresh")
 	}
 
 	clock = &fakeClock{elapsed: 30 * time.Second}
-	if getFreshness(respHeaders, reqHeaders) != stale {
+	if getFreshness(respHeaders, reqHeaders, clock) != stale {
 		t.Fatal("freshness isn't stale")
 	}
 }
@@ -1443,6 +1604,26 @@ func (c *memoryCache) Delete(key string) {
 	c.mu.Unlock()
 }
 
+// Keys calls fn for every key starting with prefix.
+func (c *memoryCache) Keys(prefix string, fn func(key string) bool) {
+	c.mu.RLock()
+	var keys []string
+	for k := range c.items {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !fn(k) {
+			return
+		}
+	}
+}
+
+var _ IterableCache = &memoryCache{}
+
 var _ Cache = &staleCache{}
 
 type staleCache struct {
@@ -1464,3 +1645,52 @@ func (c *staleCache) Delete(key string) {
 func (c *staleCache) Size() int {
 	return 1
 }
+
+func TestMissWithoutInternalHeaders(t *testing.T) {
+	c := qt.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Accept", "text/plain")
+	resp, err := newMemoryCacheTransport().RoundTrip(req)
+	c.Assert(err, qt.IsNil)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	for k := range resp.Header {
+		c.Assert(isInternalHeader(k), qt.IsFalse, qt.Commentf("%s", k))
+	}
+}
+
+func TestModifiedWithoutInternalHeaders(t *testing.T) {
+	c := qt.New(t)
+	var etag int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag++
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Etag", strconv.Quote(strconv.Itoa(etag)))
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		resp, err := tp.RoundTrip(req)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		for k := range resp.Header {
+			c.Assert(isInternalHeader(k), qt.IsFalse, qt.Commentf("%s", k))
+		}
+	}
+	info, ok := tp.EntryInfo(ts.URL)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(info.LastValidation, qt.Equals, ValidationModified)
+}
@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// ImmutablePolicy tells how the Transport honors the immutable directive of
+// cached responses (RFC 8246), telling that their body will not change while fresh.
+type ImmutablePolicy int
+
+const (
+	// ImmutableIgnore handles the responses marked immutable like any other.
+	ImmutableIgnore ImmutablePolicy = iota
+
+	// ImmutableLenient serves the fresh responses marked immutable to requests with
+	// a max-age directive, such as the Cache-Control: max-age=0 sent by browsers
+	// when the user reloads a page, without revalidating them.
+	// Requests with a no-cache directive still revalidate them.
+	ImmutableLenient
+
+	// ImmutableStrict is like ImmutableLenient, but also serves the fresh responses
+	// marked immutable to requests with a no-cache directive.
+	ImmutableStrict
+)
+
+func (p ImmutablePolicy) String() string {
+	switch p {
+	case ImmutableIgnore:
+		return "ignore"
+	case ImmutableLenient:
+		return "lenient"
+	case ImmutableStrict:
+		return "strict"
+	default:
+		return "unknown"
+	}
+}
+
+// immutableRequestHeader returns the request headers reqHeaders to compute the
+// freshness of a cached response with the headers respHeaders: if the response is
+// marked immutable, without the request directives ignored by the Immutable policy.
+func (t *Transport) immutableRequestHeader(respHeaders, reqHeaders http.Header) http.Header {
+	if t.Immutable == ImmutableIgnore || reqHeaders.Get("Cache-Control") == "" {
+		return reqHeaders
+	}
+	if _, ok := parseCacheControl(respHeaders)["immutable"]; !ok {
+		return reqHeaders
+	}
+	if t.Immutable == ImmutableStrict {
+		return withoutDirectives(reqHeaders, "max-age", "no-cache")
+	}
+	return withoutDirectives(reqHeaders, "max-age")
+}
+
+// suspiciousSizeFactor is the factor by which the size of the body of a
+// replacement for an immutable response must differ from the cached one
+// to be rejected in GuardImmutable mode.
+const suspiciousSizeFactor = 10
+
+// suspiciousUpdate returns why resp, a 200 response fetched to replace cachedResp,
+// is rejected in GuardImmutable mode, or an empty string if it is accepted.
+// err is the error fetching resp, if any.
+func (t *Transport) suspiciousUpdate(req *http.Request, cachedResp, resp *http.Response, err error) string {
+	if !t.GuardImmutable || err != nil || req.Method == http.MethodHead || resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	if _, ok := parseCacheControl(cachedResp.Header)["immutable"]; !ok {
+		return ""
+	}
+	if cached, got := mediaType(cachedResp.Header), mediaType(resp.Header); cached != got {
+		return "Content-Type changed from " + strconv.Quote(cached) + " to " + strconv.Quote(got)
+	}
+	cachedSize := cachedResp.ContentLength
+	if size, err := strconv.ParseInt(cachedResp.Header.Get(xContentSize), 10, 64); err == nil {
+		cachedSize = size
+	}
+	if cachedSize > 0 && resp.ContentLength >= 0 &&
+		(resp.ContentLength*suspiciousSizeFactor < cachedSize || resp.ContentLength > cachedSize*suspiciousSizeFactor) {
+		return "Content-Length changed from " + strconv.FormatInt(cachedSize, 10) + " to " + strconv.FormatInt(resp.ContentLength, 10)
+	}
+	return ""
+}
+
+// mediaType returns the media type of the Content-Type in h, without parameters.
+func mediaType(h http.Header) string {
+	ct := h.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(ct); err == nil {
+		return mt
+	}
+	return ct
+}
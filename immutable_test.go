@@ -0,0 +1,134 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestGuardImmutable(t *testing.T) {
+	c := qt.New(t)
+	contentType, body := "text/css; charset=utf-8", strings.Repeat("a", 1000)
+	clk := &offsetClock{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60, immutable")
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Date", clk.Now().UTC().Format(http.TimeFormat))
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	var reasons []string
+	tp := &Transport{
+		Cache:          newMemoryCache(),
+		Clock:          clk,
+		GuardImmutable: true,
+		OnSuspiciousUpdate: func(req *http.Request, key, reason string) {
+			reasons = append(reasons, reason)
+		},
+	}
+	get := func() (string, CacheStatus) {
+		resp, err := (&http.Client{Transport: tp}).Get(srv.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	b, status := get()
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(b, qt.Equals, body)
+
+	// An error page in place of the stylesheet.
+	clk.offset += 2 * time.Minute
+	contentType, body = "text/html", "<h1>Oops</h1>"
+	b, status = get()
+	c.Assert(status, qt.Equals, StatusStale)
+	c.Assert(b, qt.Equals, strings.Repeat("a", 1000))
+	c.Assert(reasons, qt.DeepEquals, []string{`Content-Type changed from "text/css" to "text/html"`})
+
+	// Same type, truncated.
+	contentType, body = "text/css", "a"
+	b, status = get()
+	c.Assert(status, qt.Equals, StatusStale)
+	c.Assert(b, qt.Equals, strings.Repeat("a", 1000))
+	c.Assert(reasons[1], qt.Equals, "Content-Length changed from 1000 to 1")
+
+	// A plausible update is accepted.
+	body = strings.Repeat("b", 800)
+	b, status = get()
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(b, qt.Equals, body)
+	b, status = get()
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(b, qt.Equals, body)
+	c.Assert(reasons, qt.HasLen, 2)
+
+	// Without GuardImmutable, any update is accepted.
+	tp.GuardImmutable = false
+	clk.offset += 2 * time.Minute
+	contentType, body = "text/html", "<h1>Oops</h1>"
+	b, status = get()
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(b, qt.Equals, body)
+}
+
+func TestImmutablePolicy(t *testing.T) {
+	c := qt.New(t)
+	clk := &offsetClock{}
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60, immutable")
+		w.Header().Set("Date", clk.Now().UTC().Format(http.TimeFormat))
+		w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+
+	for _, test := range []struct {
+		policy       ImmutablePolicy
+		cacheControl string
+		want         CacheStatus
+	}{
+		{ImmutableIgnore, "max-age=0", StatusMiss},
+		{ImmutableLenient, "max-age=0", StatusHit},
+		{ImmutableLenient, "no-cache", StatusMiss},
+		{ImmutableStrict, "max-age=0", StatusHit},
+		{ImmutableStrict, "no-cache", StatusHit},
+		{ImmutableStrict, "no-cache, max-stale=10", StatusHit},
+	} {
+		c.Run(test.policy.String()+" "+test.cacheControl, func(c *qt.C) {
+			clk.offset = 0
+			tp := &Transport{Cache: newMemoryCache(), Clock: clk, Immutable: test.policy}
+			get := func(cacheControl string) CacheStatus {
+				req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+				c.Assert(err, qt.IsNil)
+				if cacheControl != "" {
+					req.Header.Set("Cache-Control", cacheControl)
+				}
+				resp, err := tp.RoundTrip(req)
+				c.Assert(err, qt.IsNil)
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				status, _ := ResponseCacheStatus(resp)
+				return status
+			}
+			c.Assert(get(""), qt.Equals, StatusMiss)
+			c.Assert(get(test.cacheControl), qt.Equals, test.want)
+
+			// Once stale, the response is revalidated.
+			clk.offset += 2 * time.Minute
+			c.Assert(get(test.cacheControl), qt.Not(qt.Equals), StatusHit)
+		})
+	}
+
+	c.Assert(ImmutableStrict.String(), qt.Equals, "strict")
+	c.Assert(ImmutablePolicy(42).String(), qt.Equals, "unknown")
+}
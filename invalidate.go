@@ -0,0 +1,58 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+)
+
+// Invalidate removes the cached response for req, if any, along with its variants
+// in MaxVariants mode.
+func (t *Transport) Invalidate(req *http.Request) error {
+	key := t.cacheKey(req)
+	if key == "" {
+		return nil
+	}
+	return t.deleteEntry(req.Context(), key)
+}
+
+// InvalidateURL removes the cached responses for GET and HEAD requests to url, if any.
+func (t *Transport) InvalidateURL(ctx context.Context, url string) error {
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return err
+		}
+		if err := t.Invalidate(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgePrefix removes all cached responses with a cache key starting with prefix,
+// returning the number of entries removed.
+// With the default cache keys, the key of a GET request is its URL,
+// so e.g. "https://example.com/api/" removes the GET responses below that path.
+//
+// The Cache must implement IterableCache, or ErrCacheNotIterable is returned.
+func (t *Transport) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	ic, ok := t.Cache.(IterableCache)
+	if !ok {
+		return 0, ErrCacheNotIterable
+	}
+	// Collect the keys first, deleting while iterating is not safe for all backends.
+	var keys []string
+	ic.Keys(prefix, func(key string) bool {
+		keys = append(keys, key)
+		return ctx.Err() == nil
+	})
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		if err := t.cacheDelete(ctx, key); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,42 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestInvalidate(t *testing.T) {
+	resetTest()
+	s.transport.MarkCachedResponses = true
+	c := qt.New(t)
+	ctx := context.Background()
+
+	doMethod(t, "HEAD", "/", nil)
+	doMethod(t, "GET", "/", nil)
+	doMethod(t, "GET", "/method", nil)
+	doMethod(t, "GET", "/etag", nil)
+	c.Assert(cacheSize(), qt.Equals, 4)
+
+	req, err := http.NewRequest("GET", s.server.URL+"/etag", nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s.transport.Invalidate(req), qt.IsNil)
+	c.Assert(cacheSize(), qt.Equals, 3)
+
+	c.Assert(s.transport.InvalidateURL(ctx, s.server.URL+"/"), qt.IsNil)
+	c.Assert(cacheSize(), qt.Equals, 1)
+	_, resp := doMethod(t, "GET", "/method", nil)
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "1")
+
+	doMethod(t, "GET", "/", nil)
+	n, err := s.transport.PurgePrefix(ctx, s.server.URL+"/m")
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 1)
+	c.Assert(cacheSize(), qt.Equals, 1)
+
+	tp := &Transport{Cache: FromCacheContext(&contextMemoryCache{items: map[string][]byte{}})}
+	_, err = tp.PurgePrefix(ctx, "")
+	c.Assert(err, qt.Equals, ErrCacheNotIterable)
+}
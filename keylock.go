@@ -0,0 +1,17 @@
+package httpcache
+
+import "sync"
+
+// keyLocks serializes the writes to the entries stored for a key, so concurrent
+// stores and updates of the same entry, e.g. its metadata and its body written
+// separately to a MetadataCache, or its headers updated after a 304 Not Modified,
+// don't interleave. Keys are spread over a fixed number of mutexes, so unrelated
+// keys may share one: a lock must not be taken while holding another.
+type keyLocks [64]sync.Mutex
+
+// lock locks the mutex of key and returns the func unlocking it.
+func (l *keyLocks) lock(key string) (unlock func()) {
+	mu := &l[shardHash(key)%uint64(len(l))]
+	mu.Lock()
+	return mu.Unlock
+}
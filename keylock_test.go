@@ -0,0 +1,67 @@
+package httpcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// slowMetadataCache is a MetadataCache slow to write odd bodies, so concurrent
+// stores of an entry, written in two steps, interleave unless serialized.
+type slowMetadataCache struct {
+	*metadataCache
+}
+
+func (c slowMetadataCache) Set(key string, b []byte) {
+	if len(b) > 0 && b[len(b)-1]%2 == 1 {
+		time.Sleep(2 * time.Millisecond)
+	}
+	c.metadataCache.Set(key, b)
+}
+
+func TestConcurrentStoresOfKey(t *testing.T) {
+	c := qt.New(t)
+
+	var version atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := fmt.Sprint(version.Add(1))
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("X-Version", v)
+		w.Write([]byte(v))
+	}))
+	defer ts.Close()
+
+	for i := 0; i < 20; i++ {
+		cache := &metadataCache{memoryCache: newMemoryCache(), meta: map[string][]byte{}}
+		tp := &Transport{Cache: slowMetadataCache{cache}}
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+				req.Header.Set("Cache-Control", "no-cache")
+				resp, err := tp.RoundTrip(req)
+				c.Check(err, qt.IsNil)
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+
+		meta, _ := cache.GetMetadata(ts.URL)
+		e, err := decodeEntry(meta)
+		c.Assert(err, qt.IsNil)
+		h, err := e.Header()
+		c.Assert(err, qt.IsNil)
+		body, _ := cache.memoryCache.Get(ts.URL)
+		c.Assert(string(body), qt.Equals, h.Get("X-Version"))
+	}
+}
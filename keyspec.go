@@ -0,0 +1,133 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// KeySpec describes how to build cache keys from normalized request URLs,
+// as an alternative to writing a CacheKey func, so equivalent URLs share
+// an entry instead of being stored again, e.g.:
+//
+//	t.CacheKey = httpcache.KeySpec{SortQueryParams: true, IgnoreQueryParams: []string{"utm_*"}}.CacheKey
+type KeySpec struct {
+	// IgnoreQueryParams lists query parameters left out of the key, e.g. those
+	// used for tracking. A trailing * matches any suffix, e.g. "utm_*".
+	IgnoreQueryParams []string
+
+	// SortQueryParams sorts the query parameters by name,
+	// keeping the order of the values of repeated parameters.
+	SortQueryParams bool
+
+	// IncludeHeaders lists request headers whose values are part of the key,
+	// e.g. to store the responses for different API versions separately.
+	IncludeHeaders []string
+
+	// StripFragment removes the fragment from the URL.
+	StripFragment bool
+
+	// CanonicalizeHost lowercases the host of the URL, and removes the
+	// trailing dot and the default port of the scheme from it.
+	CanonicalizeHost bool
+}
+
+// CacheKey returns the cache key for req, see Transport.CacheKey.
+func (s KeySpec) CacheKey(req *http.Request) string {
+	u := *req.URL
+	if s.StripFragment {
+		u.Fragment, u.RawFragment = "", ""
+	}
+	if s.CanonicalizeHost {
+		u.Host = canonicalHost(u.Scheme, u.Host)
+	}
+	if len(s.IgnoreQueryParams) > 0 || s.SortQueryParams {
+		u.RawQuery = s.query(u.RawQuery)
+	}
+	key := defaultCacheKey(req, u.String())
+	if key == "" {
+		return ""
+	}
+	for _, header := range s.IncludeHeaders {
+		header = http.CanonicalHeaderKey(header)
+		if v := req.Header.Values(header); len(v) > 0 {
+			key += " " + header + ":" + strings.Join(v, ",")
+		}
+	}
+	return key
+}
+
+// query returns the raw query rawQuery without the ignored parameters, sorted if needed.
+func (s KeySpec) query(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	type param struct {
+		name, raw string
+	}
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(raw, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if !s.ignoreQueryParam(name) {
+			params = append(params, param{name, raw})
+		}
+	}
+	if s.SortQueryParams {
+		sort.SliceStable(params, func(i, j int) bool { return params[i].name < params[j].name })
+	}
+	raws := make([]string, len(params))
+	for i, p := range params {
+		raws[i] = p.raw
+	}
+	return strings.Join(raws, "&")
+}
+
+func (s KeySpec) ignoreQueryParam(name string) bool {
+	for _, pattern := range s.IgnoreQueryParams {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalHost returns host lowercased, without a trailing dot
+// and without the default port of scheme.
+func canonicalHost(scheme, host string) string {
+	host = strings.ToLower(host)
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		// No port.
+		return strings.TrimSuffix(host, ".")
+	}
+	hostname = strings.TrimSuffix(hostname, ".")
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		if strings.Contains(hostname, ":") {
+			// IPv6.
+			return "[" + hostname + "]"
+		}
+		return hostname
+	}
+	return net.JoinHostPort(hostname, port)
+}
+
+// SHA256Key returns the hex encoded SHA-256 hash of key prefixed with "sha256:",
+// for use as Transport.HashKey.
+func SHA256Key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestKeySpec(t *testing.T) {
+	c := qt.New(t)
+
+	key := func(spec KeySpec, method, u string, header http.Header) string {
+		req, err := http.NewRequest(method, u, nil)
+		c.Assert(err, qt.IsNil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return spec.CacheKey(req)
+	}
+
+	c.Assert(key(KeySpec{}, "GET", "http://example.com/a?b=1#f", nil), qt.Equals, "http://example.com/a?b=1#f")
+	c.Assert(key(KeySpec{StripFragment: true}, "GET", "http://example.com/a?b=1#f", nil), qt.Equals, "http://example.com/a?b=1")
+
+	spec := KeySpec{SortQueryParams: true, IgnoreQueryParams: []string{"utm_*", "fbclid"}}
+	c.Assert(key(spec, "GET", "http://example.com/a?z=1&utm_source=x&a=2&fbclid=y&z=0&fbclid2=1", nil),
+		qt.Equals, "http://example.com/a?a=2&fbclid2=1&z=1&z=0")
+	c.Assert(key(KeySpec{IgnoreQueryParams: []string{"utm_source"}}, "GET", "http://example.com/a?utm_source=x", nil),
+		qt.Equals, "http://example.com/a")
+
+	spec = KeySpec{CanonicalizeHost: true}
+	c.Assert(key(spec, "GET", "https://Example.COM.:443/a", nil), qt.Equals, "https://example.com/a")
+	c.Assert(key(spec, "GET", "http://example.com:8080/a", nil), qt.Equals, "http://example.com:8080/a")
+	c.Assert(key(spec, "GET", "http://[::1]:80/a", nil), qt.Equals, "http://[::1]/a")
+	c.Assert(key(spec, "GET", "http://[::1]/a", nil), qt.Equals, "http://[::1]/a")
+
+	spec = KeySpec{IncludeHeaders: []string{"accept-version"}}
+	c.Assert(key(spec, "GET", "http://example.com/a", http.Header{"Accept-Version": {"2"}}), qt.Equals, "http://example.com/a Accept-Version:2")
+	c.Assert(key(spec, "GET", "http://example.com/a", nil), qt.Equals, "http://example.com/a")
+	c.Assert(key(spec, "HEAD", "http://example.com/a", nil), qt.Equals, "HEAD http://example.com/a")
+	c.Assert(key(spec, "GET", "http://example.com/a", http.Header{"Range": {"bytes=0-1"}}), qt.Equals, "")
+}
+
+func TestKeySpecTransport(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	s.transport.CacheKey = KeySpec{IgnoreQueryParams: []string{"utm_*"}}.CacheKey
+	s.transport.MarkCachedResponses = true
+
+	_, resp := doMethod(t, http.MethodGet, "/method?utm_source=a", nil)
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "")
+	_, resp = doMethod(t, http.MethodGet, "/method?utm_source=b", nil)
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "1")
+	c.Assert(cacheSize(), qt.Equals, 1)
+}
+
+func TestHashKey(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(SHA256Key("https://example.org/"), qt.Equals, "sha256:8198d1bac40a1033653a78e48800cefc9e6b974ff075c66e5548b5c1e145a2b0")
+
+	cache := newMemoryCache()
+	tp := &Transport{Cache: cache, HashKey: SHA256Key, MarkCachedResponses: true, Clock: &offsetClock{}}
+	u := s.server.URL + "/method?q=" + strings.Repeat("x", 1000)
+	get := func() *http.Response {
+		resp, err := (&http.Client{Transport: tp}).Get(u)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		return resp
+	}
+
+	c.Assert(get().Header.Get(XFromCache), qt.Equals, "")
+	c.Assert(get().Header.Get(XFromCache), qt.Equals, "1")
+	var keys []string
+	cache.Keys("", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, qt.DeepEquals, []string{SHA256Key(u)})
+
+	info, ok := tp.EntryInfo(keys[0])
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(info.Key, qt.Equals, SHA256Key(u))
+	c.Assert(info.OriginalKey, qt.Equals, u)
+	c.Assert(info.URL, qt.Equals, u)
+
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	c.Assert(tp.Invalidate(req), qt.IsNil)
+	c.Assert(cache.Size(), qt.Equals, 0)
+}
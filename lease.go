@@ -0,0 +1,34 @@
+package httpcache
+
+import "time"
+
+// LeaseCache is an optional interface implemented by Cache backends shared by
+// several processes (e.g. Redis) that can grant leases, typically with an atomic
+// "set if not exists" with expiry.
+//
+// When RefreshLease is set on a Transport using a LeaseCache, a node only refreshes
+// a stale response in the background if it acquired the lease for its key, so
+// a hot key is revalidated once per lease period across the fleet, while the other
+// nodes keep serving the stale response.
+type LeaseCache interface {
+	Cache
+	// AcquireLease acquires the lease named key for ttl, reporting false
+	// if it is held by someone else.
+	AcquireLease(key string, ttl time.Duration) bool
+}
+
+// leasePrefix is the prefix of the names of the leases for cache keys.
+const leasePrefix = "httpcache:lease:"
+
+// acquireRefreshLease reports whether this Transport may refresh the entry
+// stored for key in the background.
+func (t *Transport) acquireRefreshLease(key string) bool {
+	if t.RefreshLease <= 0 {
+		return true
+	}
+	lc, ok := t.Cache.(LeaseCache)
+	if !ok {
+		return true
+	}
+	return lc.AcquireLease(leasePrefix+key, t.RefreshLease)
+}
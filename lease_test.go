@@ -0,0 +1,116 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// leaseCache is a LeaseCache whose leases never expire.
+type leaseCache struct {
+	*memoryCache
+	mu     sync.Mutex
+	leases map[string]time.Duration
+}
+
+func (c *leaseCache) AcquireLease(key string, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.leases[key]; found {
+		return false
+	}
+	c.leases[key] = ttl
+	return true
+}
+
+func TestRefreshLease(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var (
+		mu          sync.Mutex
+		conditional int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		w.Header().Set("Etag", `"abc"`)
+		if r.Header.Get("if-none-match") == `"abc"` {
+			mu.Lock()
+			conditional++
+			mu.Unlock()
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	cache := &leaseCache{memoryCache: newMemoryCache(), leases: map[string]time.Duration{}}
+	var nodes []*Transport
+	for i := 0; i < 3; i++ {
+		nodes = append(nodes, &Transport{Cache: cache, RefreshLease: time.Minute})
+	}
+
+	get := func(tp *Transport) CacheStatus {
+		client := http.Client{Transport: tp}
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(get(nodes[0]), qt.Equals, StatusMiss)
+	clock = &fakeClock{elapsed: 30 * time.Second}
+	for _, tp := range nodes {
+		c.Assert(get(tp), qt.Equals, StatusStale)
+		tp.refreshes.wait()
+	}
+	c.Assert(conditional, qt.Equals, 1)
+	c.Assert(cache.leases, qt.DeepEquals, map[string]time.Duration{leasePrefix + ts.URL: time.Minute})
+
+	// Without leases, every node refreshes.
+	for _, tp := range nodes {
+		tp.RefreshLease = 0
+		c.Assert(get(tp), qt.Equals, StatusStale)
+		tp.refreshes.wait()
+	}
+	c.Assert(conditional, qt.Equals, 4)
+}
+
+func TestRefreshLeaseQueueFull(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	cache := &leaseCache{memoryCache: newMemoryCache(), leases: map[string]time.Duration{}}
+	tp := &Transport{Cache: cache, RefreshLease: time.Minute}
+	client := http.Client{Transport: tp}
+	resp, err := client.Get(ts.URL)
+	c.Assert(err, qt.IsNil)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The queue is closed, the response is revalidated on demand without taking the lease.
+	tp.Close()
+	clock = &fakeClock{elapsed: 30 * time.Second}
+	resp, err = client.Get(ts.URL)
+	c.Assert(err, qt.IsNil)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Not(qt.Equals), StatusStale)
+	c.Assert(cache.leases, qt.HasLen, 0)
+}
@@ -0,0 +1,329 @@
+// Package lrucache provides an in-memory implementation of httpcache.Cache
+// with a bounded size, evicting the least recently used entries first.
+package lrucache
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gohugoio/httpcache"
+)
+
+// Options configures a Cache.
+type Options struct {
+	// MaxSize is the maximum total size in bytes of the cached values.
+	// If 0, there is no limit.
+	MaxSize int64
+
+	// MaxEntries is the maximum number of cached values.
+	// If 0, there is no limit.
+	MaxEntries int
+
+	// OnEvict is an optional func called when a value is evicted
+	// to make room for others. It is not called for deleted values.
+	OnEvict func(key string, value []byte)
+
+	// Cost is an optional func returning the cost of fetching value for key again,
+	// e.g. based on how slow its origin is. When set, the value with the lowest cost
+	// per byte among the least recently used ones is evicted first, so expensive
+	// values stay longer. MaxSize still limits the size in bytes of the values.
+	// The default cost of a value is its size, i.e. plain LRU eviction.
+	Cost func(key string, value []byte) int64
+}
+
+// costWindow is the number of least recently used values compared
+// when Options.Cost is set.
+const costWindow = 8
+
+// Stats holds the counters of a Cache.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// Cache is an in-memory implementation of httpcache.Cache that evicts the least
+// recently used values when it grows beyond its configured limits.
+// Values recently confirmed by the server are given a second chance before being evicted.
+// It is safe for concurrent use.
+type Cache struct {
+	opts Options
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List               // of *entry, most recently used first.
+	entries map[string]*list.Element // by key.
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type entry struct {
+	key   string
+	value []byte
+
+	// cost is the cost of fetching the value again, see Options.Cost.
+	cost int64
+
+	// confirmed is set when the server last confirmed the value (304 Not Modified).
+	confirmed bool
+
+	// pinned is set while the value is pinned, see httpcache.Transport.Pin.
+	pinned bool
+}
+
+// costPerByte returns the cost of e relative to its size.
+func (e *entry) costPerByte() float64 {
+	size := len(e.value)
+	if size == 0 {
+		size = 1
+	}
+	return float64(e.cost) / float64(size)
+}
+
+// New returns a new Cache with the given options.
+func New(opts Options) *Cache {
+	return &Cache{
+		opts:    opts,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// NewTransport returns a new httpcache.Transport using a new Cache with the given options.
+// This is the recommended way to set up an in-memory cache.
+func NewTransport(opts Options) *httpcache.Transport {
+	return &httpcache.Transport{Cache: New(opts), MarkCachedResponses: true}
+}
+
+// Get returns the value stored for key, if any.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	var value []byte
+	if ok {
+		c.lru.MoveToFront(el)
+		// Replaced by UpdateEntry under the lock.
+		value = el.Value.(*entry).value
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return value, true
+}
+
+// Set stores value for key, evicting other values if needed.
+// Values larger than MaxSize are not stored.
+func (c *Cache) Set(key string, value []byte) {
+	c.mu.Lock()
+	var confirmed, pinned bool
+	if el, ok := c.entries[key]; ok {
+		// The Transport stores confirmed and pinned responses again with updated headers.
+		e := c.removeElement(el)
+		confirmed, pinned = e.confirmed, e.pinned
+	}
+	var added *list.Element
+	if c.opts.MaxSize <= 0 || int64(len(value)) <= c.opts.MaxSize {
+		added = c.lru.PushFront(&entry{key: key, value: value, cost: c.cost(key, value), confirmed: confirmed, pinned: pinned})
+		c.entries[key] = added
+		c.size += int64(len(value))
+	}
+	evicted := c.evict(added)
+	c.mu.Unlock()
+
+	c.evicted(evicted)
+}
+
+// UpdateEntry implements httpcache.EntryUpdater.
+// The value updated becomes the most recently used.
+func (c *Cache) UpdateEntry(key string, update func(value []byte) []byte) bool {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return false
+	}
+	e := el.Value.(*entry)
+	value := update(e.value)
+	if value == nil {
+		c.mu.Unlock()
+		return false
+	}
+	c.size += int64(len(value) - len(e.value))
+	e.value, e.cost = value, c.cost(key, value)
+	c.lru.MoveToFront(el)
+	evicted := c.evict(el)
+	c.mu.Unlock()
+
+	c.evicted(evicted)
+	return true
+}
+
+// evict removes values until the cache is within its limits, keeping keep,
+// and returns them. c.mu must be held.
+func (c *Cache) evict(keep *list.Element) []*entry {
+	var evicted []*entry
+	for c.overLimits() {
+		el := c.victim(keep)
+		if el == nil {
+			// Only pinned values left.
+			break
+		}
+		evicted = append(evicted, c.removeElement(el))
+	}
+	return evicted
+}
+
+// evicted reports the values removed by evict.
+func (c *Cache) evicted(evicted []*entry) {
+	c.evictions.Add(uint64(len(evicted)))
+	if c.opts.OnEvict != nil {
+		for _, e := range evicted {
+			c.opts.OnEvict(e.key, e.value)
+		}
+	}
+}
+
+// Delete removes the value stored for key.
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Validated implements httpcache.ValidationObserver.
+func (c *Cache) Validated(key string, outcome httpcache.ValidationOutcome) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*entry).confirmed = outcome == httpcache.ValidationNotModified
+	}
+}
+
+// Pinned implements httpcache.PinObserver.
+// Pinned values are never evicted, even if the cache grows beyond its limits.
+func (c *Cache) Pinned(key string, pinned bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*entry).pinned = pinned
+	}
+}
+
+// Keys calls fn for every key starting with prefix, in sorted order,
+// until fn returns false.
+func (c *Cache) Keys(prefix string, fn func(key string) bool) {
+	c.mu.Lock()
+	var keys []string
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+// Len returns the number of values in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Size returns the total size in bytes of the values in the cache.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Stats returns the hit, miss and eviction counters of the cache.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
+
+// c.mu must be held.
+func (c *Cache) overLimits() bool {
+	if c.lru.Len() == 0 {
+		return false
+	}
+	return (c.opts.MaxSize > 0 && c.size > c.opts.MaxSize) ||
+		(c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries)
+}
+
+// cost returns the cost of value stored for key.
+func (c *Cache) cost(key string, value []byte) int64 {
+	if c.opts.Cost == nil {
+		return int64(len(value))
+	}
+	return c.opts.Cost(key, value)
+}
+
+// victim returns the entry to evict: the least recently used one not confirmed
+// by the server, other than keep, or with Options.Cost set, the cheapest per
+// byte of the costWindow least recently used ones. Confirmed entries passed
+// over lose their confirmation, so they are evicted next time unless confirmed again.
+// Pinned entries are never evicted, victim returns nil if there are only pinned entries.
+// c.mu must be held.
+func (c *Cache) victim(keep *list.Element) *list.Element {
+	window := 1
+	if c.opts.Cost != nil {
+		window = costWindow
+	}
+	var (
+		victim     *list.Element
+		victimCost float64
+	)
+	for el := c.lru.Back(); el != nil && window > 0; el = el.Prev() {
+		if el == keep {
+			continue
+		}
+		e := el.Value.(*entry)
+		if e.pinned {
+			continue
+		}
+		if e.confirmed {
+			e.confirmed = false
+			continue
+		}
+		window--
+		if cost := e.costPerByte(); victim == nil || cost < victimCost {
+			victim, victimCost = el, cost
+		}
+	}
+	if victim == nil {
+		for el := c.lru.Back(); el != nil; el = el.Prev() {
+			if !el.Value.(*entry).pinned {
+				return el
+			}
+		}
+	}
+	return victim
+}
+
+// c.mu must be held.
+func (c *Cache) removeElement(el *list.Element) *entry {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	c.size -= int64(len(e.value))
+	return e
+}
@@ -0,0 +1,248 @@
+package lrucache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/gohugoio/httpcache"
+)
+
+var (
+	_ httpcache.IterableCache = (*Cache)(nil)
+	_ httpcache.PinObserver   = (*Cache)(nil)
+	_ httpcache.EntryUpdater  = (*Cache)(nil)
+)
+
+func TestLRUCache(t *testing.T) {
+	c := qt.New(t)
+
+	var evicted []string
+	cache := New(Options{
+		MaxSize:    10,
+		MaxEntries: 3,
+		OnEvict: func(key string, value []byte) {
+			evicted = append(evicted, key)
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		cache.Set(fmt.Sprint(i), []byte("ab"))
+	}
+	c.Assert(cache.Len(), qt.Equals, 3)
+	c.Assert(cache.Size(), qt.Equals, int64(6))
+
+	// Entry count limit, 1 is the least recently used.
+	_, ok := cache.Get("0")
+	c.Assert(ok, qt.IsTrue)
+	cache.Set("3", []byte("ab"))
+	c.Assert(evicted, qt.DeepEquals, []string{"1"})
+	_, ok = cache.Get("1")
+	c.Assert(ok, qt.IsFalse)
+
+	// Size limit.
+	cache.Set("4", []byte("abcdefg"))
+	c.Assert(evicted, qt.DeepEquals, []string{"1", "2", "0"})
+	c.Assert(cache.Size(), qt.Equals, int64(9))
+
+	// Too large.
+	cache.Set("5", []byte("abcdefghijk"))
+	_, ok = cache.Get("5")
+	c.Assert(ok, qt.IsFalse)
+
+	var keys []string
+	cache.Keys("", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, qt.DeepEquals, []string{"3", "4"})
+
+	cache.Delete("3")
+	c.Assert(cache.Len(), qt.Equals, 1)
+	c.Assert(evicted, qt.HasLen, 3)
+
+	c.Assert(cache.Stats(), qt.Equals, Stats{Hits: 1, Misses: 2, Evictions: 3})
+}
+
+func TestLRUCacheConcurrent(t *testing.T) {
+	cache := New(Options{MaxEntries: 5})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprint(j % 10)
+				cache.Set(key, []byte{byte(i)})
+				cache.Get(key)
+				if j%3 == 0 {
+					cache.Delete(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if cache.Len() > 5 {
+		t.Fatalf("got %d entries", cache.Len())
+	}
+}
+
+func TestLRUCacheConcurrentUpdateEntry(t *testing.T) {
+	cache := New(Options{})
+	cache.Set("a", []byte("0"))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				cache.UpdateEntry("a", func([]byte) []byte { return []byte(fmt.Sprint(i, j)) })
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if v, ok := cache.Get("a"); !ok || len(v) == 0 {
+					t.Errorf("got %q", v)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewTransport(t *testing.T) {
+	c := qt.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	client := http.Client{Transport: NewTransport(Options{MaxSize: 1 << 20})}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		if i == 1 {
+			c.Assert(resp.Header.Get(httpcache.XFromCache), qt.Equals, "1")
+		}
+	}
+}
+
+func TestLRUCacheConfirmedEntries(t *testing.T) {
+	c := qt.New(t)
+	cache := New(Options{MaxEntries: 2})
+
+	cache.Set("a", []byte("a"))
+	cache.Set("b", []byte("b"))
+	cache.Validated("a", httpcache.ValidationNotModified)
+	// Stored again with updated headers, still confirmed.
+	cache.Set("a", []byte("a"))
+	cache.Get("b")
+	cache.Set("c", []byte("c"))
+	_, ok := cache.Get("a")
+	c.Assert(ok, qt.IsTrue)
+	_, ok = cache.Get("b")
+	c.Assert(ok, qt.IsFalse)
+
+	// The second chance is used up.
+	cache.Get("c")
+	cache.Set("d", []byte("d"))
+	_, ok = cache.Get("a")
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestLRUCachePinnedEntries(t *testing.T) {
+	c := qt.New(t)
+	cache := New(Options{MaxEntries: 2})
+
+	cache.Set("a", []byte("a"))
+	cache.Pinned("a", true)
+	// Stored again with updated headers, still pinned.
+	cache.Set("a", []byte("a"))
+	cache.Set("b", []byte("b"))
+	cache.Set("c", []byte("c"))
+	_, ok := cache.Get("a")
+	c.Assert(ok, qt.IsTrue)
+	_, ok = cache.Get("b")
+	c.Assert(ok, qt.IsFalse)
+
+	cache.Pinned("c", true)
+	cache.Set("d", []byte("d"))
+	c.Assert(cache.Len(), qt.Equals, 2)
+	_, ok = cache.Get("d")
+	c.Assert(ok, qt.IsFalse)
+
+	cache.Pinned("a", false)
+	cache.Set("e", []byte("e"))
+	_, ok = cache.Get("a")
+	c.Assert(ok, qt.IsFalse)
+	_, ok = cache.Get("e")
+	c.Assert(ok, qt.IsTrue)
+}
+
+func TestLRUCacheCost(t *testing.T) {
+	c := qt.New(t)
+	cache := New(Options{
+		MaxEntries: 3,
+		Cost: func(key string, value []byte) int64 {
+			if strings.HasPrefix(key, "slow") {
+				return 100 * int64(len(value))
+			}
+			return int64(len(value))
+		},
+	})
+
+	cache.Set("slow1", []byte("a"))
+	cache.Set("fast1", []byte("a"))
+	cache.Set("slow2", []byte("a"))
+	// slow1 is the least recently used, but the most expensive.
+	cache.Set("fast2", []byte("a"))
+	_, ok := cache.Get("fast1")
+	c.Assert(ok, qt.IsFalse)
+	_, ok = cache.Get("slow1")
+	c.Assert(ok, qt.IsTrue)
+
+	// Least recently used among equally expensive entries.
+	cache.Set("slow3", []byte("a"))
+	_, ok = cache.Get("fast2")
+	c.Assert(ok, qt.IsFalse)
+	cache.Set("slow4", []byte("a"))
+	_, ok = cache.Get("slow2")
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(cache.Len(), qt.Equals, 3)
+}
+
+func TestLRUCacheUpdateEntry(t *testing.T) {
+	c := qt.New(t)
+
+	var evicted []string
+	cache := New(Options{
+		MaxSize: 10,
+		OnEvict: func(key string, value []byte) {
+			evicted = append(evicted, key)
+		},
+	})
+	cache.Set("a", []byte("aaa"))
+	cache.Set("b", []byte("bbb"))
+
+	c.Assert(cache.UpdateEntry("c", func(value []byte) []byte { return []byte("c") }), qt.IsFalse)
+	c.Assert(cache.UpdateEntry("a", func(value []byte) []byte { return nil }), qt.IsFalse)
+
+	c.Assert(cache.UpdateEntry("a", func(value []byte) []byte {
+		return append([]byte("aaaaa"), value...)
+	}), qt.IsTrue)
+	v, _ := cache.Get("a")
+	c.Assert(string(v), qt.Equals, "aaaaaaaa")
+	// Over MaxSize, b is evicted.
+	c.Assert(cache.Size(), qt.Equals, int64(8))
+	c.Assert(evicted, qt.DeepEquals, []string{"b"})
+}
@@ -0,0 +1,90 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+)
+
+// HEAD and GET requests for the same URL are stored under different keys,
+// as a HEAD response has no body and cannot be served to a GET request.
+// A fresh GET response can however be served to a HEAD request, so HEAD requests
+// are first looked up under the key of the GET request for the same URL,
+// and then under their own. The response of the server to a HEAD request
+// in turn updates or invalidates the cached GET response.
+
+// withMethod returns a shallow copy of req with the method set to method.
+func withMethod(req *http.Request, method string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Method = method
+	return r
+}
+
+// getKey returns the cache key of the GET request matching the HEAD request req,
+// or "" if it is not cacheable.
+func (t *Transport) getKey(req *http.Request) string {
+	return t.cacheKey(withMethod(req, http.MethodGet))
+}
+
+// cachedGetResponseForHead returns the cached response of the GET request matching
+// the HEAD request req, if it is fresh and can be served to req.
+// The body of the response is not read.
+func (t *Transport) cachedGetResponseForHead(req *http.Request) (*http.Response, bool) {
+	key := t.getKey(req)
+	if key == "" || key == t.cacheKey(req) {
+		return nil, false
+	}
+	cachedResp, ok, err := t.cachedResponseForKey(req, key)
+	if err != nil || !ok {
+		return nil, false
+	}
+	if !t.varyMatches(cachedResp, req) || t.freshness(cachedResp, req) != fresh {
+		return nil, false
+	}
+	return cachedResp, true
+}
+
+// updateGetFromHead updates the cached response of the GET request matching the
+// HEAD request req with resp, the response of the server to req, as described
+// in RFC 9111, section 4.3.5: if their validators and Content-Length match, the
+// headers of the cached response are updated, otherwise it is invalidated.
+func (t *Transport) updateGetFromHead(req *http.Request, resp *http.Response) {
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	key := t.getKey(req)
+	if key == "" || key == t.cacheKey(req) {
+		return
+	}
+	defer t.keyLocks.lock(key)()
+	b, _ := t.cacheGet(req.Context(), key)
+	if len(b) == 0 {
+		return
+	}
+	cachedResp, err := readStoredResponse(b, nil)
+	if err != nil || !t.varyMatches(cachedResp, req) {
+		return
+	}
+	for _, header := range []string{"Etag", "Last-Modified", "Content-Length"} {
+		v, cached := resp.Header.Get(header), cachedResp.Header.Get(header)
+		if v != "" && cached != "" && v != cached {
+			// The GET response changed.
+			t.cacheDelete(req.Context(), key)
+			t.evicted(req, key)
+			return
+		}
+	}
+	for _, header := range getEndToEndHeaders(resp.Header) {
+		if !isInternalHeader(header) {
+			cachedResp.Header[header] = resp.Header[header]
+		}
+	}
+	if _, ok := t.storeMetadata(key, cachedResp); ok {
+		return
+	}
+	body, err := io.ReadAll(cachedResp.Body)
+	if err != nil {
+		return
+	}
+	t.storeEntry(req.Context(), key, cachedResp, body)
+}
@@ -0,0 +1,109 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestServeHeadFromGet(t *testing.T) {
+	resetTest()
+	s.transport.MarkCachedResponses = true
+	c := qt.New(t)
+
+	body, resp := doMethod(t, http.MethodGet, "/method", nil)
+	c.Assert(body, qt.Equals, "GET")
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "")
+
+	body, resp = doMethod(t, http.MethodHead, "/method", nil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(body, qt.Equals, "")
+	c.Assert(resp.ContentLength, qt.Equals, int64(3))
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "1")
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusHit)
+	// No HEAD entry needed.
+	c.Assert(cacheSize(), qt.Equals, 1)
+
+	// A GET response is never served to a HEAD request with different Vary headers.
+	doMethod(t, http.MethodGet, "/varyaccept", map[string]string{"Accept": "text/plain"})
+	_, resp = doMethod(t, http.MethodHead, "/varyaccept", map[string]string{"Accept": "text/html"})
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "")
+	_, resp = doMethod(t, http.MethodHead, "/varyaccept", map[string]string{"Accept": "text/plain"})
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "1")
+}
+
+func TestDontServeHeadToGet(t *testing.T) {
+	resetTest()
+	s.transport.MarkCachedResponses = true
+	c := qt.New(t)
+
+	doMethod(t, http.MethodHead, "/method", nil)
+	_, resp := doMethod(t, http.MethodHead, "/method", nil)
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "1")
+
+	body, resp := doMethod(t, http.MethodGet, "/method", nil)
+	c.Assert(body, qt.Equals, "GET")
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "")
+	c.Assert(cacheSize(), qt.Equals, 2)
+}
+
+func TestHeadUpdatesGet(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var (
+		maxAge   = "0"
+		etag     = `"v1"`
+		requests int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age="+maxAge)
+		w.Header().Set("Etag", etag)
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	client := http.Client{Transport: newMemoryCacheTransport()}
+	do := func(method string) (string, CacheStatus) {
+		req, err := http.NewRequest(method, ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	do(http.MethodGet)
+	maxAge = "3600"
+	// The stale GET response can't answer it.
+	_, status := do(http.MethodHead)
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(requests, qt.Equals, 2)
+
+	// Same validators, the GET response was freshened.
+	body, status := do(http.MethodGet)
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(body, qt.Equals, "content")
+	c.Assert(requests, qt.Equals, 2)
+
+	// Changed, the GET response is invalidated.
+	etag = `"v2"`
+	req, err := http.NewRequest(http.MethodHead, ts.URL, nil)
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Cache-Control", "no-cache")
+	resp, err := client.Do(req)
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(requests, qt.Equals, 3)
+	_, status = do(http.MethodGet)
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(requests, qt.Equals, 4)
+}
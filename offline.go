@@ -0,0 +1,23 @@
+package httpcache
+
+import "net/http"
+
+// offlineMiss returns the result of a request for an uncached response in Offline mode.
+func (t *Transport) offlineMiss(req *http.Request) (*http.Response, CacheStatus, error) {
+	if t.OfflineError != nil {
+		return nil, StatusMiss, t.OfflineError
+	}
+	if t.StrictErrors {
+		return nil, StatusMiss, ErrNoCachedResponse
+	}
+	return newGatewayTimeoutResponse(req), StatusMiss, nil
+}
+
+// onlyIfCachedMiss returns the result of a request with an only-if-cached directive
+// without a usable cached response.
+func (t *Transport) onlyIfCachedMiss(req *http.Request) (*http.Response, CacheStatus, error) {
+	if t.StrictErrors {
+		return nil, StatusMiss, ErrOnlyIfCachedMiss
+	}
+	return newGatewayTimeoutResponse(req), StatusMiss, nil
+}
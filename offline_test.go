@@ -0,0 +1,72 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestOffline(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	client := http.Client{Transport: tp}
+	get := func(ctx context.Context, path string) (*http.Response, CacheStatus, error) {
+		req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, StatusMiss, err
+		}
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return resp, status, nil
+	}
+	ctx := context.Background()
+
+	get(ctx, "/a")
+	c.Assert(requests, qt.Equals, 1)
+
+	tp.Offline = true
+	clock = &fakeClock{elapsed: time.Hour}
+	_, status, err := get(ctx, "/a")
+	c.Assert(err, qt.IsNil)
+	c.Assert(status, qt.Equals, StatusStale)
+	resp, status, err := get(ctx, "/b")
+	c.Assert(err, qt.IsNil)
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusGatewayTimeout)
+	errOffline := errors.New("offline")
+	tp.OfflineError = errOffline
+	_, _, err = get(ctx, "/b")
+	c.Assert(errors.Is(err, errOffline), qt.IsTrue)
+	_, _, err = get(WithOptions(ctx, Options{BypassCache: true}), "/a")
+	c.Assert(errors.Is(err, errOffline), qt.IsTrue)
+	c.Assert(requests, qt.Equals, 1)
+
+	// Per request.
+	tp.Offline = false
+	_, status, err = get(WithOptions(ctx, Options{Offline: true}), "/a")
+	c.Assert(err, qt.IsNil)
+	c.Assert(status, qt.Equals, StatusStale)
+	c.Assert(requests, qt.Equals, 1)
+	_, status, _ = get(ctx, "/a")
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(requests, qt.Equals, 2)
+}
@@ -0,0 +1,117 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options overrides the behavior of the Transport for a single request,
+// see WithOptions.
+type Options struct {
+	// ForceRefresh forces a revalidation of the cached response with the server,
+	// even if it is fresh.
+	ForceRefresh bool
+
+	// BypassCache sends the request to the server without looking up
+	// or storing the response in the cache.
+	BypassCache bool
+
+	// MinTTL is the minimum time, counted from their Date header, cached responses
+	// are considered fresh, regardless of what their Cache-Control and Expires headers say.
+	// Request directives such as no-cache still apply.
+	MinTTL time.Duration
+
+	// CacheKey, if set, is the key used to look up and store the response,
+	// overriding Transport.CacheKey.
+	CacheKey string
+
+	// MaxAge, if > 0, is the maximum age of a cached response acceptable for the
+	// request, as with a max-age request directive, see WithMaxAge.
+	MaxAge time.Duration
+
+	// Offline serves the cached response, regardless of its freshness, without
+	// contacting the server, see Transport.Offline.
+	Offline bool
+
+	// User, if set, identifies the user on whose behalf the request is sent,
+	// so a Cache can be shared between users while each of them sees it as
+	// a private cache: responses meant for a single user (marked private,
+	// or answering an authenticated request) are stored in a partition of
+	// the Cache reserved to the user, other responses are shared.
+	User string
+}
+
+type optionsKey struct{}
+
+// WithOptions returns a copy of ctx carrying opts.
+// Requests sent through a Transport with this context, or a context derived from it,
+// are handled according to opts.
+func WithOptions(ctx context.Context, opts Options) context.Context {
+	return context.WithValue(ctx, optionsKey{}, opts)
+}
+
+// WithMaxAge returns a copy of ctx carrying the Options of ctx, if any, with MaxAge set to d.
+// Requests sent with this context only accept cached responses not older than d;
+// if d <= 0, the cached response is always revalidated, as with ForceRefresh.
+func WithMaxAge(ctx context.Context, d time.Duration) context.Context {
+	opts, _ := ctx.Value(optionsKey{}).(Options)
+	if d <= 0 {
+		opts.ForceRefresh = true
+	} else {
+		opts.MaxAge = d
+	}
+	return WithOptions(ctx, opts)
+}
+
+// withMaxAge returns req, or a copy of it, with a max-age directive of no more
+// than maxAge in its Cache-Control header.
+func withMaxAge(req *http.Request, maxAge time.Duration) *http.Request {
+	secs := int64(maxAge / time.Second)
+	if v, ok := parseCacheControl(req.Header)["max-age"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n <= secs {
+			return req
+		}
+	}
+	directives := []string{"max-age=" + strconv.FormatInt(secs, 10)}
+	for _, v := range headerAllCommaSepValues(req.Header, "cache-control") {
+		if v != "max-age" && !strings.HasPrefix(v, "max-age=") {
+			directives = append(directives, v)
+		}
+	}
+	req2 := cloneRequest(req)
+	req2.Header.Set("Cache-Control", strings.Join(directives, ", "))
+	return req2
+}
+
+// requestOptions returns the Options set on the context of req, if any.
+func requestOptions(req *http.Request) Options {
+	opts, _ := req.Context().Value(optionsKey{}).(Options)
+	return opts
+}
+
+// applyMinTTL returns freshness adjusted for the minTTL of the request, if any,
+// the age of the response measured with clk.
+func applyMinTTL(freshness int, respHeaders http.Header, minTTL time.Duration, clk timer) int {
+	if freshness != stale || minTTL <= 0 {
+		return freshness
+	}
+	date, err := date(respHeaders)
+	if err != nil {
+		return freshness
+	}
+	if clk.since(date) < minTTL {
+		return fresh
+	}
+	return freshness
+}
+
+// microCacheWindow returns the MicroCache window applying to req, 0 if none.
+func (t *Transport) microCacheWindow(req *http.Request) time.Duration {
+	if t.MicroCache <= 0 || req.Method != http.MethodGet || req.Header.Get("Authorization") != "" {
+		return 0
+	}
+	return t.MicroCache
+}
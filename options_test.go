@@ -0,0 +1,176 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestWithOptions(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var (
+		cacheControl = "max-age=3600"
+		requests     []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("if-none-match"))
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("Etag", `"abc"`)
+		if r.Header.Get("if-none-match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	client := http.Client{Transport: tp}
+	get := func(opts Options) CacheStatus {
+		req, err := http.NewRequestWithContext(WithOptions(context.Background(), opts), "GET", ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(string(b), qt.Equals, "content")
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(get(Options{}), qt.Equals, StatusMiss)
+	c.Assert(get(Options{}), qt.Equals, StatusHit)
+
+	c.Run("ForceRefresh", func(c *qt.C) {
+		requests = nil
+		c.Assert(get(Options{ForceRefresh: true}), qt.Equals, StatusRevalidated)
+		c.Assert(requests, qt.DeepEquals, []string{`"abc"`})
+	})
+
+	c.Run("BypassCache", func(c *qt.C) {
+		requests = nil
+		c.Assert(get(Options{BypassCache: true}), qt.Equals, StatusMiss)
+		c.Assert(requests, qt.DeepEquals, []string{""})
+		c.Assert(get(Options{}), qt.Equals, StatusHit)
+	})
+
+	c.Run("CacheKey", func(c *qt.C) {
+		c.Assert(get(Options{CacheKey: "other"}), qt.Equals, StatusMiss)
+		c.Assert(get(Options{CacheKey: "other"}), qt.Equals, StatusHit)
+		_, ok := tp.Cache.Get("other")
+		c.Assert(ok, qt.IsTrue)
+	})
+
+	c.Run("MinTTL", func(c *qt.C) {
+		cacheControl = "max-age=10"
+		c.Assert(get(Options{CacheKey: "minttl"}), qt.Equals, StatusMiss)
+		clock = &fakeClock{elapsed: 30 * time.Second}
+		defer func() { clock = &realClock{} }()
+		c.Assert(get(Options{CacheKey: "minttl", MinTTL: time.Minute}), qt.Equals, StatusHit)
+		c.Assert(get(Options{CacheKey: "minttl"}), qt.Equals, StatusRevalidated)
+	})
+}
+
+func TestWithMaxAge(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Etag", `"abc"`)
+		if r.Header.Get("if-none-match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	client := http.Client{Transport: newMemoryCacheTransport()}
+	get := func(ctx context.Context) CacheStatus {
+		req, err := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(get(context.Background()), qt.Equals, StatusMiss)
+	clock = &fakeClock{elapsed: 10 * time.Minute}
+	defer func() { clock = &realClock{} }()
+	c.Assert(get(WithMaxAge(context.Background(), time.Hour)), qt.Equals, StatusHit)
+	c.Assert(get(WithMaxAge(context.Background(), 5*time.Minute)), qt.Equals, StatusRevalidated)
+	c.Assert(get(WithMaxAge(context.Background(), 0)), qt.Equals, StatusRevalidated)
+	// Other options are kept.
+	ctx := WithOptions(context.Background(), Options{BypassCache: true})
+	c.Assert(get(WithMaxAge(ctx, time.Hour)), qt.Equals, StatusMiss)
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Cache-Control", "max-age=600, no-transform")
+	c.Assert(withMaxAge(req, time.Hour), qt.Equals, req)
+	c.Assert(withMaxAge(req, time.Minute).Header.Get("Cache-Control"), qt.Equals, "max-age=60, no-transform")
+	c.Assert(req.Header.Get("Cache-Control"), qt.Equals, "max-age=600, no-transform")
+}
+
+func TestMicroCache(t *testing.T) {
+	c := qt.New(t)
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		default:
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Etag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	clk := &offsetClock{}
+	tp := &Transport{Cache: newMemoryCache(), Clock: clk, MicroCache: 5 * time.Second}
+	get := func(path string, header http.Header) CacheStatus {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		c.Assert(err, qt.IsNil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := (&http.Client{Transport: tp}).Do(req)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(get("/", nil), qt.Equals, StatusMiss)
+	c.Assert(get("/", nil), qt.Equals, StatusHit)
+	c.Assert(get("/", http.Header{"Authorization": {"Bearer x"}}), qt.Equals, StatusRevalidated)
+	c.Assert(get("/", http.Header{"Cache-Control": {"no-cache"}}), qt.Not(qt.Equals), StatusHit)
+	c.Assert(requests, qt.Equals, 3)
+
+	clk.offset = 10 * time.Second
+	c.Assert(get("/", nil), qt.Equals, StatusRevalidated)
+
+	get("/no-store", nil)
+	get("/no-store", nil)
+	c.Assert(requests, qt.Equals, 6)
+}
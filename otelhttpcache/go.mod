@@ -0,0 +1,27 @@
+module github.com/gohugoio/httpcache/otelhttpcache
+
+go 1.20
+
+require (
+	github.com/frankban/quicktest v1.14.6
+	github.com/gohugoio/httpcache v0.7.0
+	go.opentelemetry.io/otel v1.19.0
+	go.opentelemetry.io/otel/sdk v1.19.0
+	go.opentelemetry.io/otel/trace v1.19.0
+)
+
+require (
+	github.com/go-logr/logr v1.2.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/go-cmp v0.5.9 // indirect
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/rogpeppe/go-internal v1.9.0 // indirect
+	go.opentelemetry.io/otel/metric v1.19.0 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+)
+
+// For local development, build against the httpcache of this repository.
+// The requirement above is the latest release, to be raised to the release
+// adding the APIs used here once it's tagged.
+replace github.com/gohugoio/httpcache => ../
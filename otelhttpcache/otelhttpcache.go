@@ -0,0 +1,120 @@
+// Package otelhttpcache instruments an httpcache.Transport with OpenTelemetry
+// spans, so the remote fetches dominating e.g. a build can be told apart from
+// the requests served from the cache.
+//
+// It lives in its own module, so httpcache itself doesn't depend on OpenTelemetry.
+package otelhttpcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gohugoio/httpcache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer used by default.
+const instrumentationName = "github.com/gohugoio/httpcache/otelhttpcache"
+
+// Transport is a http.RoundTripper sending requests through an httpcache.Transport
+// within a span, carrying the cache status and key of the request, with an event
+// for each caching decision: the lookup, the freshness of the cached response,
+// its revalidation and the store decision.
+//
+// The span ends when the response body is closed, as the response is stored
+// once its body has been read.
+type Transport struct {
+	// Transport is the instrumented Transport.
+	Transport *httpcache.Transport
+
+	// Tracer is the tracer creating the spans.
+	// If nil, the tracer of the global TracerProvider is used.
+	Tracer trace.Tracer
+}
+
+// NewTransport returns a new Transport instrumenting t with the global TracerProvider.
+func NewTransport(t *httpcache.Transport) *Transport {
+	return &Transport{Transport: t}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tracer := t.Tracer
+	if tracer == nil {
+		tracer = otel.Tracer(instrumentationName)
+	}
+	ctx, span := tracer.Start(req.Context(), "httpcache "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+		),
+	)
+	ctx = httpcache.WithTrace(ctx, httpcache.LoggerFunc(func(msg string, args ...any) {
+		span.AddEvent(strings.TrimPrefix(msg, "httpcache: "), trace.WithAttributes(attributes(args)...))
+	}))
+
+	resp, result, err := t.Transport.RoundTripWithResult(req.WithContext(ctx))
+	span.SetAttributes(
+		attribute.String("httpcache.status", result.Status.String()),
+		attribute.String("httpcache.key", result.Key),
+		attribute.Int64("httpcache.cache_time_us", result.CacheTime.Microseconds()),
+		attribute.Int64("httpcache.origin_time_us", result.OriginTime.Microseconds()),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", resp.StatusCode),
+		attribute.Int64("httpcache.age_s", int64(result.Age.Seconds())),
+	)
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// spanBody ends its span when closed.
+type spanBody struct {
+	io.ReadCloser
+	span trace.Span
+	once sync.Once
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.span.End() })
+	return err
+}
+
+// attributes converts the alternating keys and values args to attributes.
+func attributes(args []any) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		switch v := args[i+1].(type) {
+		case string:
+			attrs = append(attrs, attribute.String(key, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(key, v))
+		case int:
+			attrs = append(attrs, attribute.Int(key, v))
+		case int64:
+			attrs = append(attrs, attribute.Int64(key, v))
+		case nil:
+		default:
+			attrs = append(attrs, attribute.String(key, fmt.Sprint(v)))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,99 @@
+package otelhttpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/gohugoio/httpcache"
+	"github.com/gohugoio/httpcache/lrucache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTransport(t *testing.T) {
+	c := qt.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := &Transport{
+		Transport: lrucache.NewTransport(lrucache.Options{}),
+		Tracer:    sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test"),
+	}
+	client := http.Client{Transport: tp}
+	get := func() {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(b), qt.Equals, "content")
+		// The span ends when the body is closed.
+		c.Assert(recorder.Ended(), qt.HasLen, len(recorder.Started())-1)
+		resp.Body.Close()
+	}
+
+	get()
+	get()
+	spans := recorder.Ended()
+	c.Assert(spans, qt.HasLen, 2)
+	for i, want := range []string{"miss", "hit"} {
+		span := spans[i]
+		c.Assert(span.Name(), qt.Equals, "httpcache GET")
+		attrs := attributeMap(span.Attributes())
+		c.Assert(attrs["httpcache.status"], qt.Equals, want)
+		c.Assert(attrs["httpcache.key"], qt.Equals, ts.URL)
+		c.Assert(attrs["http.response.status_code"], qt.Equals, "200")
+		c.Assert(span.Events(), qt.Not(qt.HasLen), 0)
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	c := qt.New(t)
+	errOrigin := errors.New("origin down")
+	recorder := tracetest.NewSpanRecorder()
+	tp := &Transport{
+		Transport: &httpcache.Transport{
+			Cache:     lrucache.New(lrucache.Options{}),
+			Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) { return nil, errOrigin }),
+		},
+		Tracer: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test"),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	c.Assert(err, qt.IsNil)
+	_, err = tp.RoundTrip(req)
+	c.Assert(errors.Is(err, errOrigin), qt.IsTrue)
+	spans := recorder.Ended()
+	c.Assert(spans, qt.HasLen, 1)
+	c.Assert(spans[0].Status().Code, qt.Equals, codes.Error)
+	c.Assert(spans[0].Status().Description, qt.Equals, "origin down")
+}
+
+func TestAttributes(t *testing.T) {
+	c := qt.New(t)
+	attrs := attributeMap(attributes([]any{"key", "k", "fresh", true, "n", 3, "size", int64(4), "nil", nil, "err", errors.New("e"), "odd"}))
+	c.Assert(attrs, qt.DeepEquals, map[string]string{"key": "k", "fresh": "true", "n": "3", "size": "4", "err": "e"})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func attributeMap(kvs []attribute.KeyValue) map[string]string {
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		m[string(kv.Key)] = kv.Value.Emit()
+	}
+	return m
+}
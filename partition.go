@@ -0,0 +1,69 @@
+package httpcache
+
+import (
+	"net/http"
+)
+
+// When the Options of a request have a User set, the Transport keeps a partition
+// of the cache for that user: responses only meant for the user (see isPrivateResponse)
+// are stored in it, while other responses are stored once for all users.
+// Lookups try the user's partition first.
+
+// partitionKey returns the key of the entry for key in the partition of user.
+func partitionKey(key, user string) string {
+	return key + " user=" + user
+}
+
+// isPrivateResponse reports whether resp, the response to req,
+// must only be served to the user who sent req.
+func isPrivateResponse(req *http.Request, resp *http.Response) bool {
+	respCacheControl := parseCacheControl(resp.Header)
+	if respCacheControl.unqualified("private") {
+		// With header names, only these headers are private, see strippedHeaders.
+		return true
+	}
+	return req.Header.Get("Authorization") != "" && !allowsAuthorizedSharing(respCacheControl)
+}
+
+// allowsAuthorizedSharing reports whether a response with respCacheControl to
+// an authenticated request may be shared with other users, see RFC 9111 section 3.5.
+func allowsAuthorizedSharing(respCacheControl cacheControl) bool {
+	for _, directive := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := respCacheControl[directive]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupPartitioned looks up the cached response for req, stored for key,
+// first in the partition of user, if set.
+// It returns the key the response was found under.
+func (t *Transport) lookupPartitioned(req *http.Request, key, user string) (string, *http.Response, bool, error) {
+	if user != "" {
+		userKey := partitionKey(key, user)
+		cachedResp, ok, err := t.cachedResponseForKey(req, userKey)
+		if err != nil || cachedResp != nil {
+			return userKey, cachedResp, ok, err
+		}
+	}
+	cachedResp, ok, err := t.cachedResponseForKey(req, key)
+	return key, cachedResp, ok, err
+}
+
+// storeKey returns the key to store resp, the response to req, under,
+// given the key it was looked up under and the shared key for req,
+// and the key of a stale entry to remove, if any.
+func storeKey(req *http.Request, resp *http.Response, lookupKey, sharedKey, user string) (key, obsolete string) {
+	if user == "" {
+		return lookupKey, ""
+	}
+	if isPrivateResponse(req, resp) {
+		return partitionKey(sharedKey, user), ""
+	}
+	if lookupKey != sharedKey {
+		// No longer private.
+		return sharedKey, lookupKey
+	}
+	return sharedKey, ""
+}
@@ -0,0 +1,87 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestUserPartitions(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=3600")
+		case "/auth":
+			w.Header().Set("Cache-Control", "max-age=3600")
+		case "/auth-public":
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+		default:
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
+		w.Write([]byte(r.Header.Get("X-User")))
+	}))
+	defer ts.Close()
+
+	cache := newMemoryCache()
+	client := http.Client{Transport: &Transport{Cache: cache}}
+	get := func(user, path string, auth bool) (string, CacheStatus) {
+		ctx := WithOptions(context.Background(), Options{User: user})
+		req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+path, nil)
+		c.Assert(err, qt.IsNil)
+		req.Header.Set("X-User", user)
+		if auth {
+			req.Header.Set("Authorization", "Bearer "+user)
+		}
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	for _, test := range []struct {
+		path   string
+		auth   bool
+		shared bool
+	}{
+		{"/public", false, true},
+		{"/private", false, false},
+		{"/auth", true, false},
+		{"/auth-public", true, true},
+	} {
+		c.Run(test.path, func(c *qt.C) {
+			body, status := get("alice", test.path, test.auth)
+			c.Assert(body, qt.Equals, "alice")
+			c.Assert(status, qt.Equals, StatusMiss)
+
+			body, status = get("alice", test.path, test.auth)
+			c.Assert(body, qt.Equals, "alice")
+			c.Assert(status, qt.Equals, StatusHit)
+
+			body, status = get("bob", test.path, test.auth)
+			if test.shared {
+				c.Assert(body, qt.Equals, "alice")
+				c.Assert(status, qt.Equals, StatusHit)
+			} else {
+				c.Assert(body, qt.Equals, "bob")
+				c.Assert(status, qt.Equals, StatusMiss)
+			}
+		})
+	}
+
+	// Public responses are stored once.
+	_, found := cache.Get(partitionKey(ts.URL+"/public", "alice"))
+	c.Assert(found, qt.IsFalse)
+	_, found = cache.Get(partitionKey(ts.URL+"/private", "bob"))
+	c.Assert(found, qt.IsTrue)
+	c.Assert(cache.Size(), qt.Equals, 6)
+}
@@ -0,0 +1,378 @@
+// Package peercache provides an httpcache.Cache shared by a fleet of nodes,
+// e.g. build machines, each owning a part of the keys, so a response fetched
+// by one of them is served from the cache to all the others.
+//
+// Every node runs a Pool, with the same list of peers, and serves its part of
+// the cache to the other nodes by mounting the Pool as an http.Handler at BasePath.
+// The owner of a key is picked by consistent hashing, see httpcache.ShardedCache:
+// reads and writes for a key go to its owner.
+//
+// Used as the Cache of a Transport, the Pool only shares the storage: on a miss,
+// the Transport of every node fetches the response from the server and stores
+// it on the owner. Used as the http.RoundTripper of the clients, with the Transport
+// of the node set in Options, the Pool sends the GET requests to the owner of their
+// URL, which gets the response with its Transport, from its cache or from the
+// server, the concurrent requests for a URL waiting for the first one: the server
+// is then asked once for the whole fleet.
+//
+// The handler lets anyone reaching it read and write the cache, and get any URL
+// through the node, so it must only be exposed on a trusted network.
+package peercache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gohugoio/httpcache"
+)
+
+// BasePath is the path the Pool handler is mounted at on every node.
+const BasePath = "/_httpcache/"
+
+// Options configures a Pool.
+type Options struct {
+	// Self is the base URL of this node, e.g. "http://10.0.0.1:8080".
+	// It must be one of Peers.
+	Self string
+
+	// Peers are the base URLs of all the nodes, including Self.
+	// All nodes must use the same peers, in any order.
+	Peers []string
+
+	// Local is the Cache storing the part of the keys owned by this node.
+	Local httpcache.Cache
+
+	// Client is the client used to reach the other nodes.
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Transport, if set, gets the responses to the requests of RoundTrip for the
+	// URLs owned by this node, also on behalf of the other nodes. It's typically
+	// an httpcache.Transport with the Pool as its Cache.
+	Transport http.RoundTripper
+}
+
+// Pool is an httpcache.Cache spreading the keys across a set of peers.
+// It's also the http.Handler serving the keys owned by this node to the others,
+// and an http.RoundTripper getting the responses from the owner of their URL.
+type Pool struct {
+	*httpcache.ShardedCache
+	local     httpcache.CacheContext
+	transport http.RoundTripper
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// New returns a new Pool with the given options.
+func New(opts Options) (*Pool, error) {
+	if opts.Local == nil {
+		return nil, errors.New("peercache: no local cache")
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	self := strings.TrimSuffix(opts.Self, "/")
+	peers := make([]string, len(opts.Peers))
+	for i, peer := range opts.Peers {
+		peers[i] = strings.TrimSuffix(peer, "/")
+	}
+	var (
+		backends = make(map[string]httpcache.Cache, len(peers))
+		found    bool
+	)
+	for _, peer := range peers {
+		// Named after the peer, so the ring is the same on all nodes.
+		if peer == self {
+			backends[peer] = opts.Local
+			found = true
+			continue
+		}
+		backends[peer] = &peerCache{baseURL: peer + BasePath, client: client}
+	}
+	if !found {
+		return nil, fmt.Errorf("peercache: self %q is not one of the peers", opts.Self)
+	}
+	return &Pool{
+		ShardedCache: httpcache.NewShardedCache(backends),
+		local:        httpcache.AdaptCache(opts.Local),
+		transport:    opts.Transport,
+		flights:      make(map[string]*flight),
+	}, nil
+}
+
+// RoundTrip implements http.RoundTripper. A GET request is sent to the owner of its
+// URL, which gets the response with the Transport set in Options, only once for the
+// concurrent requests for the URL with the same header. Other requests are sent with
+// the Transport of this node.
+func (p *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	if p.transport == nil {
+		return nil, errors.New("peercache: no Transport")
+	}
+	if req.Method != http.MethodGet {
+		return p.transport.RoundTrip(req)
+	}
+	if owner, ok := p.Backend(req.URL.String()).(*peerCache); ok {
+		return owner.fill(req)
+	}
+	return p.fill(req)
+}
+
+// flight is the request for a URL getting the response shared by the concurrent
+// requests for it.
+type flight struct {
+	done chan struct{}
+	resp *http.Response
+	body []byte
+	err  error
+}
+
+// response returns a copy of the response of f for req.
+func (f *flight) response(req *http.Request) (*http.Response, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := *f.resp
+	resp.Header = f.resp.Header.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(f.body))
+	resp.Request = req
+	return &resp, nil
+}
+
+// fill gets the response to req with the Transport of this node, or waits for
+// the response to the same request if it's already being fetched.
+func (p *Pool) fill(req *http.Request) (*http.Response, error) {
+	var key strings.Builder
+	key.WriteString(req.URL.String() + "\n")
+	req.Header.Write(&key)
+
+	p.mu.Lock()
+	if f, ok := p.flights[key.String()]; ok {
+		p.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.response(req)
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	p.flights[key.String()] = f
+	p.mu.Unlock()
+
+	f.resp, f.err = p.transport.RoundTrip(req)
+	if f.err == nil {
+		f.body, f.err = io.ReadAll(f.resp.Body)
+		f.resp.Body.Close()
+	}
+	p.mu.Lock()
+	delete(p.flights, key.String())
+	p.mu.Unlock()
+	close(f.done)
+	return f.response(req)
+}
+
+// fillErrorHeader marks the responses of the handler to the requests of fill
+// that failed, as opposed to the error responses of the servers.
+const fillErrorHeader = "X-Peercache-Error"
+
+// serveFill serves the response to the GET request for rawURL with the header
+// in the body of r, see RoundTrip.
+func (p *Pool) serveFill(w http.ResponseWriter, r *http.Request, rawURL string) {
+	fail := func(err error, code int) {
+		w.Header().Set(fillErrorHeader, "1")
+		http.Error(w, err.Error(), code)
+	}
+	if p.transport == nil {
+		fail(errors.New("peercache: no Transport"), http.StatusNotImplemented)
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, rawURL, nil)
+	if err != nil {
+		fail(err, http.StatusBadRequest)
+		return
+	}
+	header, err := textproto.NewReader(bufio.NewReader(r.Body)).ReadMIMEHeader()
+	if err != nil {
+		fail(err, http.StatusBadRequest)
+		return
+	}
+	req.Header = http.Header(header)
+	resp, err := p.fill(req)
+	if err != nil {
+		fail(err, http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, vv := range resp.Header {
+		w.Header()[k] = vv
+	}
+	// The body may have been decoded.
+	w.Header().Del("Content-Length")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// ServeHTTP serves the local cache to the other nodes:
+// GET returns the value stored for the key in the key query parameter,
+// PUT stores it and DELETE removes it. A POST with the fill query parameter
+// returns the response to a GET request for its URL, see RoundTrip.
+func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != BasePath {
+		http.NotFound(w, r)
+		return
+	}
+	if u := r.URL.Query().Get("fill"); u != "" && r.Method == http.MethodPost {
+		p.serveFill(w, r, u)
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		b, ok, err := p.local.GetContext(ctx, key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(b)
+	case http.MethodPut:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.local.SetContext(ctx, key, b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := p.local.DeleteContext(ctx, key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// peerCache is a httpcache.Cache stored on another node.
+type peerCache struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (c *peerCache) Get(key string) ([]byte, bool) {
+	return httpcache.FromCacheContext(c).Get(key)
+}
+
+func (c *peerCache) Set(key string, b []byte) {
+	httpcache.FromCacheContext(c).Set(key, b)
+}
+
+func (c *peerCache) Delete(key string) {
+	httpcache.FromCacheContext(c).Delete(key)
+}
+
+// fill gets the response to the GET request req from the peer, see Pool.RoundTrip.
+func (c *peerCache) fill(req *http.Request) (*http.Response, error) {
+	// The header is sent in the body, apart from that of the request to the peer.
+	var header bytes.Buffer
+	req.Header.Write(&header)
+	header.WriteString("\r\n")
+	preq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, c.baseURL+"?fill="+url.QueryEscape(req.URL.String()), &header)
+	if err != nil {
+		return nil, err
+	}
+	// The redirects are for the client of the Pool to follow.
+	client := *c.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Do(preq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get(fillErrorHeader) != "" {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("peercache: fill %s: %s", c.baseURL, strings.TrimSpace(string(b)))
+	}
+	resp.Request = req
+	return resp, nil
+}
+
+func (c *peerCache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+func (c *peerCache) SetContext(ctx context.Context, key string, b []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, key, b)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *peerCache) DeleteContext(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a request for key to the peer, returning an error for unexpected statuses.
+func (c *peerCache) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"?key="+url.QueryEscape(key), r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("peercache: %s %s: %s", method, c.baseURL, resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,174 @@
+package peercache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/gohugoio/httpcache"
+	"github.com/gohugoio/httpcache/lrucache"
+)
+
+var _ httpcache.CacheContext = (*Pool)(nil)
+
+func TestPool(t *testing.T) {
+	c := qt.New(t)
+	var requests atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("hello " + r.URL.Path))
+	}))
+	defer origin.Close()
+
+	// Start the nodes first, their URLs are needed to create the pools.
+	const n = 3
+	var (
+		mu      sync.Mutex
+		pools   = make([]*Pool, n)
+		locals  = make([]*lrucache.Cache, n)
+		servers = make([]*httptest.Server, n)
+		peers   []string
+	)
+	for i := 0; i < n; i++ {
+		i := i
+		mux := http.NewServeMux()
+		mux.Handle(BasePath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			pool := pools[i]
+			mu.Unlock()
+			pool.ServeHTTP(w, r)
+		}))
+		servers[i] = httptest.NewServer(mux)
+		defer servers[i].Close()
+		peers = append(peers, servers[i].URL)
+	}
+	for i := 0; i < n; i++ {
+		locals[i] = lrucache.New(lrucache.Options{})
+		pool, err := New(Options{Self: servers[i].URL, Peers: peers, Local: locals[i]})
+		c.Assert(err, qt.IsNil)
+		mu.Lock()
+		pools[i] = pool
+		mu.Unlock()
+	}
+
+	get := func(node int, path string) httpcache.CacheStatus {
+		client := http.Client{Transport: &httpcache.Transport{Cache: pools[node]}}
+		resp, err := client.Get(origin.URL + path)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(string(b), qt.Equals, "hello "+path)
+		status, _ := httpcache.ResponseCacheStatus(resp)
+		return status
+	}
+
+	paths := []string{"/a", "/b", "/c", "/d", "/e", "/f"}
+	for _, path := range paths {
+		c.Assert(get(0, path), qt.Equals, httpcache.StatusMiss)
+	}
+	// Served to the other nodes from the cache.
+	for node := 1; node < n; node++ {
+		for _, path := range paths {
+			c.Assert(get(node, path), qt.Equals, httpcache.StatusHit)
+		}
+	}
+	c.Assert(requests.Load(), qt.Equals, int64(len(paths)))
+
+	// Stored once, on the owner.
+	var total int
+	for _, local := range locals {
+		total += local.Len()
+	}
+	c.Assert(total, qt.Equals, len(paths))
+
+	pools[1].Delete(origin.URL + "/a")
+	c.Assert(get(2, "/a"), qt.Equals, httpcache.StatusMiss)
+
+	_, err := New(Options{Self: "http://unknown", Peers: peers, Local: locals[0]})
+	c.Assert(err, qt.ErrorMatches, `peercache: self "http://unknown" is not one of the peers`)
+}
+
+func TestPoolFill(t *testing.T) {
+	c := qt.New(t)
+	var requests atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// Long enough for the requests of all the nodes to wait for this one.
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("hello " + r.URL.Path))
+	}))
+	defer origin.Close()
+
+	const n = 3
+	var (
+		mu      sync.Mutex
+		pools   = make([]*Pool, n)
+		servers = make([]*httptest.Server, n)
+		peers   []string
+	)
+	for i := 0; i < n; i++ {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			pool := pools[i]
+			mu.Unlock()
+			pool.ServeHTTP(w, r)
+		}))
+		defer servers[i].Close()
+		peers = append(peers, servers[i].URL)
+	}
+	for i := 0; i < n; i++ {
+		tp := &httpcache.Transport{}
+		pool, err := New(Options{Self: servers[i].URL, Peers: peers, Local: lrucache.New(lrucache.Options{}), Transport: tp})
+		c.Assert(err, qt.IsNil)
+		tp.Cache = pool
+		mu.Lock()
+		pools[i] = pool
+		mu.Unlock()
+	}
+
+	paths := []string{"/a", "/b", "/c", "/d", "/e", "/f"}
+	var wg sync.WaitGroup
+	for node := 0; node < n; node++ {
+		for _, path := range paths {
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func(node int, path string) {
+					defer wg.Done()
+					client := http.Client{Transport: pools[node]}
+					resp, err := client.Get(origin.URL + path)
+					c.Check(err, qt.IsNil)
+					if err != nil {
+						return
+					}
+					b, err := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					c.Check(err, qt.IsNil)
+					c.Check(string(b), qt.Equals, "hello "+path)
+				}(node, path)
+			}
+		}
+	}
+	wg.Wait()
+	// Fetched once for the whole fleet.
+	c.Assert(requests.Load(), qt.Equals, int64(len(paths)))
+
+	// Then served from the cache of the owner.
+	client := http.Client{Transport: pools[1]}
+	resp, err := client.Get(origin.URL + "/a")
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(requests.Load(), qt.Equals, int64(len(paths)))
+
+	// Failures of the owner are errors.
+	_, err = client.Get("http://127.0.0.1:0/unreachable")
+	c.Assert(err, qt.Not(qt.IsNil))
+}
@@ -0,0 +1,66 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// xPinned is the header set on cached responses pinned with Transport.Pin.
+const xPinned = "X-Pinned"
+
+// PinObserver is an optional interface implemented by Cache backends with an
+// eviction policy, so they never evict the entries pinned with Transport.Pin.
+type PinObserver interface {
+	// Pinned is called when the cached response for key is pinned or unpinned.
+	Pinned(key string, pinned bool)
+}
+
+// Pin pins the response cached for key, e.g. a vendored remote asset that must
+// stay byte-identical across builds: it is always considered fresh, whatever its
+// Cache-Control and Expires headers and the directives of the requests, so it is
+// never revalidated nor replaced, and neither GC nor the eviction policy of a Cache
+// implementing PinObserver remove it, until it is unpinned with Unpin.
+// Invalidate and PurgePrefix still remove it.
+func (t *Transport) Pin(key string) error {
+	return t.setPinned(key, true)
+}
+
+// Unpin unpins the response cached for key, pinned with Pin.
+func (t *Transport) Unpin(key string) error {
+	return t.setPinned(key, false)
+}
+
+func (t *Transport) setPinned(key string, pinned bool) error {
+	ctx := context.Background()
+	defer t.keyLocks.lock(key)()
+	b, _ := t.cacheGet(ctx, key)
+	if len(b) == 0 {
+		return ErrNotCached
+	}
+	cachedResp, err := readStoredResponse(b, nil)
+	if err != nil {
+		return err
+	}
+	if pinned {
+		cachedResp.Header.Set(xPinned, "1")
+	} else {
+		cachedResp.Header.Del(xPinned)
+	}
+	if _, ok := t.storeMetadata(key, cachedResp); !ok {
+		body, err := io.ReadAll(cachedResp.Body)
+		if err != nil {
+			return err
+		}
+		t.storeEntry(ctx, key, cachedResp, body)
+	}
+	if o, ok := t.Cache.(PinObserver); ok {
+		o.Pinned(key, pinned)
+	}
+	return nil
+}
+
+// isPinned reports whether the cached response with the headers respHeaders was pinned with Pin.
+func isPinned(respHeaders http.Header) bool {
+	return respHeaders.Get(xPinned) != ""
+}
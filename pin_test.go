@@ -0,0 +1,85 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestPin(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	client := http.Client{Transport: tp}
+	get := func(opts Options) CacheStatus {
+		req, err := http.NewRequestWithContext(WithOptions(context.Background(), opts), "GET", ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(tp.Pin(ts.URL), qt.Equals, ErrNotCached)
+	c.Assert(get(Options{}), qt.Equals, StatusMiss)
+	c.Assert(tp.Pin(ts.URL), qt.IsNil)
+	info, _ := tp.EntryInfo(ts.URL)
+	c.Assert(info.Pinned, qt.IsTrue)
+
+	clock = &fakeClock{elapsed: 24 * time.Hour}
+	defer func() { clock = &realClock{} }()
+	c.Assert(get(Options{}), qt.Equals, StatusHit)
+	c.Assert(get(Options{ForceRefresh: true}), qt.Equals, StatusHit)
+	c.Assert(requests, qt.Equals, 1)
+
+	report, err := tp.GC(context.Background())
+	c.Assert(err, qt.IsNil)
+	c.Assert(report.Deleted, qt.Equals, 0)
+
+	c.Assert(tp.Unpin(ts.URL), qt.IsNil)
+	c.Assert(get(Options{}), qt.Equals, StatusMiss)
+	c.Assert(requests, qt.Equals, 2)
+}
+
+func TestPinFromServerIgnored(t *testing.T) {
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set(xPinned, "1")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		resp, err := tp.RoundTrip(req)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		c.Assert(resp.Header.Get(xPinned), qt.Equals, "")
+	}
+	c.Assert(requests, qt.Equals, 3)
+	info, _ := tp.EntryInfo(ts.URL)
+	c.Assert(info.Pinned, qt.IsFalse)
+}
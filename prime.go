@@ -0,0 +1,200 @@
+package httpcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// PrimeOptions configures Transport.Prime.
+type PrimeOptions struct {
+	// Hosts, if set, limits priming to URLs with one of these hosts.
+	Hosts []string
+
+	// Limit is the maximum number of URLs fetched.
+	// If 0, there is no limit.
+	Limit int
+
+	// Concurrency is the maximum number of requests in flight.
+	// Default is 4.
+	Concurrency int
+}
+
+// PrimeReport is the result of a Transport.Prime.
+type PrimeReport struct {
+	// URLs is the number of URLs selected for priming.
+	URLs int
+
+	// Fetched is the number of responses fetched from the server.
+	Fetched int
+
+	// Cached is the number of URLs already cached, fresh or revalidated.
+	Cached int
+
+	// Errors is the number of requests that failed or got an error response.
+	Errors int
+}
+
+// ReadURLs reads the URLs listed in r, either a sitemap (or sitemap index)
+// in XML format, or a newline-delimited list of URLs where empty lines and
+// lines starting with # are ignored.
+func ReadURLs(r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if !isSpace(b[0]) {
+			if b[0] == '<' {
+				return readSitemap(br)
+			}
+			break
+		}
+		br.ReadByte()
+	}
+
+	var urls []string
+	sc := bufio.NewScanner(br)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, sc.Err()
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
+
+// readSitemap reads the <loc> elements of a sitemap or sitemap index.
+func readSitemap(r io.Reader) ([]string, error) {
+	var urls []string
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return urls, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "loc" {
+			var loc string
+			if err := dec.DecodeElement(&loc, &se); err != nil {
+				return nil, err
+			}
+			if loc = strings.TrimSpace(loc); loc != "" {
+				urls = append(urls, loc)
+			}
+		}
+	}
+}
+
+// PrimeFrom reads the URLs listed in r, see ReadURLs, and primes the cache with them,
+// see Prime.
+func (t *Transport) PrimeFrom(ctx context.Context, r io.Reader, opts PrimeOptions) (PrimeReport, error) {
+	urls, err := ReadURLs(r)
+	if err != nil {
+		return PrimeReport{}, err
+	}
+	return t.Prime(ctx, urls, opts)
+}
+
+// Prime fills the cache by sending GET requests for urls through the Transport,
+// e.g. before a build, so later requests are served from the cache.
+// URLs already cached and fresh are not fetched again.
+func (t *Transport) Prime(ctx context.Context, urls []string, opts PrimeOptions) (PrimeReport, error) {
+	var report PrimeReport
+
+	hosts := make(map[string]bool)
+	for _, host := range opts.Hosts {
+		hosts[strings.ToLower(host)] = true
+	}
+	var selected []string
+	for _, u := range urls {
+		if opts.Limit > 0 && len(selected) >= opts.Limit {
+			break
+		}
+		if len(hosts) > 0 {
+			pu, err := url.Parse(u)
+			if err != nil || !hosts[strings.ToLower(pu.Hostname())] {
+				continue
+			}
+		}
+		selected = append(selected, u)
+	}
+	report.URLs = len(selected)
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+
+	for _, u := range selected {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(u string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			status, err := t.prime(ctx, u)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				report.Errors++
+			case status == StatusMiss:
+				report.Fetched++
+			default:
+				report.Cached++
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	return report, ctx.Err()
+}
+
+func (t *Transport) prime(ctx context.Context, u string) (CacheStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return StatusMiss, err
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return StatusMiss, err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return StatusMiss, err
+	}
+	if resp.StatusCode >= 400 {
+		return StatusMiss, fmt.Errorf("httpcache: priming %s failed: %s", u, resp.Status)
+	}
+	status, _ := ResponseCacheStatus(resp)
+	return status, nil
+}
@@ -0,0 +1,61 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestReadURLs(t *testing.T) {
+	c := qt.New(t)
+
+	urls, err := ReadURLs(strings.NewReader(`
+<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/</loc><lastmod>2024-01-01</lastmod></url>
+  <url><loc> https://example.com/about/ </loc></url>
+</urlset>`))
+	c.Assert(err, qt.IsNil)
+	c.Assert(urls, qt.DeepEquals, []string{"https://example.com/", "https://example.com/about/"})
+
+	urls, err = ReadURLs(strings.NewReader("# comment\nhttps://example.com/a\n\n  https://example.com/b  \n"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(urls, qt.DeepEquals, []string{"https://example.com/a", "https://example.com/b"})
+
+	urls, err = ReadURLs(strings.NewReader(""))
+	c.Assert(err, qt.IsNil)
+	c.Assert(urls, qt.HasLen, 0)
+
+	_, err = ReadURLs(strings.NewReader("<urlset><url><loc>"))
+	c.Assert(err, qt.Not(qt.IsNil))
+}
+
+func TestPrime(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	ctx := context.Background()
+	list := strings.Join([]string{ts.URL + "/a", ts.URL + "/b", ts.URL + "/missing", "http://example.com/c", ts.URL + "/d"}, "\n")
+
+	report, err := tp.PrimeFrom(ctx, strings.NewReader(list), PrimeOptions{Hosts: []string{"127.0.0.1"}, Limit: 3})
+	c.Assert(err, qt.IsNil)
+	c.Assert(report, qt.Equals, PrimeReport{URLs: 3, Fetched: 2, Errors: 1})
+
+	report, err = tp.PrimeFrom(ctx, strings.NewReader(list), PrimeOptions{Hosts: []string{"127.0.0.1"}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(report, qt.Equals, PrimeReport{URLs: 4, Fetched: 1, Cached: 2, Errors: 1})
+}
@@ -0,0 +1,184 @@
+package httpcache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errUnsatisfiableRange is returned by parseRange when the range does not
+// overlap the content.
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// byteRange is a byte range of length length starting at start.
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header value with a single byte range
+// (e.g. "bytes=0-499", "bytes=500-", "bytes=-500") for content of the given size.
+// It returns ok set to false if the header can't be served as a single range,
+// in which case the full content should be served.
+func parseRange(s string, size int64) (r byteRange, ok bool, err error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return r, false, nil
+	}
+	spec := strings.TrimSpace(s[len(b):])
+	if strings.Contains(spec, ",") {
+		// Multiple ranges.
+		return r, false, nil
+	}
+	i := strings.IndexByte(spec, '-')
+	if i < 0 {
+		return r, false, nil
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		// Suffix range, the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return r, false, nil
+		}
+		if n == 0 {
+			return r, true, errUnsatisfiableRange
+		}
+		if n > size {
+			n = size
+		}
+		return byteRange{start: size - n, length: n}, true, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return r, false, nil
+	}
+	if start >= size {
+		return r, true, errUnsatisfiableRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return r, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return byteRange{start: start, length: end - start + 1}, true, nil
+}
+
+// ifRangeMatches reports whether the If-Range precondition in req, if any,
+// matches the validators of resp.
+func ifRangeMatches(req *http.Request, resp *http.Response) bool {
+	ir := req.Header.Get("if-range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, `W/"`) {
+		etag := resp.Header.Get("etag")
+		// A strong comparison is required.
+		return etag != "" && !strings.HasPrefix(etag, "W/") && etag == ir
+	}
+	return ir == resp.Header.Get("last-modified")
+}
+
+// rangeResponse returns a response to the Range request req built from the
+// full cached response resp.
+// If the range can't be served as a single range, the full response is returned.
+func rangeResponse(req *http.Request, resp *http.Response) (*http.Response, error) {
+	body, ok := resp.Body.(entryBody)
+	if !ok {
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = entryBody{bytes.NewReader(b)}
+		resp.Body = body
+	}
+
+	if resp.StatusCode != http.StatusOK || !ifRangeMatches(req, resp) {
+		return resp, nil
+	}
+
+	size := body.Size()
+	r, ok, err := parseRange(req.Header.Get("range"), size)
+	if !ok {
+		return resp, nil
+	}
+
+	resp2 := *resp
+	resp2.Header = resp.Header.Clone()
+
+	if err != nil {
+		resp2.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		resp2.Status = "416 " + http.StatusText(http.StatusRequestedRangeNotSatisfiable)
+		resp2.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		resp2.Header.Set("Content-Length", "0")
+		resp2.ContentLength = 0
+		resp2.Body = http.NoBody
+		return &resp2, nil
+	}
+
+	resp2.StatusCode = http.StatusPartialContent
+	resp2.Status = "206 " + http.StatusText(http.StatusPartialContent)
+	resp2.Header.Set("Content-Range", r.contentRange(size))
+	resp2.Header.Set("Content-Length", strconv.FormatInt(r.length, 10))
+	resp2.ContentLength = r.length
+	resp2.Body = io.NopCloser(io.NewSectionReader(body, r.start, r.length))
+	return &resp2, nil
+}
+
+// cachedRangeResponse serves the only-if-cached Range request req from the
+// cached full response, without contacting the server.
+// If there is no usable cached response, a 504 response is returned, see onlyIfCachedMiss.
+func (t *Transport) cachedRangeResponse(req *http.Request, user string) (*http.Response, CacheStatus, error) {
+	resp, ok, err := t.rangeFromFullResponse(req, user)
+	if !ok {
+		return t.onlyIfCachedMiss(req)
+	}
+	return resp, StatusHit, err
+}
+
+// rangeFromFullResponse returns a response to the Range request req built from
+// the fresh cached response to the same request without its Range header, if any,
+// looked up first in the partition of user, if set.
+// It returns ok set to false if there is none.
+func (t *Transport) rangeFromFullResponse(req *http.Request, user string) (resp *http.Response, ok bool, err error) {
+	full := cloneRequest(req)
+	full.Header.Del("range")
+	full.Header.Del("if-range")
+	key := t.cacheKey(full)
+	if key == "" {
+		return nil, false, nil
+	}
+
+	_, cachedResp, ok, err := t.lookupPartitioned(full, key, user)
+	if err != nil || !ok || !t.varyMatches(cachedResp, req) || t.freshness(cachedResp, req) != fresh {
+		return nil, false, nil
+	}
+	if t.MarkCachedResponses {
+		cachedResp.Header.Set(XFromCache, "1")
+	}
+	resp, err = rangeResponse(req, cachedResp)
+	return resp, true, err
+}
+
+// rangeCacheKey returns the key under which the response to the Range request req
+// is stored in CacheRangeResponses mode, or an empty string if it has no Range header.
+func rangeCacheKey(req *http.Request) string {
+	r := strings.Join(strings.Fields(req.Header.Get("range")), "")
+	if r == "" || req.Method != http.MethodGet {
+		return ""
+	}
+	return req.URL.String() + " range:" + r
+}
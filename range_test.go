@@ -0,0 +1,205 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseRange(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range []struct {
+		header string
+		r      byteRange
+		ok     bool
+		err    error
+	}{
+		{"bytes=0-4", byteRange{0, 5}, true, nil},
+		{"bytes=5-", byteRange{5, 5}, true, nil},
+		{"bytes=-3", byteRange{7, 3}, true, nil},
+		{"bytes=-30", byteRange{0, 10}, true, nil},
+		{"bytes=8-20", byteRange{8, 2}, true, nil},
+		{"bytes=10-", byteRange{}, true, errUnsatisfiableRange},
+		{"bytes=0-1,4-5", byteRange{}, false, nil},
+		{"bytes=4-1", byteRange{}, false, nil},
+		{"items=0-1", byteRange{}, false, nil},
+	} {
+		r, ok, err := parseRange(test.header, 10)
+		c.Assert(r, qt.Equals, test.r, qt.Commentf(test.header))
+		c.Assert(ok, qt.Equals, test.ok, qt.Commentf(test.header))
+		c.Assert(err, qt.Equals, test.err, qt.Commentf(test.header))
+	}
+}
+
+func TestGetOnlyIfCachedRange(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	s.transport.MarkCachedResponses = true
+
+	{
+		_, resp := doMethod(t, "GET", "/method", map[string]string{"range": "bytes=1-2", "cache-control": "only-if-cached"})
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusGatewayTimeout)
+	}
+
+	doMethod(t, "GET", "/method", nil)
+	c.Assert(cacheSize(), qt.Equals, 1)
+
+	{
+		body, resp := doMethod(t, "GET", "/method", map[string]string{"range": "bytes=1-2", "cache-control": "only-if-cached"})
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusPartialContent)
+		c.Assert(body, qt.Equals, "ET")
+		c.Assert(resp.Header.Get("Content-Range"), qt.Equals, "bytes 1-2/3")
+		c.Assert(resp.Header.Get(XFromCache), qt.Equals, "1")
+	}
+	{
+		_, resp := doMethod(t, "GET", "/method", map[string]string{"range": "bytes=5-", "cache-control": "only-if-cached"})
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusRequestedRangeNotSatisfiable)
+		c.Assert(resp.Header.Get("Content-Range"), qt.Equals, "bytes */3")
+	}
+	{
+		body, resp := doMethod(t, "GET", "/method", map[string]string{"range": "bytes=0-0,2-2", "cache-control": "only-if-cached"})
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+		c.Assert(body, qt.Equals, "GET")
+	}
+	c.Assert(cacheSize(), qt.Equals, 1)
+}
+
+func TestRangeFromCachedFullResponse(t *testing.T) {
+	c := qt.New(t)
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.MarkCachedResponses = true
+	client := http.Client{Transport: tp}
+	get := func(header map[string]string) (string, *http.Response) {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		return string(b), resp
+	}
+
+	// Nothing cached, forwarded to the server.
+	body, resp := get(map[string]string{"range": "bytes=2-4"})
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusPartialContent)
+	c.Assert(body, qt.Equals, "234")
+	c.Assert(requests, qt.Equals, 1)
+
+	get(nil)
+	c.Assert(requests, qt.Equals, 2)
+
+	body, resp = get(map[string]string{"range": "bytes=2-4"})
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusPartialContent)
+	c.Assert(body, qt.Equals, "234")
+	c.Assert(resp.Header.Get("Content-Range"), qt.Equals, "bytes 2-4/10")
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "1")
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(requests, qt.Equals, 2)
+
+	// A failed If-Range gets the full response.
+	body, resp = get(map[string]string{"range": "bytes=2-4", "if-range": `"v0"`})
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(body, qt.Equals, "0123456789")
+	c.Assert(requests, qt.Equals, 2)
+}
+
+func TestRangeFromPartitionedResponse(t *testing.T) {
+	c := qt.New(t)
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(r.Header.Get("X-User")+":0123456789"))
+	}))
+	defer ts.Close()
+
+	client := http.Client{Transport: newMemoryCacheTransport()}
+	get := func(user, rangeHeader string) (string, CacheStatus) {
+		ctx := WithOptions(context.Background(), Options{User: user})
+		req, err := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		req.Header.Set("X-User", user)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	get("alice", "")
+	c.Assert(requests, qt.Equals, 1)
+
+	// Served from the partition of the user.
+	body, status := get("alice", "bytes=0-6")
+	c.Assert(body, qt.Equals, "alice:0")
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(requests, qt.Equals, 1)
+
+	// But not to other users.
+	body, _ = get("bob", "bytes=0-4")
+	c.Assert(body, qt.Equals, "bob:0")
+	c.Assert(requests, qt.Equals, 2)
+}
+
+func TestCacheRangeResponses(t *testing.T) {
+	c := qt.New(t)
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=3600")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.CacheRangeResponses = true
+	client := http.Client{Transport: tp}
+	get := func(r string) string {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		req.Header.Set("Range", r)
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusPartialContent)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		return string(b)
+	}
+
+	c.Assert(get("bytes=2-4"), qt.Equals, "234")
+	c.Assert(get("bytes=2-4"), qt.Equals, "234")
+	c.Assert(requests, qt.Equals, 1)
+	c.Assert(get("bytes=5-"), qt.Equals, "56789")
+	c.Assert(requests, qt.Equals, 2)
+
+	_, ok := tp.Cache.Get(ts.URL)
+	c.Assert(ok, qt.IsFalse)
+	_, ok = tp.Cache.Get(ts.URL + " range:bytes=2-4")
+	c.Assert(ok, qt.IsTrue)
+}
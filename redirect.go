@@ -0,0 +1,155 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+)
+
+// xRedirectKey is the header holding the cache key of the target of a cached redirect.
+const xRedirectKey = "X-Redirect-Key"
+
+// maxRedirectChain is the maximum number of redirects followed by InvalidateRedirectChain
+// and FollowCachedRedirects.
+const maxRedirectChain = 10
+
+// isPermanentRedirect reports whether statusCode is that of a permanent redirect.
+func isPermanentRedirect(statusCode int) bool {
+	return statusCode == http.StatusMovedPermanently || statusCode == http.StatusPermanentRedirect
+}
+
+// isTemporaryRedirect reports whether statusCode is that of a temporary redirect.
+func isTemporaryRedirect(statusCode int) bool {
+	return statusCode == http.StatusFound || statusCode == http.StatusTemporaryRedirect
+}
+
+// isRedirect reports whether statusCode is that of a redirect the Transport follows
+// from the cache, see FollowCachedRedirects.
+func isRedirect(statusCode int) bool {
+	return isPermanentRedirect(statusCode) || isTemporaryRedirect(statusCode)
+}
+
+// canStoreRedirect reports whether resp, a redirect, may be stored.
+// Permanent redirects are heuristically cacheable, temporary ones
+// need an explicit lifetime or the public directive, see RFC 9111 section 3.
+func (t *Transport) canStoreRedirect(resp *http.Response) bool {
+	if !isTemporaryRedirect(resp.StatusCode) || hasExplicitLifetime(resp.Header) {
+		return true
+	}
+	respCacheControl := parseCacheControl(resp.Header)
+	if _, ok := respCacheControl["public"]; ok {
+		return true
+	}
+	_, ok := respCacheControl["s-maxage"]
+	return ok && t.Shared
+}
+
+// setRedirectKey records in h the cache key of the target of resp,
+// a redirect in response to req, if it has a Location.
+func (t *Transport) setRedirectKey(h http.Header, req *http.Request, resp *http.Response) {
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return
+	}
+	u, err := req.URL.Parse(loc)
+	if err != nil {
+		return
+	}
+	u.Fragment = ""
+	if key := t.urlKey(req, u.String()); key != "" {
+		h.Set(xRedirectKey, key)
+	}
+}
+
+// InvalidateRedirectChain removes the cached responses for GET and HEAD requests
+// to url and, if the response for the GET request is a cached permanent redirect
+// (301 or 308), the cached responses along the chain of redirects it starts,
+// so purging a moved URL also purges the URL it now points to.
+func (t *Transport) InvalidateRedirectChain(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	key := t.cacheKey(req)
+	var keys []string
+	seen := make(map[string]bool)
+	for key != "" && !seen[key] && len(keys) <= maxRedirectChain {
+		seen[key] = true
+		keys = append(keys, key)
+		key = t.redirectKey(ctx, key)
+	}
+	if err := t.InvalidateURL(ctx, url); err != nil {
+		return err
+	}
+	// The first key was removed with url.
+	for i := 1; i < len(keys); i++ {
+		if err := t.cacheDelete(ctx, keys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redirectKey returns the cache key of the target of the permanent redirect
+// stored for key, or "" if there is none.
+func (t *Transport) redirectKey(ctx context.Context, key string) string {
+	b, _ := t.cacheGet(ctx, key)
+	if len(b) == 0 {
+		return ""
+	}
+	e, err := decodeEntry(b)
+	if err != nil || !isPermanentRedirect(e.statusCode) {
+		return ""
+	}
+	redirectKey, _ := e.Get(xRedirectKey)
+	return redirectKey
+}
+
+// followCachedRedirects returns the response at the end of the chain of redirects
+// started by resp, the fresh cached response to req, looked up with user, and true,
+// if all its hops are cached and fresh.
+// Otherwise, e.g. on loops, it returns resp and false.
+func (t *Transport) followCachedRedirects(req *http.Request, resp *http.Response, user string) (*http.Response, bool) {
+	var hops []*http.Response
+	done := func(keep *http.Response) {
+		for _, hop := range append(hops, resp) {
+			if hop != keep {
+				hop.Body.Close()
+			}
+		}
+	}
+	seen := map[string]bool{req.URL.String(): true}
+	hop, hopReq := resp, req
+	for len(hops) < maxRedirectChain {
+		u, err := hopReq.URL.Parse(hop.Header.Get("Location"))
+		if err != nil || hop.Header.Get("Location") == "" {
+			break
+		}
+		u.Fragment = ""
+		if seen[u.String()] {
+			// A loop, let the client deal with it.
+			break
+		}
+		seen[u.String()] = true
+		next := hopReq.Clone(hopReq.Context())
+		next.URL, next.Host = u, ""
+		key := t.cacheKey(next)
+		if key == "" {
+			break
+		}
+		_, cachedResp, ok, err := t.lookupPartitioned(next, key, user)
+		if err != nil || cachedResp == nil {
+			break
+		}
+		hops = append(hops, cachedResp)
+		if !ok || !t.varyMatches(cachedResp, next) || t.freshness(cachedResp, next) != fresh {
+			break
+		}
+		if !isRedirect(cachedResp.StatusCode) {
+			done(cachedResp)
+			return cachedResp, true
+		}
+		hop, hopReq = cachedResp, next
+	}
+	done(resp)
+	return resp, false
+}
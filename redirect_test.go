@@ -0,0 +1,151 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestInvalidateRedirectChain(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusPermanentRedirect)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusMovedPermanently)
+		default:
+			w.Write([]byte("content"))
+		}
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	client := http.Client{Transport: tp}
+	get := func(path string) {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+	}
+	cached := func(path string) bool {
+		_, ok := tp.EntryInfo(ts.URL + path)
+		return ok
+	}
+
+	get("/a")
+	get("/d")
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		c.Assert(cached(path), qt.IsTrue, qt.Commentf(path))
+	}
+
+	ctx := context.Background()
+	c.Assert(tp.InvalidateRedirectChain(ctx, ts.URL+"/b"), qt.IsNil)
+	c.Assert(cached("/a"), qt.IsTrue)
+	c.Assert(cached("/b"), qt.IsFalse)
+	c.Assert(cached("/c"), qt.IsFalse)
+
+	get("/a")
+	c.Assert(tp.InvalidateRedirectChain(ctx, ts.URL+"/a"), qt.IsNil)
+	for _, path := range []string{"/a", "/b", "/c"} {
+		c.Assert(cached(path), qt.IsFalse, qt.Commentf(path))
+	}
+	c.Assert(cached("/d"), qt.IsTrue)
+
+	// Loops are not followed forever.
+	get("/d")
+	tp.Cache.Set(ts.URL+"/loop", encodeEntry(&http.Response{
+		StatusCode: http.StatusMovedPermanently,
+		Header:     http.Header{xRedirectKey: []string{ts.URL + "/loop"}},
+	}, nil))
+	c.Assert(tp.InvalidateRedirectChain(ctx, ts.URL+"/loop"), qt.IsNil)
+	c.Assert(cached("/loop"), qt.IsFalse)
+	c.Assert(cached("/d"), qt.IsTrue)
+}
+
+func TestFollowCachedRedirects(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	requests := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		w.Header().Set("Cache-Control", "max-age=3600")
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusTemporaryRedirect)
+		case "/loop1":
+			http.Redirect(w, r, "/loop2", http.StatusPermanentRedirect)
+		case "/loop2":
+			http.Redirect(w, r, "/loop1", http.StatusPermanentRedirect)
+		case "/temporary":
+			w.Header().Del("Cache-Control")
+			http.Redirect(w, r, "/c", http.StatusFound)
+		case "/stale":
+			http.Redirect(w, r, "/no-cache", http.StatusMovedPermanently)
+		case "/no-cache":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Write([]byte("no-cache"))
+		default:
+			w.Write([]byte("content"))
+		}
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.FollowCachedRedirects = true
+	follow := &http.Client{Transport: tp}
+	noFollow := &http.Client{
+		Transport: tp,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	get := func(client *http.Client, path string) (*http.Response, string) {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		return resp, string(b)
+	}
+
+	get(follow, "/a")
+	resp, b := get(noFollow, "/a")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(b, qt.Equals, "content")
+	c.Assert(resp.Request.URL.String(), qt.Equals, ts.URL+"/c")
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(requests, qt.DeepEquals, map[string]int{"/a": 1, "/b": 1, "/c": 1})
+
+	// Loops are returned to the client.
+	_, err := follow.Get(ts.URL + "/loop1")
+	c.Assert(err, qt.IsNotNil)
+	resp, _ = get(noFollow, "/loop1")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusPermanentRedirect)
+
+	// So are chains with hops to revalidate.
+	get(follow, "/stale")
+	resp, _ = get(noFollow, "/stale")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusMovedPermanently)
+
+	// Temporary redirects without a lifetime are not stored.
+	get(follow, "/temporary")
+	get(follow, "/temporary")
+	c.Assert(requests["/temporary"], qt.Equals, 2)
+	_, ok := tp.EntryInfo(ts.URL + "/temporary")
+	c.Assert(ok, qt.IsFalse)
+}
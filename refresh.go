@@ -0,0 +1,356 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RefreshInfo describes a refresh of a cache entry queued or running in the background.
+type RefreshInfo struct {
+	// Key is the cache key of the entry being refreshed.
+	Key string
+
+	// URL is the URL of the background request.
+	URL string
+
+	// Queued is when the refresh was queued.
+	Queued time.Time
+
+	// Running is true when the request to the origin has started.
+	Running bool
+
+	// Hits is the number of requests served the stale entry while the refresh was pending.
+	// Queued refreshes with the most hits are started first.
+	Hits int
+}
+
+// PendingRefreshes returns the background refreshes currently queued or running,
+// sorted by key.
+func (t *Transport) PendingRefreshes() []RefreshInfo {
+	return t.refreshes.list()
+}
+
+// CancelRefresh cancels the queued or running background refresh for key,
+// reporting whether there was one.
+func (t *Transport) CancelRefresh(key string) bool {
+	return t.refreshes.cancel(key)
+}
+
+type refreshTask struct {
+	info   RefreshInfo
+	ctx    context.Context
+	cancel context.CancelFunc
+	run    func(ctx context.Context)
+}
+
+// refreshTracker keeps track of background refreshes, at most one per key.
+type refreshTracker struct {
+	mu         sync.Mutex
+	tasks      map[string]*refreshTask
+	running    int
+	maxRunning int
+	closed     bool
+	closing    chan struct{} // closed when the tracker is closed.
+	wg         sync.WaitGroup
+}
+
+// add schedules run as the refresh of key using req, reporting false if
+// there are already maxQueued refreshes waiting to start (if maxQueued > 0).
+// At most maxRunning refreshes run at the same time (if maxRunning > 0).
+// If a refresh of key is already pending, it is counted as a hit for it.
+// Once the tracker is closed, add reports false.
+func (r *refreshTracker) add(key string, req *http.Request, maxRunning, maxQueued int, run func(ctx context.Context)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	if task, found := r.tasks[key]; found {
+		task.info.Hits++
+		return true
+	}
+	if maxQueued > 0 && len(r.tasks)-r.running >= maxQueued {
+		return false
+	}
+	if r.tasks == nil {
+		r.tasks = make(map[string]*refreshTask)
+	}
+	r.wg.Add(1)
+	ctx, cancel := context.WithCancel(req.Context())
+	r.tasks[key] = &refreshTask{
+		info: RefreshInfo{
+			Key:    key,
+			URL:    req.URL.String(),
+			Queued: time.Now(),
+			Hits:   1,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		run:    run,
+	}
+	r.maxRunning = maxRunning
+	r.dispatch()
+	return true
+}
+
+// dispatch starts queued refreshes while below the concurrency limit,
+// the most popular first.
+// r.mu must be held.
+func (r *refreshTracker) dispatch() {
+	for r.maxRunning <= 0 || r.running < r.maxRunning {
+		var next *refreshTask
+		for _, task := range r.tasks {
+			if task.info.Running {
+				continue
+			}
+			if next == nil || task.info.Hits > next.info.Hits ||
+				(task.info.Hits == next.info.Hits && task.info.Queued.Before(next.info.Queued)) {
+				next = task
+			}
+		}
+		if next == nil {
+			return
+		}
+		next.info.Running = true
+		r.running++
+		go r.execute(next)
+	}
+}
+
+func (r *refreshTracker) execute(task *refreshTask) {
+	defer r.done(task)
+	task.run(task.ctx)
+}
+
+// done removes task, which has run.
+func (r *refreshTracker) done(task *refreshTask) {
+	r.mu.Lock()
+	task.cancel()
+	delete(r.tasks, task.info.Key)
+	if task.info.Running {
+		r.running--
+		r.dispatch()
+	}
+	r.mu.Unlock()
+	r.wg.Done()
+}
+
+// wait waits for all refreshes to finish.
+func (r *refreshTracker) wait() {
+	r.wg.Wait()
+}
+
+// close stops accepting refreshes and waits for the pending ones to finish.
+func (r *refreshTracker) close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.closingChan())
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// closingChan returns a channel closed when the tracker is closed.
+// r.mu must be held.
+func (r *refreshTracker) closingChan() chan struct{} {
+	if r.closing == nil {
+		r.closing = make(chan struct{})
+	}
+	return r.closing
+}
+
+// cancel cancels the refresh of key, reporting whether there was one.
+// A queued refresh is removed at once, under the same lock dispatch starts
+// it with, a running one removes itself when done.
+func (r *refreshTracker) cancel(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, found := r.tasks[key]
+	if !found {
+		return false
+	}
+	task.cancel()
+	if !task.info.Running {
+		delete(r.tasks, key)
+		r.wg.Done()
+	}
+	return true
+}
+
+func (r *refreshTracker) list() []RefreshInfo {
+	r.mu.Lock()
+	infos := make([]RefreshInfo, 0, len(r.tasks))
+	for _, task := range r.tasks {
+		infos = append(infos, task.info)
+	}
+	r.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos
+}
+
+type refreshKey struct{}
+
+// isRefresh reports whether ctx belongs to a refresh of a cache entry.
+// Refreshes always revalidate the cached response, and failures to do so
+// keep it in the cache and are reported as errors.
+func isRefresh(ctx context.Context) bool {
+	_, ok := ctx.Value(refreshKey{}).(bool)
+	return ok
+}
+
+// withRefresh returns a copy of ctx marked as belonging to a refresh.
+func withRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, true)
+}
+
+// Close stops the Transport from refreshing stale responses in the background
+// and waits for the queued and running refreshes to finish, without retrying
+// failed ones. Stale responses are revalidated on demand afterwards.
+// Failed cache writes waiting to be retried are dropped.
+func (t *Transport) Close() error {
+	t.refreshes.close()
+	t.writes.close()
+	return nil
+}
+
+// canRefreshInBackground reports whether the stale cached response with the
+// headers respHeaders may be served while it's refreshed in the background,
+// because of its stale-while-revalidate directive or Transport.StaleWhileRevalidate.
+func (t *Transport) canRefreshInBackground(respHeaders http.Header) bool {
+	if varyAll(respHeaders) {
+		return false
+	}
+	defaultLifetime := t.defaultLifetime(respHeaders)
+	clk := t.timer()
+	if canStaleWhileRevalidate(respHeaders, defaultLifetime, t.Shared, clk) {
+		return true
+	}
+	if t.StaleWhileRevalidate <= 0 {
+		return false
+	}
+	respCacheControl := parseCacheControl(respHeaders)
+	for _, directive := range []string{"stale-while-revalidate", "must-revalidate", "proxy-revalidate", "no-cache"} {
+		if _, ok := respCacheControl[directive]; ok {
+			return false
+		}
+	}
+	reqHeaders := http.Header{}
+	reqHeaders.Set("Cache-Control", "max-stale="+strconv.FormatInt(int64(t.StaleWhileRevalidate/time.Second), 10))
+	return getFreshnessWithDefault(respHeaders, reqHeaders, defaultLifetime, t.Shared, clk) == fresh
+}
+
+// refreshInBackground schedules a revalidation of the cached response cachedResp,
+// stored for key, using a request derived from req, reporting false if the
+// refresh queue is full and the caller should revalidate on demand.
+// Only one refresh per key is pending at any time.
+// The refresh lease, if any, is acquired when the refresh starts, it is skipped
+// if another node holds it.
+// Failed refreshes are retried up to RefreshRetries times.
+func (t *Transport) refreshInBackground(key string, req *http.Request, cachedResp *http.Response) bool {
+	bg := t.backgroundRequest(req, cachedResp)
+	return t.refreshes.add(key, bg, t.MaxConcurrentRefreshes, t.MaxQueuedRefreshes, func(ctx context.Context) {
+		if !t.acquireRefreshLease(key) {
+			// Another node is refreshing the response.
+			return
+		}
+		backoff := t.RefreshRetryBackoff
+		if backoff <= 0 {
+			backoff = defaultRefreshRetryBackoff
+		}
+		for attempt := 0; ; attempt++ {
+			bg, err := t.refreshOnce(ctx, bg)
+			if err == nil {
+				return
+			}
+			if attempt >= t.RefreshRetries || !t.waitRetry(ctx, backoff<<attempt) {
+				if t.OnRefreshError != nil {
+					t.OnRefreshError(bg, err)
+				}
+				return
+			}
+		}
+	})
+}
+
+// defaultRefreshRetryBackoff is the default value of Transport.RefreshRetryBackoff.
+const defaultRefreshRetryBackoff = time.Second
+
+// refreshOnce sends the background request bg with ctx, within SWRRevalidateTimeout,
+// returning the request sent.
+func (t *Transport) refreshOnce(ctx context.Context, bg *http.Request) (*http.Request, error) {
+	if t.SWRRevalidateTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.SWRRevalidateTimeout)
+		defer cancel()
+	}
+	bg = bg.WithContext(withRefresh(ctx))
+	return bg, t.refresh(bg)
+}
+
+// waitRetry waits for d before retrying a refresh, reporting false if ctx was
+// canceled or the Transport closed meanwhile.
+func (t *Transport) waitRetry(ctx context.Context, d time.Duration) bool {
+	t.refreshes.mu.Lock()
+	closing := t.refreshes.closingChan()
+	t.refreshes.mu.Unlock()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+	case <-closing:
+	}
+	return false
+}
+
+// refresh sends req through the Transport and reads the response,
+// so the cache entry gets updated.
+func (t *Transport) refresh(req *http.Request) error {
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return err
+}
+
+// Changed sends a conditional request for url using the validators of the cached
+// response, if any, and reports whether the resource has changed since it was cached.
+// The cache is updated with the new response if it did.
+//
+// If there is no cached response, Changed reports true.
+// Resources without validators are fetched in full and compared with the cached body.
+func (t *Transport) Changed(ctx context.Context, url string) (bool, error) {
+	req, err := http.NewRequestWithContext(withRefresh(ctx), http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	before, hadFingerprint := t.Fingerprint(url)
+
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return false, err
+	}
+
+	if status, _ := ResponseCacheStatus(resp); status == StatusRevalidated || status == StatusHit {
+		return false, nil
+	}
+	if !hadFingerprint {
+		return true, nil
+	}
+	after, ok := t.Fingerprint(url)
+	return !ok || after.Hash != before.Hash, nil
+}
@@ -0,0 +1,340 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRefreshTracker(t *testing.T) {
+	c := qt.New(t)
+	tp := &Transport{}
+	req, err := http.NewRequest("GET", "http://example.com/foo", nil)
+	c.Assert(err, qt.IsNil)
+
+	var (
+		mu      sync.Mutex
+		started []string
+	)
+	release := make(chan struct{})
+	run := func(key string) func(ctx context.Context) {
+		return func(ctx context.Context) {
+			mu.Lock()
+			started = append(started, key)
+			mu.Unlock()
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+		}
+	}
+	add := func(key string) bool {
+		return tp.refreshes.add(key, req, 1, 2, run(key))
+	}
+
+	c.Assert(add("foo"), qt.IsTrue)
+	c.Assert(add("foo"), qt.IsTrue)
+	c.Assert(add("bar"), qt.IsTrue)
+	c.Assert(add("baz"), qt.IsTrue)
+	c.Assert(add("baz"), qt.IsTrue)
+	// Queue full.
+	c.Assert(add("qux"), qt.IsFalse)
+
+	pending := tp.PendingRefreshes()
+	c.Assert(pending, qt.HasLen, 3)
+	c.Assert(pending[0].Key, qt.Equals, "bar")
+	c.Assert(pending[0].Running, qt.IsFalse)
+	c.Assert(pending[1].Key, qt.Equals, "baz")
+	c.Assert(pending[1].Hits, qt.Equals, 2)
+	c.Assert(pending[2].Key, qt.Equals, "foo")
+	c.Assert(pending[2].URL, qt.Equals, "http://example.com/foo")
+	c.Assert(pending[2].Running, qt.IsTrue)
+
+	// Canceling a queued refresh removes it.
+	c.Assert(tp.CancelRefresh("bar"), qt.IsTrue)
+	c.Assert(tp.PendingRefreshes(), qt.HasLen, 2)
+	c.Assert(tp.CancelRefresh("bar"), qt.IsFalse)
+	c.Assert(add("bar"), qt.IsTrue)
+
+	// baz is the most popular.
+	c.Assert(tp.CancelRefresh("foo"), qt.IsTrue)
+	close(release)
+	tp.refreshes.wait()
+	c.Assert(started, qt.DeepEquals, []string{"foo", "baz", "bar"})
+	c.Assert(tp.PendingRefreshes(), qt.HasLen, 0)
+}
+
+func TestRefreshTrackerCancelWhileDispatching(t *testing.T) {
+	c := qt.New(t)
+	req, err := http.NewRequest("GET", "http://example.com/foo", nil)
+	c.Assert(err, qt.IsNil)
+
+	for i := 0; i < 10000; i++ {
+		var r refreshTracker
+		release := make(chan struct{})
+		r.add("running", req, 1, 0, func(ctx context.Context) { <-release })
+		r.add("queued", req, 1, 0, func(ctx context.Context) {})
+		// Finishing the running refresh starts the queued one while it's canceled.
+		close(release)
+		r.cancel("queued")
+		r.wait()
+		c.Assert(r.running, qt.Equals, 0)
+		c.Assert(r.tasks, qt.HasLen, 0)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var (
+		mu          sync.Mutex
+		conditional []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conditional = append(conditional, r.Header.Get("if-none-match"))
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		w.Header().Set("Etag", `"abc"`)
+		if r.Header.Get("if-none-match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	var refreshErrs []error
+	tp.OnRefreshError = func(req *http.Request, err error) {
+		refreshErrs = append(refreshErrs, err)
+	}
+	client := http.Client{Transport: tp}
+
+	get := func() (string, CacheStatus) {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	body, status := get()
+	c.Assert(body, qt.Equals, "content")
+	c.Assert(status, qt.Equals, StatusMiss)
+
+	clock = &fakeClock{elapsed: 30 * time.Second}
+	body, status = get()
+	c.Assert(body, qt.Equals, "content")
+	c.Assert(status, qt.Equals, StatusStale)
+	tp.refreshes.wait()
+	c.Assert(conditional, qt.DeepEquals, []string{"", `"abc"`})
+	c.Assert(tp.PendingRefreshes(), qt.HasLen, 0)
+
+	// Outside of the window, revalidate synchronously.
+	clock = &fakeClock{elapsed: 90 * time.Second}
+	body, status = get()
+	c.Assert(body, qt.Equals, "content")
+	c.Assert(status, qt.Equals, StatusRevalidated)
+	c.Assert(conditional, qt.HasLen, 3)
+
+	// Failing refreshes keep the entry.
+	clock = &fakeClock{elapsed: 30 * time.Second}
+	tp.Transport = transportMock{err: errors.New("network down")}
+	_, status = get()
+	c.Assert(status, qt.Equals, StatusStale)
+	tp.refreshes.wait()
+	c.Assert(refreshErrs, qt.HasLen, 1)
+	_, status = get()
+	c.Assert(status, qt.Equals, StatusStale)
+	tp.refreshes.wait()
+	c.Assert(refreshErrs, qt.HasLen, 2)
+}
+
+func TestCanStaleWhileRevalidate(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	respHeaders := http.Header{}
+	respHeaders.Set("Date", time.Now().Format(time.RFC1123))
+	respHeaders.Set("Cache-Control", "max-age=10, stale-while-revalidate=20")
+	clock = &fakeClock{elapsed: 25 * time.Second}
+	c.Assert(canStaleWhileRevalidate(respHeaders, 0, false, clock), qt.IsTrue)
+	clock = &fakeClock{elapsed: 35 * time.Second}
+	c.Assert(canStaleWhileRevalidate(respHeaders, 0, false, clock), qt.IsFalse)
+	respHeaders.Set("Cache-Control", "max-age=10, stale-while-revalidate")
+	clock = &fakeClock{elapsed: 15 * time.Second}
+	c.Assert(canStaleWhileRevalidate(respHeaders, 0, false, clock), qt.IsFalse)
+}
+
+func TestDefaultStaleWhileRevalidate(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	tp := &Transport{StaleWhileRevalidate: 20 * time.Second}
+	respHeaders := http.Header{}
+	respHeaders.Set("Date", time.Now().Format(time.RFC1123))
+	respHeaders.Set("Cache-Control", "max-age=10")
+	clock = &fakeClock{elapsed: 25 * time.Second}
+	c.Assert(tp.canRefreshInBackground(respHeaders), qt.IsTrue)
+	clock = &fakeClock{elapsed: 35 * time.Second}
+	c.Assert(tp.canRefreshInBackground(respHeaders), qt.IsFalse)
+
+	// The response's own directive wins.
+	respHeaders.Set("Cache-Control", "max-age=10, stale-while-revalidate=5")
+	clock = &fakeClock{elapsed: 25 * time.Second}
+	c.Assert(tp.canRefreshInBackground(respHeaders), qt.IsFalse)
+	respHeaders.Set("Cache-Control", "max-age=10, must-revalidate")
+	c.Assert(tp.canRefreshInBackground(respHeaders), qt.IsFalse)
+
+	tp.StaleWhileRevalidate = 0
+	respHeaders.Set("Cache-Control", "max-age=10")
+	c.Assert(tp.canRefreshInBackground(respHeaders), qt.IsFalse)
+}
+
+type failingTransport struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	next     http.RoundTripper
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.calls++
+	fail := t.calls <= t.failures
+	t.mu.Unlock()
+	if fail {
+		return nil, errors.New("network down")
+	}
+	return t.next.RoundTrip(req)
+}
+
+func TestRefreshRetries(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.StaleWhileRevalidate = time.Minute
+	tp.RefreshRetries = 2
+	tp.RefreshRetryBackoff = time.Millisecond
+	var refreshErrs []error
+	tp.OnRefreshError = func(req *http.Request, err error) {
+		refreshErrs = append(refreshErrs, err)
+	}
+	client := http.Client{Transport: tp}
+	get := func() CacheStatus {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(get(), qt.Equals, StatusMiss)
+	clock = &fakeClock{elapsed: 30 * time.Second}
+
+	failing := &failingTransport{failures: 2, next: http.DefaultTransport}
+	tp.Transport = failing
+	c.Assert(get(), qt.Equals, StatusStale)
+	tp.refreshes.wait()
+	c.Assert(failing.calls, qt.Equals, 3)
+	c.Assert(refreshErrs, qt.HasLen, 0)
+
+	failing = &failingTransport{failures: 3, next: http.DefaultTransport}
+	tp.Transport = failing
+	c.Assert(get(), qt.Equals, StatusStale)
+	tp.refreshes.wait()
+	c.Assert(failing.calls, qt.Equals, 3)
+	c.Assert(refreshErrs, qt.HasLen, 1)
+}
+
+func TestTransportClose(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.RefreshRetries = 5
+	tp.RefreshRetryBackoff = time.Hour
+	client := http.Client{Transport: tp}
+	get := func() CacheStatus {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(get(), qt.Equals, StatusMiss)
+	clock = &fakeClock{elapsed: 30 * time.Second}
+
+	// Close does not wait for the retries.
+	tp.Transport = &failingTransport{failures: 1, next: http.DefaultTransport}
+	c.Assert(get(), qt.Equals, StatusStale)
+	c.Assert(tp.Close(), qt.IsNil)
+	c.Assert(tp.PendingRefreshes(), qt.HasLen, 0)
+
+	// Fetched on demand once closed.
+	c.Assert(get(), qt.Equals, StatusMiss)
+	c.Assert(tp.Close(), qt.IsNil)
+}
+
+func TestChanged(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	changed, err := s.transport.Changed(ctx, s.server.URL+"/etag")
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsTrue)
+	changed, err = s.transport.Changed(ctx, s.server.URL+"/etag")
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsFalse)
+
+	// No validators, compare the bodies.
+	var content string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	defer ts.Close()
+	content = "v1"
+	changed, err = s.transport.Changed(ctx, ts.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsTrue)
+	changed, err = s.transport.Changed(ctx, ts.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsFalse)
+	content = "v2"
+	changed, err = s.transport.Changed(ctx, ts.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(changed, qt.IsTrue)
+
+	// Errors keep the cached response.
+	ts.Close()
+	_, err = s.transport.Changed(ctx, ts.URL)
+	c.Assert(err, qt.Not(qt.IsNil))
+	_, ok := s.transport.Fingerprint(ts.URL)
+	c.Assert(ok, qt.IsTrue)
+}
@@ -0,0 +1,138 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// RefreshAllReport is the result of a Transport.RefreshAll.
+type RefreshAllReport struct {
+	// Entries is the number of entries selected by the filter.
+	Entries int
+
+	// NotModified is the number of entries the server confirmed as still valid.
+	NotModified int
+
+	// Refetched is the number of entries replaced by a new response from the server.
+	Refetched int
+
+	// Skipped is the number of selected entries that could not be refreshed,
+	// e.g. responses to POST requests, whose body isn't stored.
+	Skipped int
+
+	// Errors is the number of refreshes that failed.
+	// The cached responses are kept.
+	Errors int
+}
+
+// RefreshAll revalidates, or fetches again, all the cached responses for which
+// filter returns true, or all of them if filter is nil, with up to concurrency
+// requests in flight (4 if concurrency <= 0), e.g. so the cache is as fresh as
+// possible before a planned outage of the servers.
+// Fresh responses are revalidated too.
+//
+// The responses are refreshed with GET or HEAD requests rebuilt from what was
+// stored with them: their URL and the request headers they vary on.
+//
+// The Cache must implement IterableCache.
+func (t *Transport) RefreshAll(ctx context.Context, filter func(EntryInfo) bool, concurrency int) (RefreshAllReport, error) {
+	var report RefreshAllReport
+
+	cache, ok := t.Cache.(IterableCache)
+	if !ok {
+		return report, ErrCacheNotIterable
+	}
+
+	var keys []string
+	cache.Keys("", func(key string) bool {
+		keys = append(keys, key)
+		return ctx.Err() == nil
+	})
+
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		info, ok := t.EntryInfo(key)
+		if !ok || info.URL == "" || (filter != nil && !filter(info)) {
+			// Not a response, e.g. the saved Counters.
+			continue
+		}
+		report.Entries++
+		if info.Method != "" && info.Method != http.MethodGet && info.Method != http.MethodHead {
+			report.Skipped++
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			status, err := t.refreshEntry(ctx, key)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == errNoStoredRequest:
+				report.Skipped++
+			case err != nil:
+				report.Errors++
+			case status == StatusRevalidated:
+				report.NotModified++
+			default:
+				report.Refetched++
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	return report, ctx.Err()
+}
+
+// errNoStoredRequest is returned by refreshEntry for entries it can't rebuild a request for.
+var errNoStoredRequest = errors.New("httpcache: no request stored with response")
+
+// refreshEntry refreshes the response stored for key, returning the status of the refresh.
+func (t *Transport) refreshEntry(ctx context.Context, key string) (CacheStatus, error) {
+	b, _ := t.cacheGet(ctx, key)
+	if len(b) == 0 {
+		return StatusMiss, errNoStoredRequest
+	}
+	cachedResp, err := readStoredResponse(b, nil)
+	if err != nil {
+		return StatusMiss, errNoStoredRequest
+	}
+	req, err := storedRequest(withRefresh(ctx), cachedResp)
+	if err != nil {
+		return StatusMiss, errNoStoredRequest
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return StatusMiss, err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return StatusMiss, err
+	}
+	if resp.StatusCode >= 500 {
+		return StatusMiss, fmt.Errorf("httpcache: refreshing %s failed: %s", req.URL, resp.Status)
+	}
+	status, _ := ResponseCacheStatus(resp)
+	return status, nil
+}
@@ -0,0 +1,60 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRefreshAll(t *testing.T) {
+	c := qt.New(t)
+	var down atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		if strings.HasPrefix(r.URL.Path, "/etag") {
+			w.Header().Set("Etag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	cache := newMemoryCache()
+	tp := &Transport{Cache: cache, Clock: &offsetClock{}}
+	client := http.Client{Transport: tp}
+	for _, path := range []string{"/etag/a", "/etag/b", "/plain", "/other"} {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	cache.Set(StatsCacheKey, []byte("{}"))
+
+	notOther := func(info EntryInfo) bool { return !strings.HasSuffix(info.URL, "/other") }
+	report, err := tp.RefreshAll(context.Background(), notOther, 2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(report, qt.Equals, RefreshAllReport{Entries: 3, NotModified: 2, Refetched: 1})
+
+	down.Store(true)
+	report, err = tp.RefreshAll(context.Background(), nil, 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(report, qt.Equals, RefreshAllReport{Entries: 4, Errors: 4})
+	// The cached responses are kept.
+	c.Assert(cache.Size(), qt.Equals, 5)
+
+	_, err = (&Transport{Cache: &staleCache{}}).RefreshAll(context.Background(), nil, 0)
+	c.Assert(err, qt.Equals, ErrCacheNotIterable)
+}
@@ -0,0 +1,92 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+)
+
+// defaultMaxRequestBodyBytes is the default value of Transport.MaxRequestBodyBytes.
+const defaultMaxRequestBodyBytes = 64 << 10
+
+type bodyHashKey struct{}
+
+// RequestBodyHash returns the hex encoded SHA-256 hash of the body of req,
+// as included in the cache key of requests with a method listed in
+// Transport.CacheableMethods, e.g. for a custom CacheKey func,
+// and false if that body was not hashed.
+func RequestBodyHash(req *http.Request) (string, bool) {
+	h, ok := req.Context().Value(bodyHashKey{}).(string)
+	return h, ok
+}
+
+// methodCacheable reports whether responses to requests with method may be cached.
+func (t *Transport) methodCacheable(method string) bool {
+	if t.CacheableMethods == nil || method == http.MethodGet || method == http.MethodHead {
+		return true
+	}
+	for _, m := range t.CacheableMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// needsBodyHash reports whether the body of req must be part of its cache key.
+func (t *Transport) needsBodyHash(req *http.Request) bool {
+	if t.CacheableMethods == nil || req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return false
+	}
+	if _, ok := RequestBodyHash(req); ok && isRefresh(req.Context()) {
+		// Hashed already by the request that triggered the refresh.
+		return false
+	}
+	return true
+}
+
+// withBodyHash returns a copy of req with the hash of its body stored in its
+// context, and its body rewound, so it can be sent to the server.
+// It reports false if the body is larger than MaxRequestBodyBytes, in which
+// case the request can't be cached.
+func (t *Transport) withBodyHash(req *http.Request) (*http.Request, bool, error) {
+	max := t.MaxRequestBodyBytes
+	if max <= 0 {
+		max = defaultMaxRequestBodyBytes
+	}
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.ContentLength > max {
+			return req, false, nil
+		}
+		var err error
+		body, err = io.ReadAll(io.LimitReader(req.Body, max+1))
+		if err != nil {
+			req.Body.Close()
+			return nil, false, err
+		}
+		if int64(len(body)) > max {
+			// Too large, send what's been read along with the rest.
+			req2 := cloneRequest(req)
+			req2.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+			return req2, false, nil
+		}
+		req.Body.Close()
+	}
+
+	sum := sha256.Sum256(body)
+	req2 := cloneRequest(req).WithContext(context.WithValue(req.Context(), bodyHashKey{}, hex.EncodeToString(sum[:])))
+	if body != nil {
+		req2.Body = io.NopCloser(bytes.NewReader(body))
+		req2.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	return req2, true, nil
+}
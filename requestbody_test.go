@@ -0,0 +1,81 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCacheableMethods(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte(r.Method + " " + string(b)))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.CacheableMethods = []string{http.MethodPost}
+	tp.MaxRequestBodyBytes = 20
+	client := http.Client{Transport: tp}
+
+	do := func(method, body string) (string, CacheStatus) {
+		req, err := http.NewRequest(method, ts.URL, strings.NewReader(body))
+		c.Assert(err, qt.IsNil)
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	query := `{"query":"{a}"}`
+	body, status := do(http.MethodPost, query)
+	c.Assert(body, qt.Equals, "POST "+query)
+	c.Assert(status, qt.Equals, StatusMiss)
+	body, status = do(http.MethodPost, query)
+	c.Assert(body, qt.Equals, "POST "+query)
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(requests.Load(), qt.Equals, int32(1))
+
+	// Another body, another entry.
+	body, status = do(http.MethodPost, `{"query":"{b}"}`)
+	c.Assert(body, qt.Equals, `POST {"query":"{b}"}`)
+	c.Assert(status, qt.Equals, StatusMiss)
+
+	// Too large to be hashed, sent in full.
+	large := strings.Repeat("x", 30)
+	for i := 0; i < 2; i++ {
+		body, status = do(http.MethodPost, large)
+		c.Assert(body, qt.Equals, "POST "+large)
+		c.Assert(status, qt.Equals, StatusMiss)
+	}
+
+	// Not listed.
+	for i := 0; i < 2; i++ {
+		body, status = do(http.MethodPut, "a")
+		c.Assert(body, qt.Equals, "PUT a")
+		c.Assert(status, qt.Equals, StatusMiss)
+	}
+	c.Assert(requests.Load(), qt.Equals, int32(6))
+
+	var keys []string
+	tp.Cache.(*memoryCache).Keys("POST ", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, qt.HasLen, 2)
+	c.Assert(strings.HasPrefix(keys[0], "POST "+ts.URL+" "), qt.IsTrue)
+}
@@ -0,0 +1,104 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Result describes how a Transport answered a request, see RoundTripWithResult.
+type Result struct {
+	// Status tells whether the response was served from the cache.
+	Status CacheStatus
+
+	// Key is the cache key of the request, empty if it was not cacheable.
+	Key string
+
+	// Age is the age of the response, counted from its Date header.
+	Age time.Duration
+
+	// CacheTime is the time spent reading from the Cache.
+	CacheTime time.Duration
+
+	// OriginTime is the time spent waiting for the server to send the response
+	// headers, zero if it was not contacted.
+	OriginTime time.Duration
+
+	// StoreSkipReason tells why the response was not stored in the cache, if known
+	// when RoundTrip returned. Reasons found later, while the body is read
+	// (e.g. SkipTooLarge), are only reported to Transport.OnStoreSkip.
+	StoreSkipReason StoreSkipReason
+}
+
+type resultKey struct{}
+
+// resultRecorder collects the Result of a request.
+type resultRecorder struct {
+	mu     sync.Mutex
+	result Result
+}
+
+// RoundTripWithResult is like RoundTrip, but also returns a Result describing how
+// the request was answered, a typed alternative to ResponseCacheStatus and the
+// X-From-Cache header for programmatic consumers.
+func (t *Transport) RoundTripWithResult(req *http.Request) (*http.Response, Result, error) {
+	rec := &resultRecorder{}
+	req = req.WithContext(context.WithValue(req.Context(), resultKey{}, rec))
+	resp, err := t.RoundTrip(req)
+
+	rec.mu.Lock()
+	result := rec.result
+	rec.mu.Unlock()
+	if err != nil {
+		return nil, result, err
+	}
+	result.Status, _ = ResponseCacheStatus(resp)
+	if d, err := date(resp.Header); err == nil {
+		if result.Age = t.timer().since(d); result.Age < 0 {
+			result.Age = 0
+		}
+	}
+	return resp, result, nil
+}
+
+// recordResult updates the Result of the request with ctx, if one is being recorded.
+// Background refreshes, outliving the request that triggered them, are not recorded.
+func recordResult(ctx context.Context, update func(r *Result)) {
+	rec, ok := ctx.Value(resultKey{}).(*resultRecorder)
+	if !ok || isRefresh(ctx) {
+		return
+	}
+	rec.mu.Lock()
+	update(&rec.result)
+	rec.mu.Unlock()
+}
+
+// timedTransport is a http.RoundTripper recording the time spent in its RoundTrip
+// as the OriginTime of the Result of the request.
+type timedTransport struct {
+	http.RoundTripper
+}
+
+func (t timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(req)
+	elapsed := time.Since(start)
+	recordResult(req.Context(), func(r *Result) { r.OriginTime += elapsed })
+	return resp, err
+}
+
+// originTransport returns the RoundTripper used to send req to the origin.
+func (t *Transport) originTransport(req *http.Request) http.RoundTripper {
+	var rt http.RoundTripper = upstreamTransport{t.transport()}
+	if t.CanonicalizeHeaders {
+		rt = canonicalTransport{rt}
+	}
+	if t.UpstreamRetries > 0 {
+		rt = retryTransport{rt, t}
+	}
+	if _, ok := req.Context().Value(resultKey{}).(*resultRecorder); ok {
+		return timedTransport{rt}
+	}
+	return rt
+}
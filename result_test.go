@@ -0,0 +1,44 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRoundTripWithResult(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	roundTrip := func(tp *Transport) Result {
+		req, err := http.NewRequest(http.MethodGet, s.server.URL+"/method", nil)
+		c.Assert(err, qt.IsNil)
+		resp, result, err := tp.RoundTripWithResult(req)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return result
+	}
+
+	result := roundTrip(s.transport)
+	c.Assert(result.Status, qt.Equals, StatusMiss)
+	c.Assert(result.Key, qt.Equals, s.server.URL+"/method")
+	c.Assert(result.OriginTime > 0, qt.IsTrue)
+
+	result = roundTrip(s.transport)
+	c.Assert(result.Status, qt.Equals, StatusHit)
+	c.Assert(result.Key, qt.Equals, s.server.URL+"/method")
+	c.Assert(result.OriginTime, qt.Equals, time.Duration(0))
+	c.Assert(result.Age >= 0, qt.IsTrue)
+
+	tp := &Transport{Cache: newMemoryCache(), Transport: transportMock{err: errors.New("network down")}}
+	req, err := http.NewRequest(http.MethodGet, s.server.URL+"/other", nil)
+	c.Assert(err, qt.IsNil)
+	_, result, err = tp.RoundTripWithResult(req)
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(result.Key, qt.Equals, s.server.URL+"/other")
+}
@@ -0,0 +1,117 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// defaultUpstreamRetryBackoff is the default value of Transport.UpstreamRetryBackoff.
+const defaultUpstreamRetryBackoff = 100 * time.Millisecond
+
+// DefaultRetryUpstream is the default value of Transport.RetryUpstream: it retries
+// the GET, HEAD and OPTIONS requests failing with a transport error, but those caused
+// by the request context, or with a 502 Bad Gateway, 503 Service Unavailable or
+// 504 Gateway Timeout response. Requests with other methods are not sent again,
+// as the server may have applied them already, but those with one of the
+// Transport.CacheableMethods.
+func DefaultRetryUpstream(req *http.Request, resp *http.Response, err error) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return retryableFailure(req, resp, err)
+	}
+	return false
+}
+
+// retryableFailure reports whether the failure of req, with the response resp or
+// the error err, is worth retrying, see DefaultRetryUpstream.
+func retryableFailure(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// defaultRetryUpstream is DefaultRetryUpstream, also retrying the requests with
+// one of the CacheableMethods, which are expected not to change anything.
+func (t *Transport) defaultRetryUpstream(req *http.Request, resp *http.Response, err error) bool {
+	for _, m := range t.CacheableMethods {
+		if m == req.Method {
+			return retryableFailure(req, resp, err)
+		}
+	}
+	return DefaultRetryUpstream(req, resp, err)
+}
+
+// retryTransport is a http.RoundTripper retrying the failed requests sent
+// to the servers, see Transport.UpstreamRetries.
+type retryTransport struct {
+	http.RoundTripper
+	t *Transport
+}
+
+func (rt retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := rt.t
+	retryable := t.RetryUpstream
+	if retryable == nil {
+		retryable = t.defaultRetryUpstream
+	}
+	backoff := t.UpstreamRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultUpstreamRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := rt.RoundTripper.RoundTrip(req)
+		if attempt >= t.UpstreamRetries || !retryable(req, resp, err) {
+			return resp, err
+		}
+		next, ok := rewindRequest(req)
+		if !ok {
+			return resp, err
+		}
+		if err == nil {
+			t.debug(req, "httpcache: retry", "attempt", attempt+1, "status", resp.StatusCode)
+			resp.Body.Close()
+		} else {
+			t.debug(req, "httpcache: retry", "attempt", attempt+1, "error", err)
+		}
+		if !sleepContext(req.Context(), backoff<<attempt) {
+			return nil, req.Context().Err()
+		}
+		req = next
+	}
+}
+
+// rewindRequest returns a copy of req to send again, with a new body,
+// reporting false if its body can't be read again.
+func rewindRequest(req *http.Request) (*http.Request, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	next := req.Clone(req.Context())
+	next.Body = body
+	return next, true
+}
+
+// sleepContext waits for d, reporting false if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
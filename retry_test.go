@@ -0,0 +1,99 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestUpstreamRetries(t *testing.T) {
+	c := qt.New(t)
+	var (
+		requests atomic.Int32
+		failures atomic.Int32
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60, stale-if-error=3600")
+		w.Write(append([]byte("ok"), body...))
+	}))
+	defer ts.Close()
+
+	clk := &offsetClock{}
+	tp := &Transport{Cache: newMemoryCache(), Clock: clk, UpstreamRetries: 2, UpstreamRetryBackoff: time.Millisecond}
+	do := func(method, body string) (*http.Response, string) {
+		req, err := http.NewRequest(method, ts.URL, strings.NewReader(body))
+		c.Assert(err, qt.IsNil)
+		resp, err := (&http.Client{Transport: tp}).Do(req)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		return resp, string(b)
+	}
+
+	failures.Store(2)
+	resp, b := do(http.MethodGet, "")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(b, qt.Equals, "ok")
+	c.Assert(requests.Load(), qt.Equals, int32(3))
+
+	// The retries are exhausted before serving the stale response.
+	requests.Store(0)
+	failures.Store(10)
+	clk.offset = 2 * time.Minute
+	resp, _ = do(http.MethodGet, "")
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusStale)
+	c.Assert(requests.Load(), qt.Equals, int32(3))
+
+	// Not replayed, the server may have applied it.
+	requests.Store(0)
+	failures.Store(1)
+	resp, _ = do(http.MethodPost, "body")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusServiceUnavailable)
+	c.Assert(requests.Load(), qt.Equals, int32(1))
+
+	// Unless cacheable, the body is sent again.
+	tp.CacheableMethods = []string{http.MethodPost}
+	requests.Store(0)
+	failures.Store(1)
+	resp, b = do(http.MethodPost, "body")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(b, qt.Equals, "okbody")
+	c.Assert(requests.Load(), qt.Equals, int32(2))
+
+	// A custom classifier.
+	requests.Store(0)
+	failures.Store(1)
+	tp.RetryUpstream = func(req *http.Request, resp *http.Response, err error) bool { return false }
+	resp, _ = do(http.MethodPut, "")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusServiceUnavailable)
+	c.Assert(requests.Load(), qt.Equals, int32(1))
+}
+
+func TestDefaultRetryUpstream(t *testing.T) {
+	c := qt.New(t)
+	req, _ := http.NewRequest(http.MethodGet, "http://example.org", nil)
+	c.Assert(DefaultRetryUpstream(req, nil, errors.New("connection reset")), qt.IsTrue)
+	c.Assert(DefaultRetryUpstream(req, &http.Response{StatusCode: http.StatusBadGateway}, nil), qt.IsTrue)
+	c.Assert(DefaultRetryUpstream(req, &http.Response{StatusCode: http.StatusInternalServerError}, nil), qt.IsFalse)
+	c.Assert(DefaultRetryUpstream(req, &http.Response{StatusCode: http.StatusNotFound}, nil), qt.IsFalse)
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		req, _ := http.NewRequest(method, "http://example.org", nil)
+		c.Assert(DefaultRetryUpstream(req, nil, errors.New("connection reset")), qt.IsFalse)
+		c.Assert(DefaultRetryUpstream(req, &http.Response{StatusCode: http.StatusBadGateway}, nil), qt.IsFalse)
+	}
+}
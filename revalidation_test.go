@@ -0,0 +1,71 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestExpiresInThePastWithValidator(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var (
+		etag     atomic.Value
+		requests atomic.Int32
+	)
+	etag.Store(`"v1"`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		current := etag.Load().(string)
+		w.Header().Set("Expires", "Thu, 01 Jan 1970 00:00:00 GMT")
+		w.Header().Set("Etag", current)
+		if r.Header.Get("if-none-match") == current {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(current))
+	}))
+	defer ts.Close()
+
+	counters := &Counters{}
+	tp := newMemoryCacheTransport()
+	tp.Stats = counters
+	client := http.Client{Transport: tp}
+	get := func() (string, CacheStatus) {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	body, status := get()
+	c.Assert(body, qt.Equals, `"v1"`)
+	c.Assert(status, qt.Equals, StatusMiss)
+
+	for i := 0; i < 3; i++ {
+		body, status = get()
+		c.Assert(body, qt.Equals, `"v1"`)
+		c.Assert(status, qt.Equals, StatusRevalidated)
+	}
+
+	etag.Store(`"v2"`)
+	body, status = get()
+	c.Assert(body, qt.Equals, `"v2"`)
+	c.Assert(status, qt.Equals, StatusMiss)
+	body, status = get()
+	c.Assert(body, qt.Equals, `"v2"`)
+	c.Assert(status, qt.Equals, StatusRevalidated)
+
+	c.Assert(requests.Load(), qt.Equals, int32(6))
+	v := counters.Values()
+	c.Assert(v.RevalidatedNotModified, qt.Equals, uint64(4))
+	c.Assert(v.RevalidatedModified, qt.Equals, uint64(1))
+}
@@ -0,0 +1,483 @@
+// Package s3cache provides an implementation of httpcache.Cache that stores
+// responses as objects in a bucket of an S3-compatible object store, e.g.
+// Amazon S3, Google Cloud Storage (with HMAC keys), Cloudflare R2 or MinIO,
+// so that several machines, e.g. CI runners, can share a cache without
+// running a server of their own.
+//
+// Requests are signed with AWS Signature Version 4 and use path-style URLs,
+// <Endpoint>/<Bucket>/<object>. The object name of a key is Options.Prefix
+// followed by the path-escaped key.
+//
+// Expiry set with SetWithTTL is stored in the object metadata, and expired
+// objects are treated as missing. They are not removed from the bucket:
+// configure a lifecycle rule for the prefix to clean them up.
+//
+// With Options.ConditionalWrites, entries are written with conditional PUTs, so a
+// machine doesn't replace an entry stored by another one since it last read it.
+// The leases of AcquireLease always use conditional PUTs, so a stale response
+// is refreshed by one machine at a time. ReadRange reads a part of a stored
+// response with a ranged GET.
+package s3cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxObjectName is the maximum length in bytes of an object name.
+const maxObjectName = 1024
+
+// leasePrefix is the object name prefix of leases, below Options.Prefix.
+// Escaped keys never contain '/', so this doesn't clash with entries.
+const leasePrefix = "leases/"
+
+// maxVersions is the maximum number of ETags of entries remembered for
+// the conditional writes, see Options.ConditionalWrites.
+const maxVersions = 10000
+
+// headerExpires is the object metadata header holding the expiry time of an
+// object, in Unix seconds.
+const headerExpires = "X-Amz-Meta-Httpcache-Expires"
+
+// Options configures a Cache.
+type Options struct {
+	// Endpoint is the base URL of the object store,
+	// e.g. "https://s3.eu-west-1.amazonaws.com" or "http://localhost:9000".
+	Endpoint string
+
+	// Bucket is the name of the bucket.
+	Bucket string
+
+	// Prefix is prepended to the object names, e.g. "httpcache/".
+	Prefix string
+
+	// Region is the region of the bucket, used for signing.
+	// Default is "us-east-1".
+	Region string
+
+	// AccessKeyID and SecretAccessKey are the credentials used to sign requests.
+	// If AccessKeyID is empty, requests are not signed.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// SessionToken is the optional session token of temporary credentials.
+	SessionToken string
+
+	// Client is the client used to send requests.
+	// Default is http.DefaultClient.
+	Client *http.Client
+
+	// ConditionalWrites, if set, makes the writes of an entry conditional, with
+	// If-Match or If-None-Match, on the entry not having changed since the Cache
+	// last read or wrote it, so a machine doesn't replace the response stored by
+	// another one in the meantime with an older one: its write is dropped.
+	// The object store must support conditional writes, as Amazon S3,
+	// Cloudflare R2 and MinIO do.
+	ConditionalWrites bool
+}
+
+// Cache is an implementation of httpcache.Cache, httpcache.CacheContext,
+// httpcache.CacheWithExpiryContext, httpcache.IterableCache and httpcache.LeaseCache
+// that stores responses in an S3-compatible bucket.
+// It is safe for concurrent use.
+type Cache struct {
+	base   string // <Endpoint>/<Bucket>/
+	prefix string
+	client *http.Client
+	signer signer
+
+	conditional bool
+	mu          sync.Mutex
+	versions    map[string]string // ETag of the entries last seen, "" if missing.
+
+	now func() time.Time
+}
+
+// New returns a new Cache with the given options.
+func New(opts Options) (*Cache, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, errors.New("s3cache: Endpoint and Bucket must be set")
+	}
+	if _, err := url.Parse(opts.Endpoint); err != nil {
+		return nil, fmt.Errorf("s3cache: invalid Endpoint: %w", err)
+	}
+	region := opts.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Cache{
+		base:   strings.TrimSuffix(opts.Endpoint, "/") + "/" + opts.Bucket + "/",
+		prefix: opts.Prefix,
+		client: client,
+		signer: signer{
+			accessKeyID:     opts.AccessKeyID,
+			secretAccessKey: opts.SecretAccessKey,
+			sessionToken:    opts.SessionToken,
+			region:          region,
+		},
+		conditional: opts.ConditionalWrites,
+		versions:    make(map[string]string),
+		now:         time.Now,
+	}, nil
+}
+
+// Get returns the response stored for key, if any.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	b, ok, err := c.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return b, ok
+}
+
+// Set stores the response b for key.
+func (c *Cache) Set(key string, b []byte) {
+	c.SetContext(context.Background(), key, b)
+}
+
+// Delete removes the response stored for key.
+func (c *Cache) Delete(key string) {
+	c.DeleteContext(context.Background(), key)
+}
+
+// GetContext returns the response stored for key, if any.
+func (c *Cache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	name, err := c.objectName(key)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := c.do(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		c.seen(key, "")
+		return nil, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, statusError(resp)
+	}
+	c.seen(key, resp.Header.Get("ETag"))
+	if c.expired(resp.Header) {
+		return nil, false, nil
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// SetContext stores the response b for key.
+func (c *Cache) SetContext(ctx context.Context, key string, b []byte) error {
+	return c.SetWithTTLContext(ctx, key, b, 0)
+}
+
+// SetWithTTL stores the response b for key, to be treated as missing after ttl.
+func (c *Cache) SetWithTTL(key string, b []byte, ttl time.Duration) {
+	c.SetWithTTLContext(context.Background(), key, b, ttl)
+}
+
+// SetWithTTLContext stores the response b for key, to be treated as missing after ttl.
+func (c *Cache) SetWithTTLContext(ctx context.Context, key string, b []byte, ttl time.Duration) error {
+	name, err := c.objectName(key)
+	if err != nil {
+		return err
+	}
+	var h http.Header
+	if c.conditional {
+		c.mu.Lock()
+		etag, ok := c.versions[key]
+		c.mu.Unlock()
+		switch {
+		case ok && etag == "":
+			h = http.Header{"If-None-Match": {"*"}}
+		case ok:
+			h = http.Header{"If-Match": {etag}}
+		}
+	}
+	etag, ok, err := c.put(ctx, name, b, ttl, h)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Stored by someone else since, it's kept. Unknown until read again.
+		c.mu.Lock()
+		delete(c.versions, key)
+		c.mu.Unlock()
+		return nil
+	}
+	c.seen(key, etag)
+	return nil
+}
+
+// seen records the ETag of the entry for key, "" if there is none,
+// for the conditional writes.
+func (c *Cache) seen(key, etag string) {
+	if !c.conditional {
+		return
+	}
+	c.mu.Lock()
+	if len(c.versions) >= maxVersions {
+		c.versions = make(map[string]string)
+	}
+	c.versions[key] = etag
+	c.mu.Unlock()
+}
+
+// DeleteContext removes the response stored for key.
+func (c *Cache) DeleteContext(ctx context.Context, key string) error {
+	name, err := c.objectName(key)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return statusError(resp)
+	}
+	c.seen(key, "")
+	return nil
+}
+
+// ReadRange returns length bytes of the response stored for key, starting at offset,
+// using a ranged GET. If length is negative, the rest of the response is returned.
+// It returns false if there is no response for key, and fewer bytes than
+// requested if the response is shorter.
+func (c *Cache) ReadRange(ctx context.Context, key string, offset, length int64) ([]byte, bool, error) {
+	if offset < 0 {
+		return nil, false, errors.New("s3cache: negative offset")
+	}
+	if length == 0 {
+		return nil, false, errors.New("s3cache: empty range")
+	}
+	name, err := c.objectName(key)
+	if err != nil {
+		return nil, false, err
+	}
+	rng := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if length > 0 {
+		rng += strconv.FormatInt(offset+length-1, 10)
+	}
+	resp, err := c.do(ctx, http.MethodGet, name, nil, http.Header{"Range": {rng}})
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, nil
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// offset is beyond the end of the response.
+		return nil, true, nil
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent:
+		return nil, false, statusError(resp)
+	case c.expired(resp.Header):
+		return nil, false, nil
+	}
+	r := io.Reader(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		// The store ignored the range.
+		if _, err := io.CopyN(io.Discard, r, offset); err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		if length > 0 {
+			r = io.LimitReader(r, length)
+		}
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Keys calls fn for every key starting with prefix, in the order listed by
+// the object store, until fn returns false.
+func (c *Cache) Keys(prefix string, fn func(key string) bool) {
+	c.KeysContext(context.Background(), prefix, fn)
+}
+
+// KeysContext is like Keys, but also returns the error of a failed listing.
+func (c *Cache) KeysContext(ctx context.Context, prefix string, fn func(key string) bool) error {
+	var token string
+	for {
+		q := url.Values{
+			"list-type": {"2"},
+			"prefix":    {c.prefix + url.PathEscape(prefix)},
+			"delimiter": {"/"},
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := c.do(ctx, http.MethodGet, "?"+q.Encode(), nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := statusError(resp)
+			resp.Body.Close()
+			return err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3cache: invalid listing: %w", err)
+		}
+		for _, obj := range result.Contents {
+			key, err := url.PathUnescape(strings.TrimPrefix(obj.Key, c.prefix))
+			if err != nil {
+				// Not written by a Cache.
+				continue
+			}
+			if !fn(key) {
+				return nil
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// listBucketResult is the response body of ListObjectsV2.
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// AcquireLease implements httpcache.LeaseCache using conditional PUTs,
+// so only one of the machines sharing the bucket acquires a lease at a time.
+// A lease held by someone else is taken over when it has expired.
+func (c *Cache) AcquireLease(key string, ttl time.Duration) bool {
+	ctx := context.Background()
+	name := c.prefix + leasePrefix + url.PathEscape(key)
+	if len(name) > maxObjectName {
+		return false
+	}
+	_, ok, err := c.put(ctx, name, nil, ttl, http.Header{"If-None-Match": {"*"}})
+	if ok || err != nil {
+		return ok
+	}
+
+	// Held by someone else: take it over if it has expired, unless someone
+	// else does first.
+	resp, err := c.do(ctx, http.MethodHead, name, nil, nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || !c.expired(resp.Header) {
+		return false
+	}
+	_, ok, _ = c.put(ctx, name, nil, ttl, http.Header{"If-Match": {etag}})
+	return ok
+}
+
+// put stores b as the object name, to expire after ttl if positive, returning
+// the ETag of the object. The conditional headers in h, if any, are sent along,
+// and put returns false without error if the object store rejects the condition.
+func (c *Cache) put(ctx context.Context, name string, b []byte, ttl time.Duration, h http.Header) (string, bool, error) {
+	if h == nil {
+		h = make(http.Header)
+	}
+	h.Set("Content-Type", "application/octet-stream")
+	if ttl > 0 {
+		h.Set(headerExpires, strconv.FormatInt(c.now().Add(ttl).Unix(), 10))
+	}
+	resp, err := c.do(ctx, http.MethodPut, name, b, h)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return resp.Header.Get("ETag"), true, nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// 409 is returned for concurrent conditional writes to the same object.
+		return "", false, nil
+	default:
+		return "", false, statusError(resp)
+	}
+}
+
+// expired reports whether the object with the response headers h has expired.
+func (c *Cache) expired(h http.Header) bool {
+	v := h.Get(headerExpires)
+	if v == "" {
+		return false
+	}
+	expires, err := strconv.ParseInt(v, 10, 64)
+	return err == nil && c.now().Unix() >= expires
+}
+
+// objectName returns the object name for key.
+func (c *Cache) objectName(key string) (string, error) {
+	name := c.prefix + url.PathEscape(key)
+	if len(name) > maxObjectName {
+		return "", fmt.Errorf("s3cache: key too long: %.64q...", key)
+	}
+	return name, nil
+}
+
+// do sends a signed request with method for the object name, or for the
+// bucket if name starts with '?'.
+func (c *Cache) do(ctx context.Context, method, name string, b []byte, h http.Header) (*http.Response, error) {
+	rawURL := c.base + uriEncode(name, false)
+	if name, query, ok := strings.Cut(name, "?"); ok {
+		rawURL = c.base + uriEncode(name, false) + "?" + query
+	}
+	var body io.Reader
+	if b != nil {
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vv := range h {
+		req.Header[k] = vv
+	}
+	payloadHash := emptyPayloadHash
+	if len(b) > 0 {
+		payloadHash = hexSHA256(b)
+	}
+	c.signer.sign(req, payloadHash, c.now())
+	return c.client.Do(req)
+}
+
+// statusError returns the error for the unexpected response resp.
+func statusError(resp *http.Response) error {
+	var body struct {
+		Code    string
+		Message string
+	}
+	if xml.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body) == nil && body.Code != "" {
+		return fmt.Errorf("s3cache: %s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, body.Code, body.Message)
+	}
+	return fmt.Errorf("s3cache: %s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
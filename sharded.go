@@ -0,0 +1,198 @@
+package httpcache
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// shardReplicas is the number of points of each backend on the hash ring of a
+// ShardedCache, so keys spread evenly and adding a backend moves few of them.
+const shardReplicas = 160
+
+// ShardedCache is a Cache distributing keys across several backends (e.g. Redis
+// or memcached instances) by consistent hashing, so a cache too large for one
+// backend can scale horizontally.
+//
+// A given key always goes to the same backend, and adding or removing a backend
+// only moves about 1/n of the keys to other backends.
+//
+// The SetWithTTL and UpdateEntry calls are forwarded to the backends implementing
+// CacheWithExpiry and EntryUpdater: the entries of the other backends don't expire,
+// and are not updated in place. The metadata of the entries is never stored on its
+// own, even if the backends implement MetadataCache.
+type ShardedCache struct {
+	backends []Cache
+	adapted  []CacheContext
+	ring     []shardPoint // sorted by hash.
+}
+
+var (
+	_ CacheContext           = (*ShardedCache)(nil)
+	_ CacheWithExpiryContext = (*ShardedCache)(nil)
+	_ EntryUpdater           = (*ShardedCache)(nil)
+	_ IterableCache          = (*ShardedCache)(nil)
+	_ LeaseCache             = (*ShardedCache)(nil)
+)
+
+// shardPoint is a point of a backend on the hash ring.
+type shardPoint struct {
+	hash    uint64
+	backend int
+}
+
+// NewShardedCache returns a new ShardedCache distributing keys across backends,
+// keyed by a name identifying them, e.g. the address of the server. Keys only
+// depend on the names, so the backends are looked up in the same way by all the
+// processes given the same names, and keep their keys as others are added or removed.
+// It panics if backends is empty.
+func NewShardedCache(backends map[string]Cache) *ShardedCache {
+	if len(backends) == 0 {
+		panic("httpcache: NewShardedCache needs at least one backend")
+	}
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c := &ShardedCache{
+		backends: make([]Cache, len(names)),
+		adapted:  make([]CacheContext, len(names)),
+		ring:     make([]shardPoint, 0, len(names)*shardReplicas),
+	}
+	for i, name := range names {
+		c.backends[i] = backends[name]
+		c.adapted[i] = AdaptCache(backends[name])
+		for j := 0; j < shardReplicas; j++ {
+			c.ring = append(c.ring, shardPoint{hash: shardHash(name + "-" + strconv.Itoa(j)), backend: i})
+		}
+	}
+	// Ties broken by index, for an order not depending on the sort algorithm.
+	sort.Slice(c.ring, func(i, j int) bool {
+		if c.ring[i].hash != c.ring[j].hash {
+			return c.ring[i].hash < c.ring[j].hash
+		}
+		return c.ring[i].backend < c.ring[j].backend
+	})
+	return c
+}
+
+func shardHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// FNV spreads similar short strings poorly, mix the bits (MurmurHash3's fmix64).
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// shard returns the index of the backend for key: the owner of
+// the first point of the ring at or after the hash of key.
+func (c *ShardedCache) shard(key string) int {
+	hash := shardHash(key)
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= hash })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.ring[i].backend
+}
+
+// Backend returns the backend storing the response for key.
+func (c *ShardedCache) Backend(key string) Cache {
+	return c.backends[c.shard(key)]
+}
+
+// Get returns the response stored for key, if any.
+func (c *ShardedCache) Get(key string) ([]byte, bool) {
+	return c.Backend(key).Get(key)
+}
+
+// Set stores the response b for key.
+func (c *ShardedCache) Set(key string, b []byte) {
+	c.Backend(key).Set(key, b)
+}
+
+// Delete removes the response stored for key.
+func (c *ShardedCache) Delete(key string) {
+	c.Backend(key).Delete(key)
+}
+
+// GetContext returns the response stored for key, if any.
+func (c *ShardedCache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	return c.adapted[c.shard(key)].GetContext(ctx, key)
+}
+
+// SetContext stores the response b for key.
+func (c *ShardedCache) SetContext(ctx context.Context, key string, b []byte) error {
+	return c.adapted[c.shard(key)].SetContext(ctx, key, b)
+}
+
+// DeleteContext removes the response stored for key.
+func (c *ShardedCache) DeleteContext(ctx context.Context, key string) error {
+	return c.adapted[c.shard(key)].DeleteContext(ctx, key)
+}
+
+// SetWithTTL stores the response b for key, to expire after ttl
+// if its backend implements CacheWithExpiry.
+func (c *ShardedCache) SetWithTTL(key string, b []byte, ttl time.Duration) {
+	c.SetWithTTLContext(context.Background(), key, b, ttl)
+}
+
+// SetWithTTLContext stores the response b for key, to expire after ttl
+// if its backend implements CacheWithExpiry.
+func (c *ShardedCache) SetWithTTLContext(ctx context.Context, key string, b []byte, ttl time.Duration) error {
+	i := c.shard(key)
+	switch backend := c.backends[i].(type) {
+	case CacheWithExpiryContext:
+		return backend.SetWithTTLContext(ctx, key, b, ttl)
+	case CacheWithExpiry:
+		backend.SetWithTTL(key, b, ttl)
+		return nil
+	}
+	return c.adapted[i].SetContext(ctx, key, b)
+}
+
+// UpdateEntry implements EntryUpdater, updating the value stored for key
+// on its backend. It reports false if the backend is not an EntryUpdater.
+func (c *ShardedCache) UpdateEntry(key string, update func(value []byte) []byte) bool {
+	if eu, ok := c.Backend(key).(EntryUpdater); ok {
+		return eu.UpdateEntry(key, update)
+	}
+	return false
+}
+
+// AcquireLease implements LeaseCache, acquiring the lease on the backend for key.
+// Backends not implementing LeaseCache always grant it.
+func (c *ShardedCache) AcquireLease(key string, ttl time.Duration) bool {
+	if lc, ok := c.Backend(key).(LeaseCache); ok {
+		return lc.AcquireLease(key, ttl)
+	}
+	return true
+}
+
+// Keys calls fn for every key starting with prefix, in sorted order,
+// until fn returns false.
+// Only the keys of the backends implementing IterableCache are listed.
+func (c *ShardedCache) Keys(prefix string, fn func(key string) bool) {
+	var keys []string
+	for _, b := range c.backends {
+		if ic, ok := b.(IterableCache); ok {
+			ic.Keys(prefix, func(key string) bool {
+				keys = append(keys, key)
+				return true
+			})
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn(key) {
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestShardedCache(t *testing.T) {
+	c := qt.New(t)
+	backends := []*memoryCache{newMemoryCache(), newMemoryCache(), newMemoryCache()}
+	cache := NewShardedCache(map[string]Cache{"a": backends[0], "b": backends[1], "c": backends[2]})
+
+	const n = 3000
+	for i := 0; i < n; i++ {
+		cache.Set(fmt.Sprint("key", i), []byte(fmt.Sprint(i)))
+	}
+	for i, b := range backends {
+		// Evenly spread.
+		c.Assert(b.Size() > n/5, qt.IsTrue, qt.Commentf("backend %d has %d keys", i, b.Size()))
+	}
+	b, ok := cache.Get("key42")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "42")
+	_, ok = cache.Backend("key42").Get("key42")
+	c.Assert(ok, qt.IsTrue)
+
+	var keys []string
+	cache.Keys("key100", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, qt.DeepEquals, []string{"key100", "key1000", "key1001", "key1002", "key1003", "key1004", "key1005", "key1006", "key1007", "key1008", "key1009"})
+
+	cache.Delete("key42")
+	_, ok = cache.Get("key42")
+	c.Assert(ok, qt.IsFalse)
+
+	// Adding a backend moves about a quarter of the keys, all to the new backend.
+	added := newMemoryCache()
+	grown := NewShardedCache(map[string]Cache{"a": backends[0], "b": backends[1], "c": backends[2], "d": added})
+	var moved int
+	for i := 0; i < n; i++ {
+		key := fmt.Sprint("key", i)
+		if grown.Backend(key) != cache.Backend(key) {
+			c.Assert(grown.Backend(key), qt.Equals, Cache(added))
+			moved++
+		}
+	}
+	c.Assert(moved > n/8 && moved < n/2, qt.IsTrue, qt.Commentf("moved %d keys", moved))
+
+	// Removing a backend only moves its keys, whatever its position.
+	shrunk := NewShardedCache(map[string]Cache{"a": backends[0], "c": backends[2]})
+	for i := 0; i < n; i++ {
+		key := fmt.Sprint("key", i)
+		if b := cache.Backend(key); b != Cache(backends[1]) {
+			c.Assert(shrunk.Backend(key), qt.Equals, b)
+		}
+	}
+}
+
+func TestShardedCacheForwarding(t *testing.T) {
+	c := qt.New(t)
+	expiring := &expiringCache{memoryCache: newMemoryCache(), ttls: map[string]time.Duration{}}
+	updating := &updatingCache{memoryCache: newMemoryCache()}
+	cache := NewShardedCache(map[string]Cache{"expiring": expiring, "updating": updating})
+	var expiringKey, updatingKey string
+	for i := 0; expiringKey == "" || updatingKey == ""; i++ {
+		key := fmt.Sprint("key", i)
+		if cache.Backend(key) == Cache(expiring) {
+			expiringKey = key
+		} else {
+			updatingKey = key
+		}
+	}
+
+	cache.SetWithTTL(expiringKey, []byte("a"), time.Minute)
+	c.Assert(expiring.ttls[expiringKey], qt.Equals, time.Minute)
+	c.Assert(cache.SetWithTTLContext(context.Background(), updatingKey, []byte("b"), time.Minute), qt.IsNil)
+	b, ok := updating.Get(updatingKey)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "b")
+
+	c.Assert(cache.UpdateEntry(updatingKey, func([]byte) []byte { return []byte("c") }), qt.IsTrue)
+	b, _ = updating.Get(updatingKey)
+	c.Assert(string(b), qt.Equals, "c")
+	c.Assert(cache.UpdateEntry(expiringKey, func([]byte) []byte { return []byte("c") }), qt.IsFalse)
+}
+
+func TestShardedCacheTransport(t *testing.T) {
+	c := qt.New(t)
+	tp := &Transport{Cache: NewShardedCache(map[string]Cache{"a": newMemoryCache(), "b": newMemoryCache()})}
+	client := http.Client{Transport: tp}
+
+	for _, want := range []CacheStatus{StatusMiss, StatusHit} {
+		resp, err := client.Get(s.server.URL + "/method")
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		c.Assert(status, qt.Equals, want)
+	}
+}
@@ -0,0 +1,78 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestShared(t *testing.T) {
+	clk := &offsetClock{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.Header().Set("Date", clk.Now().UTC().Format(http.TimeFormat))
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name          string
+		cacheControl  string
+		authorization string
+		offset        time.Duration
+		private       CacheStatus
+		shared        CacheStatus
+	}{
+		{"s-maxage longer", "max-age=60, s-maxage=3600", "", 30 * time.Minute, StatusMiss, StatusHit},
+		{"s-maxage shorter", "max-age=3600, s-maxage=60", "", 30 * time.Minute, StatusHit, StatusMiss},
+		{"private", "private, max-age=3600", "", 0, StatusHit, StatusMiss},
+		{"private header names", `private="Set-Cookie", max-age=3600`, "", 0, StatusHit, StatusHit},
+		{"authorization", "max-age=3600", "Bearer x", 0, StatusHit, StatusMiss},
+		{"authorization public", "public, max-age=3600", "Bearer x", 0, StatusHit, StatusHit},
+		{"authorization s-maxage", "s-maxage=3600", "Bearer x", 0, StatusMiss, StatusHit},
+		{"authorization must-revalidate", "must-revalidate, max-age=3600", "Bearer x", 0, StatusHit, StatusHit},
+	} {
+		for _, shared := range []bool{false, true} {
+			c := qt.New(t)
+			clk.offset = 0
+			tp := &Transport{Cache: newMemoryCache(), Clock: clk, Shared: shared}
+			get := func() CacheStatus {
+				req, err := http.NewRequest(http.MethodGet, ts.URL+"?cc="+url.QueryEscape(test.cacheControl), nil)
+				c.Assert(err, qt.IsNil)
+				if test.authorization != "" {
+					req.Header.Set("Authorization", test.authorization)
+				}
+				resp, err := (&http.Client{Transport: tp}).Do(req)
+				c.Assert(err, qt.IsNil)
+				_, err = io.ReadAll(resp.Body)
+				c.Assert(err, qt.IsNil)
+				resp.Body.Close()
+				status, _ := ResponseCacheStatus(resp)
+				return status
+			}
+			c.Assert(get(), qt.Equals, StatusMiss)
+			clk.offset = test.offset
+			want := test.private
+			if shared {
+				want = test.shared
+			}
+			c.Assert(get(), qt.Equals, want, qt.Commentf("%s, shared: %t", test.name, shared))
+		}
+	}
+}
+
+func TestSharedProxyRevalidate(t *testing.T) {
+	c := qt.New(t)
+	respHeaders := http.Header{}
+	respHeaders.Set("Date", time.Now().Add(-2*time.Minute).UTC().Format(http.TimeFormat))
+	respHeaders.Set("Cache-Control", "max-age=60, proxy-revalidate")
+	reqHeaders := http.Header{}
+	reqHeaders.Set("Cache-Control", "max-stale")
+	c.Assert(getFreshnessWithDefault(respHeaders, reqHeaders, 0, false, clock), qt.Equals, fresh)
+	c.Assert(getFreshnessWithDefault(respHeaders, reqHeaders, 0, true, clock), qt.Equals, stale)
+}
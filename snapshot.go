@@ -0,0 +1,39 @@
+package httpcache
+
+import "expvar"
+
+// Stats is a snapshot of the cumulative counts of the decisions made by
+// a Transport, see Transport.Snapshot.
+type Stats struct {
+	CounterValues
+
+	// HitRatio is the ratio of the requests answered from the cache, fresh,
+	// stale or revalidated with a 304 Not Modified, to all the requests counted,
+	// 0 if there are none.
+	HitRatio float64 `json:"hit_ratio"`
+
+	// AverageEntrySize is the average size in bytes of the responses stored,
+	// 0 if there are none.
+	AverageEntrySize float64 `json:"average_entry_size"`
+}
+
+// Snapshot returns the counts of the decisions made by t since it was created,
+// whether Stats is set or not. A Transport returned by Clone starts from zero.
+func (t *Transport) Snapshot() Stats {
+	s := Stats{CounterValues: t.counters.Values()}
+	cached := s.Hits + s.StaleServed + s.RevalidatedNotModified
+	if total := cached + s.Misses; total > 0 {
+		s.HitRatio = float64(cached) / float64(total)
+	}
+	if s.Stores > 0 {
+		s.AverageEntrySize = float64(s.StoredBytes) / float64(s.Stores)
+	}
+	return s
+}
+
+// PublishExpvar publishes the Snapshot of t as the expvar variable name,
+// served as JSON by the expvar handler, e.g. at /debug/vars.
+// As with expvar.Publish, it panics if name is already in use.
+func (t *Transport) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return t.Snapshot() }))
+}
@@ -0,0 +1,46 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestSnapshot(t *testing.T) {
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	c.Assert(tp.Snapshot(), qt.DeepEquals, Stats{})
+
+	client := http.Client{Transport: tp}
+	for _, path := range []string{"/a", "/a", "/a", "/b"} {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	s := tp.Snapshot()
+	c.Assert(s.Hits, qt.Equals, uint64(2))
+	c.Assert(s.Misses, qt.Equals, uint64(2))
+	c.Assert(s.Stores, qt.Equals, uint64(2))
+	c.Assert(s.BytesServed, qt.Equals, uint64(14))
+	c.Assert(s.HitRatio, qt.Equals, 0.5)
+	c.Assert(s.AverageEntrySize, qt.Equals, float64(s.StoredBytes)/2)
+
+	tp.PublishExpvar("httpcache_test_snapshot")
+	var published Stats
+	c.Assert(json.Unmarshal([]byte(expvar.Get("httpcache_test_snapshot").String()), &published), qt.IsNil)
+	c.Assert(published, qt.DeepEquals, s)
+}
@@ -0,0 +1,38 @@
+package httpcache
+
+import "net/http"
+
+// serveStaleOnError reports whether the stale cached response with the headers
+// respHeaders may be served when revalidating it fails in ServeStaleOnError mode.
+func (t *Transport) serveStaleOnError(respHeaders http.Header) bool {
+	respCacheControl := parseCacheControl(respHeaders)
+	if !t.staleOnError(respCacheControl) {
+		return false
+	}
+	if t.MaxStaleOnError <= 0 {
+		return true
+	}
+	date, err := date(respHeaders)
+	if err != nil {
+		return false
+	}
+	lifetime := freshnessLifetime(respHeaders, respCacheControl, date, t.defaultLifetime(respHeaders), t.Shared)
+	if extended := extendedLifetime(respHeaders); extended > lifetime {
+		lifetime = extended
+	}
+	return t.timer().since(date)-lifetime <= t.MaxStaleOnError
+}
+
+// staleOnError reports whether a response with the Cache-Control directives
+// respCacheControl is served stale on errors in ServeStaleOnError mode.
+func (t *Transport) staleOnError(respCacheControl cacheControl) bool {
+	if !t.ServeStaleOnError {
+		return false
+	}
+	for _, directive := range []string{"must-revalidate", "proxy-revalidate", "no-cache"} {
+		if _, ok := respCacheControl[directive]; ok {
+			return false
+		}
+	}
+	return true
+}
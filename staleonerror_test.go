@@ -0,0 +1,79 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestServeStaleOnError(t *testing.T) {
+	c := qt.New(t)
+	clk := &offsetClock{}
+	var (
+		failing      bool
+		cacheControl = "max-age=60"
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("error"))
+			return
+		}
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("Date", clk.Now().UTC().Format(http.TimeFormat))
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	tp := &Transport{Cache: newMemoryCache(), Clock: clk, ServeStaleOnError: true, MaxStaleOnError: time.Hour}
+	get := func() (string, CacheStatus) {
+		resp, err := (&http.Client{Transport: tp}).Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	get()
+	failing = true
+	clk.offset = 30 * time.Minute
+	b, status := get()
+	c.Assert(status, qt.Equals, StatusStale)
+	c.Assert(b, qt.Equals, "ok")
+
+	// Transport errors too.
+	tp.Transport = transportMock{err: io.ErrUnexpectedEOF}
+	b, status = get()
+	c.Assert(status, qt.Equals, StatusStale)
+	c.Assert(b, qt.Equals, "ok")
+	tp.Transport = nil
+
+	// Past the cap.
+	clk.offset = 2 * time.Hour
+	b, status = get()
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(b, qt.Equals, "error")
+
+	// must-revalidate is honored.
+	failing, clk.offset, cacheControl = false, 0, "max-age=60, must-revalidate"
+	get()
+	failing = true
+	clk.offset = time.Minute + time.Second
+	_, status = get()
+	c.Assert(status, qt.Equals, StatusMiss)
+
+	// Off by default.
+	failing, clk.offset, cacheControl = false, 0, "max-age=60"
+	tp.ServeStaleOnError = false
+	get()
+	failing = true
+	clk.offset = 2 * time.Minute
+	_, status = get()
+	c.Assert(status, qt.Equals, StatusMiss)
+}
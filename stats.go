@@ -0,0 +1,159 @@
+package httpcache
+
+import (
+	"net/http"
+	"time"
+)
+
+// StatsRecorder is notified of the decisions made by a Transport.
+// Implementations must be safe for concurrent use.
+//
+// Every request handled by RoundTrip is reported as exactly one of a hit,
+// a miss or a stale serve, unless it was revalidated with a 304 Not Modified
+// response, which is reported by Revalidated only.
+type StatsRecorder interface {
+	// Hit is called when a fresh cached response was served.
+	Hit(req *http.Request)
+
+	// Miss is called when the response was fetched from the server,
+	// including when a revalidation of a cached response returned a new one.
+	Miss(req *http.Request)
+
+	// Revalidated is called when a stale cached response was revalidated with the
+	// server, with modified set to false if the server answered 304 Not Modified.
+	Revalidated(req *http.Request, modified bool)
+
+	// StaleServed is called when a stale cached response was served,
+	// because of stale-while-revalidate or stale-if-error.
+	StaleServed(req *http.Request)
+
+	// Stored is called when a response of size bytes was stored in the cache.
+	Stored(req *http.Request, size int)
+
+	// Evicted is called when the cached response for req was removed from the cache,
+	// e.g. because the server no longer returned a cacheable response.
+	Evicted(req *http.Request)
+
+	// UpstreamError is called when the request to the server failed.
+	UpstreamError(req *http.Request, err error)
+}
+
+// BytesServedRecorder is an optional interface implemented by StatsRecorders
+// that want to know the amount of data served from the cache.
+type BytesServedRecorder interface {
+	// ServedFromCache is called when a cached response with a body of size bytes
+	// was served, including stale and revalidated ones.
+	ServedFromCache(req *http.Request, size int64)
+}
+
+// AgeRecorder is an optional interface implemented by StatsRecorders that want
+// to know how fresh the content served from the cache is.
+type AgeRecorder interface {
+	// ServedAge is called when a cached response of the given age, counted
+	// from its Date header, was served without contacting the server,
+	// fresh or stale.
+	ServedAge(req *http.Request, age time.Duration)
+}
+
+type nopStatsRecorder struct{}
+
+func (nopStatsRecorder) Hit(req *http.Request)                        {}
+func (nopStatsRecorder) Miss(req *http.Request)                       {}
+func (nopStatsRecorder) Revalidated(req *http.Request, modified bool) {}
+func (nopStatsRecorder) StaleServed(req *http.Request)                {}
+func (nopStatsRecorder) Stored(req *http.Request, size int)           {}
+func (nopStatsRecorder) Evicted(req *http.Request)                    {}
+func (nopStatsRecorder) UpstreamError(req *http.Request, err error)   {}
+
+// stats returns the StatsRecorder notified of the decisions made by t:
+// its own Counters, see Snapshot, and Stats, if set.
+func (t *Transport) stats() StatsRecorder {
+	if t.Stats == nil {
+		return &t.counters
+	}
+	return statsRecorders{&t.counters, t.Stats}
+}
+
+// statsRecorders is a StatsRecorder notifying all of its elements.
+type statsRecorders []StatsRecorder
+
+func (rs statsRecorders) Hit(req *http.Request) {
+	for _, r := range rs {
+		r.Hit(req)
+	}
+}
+
+func (rs statsRecorders) Miss(req *http.Request) {
+	for _, r := range rs {
+		r.Miss(req)
+	}
+}
+
+func (rs statsRecorders) Revalidated(req *http.Request, modified bool) {
+	for _, r := range rs {
+		r.Revalidated(req, modified)
+	}
+}
+
+func (rs statsRecorders) StaleServed(req *http.Request) {
+	for _, r := range rs {
+		r.StaleServed(req)
+	}
+}
+
+func (rs statsRecorders) Stored(req *http.Request, size int) {
+	for _, r := range rs {
+		r.Stored(req, size)
+	}
+}
+
+func (rs statsRecorders) Evicted(req *http.Request) {
+	for _, r := range rs {
+		r.Evicted(req)
+	}
+}
+
+func (rs statsRecorders) UpstreamError(req *http.Request, err error) {
+	for _, r := range rs {
+		r.UpstreamError(req, err)
+	}
+}
+
+// recordServed reports resp, returned by RoundTrip, to the StatsRecorders
+// if it was served from the cache, with its age if it was served
+// without contacting the server.
+func (t *Transport) recordServed(req *http.Request, resp *http.Response, status CacheStatus) {
+	t.recordServedTo(&t.counters, req, resp, status)
+	if t.Stats != nil {
+		t.recordServedTo(t.Stats, req, resp, status)
+	}
+}
+
+func (t *Transport) recordServedTo(rec StatsRecorder, req *http.Request, resp *http.Response, status CacheStatus) {
+	if r, ok := rec.(AgeRecorder); ok && (status == StatusHit || status == StatusStale) {
+		if d, err := date(resp.Header); err == nil {
+			age := t.timer().since(d)
+			if age < 0 {
+				age = 0
+			}
+			r.ServedAge(req, age)
+		}
+	}
+	r, ok := rec.(BytesServedRecorder)
+	if !ok || status == StatusMiss || resp.ContentLength < 0 || req.Method == http.MethodHead {
+		return
+	}
+	r.ServedFromCache(req, resp.ContentLength)
+}
+
+// recordStatus reports status, the outcome of RoundTrip for req, to the StatsRecorder.
+func (t *Transport) recordStatus(req *http.Request, status CacheStatus) {
+	switch status {
+	case StatusHit:
+		t.stats().Hit(req)
+	case StatusMiss:
+		t.stats().Miss(req)
+	case StatusStale:
+		t.stats().StaleServed(req)
+	}
+}
@@ -0,0 +1,99 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+type countingStats struct {
+	mu     sync.Mutex
+	counts map[string]int
+	bytes  int
+}
+
+func (s *countingStats) inc(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	s.counts[name]++
+}
+
+func (s *countingStats) Hit(req *http.Request)  { s.inc("hit") }
+func (s *countingStats) Miss(req *http.Request) { s.inc("miss") }
+func (s *countingStats) Revalidated(req *http.Request, modified bool) {
+	if modified {
+		s.inc("modified")
+	} else {
+		s.inc("notmodified")
+	}
+}
+func (s *countingStats) StaleServed(req *http.Request) { s.inc("stale") }
+func (s *countingStats) Stored(req *http.Request, size int) {
+	s.inc("stored")
+	s.mu.Lock()
+	s.bytes += size
+	s.mu.Unlock()
+}
+func (s *countingStats) Evicted(req *http.Request)                  { s.inc("evicted") }
+func (s *countingStats) UpstreamError(req *http.Request, err error) { s.inc("error") }
+
+func TestStats(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	stats := &countingStats{}
+	s.transport.Stats = stats
+	defer func() { s.transport.Stats = nil }()
+
+	doMethod(t, "GET", "/", nil)
+	doMethod(t, "GET", "/", nil)
+	doMethod(t, "GET", "/etag", nil)
+	doMethod(t, "GET", "/etag", nil)
+	doMethod(t, "GET", "/nostore", nil)
+
+	c.Assert(stats.counts, qt.DeepEquals, map[string]int{
+		"miss":        3,
+		"hit":         1,
+		"notmodified": 1,
+		"stored":      3, // The revalidated response is stored with its updated headers.
+	})
+	c.Assert(stats.bytes > 0, qt.IsTrue)
+
+	// Upstream errors evict entries without stale-if-error.
+	tp := newMemoryCacheTransport()
+	stats = &countingStats{}
+	tp.Stats = stats
+	tp.Transport = transportMock{response: &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Date":          []string{time.Now().UTC().Format(time.RFC1123)},
+			"Cache-Control": []string{"no-cache"},
+			"Etag":          []string{`"abc"`},
+		},
+		Body: http.NoBody,
+	}}
+	client := http.Client{Transport: tp}
+	req, err := http.NewRequest("GET", "http://example.com/foo", nil)
+	c.Assert(err, qt.IsNil)
+	resp, err := client.Do(req)
+	c.Assert(err, qt.IsNil)
+	_, err = io.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	tp.Transport = transportMock{err: errors.New("network down")}
+	_, err = client.Do(req)
+	c.Assert(err, qt.Not(qt.IsNil))
+	c.Assert(stats.counts, qt.DeepEquals, map[string]int{
+		"miss":    2,
+		"stored":  1,
+		"error":   1,
+		"evicted": 1,
+	})
+}
@@ -0,0 +1,116 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// CacheStatus describes how the Transport produced a response.
+type CacheStatus int
+
+const (
+	// StatusMiss is the status of responses fetched from the server.
+	StatusMiss CacheStatus = iota
+
+	// StatusHit is the status of fresh responses served from the cache
+	// without contacting the server.
+	StatusHit
+
+	// StatusRevalidated is the status of responses served from the cache
+	// after the server confirmed they were still valid (304 Not Modified).
+	StatusRevalidated
+
+	// StatusStale is the status of stale responses served from the cache
+	// without validation, e.g. because of stale-if-error.
+	StatusStale
+)
+
+func (s CacheStatus) String() string {
+	switch s {
+	case StatusMiss:
+		return "miss"
+	case StatusHit:
+		return "hit"
+	case StatusRevalidated:
+		return "revalidated"
+	case StatusStale:
+		return "stale"
+	default:
+		return "unknown"
+	}
+}
+
+type cacheStatusKey struct{}
+
+// ResponseCacheStatus returns the CacheStatus of resp, a response returned by
+// the Transport, and false if resp was not returned by a Transport.
+//
+// Unlike the X-From-Cache header, this also works for Transports with PreserveHeaders set.
+func ResponseCacheStatus(resp *http.Response) (CacheStatus, bool) {
+	if resp == nil || resp.Request == nil {
+		return StatusMiss, false
+	}
+	status, ok := resp.Request.Context().Value(cacheStatusKey{}).(CacheStatus)
+	return status, ok
+}
+
+// withStatus records status in resp so it can be retrieved with ResponseCacheStatus.
+func (t *Transport) withStatus(resp *http.Response, status CacheStatus) *http.Response {
+	req := resp.Request
+	if req == nil {
+		req = &http.Request{}
+	}
+	resp.Request = req.WithContext(context.WithValue(req.Context(), cacheStatusKey{}, status))
+	if t.PreserveHeaders {
+		t.stripInternalHeaders(resp.Header)
+	}
+	return resp
+}
+
+// stripInternalHeaders removes the headers the Transport adds to responses for
+// its own book-keeping.
+func (t *Transport) stripInternalHeaders(h http.Header) {
+	for k := range h {
+		switch {
+		case strings.HasPrefix(k, xEtags):
+			if !t.EnableETagPair {
+				delete(h, k)
+			}
+		case isInternalHeader(k):
+			delete(h, k)
+		}
+	}
+}
+
+// isInternalHeader reports whether the canonical header key k is one of the
+// headers the Transport adds to responses for its own book-keeping.
+func isInternalHeader(k string) bool {
+	switch {
+	case strings.HasPrefix(k, "X-Varied-"), strings.HasPrefix(k, xEtags),
+		k == XFromCache, k == xRequestURL, k == xRequestMethod, k == xAdaptiveTTL,
+		k == xContentSHA256, k == xContentSize, k == xValidation, k == xValidatedAt,
+		k == xRedirectKey, k == xVariant, k == xVariants, k == xPinned, k == xExtendedLifetime, k == xOriginalKey:
+		return true
+	}
+	return false
+}
+
+// upstreamTransport is a http.RoundTripper removing the internal headers from the
+// responses received from the server, so it can't set the book-keeping state of
+// their cache entries, e.g. pin them or extend their lifetime.
+type upstreamTransport struct {
+	http.RoundTripper
+}
+
+func (t upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil && resp.Header != nil {
+		for k := range resp.Header {
+			if isInternalHeader(http.CanonicalHeaderKey(k)) {
+				delete(resp.Header, k)
+			}
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,69 @@
+package httpcache
+
+import (
+	"net/http"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestResponseCacheStatus(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	status := func(resp *http.Response) CacheStatus {
+		c.Helper()
+		status, ok := ResponseCacheStatus(resp)
+		c.Assert(ok, qt.IsTrue)
+		return status
+	}
+
+	_, resp := doMethod(t, "GET", "/method", nil)
+	c.Assert(status(resp), qt.Equals, StatusMiss)
+	_, resp = doMethod(t, "GET", "/method", nil)
+	c.Assert(status(resp), qt.Equals, StatusHit)
+
+	doMethod(t, "GET", "/etag", nil)
+	_, resp = doMethod(t, "GET", "/etag", nil)
+	c.Assert(status(resp), qt.Equals, StatusRevalidated)
+	c.Assert(status(resp).String(), qt.Equals, "revalidated")
+
+	_, ok := ResponseCacheStatus(&http.Response{})
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestPreserveHeaders(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	s.transport.MarkCachedResponses = true
+	s.transport.PreserveHeaders = true
+
+	checkHeaders := func(resp *http.Response) {
+		c.Helper()
+		for k := range resp.Header {
+			switch k {
+			case "Cache-Control", "Content-Length", "Content-Type", "Date", "Vary":
+			default:
+				c.Fatalf("unexpected header %q", k)
+			}
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		_, resp := doMethod(t, "GET", "/varyaccept", map[string]string{"Accept": "text/plain"})
+		checkHeaders(resp)
+		status, _ := ResponseCacheStatus(resp)
+		if i == 0 {
+			c.Assert(status, qt.Equals, StatusMiss)
+		} else {
+			c.Assert(status, qt.Equals, StatusHit)
+		}
+	}
+	c.Assert(cacheSize(), qt.Equals, 1)
+
+	// The book-keeping headers are still stored.
+	b, _ := s.transport.Cache.Get(s.server.URL + "/varyaccept")
+	cachedResp, err := readStoredResponse(b, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(cachedResp.Header.Get("X-Varied-Accept"), qt.Equals, "text/plain")
+}
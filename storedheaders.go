@@ -0,0 +1,58 @@
+package httpcache
+
+import "net/http"
+
+// minimalHeaders are the response headers always stored when Transport.StoreHeaders
+// is set: the validators, the content metadata and the headers driving caching.
+var minimalHeaders = map[string]bool{
+	"Age":              true,
+	"Cache-Control":    true,
+	"Content-Encoding": true,
+	"Content-Language": true,
+	"Content-Length":   true,
+	"Content-Location": true,
+	"Content-Type":     true,
+	"Date":             true,
+	"Etag":             true,
+	"Expires":          true,
+	"Last-Modified":    true,
+	"Location":         true,
+	"Pragma":           true,
+	"Vary":             true,
+}
+
+// filterHeaders reports whether the Transport stores only some of the response headers.
+func (t *Transport) filterHeaders() bool {
+	return t.StoreHeaders != nil || len(t.OmitHeaders) > 0
+}
+
+// storedHeaders returns a copy of h with only the headers to store in the cache,
+// as configured by StoreHeaders and OmitHeaders.
+// The headers the Transport adds for its own book-keeping are always kept.
+func (t *Transport) storedHeaders(h http.Header) http.Header {
+	var allowed map[string]bool
+	if t.StoreHeaders != nil {
+		allowed = make(map[string]bool, len(minimalHeaders)+len(t.StoreHeaders))
+		for k := range minimalHeaders {
+			allowed[k] = true
+		}
+		for _, k := range t.StoreHeaders {
+			allowed[http.CanonicalHeaderKey(k)] = true
+		}
+	}
+	omitted := make(map[string]bool, len(t.OmitHeaders))
+	for _, k := range t.OmitHeaders {
+		omitted[http.CanonicalHeaderKey(k)] = true
+	}
+
+	stored := make(http.Header, len(h))
+	for k, vv := range h {
+		if !isInternalHeader(k) {
+			if omitted[k] || (allowed != nil && !allowed[k]) {
+				continue
+			}
+		}
+		stored[k] = append([]string(nil), vv...)
+	}
+	return stored
+}
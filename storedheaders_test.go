@@ -0,0 +1,82 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestStoreHeaders(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Etag", `"abc"`)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Debug", "node-1")
+		w.Header().Set("X-Keep", "yes")
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	get := func(tp *Transport) *http.Response {
+		client := http.Client{Transport: tp}
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		return resp
+	}
+
+	c.Run("Allowlist", func(c *qt.C) {
+		tp := newMemoryCacheTransport()
+		tp.StoreHeaders = []string{"x-keep"}
+		tp.OmitHeaders = []string{"X-Keep", "content-type"}
+
+		resp := get(tp)
+		c.Assert(resp.Header.Get("Set-Cookie"), qt.Equals, "session=secret")
+		c.Assert(resp.Header.Get("X-Debug"), qt.Equals, "node-1")
+
+		resp = get(tp)
+		status, _ := ResponseCacheStatus(resp)
+		c.Assert(status, qt.Equals, StatusHit)
+		c.Assert(resp.Header.Get("Set-Cookie"), qt.Equals, "")
+		c.Assert(resp.Header.Get("X-Debug"), qt.Equals, "")
+		c.Assert(resp.Header.Get("X-Keep"), qt.Equals, "")
+		c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "")
+		c.Assert(resp.Header.Get("Etag"), qt.Equals, `"abc"`)
+		c.Assert(resp.Header.Get("Cache-Control"), qt.Equals, "max-age=3600")
+
+		info, ok := tp.EntryInfo(ts.URL)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(info.URL, qt.Equals, ts.URL)
+	})
+
+	c.Run("Minimal", func(c *qt.C) {
+		tp := newMemoryCacheTransport()
+		tp.StoreHeaders = []string{}
+		get(tp)
+		resp := get(tp)
+		status, _ := ResponseCacheStatus(resp)
+		c.Assert(status, qt.Equals, StatusHit)
+		c.Assert(resp.Header.Get("X-Keep"), qt.Equals, "")
+		c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+	})
+
+	c.Run("Denylist", func(c *qt.C) {
+		tp := newMemoryCacheTransport()
+		tp.OmitHeaders = []string{"Set-Cookie"}
+		get(tp)
+		resp := get(tp)
+		status, _ := ResponseCacheStatus(resp)
+		c.Assert(status, qt.Equals, StatusHit)
+		c.Assert(resp.Header.Get("Set-Cookie"), qt.Equals, "")
+		c.Assert(resp.Header.Get("X-Debug"), qt.Equals, "node-1")
+	})
+}
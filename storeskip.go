@@ -0,0 +1,79 @@
+package httpcache
+
+import "net/http"
+
+// StoreSkipReason tells why a response was not stored in the cache,
+// see Transport.OnStoreSkip and Result.StoreSkipReason.
+type StoreSkipReason string
+
+const (
+	// SkipMethod is the reason for responses to requests with a method
+	// not listed in Transport.CacheableMethods.
+	SkipMethod StoreSkipReason = "method"
+
+	// SkipBypass is the reason for responses to requests bypassing the cache,
+	// see Options.BypassCache and HostPolicy.Disable.
+	SkipBypass StoreSkipReason = "bypass"
+
+	// SkipRequestBody is the reason for responses to requests with a body
+	// larger than Transport.MaxRequestBodyBytes.
+	SkipRequestBody StoreSkipReason = "request body"
+
+	// SkipPartialContent is the reason for responses to Range requests,
+	// unless Transport.CacheRangeResponses is set.
+	SkipPartialContent StoreSkipReason = "partial content"
+
+	// SkipNoCacheKey is the reason for responses to requests without a cache key,
+	// e.g. an empty one returned by Transport.CacheKey.
+	SkipNoCacheKey StoreSkipReason = "no cache key"
+
+	// SkipPinned is the reason for responses of another variant of
+	// a response pinned with Transport.Pin, which is kept.
+	SkipPinned StoreSkipReason = "pinned"
+
+	// SkipShouldCache is the reason for responses rejected by Transport.ShouldCache.
+	SkipShouldCache StoreSkipReason = "ShouldCache"
+
+	// SkipNoStore is the reason for responses to requests, or responses,
+	// with a no-store directive.
+	SkipNoStore StoreSkipReason = "no-store"
+
+	// SkipTemporaryRedirect is the reason for temporary redirects
+	// without an explicit freshness lifetime.
+	SkipTemporaryRedirect StoreSkipReason = "temporary redirect"
+
+	// SkipPrivate is the reason for responses with a private directive
+	// in Transport.Shared mode.
+	SkipPrivate StoreSkipReason = "private"
+
+	// SkipAuthorization is the reason for responses to requests with an Authorization
+	// header not allowed to be shared, see Transport.AuthorizationPolicy.
+	SkipAuthorization StoreSkipReason = "Authorization"
+
+	// SkipTooLarge is the reason for responses with a body larger than
+	// Transport.MaxCacheableBodySize, HostPolicy.MaxEntrySize or Transport.MaxBodyBufferBytes.
+	SkipTooLarge StoreSkipReason = "too large"
+
+	// SkipBufferLimit is the reason for responses passed through because
+	// Transport.MaxInFlightBufferBytes was reached.
+	SkipBufferLimit StoreSkipReason = "buffer limit"
+
+	// SkipTransformBeforeStore is the reason for responses for which
+	// Transport.TransformBeforeStore returned nil.
+	SkipTransformBeforeStore StoreSkipReason = "TransformBeforeStore"
+)
+
+// storeSkipped reports that resp, the response to req, was not stored for key
+// because of reason.
+func (t *Transport) storeSkipped(req *http.Request, resp *http.Response, key string, reason StoreSkipReason) {
+	t.debug(req, "httpcache: not stored", "key", key, "reason", string(reason))
+	recordResult(req.Context(), func(r *Result) { r.StoreSkipReason = reason })
+	e := Event{Kind: EventStoreSkipped, Key: key, Reason: reason}
+	if reason == SkipTooLarge {
+		e.Err = ErrEntryTooLarge
+	}
+	t.event(req, e)
+	if t.OnStoreSkip != nil {
+		t.OnStoreSkip(req, resp, reason)
+	}
+}
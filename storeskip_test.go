@@ -0,0 +1,81 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestOnStoreSkip(t *testing.T) {
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/large", "/chunked":
+			w.Header().Set("Cache-Control", "max-age=3600")
+			if r.URL.Path == "/chunked" {
+				// No Content-Length.
+				w.(http.Flusher).Flush()
+			}
+			w.Write([]byte(strings.Repeat("a", 100)))
+			return
+		default:
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	var reasons []StoreSkipReason
+	tp := newMemoryCacheTransport()
+	tp.MaxCacheableBodySize = 50
+	tp.CacheableMethods = []string{http.MethodPut}
+	tp.ShouldCache = func(req *http.Request, resp *http.Response, key string) bool {
+		return !strings.HasSuffix(key, "/rejected")
+	}
+	tp.OnStoreSkip = func(req *http.Request, resp *http.Response, reason StoreSkipReason) {
+		c.Assert(resp, qt.Not(qt.IsNil))
+		reasons = append(reasons, reason)
+	}
+	roundTrip := func(method, path string) Result {
+		reasons = nil
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		c.Assert(err, qt.IsNil)
+		resp, result, err := tp.RoundTripWithResult(req)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return result
+	}
+
+	for _, test := range []struct {
+		method string
+		path   string
+		reason StoreSkipReason
+	}{
+		{http.MethodGet, "/", ""},
+		{http.MethodPost, "/", SkipMethod},
+		{http.MethodGet, "/nostore", SkipNoStore},
+		{http.MethodGet, "/rejected", SkipShouldCache},
+		{http.MethodGet, "/large", SkipTooLarge},
+	} {
+		result := roundTrip(test.method, test.path)
+		c.Assert(result.StoreSkipReason, qt.Equals, test.reason, qt.Commentf("%s %s", test.method, test.path))
+		if test.reason == "" {
+			c.Assert(reasons, qt.HasLen, 0)
+		} else {
+			c.Assert(reasons, qt.DeepEquals, []StoreSkipReason{test.reason})
+		}
+	}
+
+	// Only known once the body is read.
+	result := roundTrip(http.MethodGet, "/chunked")
+	c.Assert(result.StoreSkipReason, qt.Equals, StoreSkipReason(""))
+	c.Assert(reasons, qt.DeepEquals, []StoreSkipReason{SkipTooLarge})
+}
@@ -0,0 +1,117 @@
+package httpcache
+
+import (
+	"hash"
+	"io"
+)
+
+// StreamingCache is an optional interface implemented by MetadataCache backends
+// that can store response bodies as they are read, e.g. into a file,
+// instead of the Transport buffering them in memory.
+//
+// When the Transport's Cache implements StreamingCache, the body of a response is
+// written to the BodyWriter for its key as the client reads it. Once the body is read
+// in full, the writer is closed and the metadata of the response stored with SetMetadata.
+// Responses not read in full are not stored.
+type StreamingCache interface {
+	MetadataCache
+	// Writer returns a BodyWriter for the body of the entry for key.
+	Writer(key string) (BodyWriter, error)
+}
+
+// BodyWriter writes the body of a cache entry.
+type BodyWriter interface {
+	// Write appends p to the body.
+	// Close replaces the body stored for the key with what was written.
+	io.WriteCloser
+	// Abort discards what was written. The BodyWriter must not be used after that.
+	Abort()
+}
+
+// streamingCache returns the Cache as a StreamingCache, if it is one and
+// entries can be stored without expiry.
+func (t *Transport) streamingCache() StreamingCache {
+	if _, ok := t.Cache.(CacheWithExpiry); ok {
+		return nil
+	}
+	sc, _ := t.Cache.(StreamingCache)
+	return sc
+}
+
+// streamingReadCloser copies the content of R to W as it is read.
+type streamingReadCloser struct {
+	// Underlying ReadCloser.
+	R io.ReadCloser
+	// W receives the content of R, and is closed when EOF is reached.
+	W BodyWriter
+	// OnEOF is called with the SHA-256 hash sum and the size of the content
+	// of R when EOF is reached and W was closed without errors.
+	OnEOF func(sum []byte, size int64)
+	// OnTooLarge, if set, is called when the content of R exceeds max.
+	OnTooLarge func()
+	// Lock, if set, locks the key written to from the closing of W until
+	// OnEOF returns or calls Unlock, and returns the func unlocking it.
+	Lock func() (unlock func())
+
+	// max, if > 0, is the maximum number of bytes written to W.
+	// Larger contents are not cached.
+	max int64
+
+	hash     hash.Hash
+	size     int64
+	done     bool
+	unlockFn func()
+}
+
+func (r *streamingReadCloser) Read(p []byte) (n int, err error) {
+	n, err = r.R.Read(p)
+	if r.done {
+		return n, err
+	}
+	if _, werr := r.W.Write(p[:n]); werr != nil {
+		r.abort()
+		return n, err
+	}
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	if r.max > 0 && r.size > r.max {
+		r.abort()
+		if r.OnTooLarge != nil {
+			r.OnTooLarge()
+		}
+		return n, err
+	}
+	if err == io.EOF {
+		r.done = true
+		if r.Lock != nil {
+			r.unlockFn = r.Lock()
+			defer r.Unlock()
+		}
+		if r.W.Close() == nil {
+			r.OnEOF(r.hash.Sum(nil), r.size)
+		}
+	}
+	return n, err
+}
+
+// Unlock releases the lock taken with Lock, if held.
+func (r *streamingReadCloser) Unlock() {
+	if r.unlockFn != nil {
+		r.unlockFn()
+		r.unlockFn = nil
+	}
+}
+
+func (r *streamingReadCloser) Close() error {
+	r.abort()
+	return r.R.Close()
+}
+
+// abort discards what was written to W, nothing more will be cached.
+func (r *streamingReadCloser) abort() {
+	if r.done {
+		return
+	}
+	r.done = true
+	r.W.Abort()
+}
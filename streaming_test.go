@@ -0,0 +1,156 @@
+package httpcache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// streamingCache is a StreamingCache recording the writes to its BodyWriters.
+type streamingCache struct {
+	*metadataCache
+	writes int
+}
+
+func (c *streamingCache) Writer(key string) (BodyWriter, error) {
+	return &memoryBodyWriter{c: c, key: key}, nil
+}
+
+type memoryBodyWriter struct {
+	c   *streamingCache
+	key string
+	buf bytes.Buffer
+}
+
+func (w *memoryBodyWriter) Write(p []byte) (int, error) {
+	w.c.mu.Lock()
+	w.c.writes++
+	w.c.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *memoryBodyWriter) Close() error {
+	w.c.memoryCache.Set(w.key, w.buf.Bytes())
+	return nil
+}
+
+func (w *memoryBodyWriter) Abort() {}
+
+func TestStreamingCache(t *testing.T) {
+	c := qt.New(t)
+	content := strings.Repeat("a", 100000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	cache := &streamingCache{metadataCache: &metadataCache{memoryCache: newMemoryCache(), meta: map[string][]byte{}}}
+	tp := &Transport{Cache: cache, MaxBodyBufferBytes: 1000}
+	client := http.Client{Transport: tp}
+
+	get := func(path string, n int64) (string, CacheStatus) {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		var b []byte
+		if n < 0 {
+			b, err = io.ReadAll(resp.Body)
+		} else {
+			b, err = io.ReadAll(io.LimitReader(resp.Body, n))
+		}
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	body, status := get("/", -1)
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(body, qt.Equals, content)
+	c.Assert(cache.writes > 1, qt.IsTrue)
+	c.Assert(cache.bodySets, qt.Equals, 0)
+	c.Assert(cache.metaWrites, qt.Equals, 1)
+
+	body, status = get("/", -1)
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(body, qt.Equals, content)
+	fp, ok := tp.Fingerprint(ts.URL + "/")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(fp.Length, qt.Equals, int64(len(content)))
+
+	// Partially read responses are not stored.
+	get("/partial", 10)
+	_, status = get("/partial", -1)
+	c.Assert(status, qt.Equals, StatusMiss)
+}
+
+func TestMaxBodyBufferBytes(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	s.transport.MaxBodyBufferBytes = 5
+	defer func() { s.transport.MaxBodyBufferBytes = 0 }()
+
+	s.transport.MarkCachedResponses = true
+	doMethod(t, "GET", "/method", nil)
+	_, resp := doMethod(t, "GET", "/method", nil)
+	c.Assert(resp.Header.Get(XFromCache), qt.Equals, "1")
+
+	c.Assert(cacheSize(), qt.Equals, 1)
+
+	// Too large to buffer.
+	body, _ := doMethod(t, "GET", "/helloheaderasbody", map[string]string{"Hello": "hello world"})
+	c.Assert(body, qt.Equals, "hello world")
+	c.Assert(cacheSize(), qt.Equals, 1)
+}
+
+func TestMaxCacheableBodySize(t *testing.T) {
+	c := qt.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		switch r.URL.Path {
+		case "/small":
+			w.Write([]byte("small"))
+		case "/large":
+			w.Write([]byte(strings.Repeat("a", 100)))
+		case "/chunked":
+			// No Content-Length.
+			for i := 0; i < 10; i++ {
+				w.Write([]byte(strings.Repeat("a", 10)))
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name  string
+		cache Cache
+	}{
+		{"buffered", newMemoryCache()},
+		{"streamed", &streamingCache{metadataCache: &metadataCache{memoryCache: newMemoryCache(), meta: map[string][]byte{}}}},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			client := http.Client{Transport: &Transport{Cache: test.cache, MaxCacheableBodySize: 50, Clock: &offsetClock{}}}
+			get := func(path string) CacheStatus {
+				resp, err := client.Get(ts.URL + path)
+				c.Assert(err, qt.IsNil)
+				_, err = io.ReadAll(resp.Body)
+				c.Assert(err, qt.IsNil)
+				resp.Body.Close()
+				status, _ := ResponseCacheStatus(resp)
+				return status
+			}
+			for _, path := range []string{"/small", "/large", "/chunked"} {
+				get(path)
+			}
+			c.Assert(get("/small"), qt.Equals, StatusHit)
+			c.Assert(get("/large"), qt.Equals, StatusMiss)
+			c.Assert(get("/chunked"), qt.Equals, StatusMiss)
+		})
+	}
+}
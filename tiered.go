@@ -0,0 +1,257 @@
+package httpcache
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// TieredCacheOptions configures a TieredCache.
+type TieredCacheOptions struct {
+	// If WriteBack is true, writes and deletes are applied to the fast cache
+	// right away and to the slow cache asynchronously, in order.
+	// Call Flush to wait for them, and Close to stop the background goroutine.
+	// If false, writes go to both caches before returning (write-through).
+	WriteBack bool
+
+	// WriteBackQueueSize is the number of writes waiting to be applied to the slow cache
+	// in WriteBack mode, beyond which writes block.
+	// Default is 1000.
+	WriteBackQueueSize int
+}
+
+// TieredCache is a Cache composed of a fast cache (e.g. in memory) in front of
+// a slow one (e.g. on disk or remote).
+//
+// Reads check the fast cache first, then the slow one, copying the responses
+// found there to the fast cache. Writes and deletes go to both. While a write or
+// a delete for a key is not applied to the slow cache yet, reads missing the
+// fast cache get its outcome instead of what the slow cache has.
+type TieredCache struct {
+	fast CacheContext
+	slow CacheContext
+	l1   Cache
+	l2   Cache
+
+	queue   chan tieredOp
+	mu      sync.Mutex
+	flushed *sync.Cond // signaled when pending drops to 0.
+	pending int
+	// keyOps holds the last write or delete of the keys with some not applied
+	// to the slow cache yet.
+	keyOps map[string]*keyOp
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// tieredOp is a write or a delete waiting to be applied to the slow cache.
+type tieredOp struct {
+	key    string
+	b      []byte
+	delete bool
+}
+
+// keyOp is the last of the n writes and deletes for a key not applied to the slow cache yet.
+type keyOp struct {
+	op tieredOp
+	n  int
+}
+
+// NewTieredCache returns a new TieredCache with the fast cache l1 in front of the slow cache l2.
+func NewTieredCache(l1, l2 Cache, opts TieredCacheOptions) *TieredCache {
+	c := &TieredCache{
+		fast:   AdaptCache(l1),
+		slow:   AdaptCache(l2),
+		l1:     l1,
+		l2:     l2,
+		keyOps: make(map[string]*keyOp),
+	}
+	if opts.WriteBack {
+		size := opts.WriteBackQueueSize
+		if size <= 0 {
+			size = 1000
+		}
+		c.queue = make(chan tieredOp, size)
+		c.flushed = sync.NewCond(&c.mu)
+		c.done = make(chan struct{})
+		go c.writeBack()
+	}
+	return c
+}
+
+// Get returns the response stored for key, if any.
+func (c *TieredCache) Get(key string) ([]byte, bool) {
+	b, ok, err := c.GetContext(context.Background(), key)
+	if err != nil {
+		return nil, false
+	}
+	return b, ok
+}
+
+// Set stores the response b for key.
+func (c *TieredCache) Set(key string, b []byte) {
+	c.SetContext(context.Background(), key, b)
+}
+
+// Delete removes the response stored for key.
+func (c *TieredCache) Delete(key string) {
+	c.DeleteContext(context.Background(), key)
+}
+
+// GetContext returns the response stored for key, if any.
+// Errors from the fast cache are treated as misses.
+func (c *TieredCache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	if b, ok, err := c.fast.GetContext(ctx, key); err == nil && ok {
+		return b, true, nil
+	}
+	b, ok, err := c.slow.GetContext(ctx, key)
+	// Under the lock, so no write or delete starts before the promotion.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ko := c.keyOps[key]; ko != nil {
+		// The slow cache is behind.
+		if ko.op.delete {
+			return nil, false, nil
+		}
+		return ko.op.b, true, nil
+	}
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	// Promote.
+	c.fast.SetContext(ctx, key, b)
+	return b, true, nil
+}
+
+// SetContext stores the response b for key.
+func (c *TieredCache) SetContext(ctx context.Context, key string, b []byte) error {
+	op := tieredOp{key: key, b: b}
+	c.startOp(op)
+	if err := c.fast.SetContext(ctx, key, b); err != nil {
+		c.endOp(key)
+		return err
+	}
+	return c.toSlow(ctx, op)
+}
+
+// DeleteContext removes the response stored for key.
+func (c *TieredCache) DeleteContext(ctx context.Context, key string) error {
+	op := tieredOp{key: key, delete: true}
+	c.startOp(op)
+	if err := c.fast.DeleteContext(ctx, key); err != nil {
+		c.endOp(key)
+		return err
+	}
+	return c.toSlow(ctx, op)
+}
+
+// startOp records op, before it's applied to the fast cache, until endOp.
+func (c *TieredCache) startOp(op tieredOp) {
+	c.mu.Lock()
+	ko := c.keyOps[op.key]
+	if ko == nil {
+		ko = &keyOp{}
+		c.keyOps[op.key] = ko
+	}
+	ko.op = op
+	ko.n++
+	c.mu.Unlock()
+}
+
+// endOp records that an op for key was applied to the slow cache, or failed.
+func (c *TieredCache) endOp(key string) {
+	c.mu.Lock()
+	if ko := c.keyOps[key]; ko != nil {
+		ko.n--
+		if ko.n == 0 {
+			delete(c.keyOps, key)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// Keys calls fn for every key starting with prefix, in sorted order,
+// until fn returns false.
+// Only the keys of the caches implementing IterableCache are listed.
+func (c *TieredCache) Keys(prefix string, fn func(key string) bool) {
+	seen := make(map[string]bool)
+	for _, cache := range []Cache{c.l1, c.l2} {
+		if ic, ok := cache.(IterableCache); ok {
+			ic.Keys(prefix, func(key string) bool {
+				seen[key] = true
+				return true
+			})
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+// Flush waits for the pending writes to the slow cache to be applied.
+func (c *TieredCache) Flush() {
+	if c.queue == nil {
+		return
+	}
+	c.mu.Lock()
+	for c.pending > 0 {
+		c.flushed.Wait()
+	}
+	c.mu.Unlock()
+}
+
+// Close flushes the pending writes and stops the background goroutine
+// in WriteBack mode. The TieredCache must not be used after Close.
+func (c *TieredCache) Close() error {
+	if c.queue == nil {
+		return nil
+	}
+	c.closeOnce.Do(func() {
+		c.Flush()
+		close(c.queue)
+		<-c.done
+	})
+	return nil
+}
+
+// toSlow applies op to the slow cache, or queues it in WriteBack mode.
+func (c *TieredCache) toSlow(ctx context.Context, op tieredOp) error {
+	if c.queue != nil {
+		c.mu.Lock()
+		c.pending++
+		c.mu.Unlock()
+		c.queue <- op
+		return nil
+	}
+	defer c.endOp(op.key)
+	return c.apply(ctx, op)
+}
+
+func (c *TieredCache) apply(ctx context.Context, op tieredOp) error {
+	if op.delete {
+		return c.slow.DeleteContext(ctx, op.key)
+	}
+	return c.slow.SetContext(ctx, op.key, op.b)
+}
+
+func (c *TieredCache) writeBack() {
+	defer close(c.done)
+	for op := range c.queue {
+		c.apply(context.Background(), op)
+		c.endOp(op.key)
+		c.mu.Lock()
+		c.pending--
+		if c.pending == 0 {
+			c.flushed.Broadcast()
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,145 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var (
+	_ IterableCache = (*TieredCache)(nil)
+	_ CacheContext  = (*TieredCache)(nil)
+)
+
+func TestTieredCache(t *testing.T) {
+	for _, writeBack := range []bool{false, true} {
+		t.Run(fmt.Sprintf("WriteBack=%t", writeBack), func(t *testing.T) {
+			c := qt.New(t)
+			l1, l2 := newMemoryCache(), newMemoryCache()
+			cache := NewTieredCache(l1, l2, TieredCacheOptions{WriteBack: writeBack})
+			defer cache.Close()
+
+			cache.Set("a", []byte("a"))
+			cache.Set("b", []byte("b"))
+			cache.Flush()
+			for _, l := range []*memoryCache{l1, l2} {
+				b, ok := l.Get("a")
+				c.Assert(ok, qt.IsTrue)
+				c.Assert(string(b), qt.Equals, "a")
+			}
+
+			// Promoted from L2.
+			l1.Delete("a")
+			b, ok := cache.Get("a")
+			c.Assert(ok, qt.IsTrue)
+			c.Assert(string(b), qt.Equals, "a")
+			_, ok = l1.Get("a")
+			c.Assert(ok, qt.IsTrue)
+
+			l2.Set("c", []byte("c"))
+			var keys []string
+			cache.Keys("", func(key string) bool {
+				keys = append(keys, key)
+				return true
+			})
+			c.Assert(keys, qt.DeepEquals, []string{"a", "b", "c"})
+
+			cache.Delete("a")
+			cache.Flush()
+			_, ok = cache.Get("a")
+			c.Assert(ok, qt.IsFalse)
+			_, ok = l2.Get("a")
+			c.Assert(ok, qt.IsFalse)
+		})
+	}
+}
+
+func TestTieredCacheWriteBackOrder(t *testing.T) {
+	c := qt.New(t)
+	l1, l2 := newMemoryCache(), newMemoryCache()
+	cache := NewTieredCache(l1, l2, TieredCacheOptions{WriteBack: true, WriteBackQueueSize: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cache.Set(fmt.Sprint(i), []byte(fmt.Sprint(j)))
+			}
+		}(i)
+	}
+	wg.Wait()
+	c.Assert(cache.Close(), qt.IsNil)
+	for i := 0; i < 4; i++ {
+		b, ok := l2.Get(fmt.Sprint(i))
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(string(b), qt.Equals, "49")
+	}
+}
+
+func TestTieredCacheSlowErrors(t *testing.T) {
+	c := qt.New(t)
+	slow := &contextMemoryCache{items: map[string][]byte{}, err: errors.New("down")}
+	cache := NewTieredCache(newMemoryCache(), FromCacheContext(slow), TieredCacheOptions{})
+	ctx := context.Background()
+
+	c.Assert(cache.SetContext(ctx, "a", []byte("a")), qt.ErrorMatches, "down")
+	// Still served from L1.
+	b, ok, err := cache.GetContext(ctx, "a")
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "a")
+	_, _, err = cache.GetContext(ctx, "b")
+	c.Assert(err, qt.ErrorMatches, "down")
+}
+
+// blockingCache is a Cache whose writes and deletes wait for unblock to be closed.
+type blockingCache struct {
+	*memoryCache
+	unblock chan struct{}
+}
+
+func (c *blockingCache) Set(key string, b []byte) {
+	<-c.unblock
+	c.memoryCache.Set(key, b)
+}
+
+func (c *blockingCache) Delete(key string) {
+	<-c.unblock
+	c.memoryCache.Delete(key)
+}
+
+func TestTieredCacheWriteBackPending(t *testing.T) {
+	c := qt.New(t)
+	l1 := newMemoryCache()
+	l2 := &blockingCache{memoryCache: newMemoryCache(), unblock: make(chan struct{})}
+	l2.memoryCache.Set("a", []byte("old"))
+	l2.memoryCache.Set("b", []byte("old"))
+	cache := NewTieredCache(l1, l2, TieredCacheOptions{WriteBack: true})
+
+	cache.Delete("a")
+	cache.Set("b", []byte("new"))
+	// Evicted from L1 before the slow cache is updated.
+	l1.Delete("b")
+
+	_, ok := cache.Get("a")
+	c.Assert(ok, qt.IsFalse)
+	_, ok = l1.Get("a")
+	c.Assert(ok, qt.IsFalse)
+	b, ok := cache.Get("b")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "new")
+
+	close(l2.unblock)
+	c.Assert(cache.Close(), qt.IsNil)
+	_, ok = cache.Get("a")
+	c.Assert(ok, qt.IsFalse)
+	b, _ = cache.Get("b")
+	c.Assert(string(b), qt.Equals, "new")
+	c.Assert(cache.keyOps, qt.HasLen, 0)
+}
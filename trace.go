@@ -0,0 +1,65 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+)
+
+// Logger is the interface of the logger of a Transport, see Transport.Logger.
+// It is satisfied by *slog.Logger.
+type Logger interface {
+	// Debug logs msg with its attributes args, given as alternating keys and values.
+	Debug(msg string, args ...any)
+}
+
+// LoggerFunc is a func implementing Logger.
+type LoggerFunc func(msg string, args ...any)
+
+// Debug calls f(msg, args...).
+func (f LoggerFunc) Debug(msg string, args ...any) {
+	f(msg, args...)
+}
+
+type traceKey struct{}
+
+// WithTrace returns a copy of ctx carrying trace: the caching decisions taken for
+// requests sent through a Transport with this context, or a context derived from it,
+// are reported to trace, as they would be to Transport.Logger.
+func WithTrace(ctx context.Context, trace Logger) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// debug reports a caching decision taken for req, described by msg
+// and the attributes args, to the Logger and the trace of req, if any.
+func (t *Transport) debug(req *http.Request, msg string, args ...any) {
+	trace, _ := req.Context().Value(traceKey{}).(Logger)
+	if t.Logger == nil && trace == nil {
+		return
+	}
+	args = append([]any{"method", req.Method, "url", req.URL.String()}, args...)
+	if t.Logger != nil {
+		t.Logger.Debug(msg, args...)
+	}
+	if trace != nil {
+		trace.Debug(msg, args...)
+	}
+}
+
+// stored records that n bytes were stored for req under key.
+func (t *Transport) stored(req *http.Request, key string, n int) {
+	t.stats().Stored(req, n)
+	t.event(req, Event{Kind: EventStored, Key: key, Size: n})
+	t.debug(req, "httpcache: stored", "key", key, "bytes", n)
+}
+
+// freshnessString returns the name of the freshness verdict freshness.
+func freshnessString(freshness int) string {
+	switch freshness {
+	case fresh:
+		return "fresh"
+	case stale:
+		return "stale"
+	default:
+		return "transparent"
+	}
+}
@@ -0,0 +1,78 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		if k := args[i]; k != "method" && k != "url" {
+			fmt.Fprintf(&b, " %v=%v", k, args[i+1])
+		}
+	}
+	l.entries = append(l.entries, b.String())
+}
+
+func TestLogger(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	logger := &recordingLogger{}
+	s.transport.Logger = logger
+	defer func() { s.transport.Logger = nil }()
+
+	get := func(ctx context.Context, path string) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.server.URL+path, nil)
+		c.Assert(err, qt.IsNil)
+		resp, err := s.client.Do(req)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	key := s.server.URL + "/method"
+	get(context.Background(), "/method")
+	get(context.Background(), "/method")
+	c.Assert(logger.entries, qt.DeepEquals, []string{
+		"httpcache: lookup key=" + key + " found=false",
+		"httpcache: done status=miss error=<nil>",
+		// Stored once the body is read.
+		"httpcache: stored key=" + key + " bytes=" + fmt.Sprint(len(mustGet(c, key))),
+		"httpcache: lookup key=" + key + " found=true",
+		"httpcache: freshness key=" + key + " freshness=fresh",
+		"httpcache: done status=hit error=<nil>",
+	})
+
+	// A single request.
+	trace := &recordingLogger{}
+	s.transport.Logger = nil
+	get(WithTrace(context.Background(), trace), "/nostore")
+	get(context.Background(), "/nostore")
+	c.Assert(trace.entries, qt.DeepEquals, []string{
+		"httpcache: lookup key=" + s.server.URL + "/nostore found=false",
+		"httpcache: not stored key=" + s.server.URL + "/nostore reason=no-store",
+		"httpcache: done status=miss error=<nil>",
+	})
+}
+
+func mustGet(c *qt.C, key string) []byte {
+	b, ok := s.transport.Cache.Get(key)
+	c.Assert(ok, qt.IsTrue)
+	return b
+}
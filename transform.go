@@ -0,0 +1,68 @@
+package httpcache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// transformCachedBody replaces the body of resp, served from the cache,
+// with the one returned by TransformCachedBody.
+func (t *Transport) transformCachedBody(req *http.Request, resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	body = t.TransformCachedBody(req, resp, body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	if resp.Header.Get("Content-Length") != "" {
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return nil
+}
+
+// transformBeforeStore applies TransformBeforeStore to resp, the response to req
+// about to be stored with body, and returns the response and body to store,
+// its book-keeping headers restored, or false if it must not be stored.
+func (t *Transport) transformBeforeStore(req *http.Request, resp *http.Response, body []byte) (*http.Response, []byte, bool) {
+	internal := make(http.Header)
+	r := *resp
+	r.Header = make(http.Header, len(resp.Header))
+	for k, v := range resp.Header {
+		if isInternalHeader(k) {
+			internal[k] = v
+		} else {
+			r.Header[k] = append([]string(nil), v...)
+		}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	r2 := t.TransformBeforeStore(req, &r)
+	if r2 == nil {
+		return nil, nil, false
+	}
+	body = nil
+	if r2.Body != nil {
+		var err error
+		body, err = io.ReadAll(r2.Body)
+		r2.Body.Close()
+		if err != nil {
+			return nil, nil, false
+		}
+	}
+	if r2.Header == nil {
+		r2.Header = make(http.Header)
+	}
+	for k, v := range internal {
+		r2.Header[k] = v
+	}
+	r2.ContentLength = int64(len(body))
+	if r2.Header.Get("Content-Length") != "" && req.Method != http.MethodHead {
+		r2.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return r2, body, true
+}
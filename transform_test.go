@@ -0,0 +1,131 @@
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestTransformCachedBody(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+	s.transport.TransformCachedBody = func(req *http.Request, resp *http.Response, body []byte) []byte {
+		status, _ := ResponseCacheStatus(resp)
+		return append([]byte(fmt.Sprintf("<!-- %s -->", status)), body...)
+	}
+	defer func() { s.transport.TransformCachedBody = nil }()
+
+	get := func() (string, *http.Response) {
+		resp, err := s.client.Get(s.server.URL + "/method")
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		return string(b), resp
+	}
+
+	body, _ := get()
+	c.Assert(body, qt.Equals, "GET")
+	for i := 0; i < 2; i++ {
+		body, resp := get()
+		c.Assert(body, qt.Equals, "<!-- hit -->GET")
+		c.Assert(resp.ContentLength, qt.Equals, int64(len(body)))
+	}
+
+	resp, err := s.client.Head(s.server.URL + "/method")
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.ContentLength, qt.Equals, int64(len("GET")))
+}
+
+func TestTransformBeforeStore(t *testing.T) {
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Write([]byte("0123456789" + r.URL.Path))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.TransformBeforeStore = func(req *http.Request, resp *http.Response) *http.Response {
+		if strings.HasSuffix(req.URL.Path, "/skip") {
+			return nil
+		}
+		c.Assert(resp.Header.Get(xRequestURL), qt.Equals, "")
+		resp.Header.Del("Set-Cookie")
+		resp.Body = io.NopCloser(io.LimitReader(resp.Body, 4))
+		return resp
+	}
+	client := http.Client{Transport: tp}
+	get := func(path string) (string, *http.Response) {
+		resp, err := client.Get(ts.URL + path)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		return string(b), resp
+	}
+
+	body, resp := get("/a")
+	c.Assert(body, qt.Equals, "0123456789/a")
+	c.Assert(resp.Header.Get("Set-Cookie"), qt.Equals, "session=abc")
+
+	body, resp = get("/a")
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(body, qt.Equals, "0123")
+	c.Assert(resp.Header.Get("Set-Cookie"), qt.Equals, "")
+
+	get("/skip")
+	_, ok := tp.EntryInfo(ts.URL + "/skip")
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestTransformAfterLoad(t *testing.T) {
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	errCorrupt := errors.New("corrupt")
+	tp := newMemoryCacheTransport()
+	tp.TransformAfterLoad = func(req *http.Request, resp *http.Response) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, "/corrupt") {
+			resp.Body.Close()
+			return nil, errCorrupt
+		}
+		status, _ := ResponseCacheStatus(resp)
+		resp.Header.Set("X-Cache", status.String())
+		return resp, nil
+	}
+	client := http.Client{Transport: tp}
+
+	for i, want := range []string{"", "hit"} {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(string(b), qt.Equals, "content")
+		c.Assert(resp.Header.Get("X-Cache"), qt.Equals, want, qt.Commentf("request %d", i))
+	}
+
+	resp, err := client.Get(ts.URL + "/corrupt")
+	c.Assert(err, qt.IsNil)
+	_, err = io.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	_, err = client.Get(ts.URL + "/corrupt")
+	c.Assert(errors.Is(err, errCorrupt), qt.IsTrue)
+}
@@ -0,0 +1,101 @@
+package httpcache
+
+import "net/http"
+
+// ValidationStrategy builds the conditional requests used to revalidate stale cached
+// responses and interprets the server's answers to them.
+// Set it on Transport.Validation to support validator schemes other than
+// ETag/If-None-Match and Last-Modified/If-Modified-Since.
+type ValidationStrategy interface {
+	// Conditional returns a copy of req with the conditional headers for the
+	// validators of cachedResp set, or nil if cachedResp cannot be validated.
+	// req must not be modified.
+	Conditional(req *http.Request, cachedResp *http.Response) *http.Request
+
+	// NotModified reports whether resp, the server's response to req,
+	// confirms that cachedResp is still valid and can be served.
+	NotModified(req *http.Request, resp, cachedResp *http.Response) bool
+}
+
+// DefaultValidation is the ValidationStrategy used by Transports without one set.
+// It uses the ETag and Last-Modified validators and treats 304 Not Modified
+// responses as confirmation.
+var DefaultValidation ValidationStrategy = defaultValidation{}
+
+type defaultValidation struct{}
+
+func (defaultValidation) Conditional(req *http.Request, cachedResp *http.Response) *http.Request {
+	var req2 *http.Request
+	// Add validators if caller hasn't already done so
+	etag := cachedResp.Header.Get("etag")
+	if etag != "" && req.Header.Get("etag") == "" {
+		req2 = cloneRequest(req)
+		req2.Header.Set("if-none-match", etag)
+	}
+	lastModified := cachedResp.Header.Get("last-modified")
+	if lastModified != "" && req.Header.Get("last-modified") == "" {
+		if req2 == nil {
+			req2 = cloneRequest(req)
+		}
+		req2.Header.Set("if-modified-since", lastModified)
+	}
+	return req2
+}
+
+func (defaultValidation) NotModified(req *http.Request, resp, cachedResp *http.Response) bool {
+	return resp.StatusCode == http.StatusNotModified
+}
+
+// ETagValidation is a ValidationStrategy sending the ETag validator only
+// (If-None-Match), for servers mishandling If-Modified-Since.
+var ETagValidation ValidationStrategy = ValidatorFunc(func(cachedResp *http.Response) http.Header {
+	if etag := cachedResp.Header.Get("etag"); etag != "" {
+		return http.Header{"If-None-Match": {etag}}
+	}
+	return nil
+})
+
+// LastModifiedValidation is a ValidationStrategy sending the Last-Modified
+// validator only (If-Modified-Since), for servers mishandling If-None-Match.
+var LastModifiedValidation ValidationStrategy = ValidatorFunc(func(cachedResp *http.Response) http.Header {
+	if lastModified := cachedResp.Header.Get("last-modified"); lastModified != "" {
+		return http.Header{"If-Modified-Since": {lastModified}}
+	}
+	return nil
+})
+
+// ValidatorFunc is a ValidationStrategy revalidating cached responses with the
+// conditional headers it returns for them, set on the requests, treating
+// 304 Not Modified responses as confirmation.
+// If it returns no headers, the cached response is not revalidated.
+type ValidatorFunc func(cachedResp *http.Response) http.Header
+
+// Conditional implements ValidationStrategy.
+func (f ValidatorFunc) Conditional(req *http.Request, cachedResp *http.Response) *http.Request {
+	h := f(cachedResp)
+	if len(h) == 0 {
+		return nil
+	}
+	req2 := cloneRequest(req)
+	for k, v := range h {
+		req2.Header[http.CanonicalHeaderKey(k)] = v
+	}
+	return req2
+}
+
+// NotModified implements ValidationStrategy.
+func (f ValidatorFunc) NotModified(req *http.Request, resp, cachedResp *http.Response) bool {
+	return resp.StatusCode == http.StatusNotModified
+}
+
+// validation returns the ValidationStrategy for req: that of the HostPolicy
+// for its host, if set, or else that of the Transport.
+func (t *Transport) validation(req *http.Request) ValidationStrategy {
+	if p, ok := t.hostPolicy(req); ok && p.Validation != nil {
+		return p.Validation
+	}
+	if t.Validation == nil {
+		return DefaultValidation
+	}
+	return t.Validation
+}
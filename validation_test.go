@@ -0,0 +1,154 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestDefaultValidation(t *testing.T) {
+	c := qt.New(t)
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	c.Assert(err, qt.IsNil)
+	cachedResp := &http.Response{Header: http.Header{}}
+
+	c.Assert(DefaultValidation.Conditional(req, cachedResp), qt.IsNil)
+
+	cachedResp.Header.Set("Etag", `"abc"`)
+	cachedResp.Header.Set("Last-Modified", "Fri, 14 Dec 2010 01:01:50 GMT")
+	req2 := DefaultValidation.Conditional(req, cachedResp)
+	c.Assert(req2, qt.Not(qt.IsNil))
+	c.Assert(req2.Header.Get("If-None-Match"), qt.Equals, `"abc"`)
+	c.Assert(req2.Header.Get("If-Modified-Since"), qt.Equals, "Fri, 14 Dec 2010 01:01:50 GMT")
+	c.Assert(req.Header.Get("If-None-Match"), qt.Equals, "")
+
+	c.Assert(DefaultValidation.NotModified(req2, &http.Response{StatusCode: http.StatusNotModified}, cachedResp), qt.IsTrue)
+	c.Assert(DefaultValidation.NotModified(req2, &http.Response{StatusCode: http.StatusOK}, cachedResp), qt.IsFalse)
+}
+
+// versionValidation validates using a vendor X-Version header,
+// the server answering 204 No Content if unchanged.
+type versionValidation struct{}
+
+func (versionValidation) Conditional(req *http.Request, cachedResp *http.Response) *http.Request {
+	v := cachedResp.Header.Get("X-Version")
+	if v == "" {
+		return nil
+	}
+	req2 := cloneRequest(req)
+	req2.Header.Set("X-If-Version", v)
+	return req2
+}
+
+func (versionValidation) NotModified(req *http.Request, resp, cachedResp *http.Response) bool {
+	return resp.StatusCode == http.StatusNoContent
+}
+
+func TestValidationStrategy(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10")
+		if r.Header.Get("X-If-Version") == "1" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("X-Version", "1")
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	counters := &Counters{}
+	tp := newMemoryCacheTransport()
+	tp.Validation = versionValidation{}
+	tp.Stats = counters
+	client := http.Client{Transport: tp}
+	get := func() CacheStatus {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(string(b), qt.Equals, "content")
+		status, _ := ResponseCacheStatus(resp)
+		return status
+	}
+
+	c.Assert(get(), qt.Equals, StatusMiss)
+	clock = &fakeClock{elapsed: 30 * time.Second}
+	c.Assert(get(), qt.Equals, StatusRevalidated)
+	v := counters.Values()
+	c.Assert(v.RevalidatedNotModified, qt.Equals, uint64(1))
+	c.Assert(v.RevalidatedModified, qt.Equals, uint64(0))
+}
+
+func TestValidatorStrategies(t *testing.T) {
+	c := qt.New(t)
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	c.Assert(err, qt.IsNil)
+	cachedResp := &http.Response{Header: http.Header{}}
+	cachedResp.Header.Set("Etag", `"abc"`)
+	cachedResp.Header.Set("Last-Modified", "Fri, 14 Dec 2010 01:01:50 GMT")
+
+	req2 := ETagValidation.Conditional(req, cachedResp)
+	c.Assert(req2.Header.Get("If-None-Match"), qt.Equals, `"abc"`)
+	c.Assert(req2.Header.Get("If-Modified-Since"), qt.Equals, "")
+
+	req2 = LastModifiedValidation.Conditional(req, cachedResp)
+	c.Assert(req2.Header.Get("If-None-Match"), qt.Equals, "")
+	c.Assert(req2.Header.Get("If-Modified-Since"), qt.Equals, "Fri, 14 Dec 2010 01:01:50 GMT")
+	c.Assert(LastModifiedValidation.NotModified(req2, &http.Response{StatusCode: http.StatusNotModified}, cachedResp), qt.IsTrue)
+
+	c.Assert(ETagValidation.Conditional(req, &http.Response{Header: http.Header{}}), qt.IsNil)
+	c.Assert(req.Header, qt.HasLen, 0)
+
+	custom := ValidatorFunc(func(cachedResp *http.Response) http.Header {
+		return http.Header{"x-if-version": {cachedResp.Header.Get("Etag")}}
+	})
+	req2 = custom.Conditional(req, cachedResp)
+	c.Assert(req2.Header.Get("X-If-Version"), qt.Equals, `"abc"`)
+}
+
+func TestHostPolicyValidation(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var conditional []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("Etag", `"abc"`)
+		w.Header().Set("Last-Modified", "Fri, 14 Dec 2010 01:01:50 GMT")
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	u, err := url.Parse(ts.URL)
+	c.Assert(err, qt.IsNil)
+	tp.HostPolicies = map[string]HostPolicy{u.Host: {Validation: ETagValidation}}
+	client := http.Client{Transport: tp}
+
+	resp, err := client.Get(ts.URL)
+	c.Assert(err, qt.IsNil)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	clock = &fakeClock{elapsed: 30 * time.Second}
+	defer func() { clock = &realClock{} }()
+	resp, err = client.Get(ts.URL)
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	status, _ := ResponseCacheStatus(resp)
+	c.Assert(status, qt.Equals, StatusRevalidated)
+	c.Assert(conditional, qt.DeepEquals, []string{"|", `"abc"|`})
+}
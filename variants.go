@@ -0,0 +1,146 @@
+package httpcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// In MaxVariants mode, the response to a GET request with a Vary header (or with
+// VaryOn set) is stored under its cache key, as usual, and under a secondary key made
+// of the cache key and the fingerprint of the variant, see variantFingerprint.
+// The entry stored under the cache key, the primary entry, is the last variant stored,
+// and lists the fingerprints of the variants stored, most recent first, in its
+// X-Variants header.
+//
+// When the primary entry doesn't match a request, the variant for its fingerprint
+// is looked up, and failing that, all the variants are revalidated at once with
+// variantConditional.
+//
+// Entries stored before MaxVariants was set are primary entries without variants,
+// indexed on their next update.
+
+// xVariants is the header of the primary entry listing the fingerprints of the variants stored.
+const xVariants = "X-Variants"
+
+// variantKey returns the key of the variant with fingerprint fp of the response stored for key.
+func variantKey(key, fp string) string {
+	return key + " variant=" + fp
+}
+
+// variantFingerprint returns the fingerprint of the variant of a response with the
+// headers respHeaders selected by req: a hash of the values of the request headers
+// listed in its Vary header and of the value returned by VaryOn, if set.
+// It returns an empty string if the response has no variants: no Vary header,
+// a Vary: *, or req is not a GET request.
+func (t *Transport) variantFingerprint(respHeaders http.Header, req *http.Request) string {
+	if req.Method != http.MethodGet || varyAll(respHeaders) {
+		return ""
+	}
+	names := varyNames(respHeaders)
+	if len(names) == 0 && t.VaryOn == nil {
+		return ""
+	}
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s: %s\n", name, req.Header.Get(name))
+	}
+	if t.VaryOn != nil {
+		fmt.Fprintf(h, "\n%s", t.VaryOn(req))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// varyNames returns the header names listed in the Vary header of respHeaders, canonicalized.
+func varyNames(respHeaders http.Header) []string {
+	var names []string
+	for _, name := range headerAllCommaSepValues(respHeaders, "vary") {
+		if name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	return names
+}
+
+// variantIndex returns the fingerprints of the variants stored for a primary entry
+// with the headers primaryHeaders, nil if there are none.
+func variantIndex(primaryHeaders http.Header) []string {
+	return headerAllCommaSepValues(primaryHeaders, xVariants)
+}
+
+// updateVariantIndex returns the variant index of a new primary entry with the headers
+// respHeaders for the variant fp, given the headers of the previous primary entry,
+// if any, and the fingerprints of the variants to evict: those beyond MaxVariants,
+// and all of them if the Vary header changed, as their fingerprints can't be compared,
+// or fp is empty, the new entry having no variants.
+func (t *Transport) updateVariantIndex(prevHeaders, respHeaders http.Header, fp string) (index, evicted []string) {
+	var prev []string
+	if prevHeaders != nil {
+		prev = variantIndex(prevHeaders)
+	}
+	if fp == "" {
+		return nil, prev
+	}
+	if prevHeaders != nil && strings.Join(varyNames(prevHeaders), ",") != strings.Join(varyNames(respHeaders), ",") {
+		return []string{fp}, prev
+	}
+	index = []string{fp}
+	for _, v := range prev {
+		if v == fp {
+			continue
+		}
+		if len(index) < t.MaxVariants {
+			index = append(index, v)
+		} else {
+			evicted = append(evicted, v)
+		}
+	}
+	return index, evicted
+}
+
+// lookupVariant looks up the variant selected by req of the primary entry
+// with the headers primaryHeaders, stored for key.
+// It returns a nil response if there is none.
+func (t *Transport) lookupVariant(req *http.Request, key string, primaryHeaders http.Header) (*http.Response, bool, error) {
+	fp := t.variantFingerprint(primaryHeaders, req)
+	if fp == "" {
+		return nil, false, nil
+	}
+	for _, v := range variantIndex(primaryHeaders) {
+		if v == fp {
+			return t.cachedResponseForKey(req, variantKey(key, fp))
+		}
+	}
+	return nil, false, nil
+}
+
+// storedVariants returns the variants listed in the primary entry with the headers
+// primaryHeaders, stored for key, skipping those that can't be read.
+func (t *Transport) storedVariants(req *http.Request, key string, primaryHeaders http.Header) []*http.Response {
+	var variants []*http.Response
+	for _, fp := range variantIndex(primaryHeaders) {
+		if v, _, err := t.cachedResponseForKey(req, variantKey(key, fp)); err == nil && v != nil {
+			variants = append(variants, v)
+		}
+	}
+	return variants
+}
+
+// deleteEntry removes the entry stored for key from the cache, along with its variants
+// in MaxVariants mode.
+func (t *Transport) deleteEntry(ctx context.Context, key string) error {
+	if t.MaxVariants > 1 {
+		if b, _ := t.cacheGet(ctx, key); len(b) > 0 {
+			if e, err := decodeEntry(b); err == nil {
+				h, _ := e.Header()
+				for _, fp := range variantIndex(h) {
+					t.cacheDelete(ctx, variantKey(key, fp))
+				}
+			}
+		}
+	}
+	return t.cacheDelete(ctx, key)
+}
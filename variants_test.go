@@ -0,0 +1,69 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestMaxVariants(t *testing.T) {
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		lang := r.Header.Get("Accept-Language")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Header().Set("Etag", `"`+lang+`"`)
+		w.Write([]byte("content-" + lang))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	client := http.Client{Transport: tp}
+	get := func(lang string) (string, CacheStatus) {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		req.Header.Set("Accept-Language", lang)
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	// Stored in the previous layout, indexed on update.
+	get("en")
+	tp.MaxVariants = 2
+
+	for _, lang := range []string{"de", "en", "de", "en"} {
+		body, _ := get(lang)
+		c.Assert(body, qt.Equals, "content-"+lang)
+	}
+	c.Assert(requests, qt.Equals, 3)
+	body, status := get("de")
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(body, qt.Equals, "content-de")
+	c.Assert(requests, qt.Equals, 3)
+
+	// The least recently stored variant, de, is evicted.
+	get("fr")
+	c.Assert(requests, qt.Equals, 4)
+	body, status = get("en")
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(body, qt.Equals, "content-en")
+	get("de")
+	c.Assert(requests, qt.Equals, 5)
+	c.Assert(tp.Cache.(*memoryCache).Size(), qt.Equals, 3)
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(tp.Invalidate(req), qt.IsNil)
+	c.Assert(tp.Cache.(*memoryCache).Size(), qt.Equals, 0)
+}
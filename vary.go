@@ -0,0 +1,62 @@
+package httpcache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// xVariant is the header holding the value returned by Transport.VaryOn
+// for the request a response was stored for.
+const xVariant = "X-Variant"
+
+// varyAll reports whether the response with the headers respHeaders has a Vary: *,
+// i.e. it may vary on anything, not only on the request headers.
+func varyAll(respHeaders http.Header) bool {
+	for _, v := range headerAllCommaSepValues(respHeaders, "vary") {
+		if v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// variantConditional returns a copy of req with an If-None-Match header listing the
+// ETags of variants, stored responses for the URL of req whose Vary headers don't
+// match it, so the server can tell whether one of them is the response it would
+// select for req, see RFC 9111 section 4.3.1.
+// It returns nil if req is already conditional or none of variants has an ETag.
+func variantConditional(req *http.Request, variants []*http.Response) *http.Request {
+	if req.Header.Get("if-none-match") != "" || req.Header.Get("if-modified-since") != "" {
+		return nil
+	}
+	var etags []string
+	seen := make(map[string]bool)
+	for _, v := range variants {
+		if etag := v.Header.Get("etag"); etag != "" && !varyAll(v.Header) && !seen[etag] {
+			seen[etag] = true
+			etags = append(etags, etag)
+		}
+	}
+	if len(etags) == 0 {
+		return nil
+	}
+	req2 := cloneRequest(req)
+	req2.Header.Set("if-none-match", strings.Join(etags, ", "))
+	return req2
+}
+
+// selectVariant returns the one of variants identified by the ETag of resp,
+// the 304 response to a request built with variantConditional, or nil if none,
+// see RFC 9111 section 4.3.4.
+func selectVariant(resp *http.Response, variants []*http.Response) *http.Response {
+	etag := resp.Header.Get("etag")
+	if etag == "" {
+		return nil
+	}
+	for _, v := range variants {
+		if v.Header.Get("etag") == etag && !varyAll(v.Header) {
+			return v
+		}
+	}
+	return nil
+}
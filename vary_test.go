@@ -0,0 +1,159 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestVaryAll(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var conditional []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		w.Header().Set("Cache-Control", "max-age=3600, stale-while-revalidate=3600")
+		w.Header().Set("Vary", "*")
+		w.Header().Set("Etag", `"abc"`)
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	client := http.Client{Transport: tp}
+	get := func() (string, CacheStatus) {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	body, status := get()
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(body, qt.Equals, "content")
+	for i := 0; i < 2; i++ {
+		body, status = get()
+		c.Assert(status, qt.Equals, StatusRevalidated)
+		c.Assert(body, qt.Equals, "content")
+	}
+	c.Assert(conditional, qt.DeepEquals, []string{"", `"abc"`, `"abc"`})
+	c.Assert(tp.PendingRefreshes(), qt.HasLen, 0)
+}
+
+func TestVaryOn(t *testing.T) {
+	resetTest()
+	c := qt.New(t)
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	tp.CacheableMethods = []string{http.MethodPost}
+	// Store the variants under the same key.
+	tp.CacheKey = func(req *http.Request) string { return req.Method + " " + req.URL.String() }
+	tp.VaryOn = func(req *http.Request) string {
+		h, _ := RequestBodyHash(req)
+		return h
+	}
+	client := http.Client{Transport: tp}
+	post := func(body string) (string, CacheStatus) {
+		resp, err := client.Post(ts.URL, "application/json", strings.NewReader(body))
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	body, status := post("a")
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(body, qt.Equals, "a")
+	body, status = post("a")
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(body, qt.Equals, "a")
+	body, status = post("b")
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(body, qt.Equals, "b")
+	c.Assert(requests, qt.Equals, 2)
+}
+
+func TestRevalidateVariants(t *testing.T) {
+	c := qt.New(t)
+
+	var conditional []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		lang := r.Header.Get("Accept-Language")
+		if lang == "" {
+			lang = "en"
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Header().Set("Etag", `"`+lang+`"`)
+		if inm := r.Header.Get("If-None-Match"); inm != "" && (lang == "fr" || strings.Contains(inm, `"`+lang+`"`)) {
+			// Misbehaves for fr, validating a response that isn't stored.
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("content-" + lang))
+	}))
+	defer ts.Close()
+
+	tp := newMemoryCacheTransport()
+	client := http.Client{Transport: tp}
+	get := func(lang string) (string, CacheStatus) {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		resp, err := client.Do(req)
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return string(b), status
+	}
+
+	body, status := get("en")
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(body, qt.Equals, "content-en")
+
+	// The server selects the stored variant.
+	body, status = get("")
+	c.Assert(status, qt.Equals, StatusRevalidated)
+	c.Assert(body, qt.Equals, "content-en")
+	body, status = get("")
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(body, qt.Equals, "content-en")
+
+	body, status = get("de")
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(body, qt.Equals, "content-de")
+
+	body, status = get("fr")
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(body, qt.Equals, "content-fr")
+
+	c.Assert(conditional, qt.DeepEquals, []string{"", `"en"`, `"en"`, `"de"`, ""})
+}
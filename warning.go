@@ -0,0 +1,100 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The Warning codes of RFC 7234 section 5.5 added to the stale responses
+// served in EmitWarnings mode.
+const (
+	// warnStale is added to all the stale responses served.
+	warnStale = 110
+
+	// warnRevalidationFailed is added to the stale responses served because
+	// they could not be revalidated, e.g. with stale-if-error.
+	warnRevalidationFailed = 111
+
+	// warnDisconnected is added to the stale responses served in Offline mode.
+	warnDisconnected = 112
+)
+
+var warningTexts = map[int]string{
+	warnStale:              "Response is Stale",
+	warnRevalidationFailed: "Revalidation Failed",
+	warnDisconnected:       "Disconnected Operation",
+}
+
+// servedStale returns the cached response resp, served stale, with a Warning
+// header for each of the given codes, along with 110 (Response is Stale),
+// in EmitWarnings mode.
+func (t *Transport) servedStale(resp *http.Response, codes ...int) *http.Response {
+	if !t.EmitWarnings {
+		return resp
+	}
+	addWarning(resp.Header, warnStale)
+	for _, code := range codes {
+		addWarning(resp.Header, code)
+	}
+	return resp
+}
+
+// servedFresh returns the cached response resp, served as fresh to req, with
+// the Warning 110 (Response is Stale) in EmitWarnings mode if it is stale,
+// only served because of the max-stale directive of req.
+func (t *Transport) servedFresh(resp *http.Response, req *http.Request) *http.Response {
+	if !t.EmitWarnings {
+		return resp
+	}
+	if _, ok := parseCacheControl(req.Header)["max-stale"]; !ok {
+		return resp
+	}
+	r := *req
+	r.Header = withoutDirectives(req.Header, "max-stale")
+	if t.freshness(resp, &r) != fresh {
+		addWarning(resp.Header, warnStale)
+	}
+	return resp
+}
+
+// addWarning adds the Warning code with its text to h, if it isn't there yet.
+func addWarning(h http.Header, code int) {
+	for _, c := range warningCodes(h) {
+		if c == code {
+			return
+		}
+	}
+	h.Add("Warning", strconv.Itoa(code)+` - "`+warningTexts[code]+`"`)
+}
+
+// stripStaleWarnings removes the 1xx warnings from h, to be done when the response
+// was revalidated, see RFC 7234 section 4.3.4. The 2xx warnings are kept.
+func stripStaleWarnings(h http.Header) {
+	var kept []string
+	for _, v := range h.Values("Warning") {
+		for _, w := range splitDirectives(v) {
+			if w = strings.TrimSpace(w); w != "" && !strings.HasPrefix(w, "1") {
+				kept = append(kept, w)
+			}
+		}
+	}
+	h.Del("Warning")
+	for _, w := range kept {
+		h.Add("Warning", w)
+	}
+}
+
+// warningCodes returns the codes of the warnings in h.
+func warningCodes(h http.Header) []int {
+	var codes []int
+	for _, v := range h.Values("Warning") {
+		for _, w := range splitDirectives(v) {
+			code, _, _ := strings.Cut(strings.TrimSpace(w), " ")
+			if c, err := strconv.Atoi(code); err == nil {
+				codes = append(codes, c)
+			}
+		}
+	}
+	return codes
+}
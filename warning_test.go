@@ -0,0 +1,96 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestEmitWarnings(t *testing.T) {
+	c := qt.New(t)
+	clk := &offsetClock{}
+	var (
+		failing bool
+		warning string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60, stale-if-error=3600")
+		w.Header().Set("Date", clk.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"v1"`)
+		if warning != "" {
+			w.Header().Set("Warning", warning)
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	tp := &Transport{Cache: newMemoryCache(), Clock: clk, EmitWarnings: true}
+	get := func(cacheControl string) ([]string, CacheStatus) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		c.Assert(err, qt.IsNil)
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		resp, err := tp.RoundTrip(req)
+		c.Assert(err, qt.IsNil)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status, _ := ResponseCacheStatus(resp)
+		return resp.Header.Values("Warning"), status
+	}
+
+	warnings, status := get("")
+	c.Assert(status, qt.Equals, StatusMiss)
+	c.Assert(warnings, qt.HasLen, 0)
+
+	// Fresh.
+	warnings, status = get("max-stale")
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(warnings, qt.HasLen, 0)
+
+	// Stale, served because of max-stale.
+	clk.offset = 2 * time.Minute
+	warnings, status = get("max-stale")
+	c.Assert(status, qt.Equals, StatusHit)
+	c.Assert(warnings, qt.DeepEquals, []string{`110 - "Response is Stale"`})
+
+	// Stale, served because the server failed.
+	failing = true
+	warnings, status = get("")
+	c.Assert(status, qt.Equals, StatusStale)
+	c.Assert(warnings, qt.DeepEquals, []string{`110 - "Response is Stale"`, `111 - "Revalidation Failed"`})
+
+	// Offline.
+	tp.Offline = true
+	warnings, status = get("")
+	c.Assert(status, qt.Equals, StatusStale)
+	c.Assert(warnings, qt.DeepEquals, []string{`110 - "Response is Stale"`, `112 - "Disconnected Operation"`})
+	tp.Offline = false
+
+	// Revalidated, the 1xx warnings are removed, the 2xx kept.
+	failing = false
+	warning = `110 - "Response is Stale", 214 - "Transformation Applied"`
+	warnings, status = get("")
+	c.Assert(status, qt.Equals, StatusRevalidated)
+	c.Assert(warnings, qt.DeepEquals, []string{`214 - "Transformation Applied"`})
+
+	// Disabled, only the stored warnings are served.
+	tp.EmitWarnings = false
+	failing = true
+	clk.offset = 4 * time.Minute
+	warnings, status = get("")
+	c.Assert(status, qt.Equals, StatusStale)
+	c.Assert(warnings, qt.DeepEquals, []string{`214 - "Transformation Applied"`})
+}
@@ -0,0 +1,203 @@
+package httpcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WriteDropRecorder is an optional interface implemented by StatsRecorders
+// that want to know about lost cache writes.
+type WriteDropRecorder interface {
+	// WriteDropped is called when storing the entry for key failed with err,
+	// and was given up, see Transport.WriteRetries.
+	WriteDropped(key string, err error)
+}
+
+const (
+	// defaultWriteRetryBackoff is the default value of Transport.WriteRetryBackoff.
+	defaultWriteRetryBackoff = time.Second
+
+	// defaultMaxQueuedWrites is the default value of Transport.MaxQueuedWrites.
+	defaultMaxQueuedWrites = 100
+)
+
+// pendingWrite is a failed cache write waiting to be retried.
+type pendingWrite struct {
+	b       []byte
+	ttl     time.Duration // the expiry of b, see Transport.cacheWrite.
+	version int           // incremented when b is replaced by a newer value.
+	err     error
+}
+
+// writeRetrier tracks the failed cache writes waiting to be retried, at most one per key.
+type writeRetrier struct {
+	mu      sync.Mutex
+	pending map[string]*pendingWrite
+	closed  bool
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// add queues a retry of the write of b for key, to expire after ttl, which failed with err.
+// If a retry for key is already pending, b replaces the value it writes.
+// It reports false if the write needs to be dropped.
+func (w *writeRetrier) add(key string, b []byte, ttl time.Duration, err error, maxQueued int, run func(p *pendingWrite)) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return false
+	}
+	if p, ok := w.pending[key]; ok {
+		p.b, p.ttl, p.err = b, ttl, err
+		p.version++
+		return true
+	}
+	if len(w.pending) >= maxQueued {
+		return false
+	}
+	if w.pending == nil {
+		w.pending = make(map[string]*pendingWrite)
+	}
+	p := &pendingWrite{b: b, ttl: ttl, err: err}
+	w.pending[key] = p
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		run(p)
+	}()
+	return true
+}
+
+// next returns the value to write for the pending write p of key, with its ttl,
+// and false if it was canceled.
+func (w *writeRetrier) next(key string, p *pendingWrite) ([]byte, time.Duration, int, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending[key] != p {
+		return nil, 0, 0, false
+	}
+	return p.b, p.ttl, p.version, true
+}
+
+// done records the outcome of writing version of the pending write p of key,
+// reporting whether retrying it is over, because it succeeded or was canceled.
+// If a newer value than version was queued meanwhile, it is retried too.
+func (w *writeRetrier) done(key string, p *pendingWrite, version int, err error) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending[key] != p {
+		return true
+	}
+	if err != nil {
+		if p.version == version {
+			p.err = err
+		}
+		return false
+	}
+	if p.version != version {
+		return false
+	}
+	delete(w.pending, key)
+	return true
+}
+
+// cancel removes the pending write for key, if any.
+func (w *writeRetrier) cancel(key string) {
+	w.mu.Lock()
+	delete(w.pending, key)
+	w.mu.Unlock()
+}
+
+// len returns the number of pending writes.
+func (w *writeRetrier) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
+// close stops accepting writes and waits for the pending ones to be dropped.
+func (w *writeRetrier) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.closingChan())
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+}
+
+// closingChan returns a channel closed when the retrier is closed.
+// w.mu must be held.
+func (w *writeRetrier) closingChan() chan struct{} {
+	if w.closing == nil {
+		w.closing = make(chan struct{})
+	}
+	return w.closing
+}
+
+// wait waits for d, reporting false if the retrier was closed meanwhile.
+func (w *writeRetrier) wait(d time.Duration) bool {
+	w.mu.Lock()
+	closing := w.closingChan()
+	w.mu.Unlock()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-closing:
+		return false
+	}
+}
+
+// retryWrite retries the write of b for key, to expire after ttl, which failed with err,
+// in the background, see WriteRetries.
+func (t *Transport) retryWrite(key string, b []byte, ttl time.Duration, err error) {
+	if t.WriteRetries <= 0 {
+		t.writeDropped(key, err)
+		return
+	}
+	maxQueued := t.MaxQueuedWrites
+	if maxQueued <= 0 {
+		maxQueued = defaultMaxQueuedWrites
+	}
+	backoff := t.WriteRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultWriteRetryBackoff
+	}
+	queued := t.writes.add(key, b, ttl, err, maxQueued, func(p *pendingWrite) {
+		for attempt := 0; attempt < t.WriteRetries; attempt++ {
+			if !t.writes.wait(backoff << attempt) {
+				break
+			}
+			b, ttl, version, ok := t.writes.next(key, p)
+			if !ok {
+				return
+			}
+			err := t.cacheWrite(context.Background(), key, b, ttl)
+			if t.writes.done(key, p, version, err) {
+				return
+			}
+		}
+		t.writes.mu.Lock()
+		canceled := t.writes.pending[key] != p
+		delete(t.writes.pending, key)
+		err := p.err
+		t.writes.mu.Unlock()
+		if !canceled {
+			t.writeDropped(key, err)
+		}
+	})
+	if !queued {
+		t.writeDropped(key, err)
+	}
+}
+
+// writeDropped reports the failed write of key to the StatsRecorders.
+func (t *Transport) writeDropped(key string, err error) {
+	t.counters.WriteDropped(key, err)
+	if r, ok := t.Stats.(WriteDropRecorder); ok {
+		r.WriteDropped(key, err)
+	}
+}
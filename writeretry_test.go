@@ -0,0 +1,134 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestWriteRetry(t *testing.T) {
+	c := qt.New(t)
+	cache := &contextMemoryCache{items: map[string][]byte{}, err: errors.New("blip")}
+	counters := &Counters{}
+	tp := &Transport{Cache: FromCacheContext(cache), Stats: counters, WriteRetries: 3, WriteRetryBackoff: 10 * time.Millisecond}
+	defer tp.Close()
+	client := http.Client{Transport: tp}
+
+	get := func() {
+		resp, err := client.Get(s.server.URL + "/method")
+		c.Assert(err, qt.IsNil)
+		_, err = io.ReadAll(resp.Body)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+	}
+
+	get()
+	c.Assert(tp.writes.len(), qt.Equals, 1)
+	cache.mu.Lock()
+	cache.err = nil
+	cache.mu.Unlock()
+
+	c.Assert(waitFor(func() bool { return tp.writes.len() == 0 }), qt.IsTrue)
+	cache.mu.Lock()
+	_, ok := cache.items[s.server.URL+"/method"]
+	cache.mu.Unlock()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(counters.Values().DroppedWrites, qt.Equals, uint64(0))
+}
+
+func TestWriteRetryDropped(t *testing.T) {
+	c := qt.New(t)
+	cache := &contextMemoryCache{items: map[string][]byte{}, err: errors.New("down")}
+
+	c.Run("no retries", func(c *qt.C) {
+		counters := &Counters{}
+		tp := &Transport{Cache: FromCacheContext(cache), Stats: counters}
+		tp.cacheSet(context.Background(), "a", []byte("a"), 0)
+		c.Assert(tp.writes.len(), qt.Equals, 0)
+		c.Assert(counters.Values().DroppedWrites, qt.Equals, uint64(1))
+	})
+
+	c.Run("retries failing", func(c *qt.C) {
+		counters := &Counters{}
+		tp := &Transport{Cache: FromCacheContext(cache), Stats: counters, WriteRetries: 2, WriteRetryBackoff: time.Millisecond}
+		tp.cacheSet(context.Background(), "a", []byte("a"), 0)
+		c.Assert(waitFor(func() bool { return tp.writes.len() == 0 }), qt.IsTrue)
+		tp.Close()
+		c.Assert(counters.Values().DroppedWrites, qt.Equals, uint64(1))
+	})
+
+	c.Run("queue full", func(c *qt.C) {
+		counters := &Counters{}
+		tp := &Transport{Cache: FromCacheContext(cache), Stats: counters, WriteRetries: 1, WriteRetryBackoff: time.Hour, MaxQueuedWrites: 1}
+		tp.cacheSet(context.Background(), "a", []byte("a"), 0)
+		// Replaces the pending write of a.
+		tp.cacheSet(context.Background(), "a", []byte("b"), 0)
+		c.Assert(counters.Values().DroppedWrites, qt.Equals, uint64(0))
+		tp.cacheSet(context.Background(), "b", []byte("b"), 0)
+		c.Assert(counters.Values().DroppedWrites, qt.Equals, uint64(1))
+		c.Assert(tp.writes.len(), qt.Equals, 1)
+
+		// Deleting the entry cancels its retry.
+		tp.cacheDelete(context.Background(), "a")
+		c.Assert(tp.writes.len(), qt.Equals, 0)
+		tp.Close()
+		c.Assert(counters.Values().DroppedWrites, qt.Equals, uint64(1))
+	})
+}
+
+// waitFor polls cond for up to a few seconds, reporting whether it became true.
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// failingExpiringCache is a CacheWithExpiryContext failing with err, if set.
+type failingExpiringCache struct {
+	*expiringCache
+	err error
+}
+
+func (c *failingExpiringCache) SetWithTTLContext(ctx context.Context, key string, b []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.SetWithTTL(key, b, ttl)
+	return nil
+}
+
+func TestWriteRetryWithTTL(t *testing.T) {
+	c := qt.New(t)
+	cache := &failingExpiringCache{
+		expiringCache: &expiringCache{memoryCache: newMemoryCache(), ttls: map[string]time.Duration{}},
+		err:           errors.New("blip"),
+	}
+	tp := &Transport{Cache: cache, WriteRetries: 3, WriteRetryBackoff: 10 * time.Millisecond}
+	defer tp.Close()
+
+	tp.cacheSet(context.Background(), "a", []byte("a"), time.Minute)
+	c.Assert(tp.writes.len(), qt.Equals, 1)
+	cache.mu.Lock()
+	cache.err = nil
+	cache.mu.Unlock()
+
+	c.Assert(waitFor(func() bool { return tp.writes.len() == 0 }), qt.IsTrue)
+	b, ok := cache.Get("a")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(b), qt.Equals, "a")
+	cache.mu.Lock()
+	c.Assert(cache.ttls["a"], qt.Equals, time.Minute)
+	cache.mu.Unlock()
+}